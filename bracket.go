@@ -0,0 +1,103 @@
+package main
+
+// how many rows a bracket search looks through before giving up, so a
+// stray bracket in a huge file doesn't stall every keypress
+var BRACKET_SCAN_ROWS = 3000
+
+var bracketPairs = map[byte]byte{
+	'(': ')', '[': ']', '{': '}',
+	')': '(', ']': '[', '}': '{',
+}
+
+// editorHlAt is the highlight of the character at cx in row
+func editorHlAt(row *erow, cx int) byte {
+	rx := editorRowCxToRx(row, cx)
+	if rx < len(row.hl) {
+		return row.hl[rx]
+	}
+	return HL_NORMAL
+}
+
+func isStringOrComment(hl byte) bool {
+	return hl == HL_STRING || hl == HL_COMMENT || hl == HL_MLCOMMENT
+}
+
+// editorMatchBracket finds the partner of the bracket at cy, cx. with syntax
+// highlighting on, brackets in strings and comments only match each other
+func editorMatchBracket(cy, cx int) (int, int, bool) {
+	if cy >= E.numrows || cx >= E.row[cy].size {
+		return 0, 0, false
+	}
+	open := E.row[cy].chars[cx]
+	close, ok := bracketPairs[open]
+	if !ok {
+		return 0, 0, false
+	}
+	dir := 1
+	if open == ')' || open == ']' || open == '}' {
+		dir = -1
+	}
+	quoted := E.syntax != nil && isStringOrComment(editorHlAt(&E.row[cy], cx))
+
+	depth := 0
+	y, x := cy, cx
+	for scanned := 0; ; {
+		x += dir
+		for x < 0 || x >= E.row[y].size {
+			y += dir
+			scanned++
+			if y < 0 || y >= E.numrows || scanned > BRACKET_SCAN_ROWS {
+				return 0, 0, false
+			}
+			if dir > 0 {
+				x = 0
+			} else {
+				x = E.row[y].size - 1
+			}
+		}
+
+		c := E.row[y].chars[x]
+		if c != open && c != close {
+			continue
+		}
+		if E.syntax != nil && isStringOrComment(editorHlAt(&E.row[y], x)) != quoted {
+			continue
+		}
+		if c == open {
+			depth++
+		} else if depth == 0 {
+			return y, x, true
+		} else {
+			depth--
+		}
+	}
+}
+
+// editorBracketMotion is %: the partner of the bracket under the cursor,
+// or of the first bracket after it on the row
+func editorBracketMotion() (int, int, bool) {
+	if E.cy >= E.numrows {
+		return 0, 0, false
+	}
+	row := &E.row[E.cy]
+	for x := E.cx; x < row.size; x++ {
+		if _, ok := bracketPairs[row.chars[x]]; ok {
+			return editorMatchBracket(E.cy, x)
+		}
+	}
+	return 0, 0, false
+}
+
+// editorDrawMatchParen tints the bracket under the cursor and its partner
+func editorDrawMatchParen() {
+	y, x, ok := editorMatchBracket(E.cy, E.cx)
+	if !ok {
+		return
+	}
+	for _, p := range [][2]int{{E.cy, E.cx}, {y, x}} {
+		sx, sy, visible := editorScreenPosition(p[0], editorRowCxToRx(&E.row[p[0]], p[1]))
+		if visible {
+			screenTint(sx, sy, 1, editorTint(HG_MATCHPAREN))
+		}
+	}
+}
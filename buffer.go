@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// E is always the live copy of buffers[curbuf], the entry in the slice
+// is only brought up to date when we switch away from it
+var (
+	buffers []EditorConfig
+	curbuf  int
+)
+
+// editorAddBuffer stashes the current buffer and starts an empty one
+// that shares the screen and options of the old one
+func editorAddBuffer() {
+	buffers[curbuf] = E
+	E = EditorConfig{
+		screenrows:     E.screenrows,
+		screencols:     E.screencols,
+		raw_screencols: E.raw_screencols,
+		linenum_indent: E.linenum_indent,
+		statusmsg:      E.statusmsg,
+		statusmsg_time: E.statusmsg_time,
+		mode:           NORMAL,
+		autoread:       E.autoread,
+	}
+	buffers = append(buffers, E)
+	curbuf = len(buffers) - 1
+	curwin.buf = curbuf
+}
+
+func editorSwitchBuffer(i int) {
+	if i < 0 || i >= len(buffers) || i == curbuf {
+		return
+	}
+	buffers[curbuf] = E
+
+	next := buffers[i]
+	next.screenrows = E.screenrows
+	next.screencols = E.screencols
+	next.raw_screencols = E.raw_screencols
+	next.statusmsg = E.statusmsg
+	next.statusmsg_time = E.statusmsg_time
+	next.mode = NORMAL
+
+	E = next
+	curbuf = i
+	curwin.buf = i
+}
+
+// editorInBuffer runs f with buffer i as E, for changes to a buffer that isn't shown
+func editorInBuffer(i int, f func()) {
+	if i == curbuf {
+		f()
+		return
+	}
+	buffers[curbuf] = E
+	cur := curbuf
+	E, curbuf = buffers[i], i
+	f()
+	buffers[i] = E
+	// what f had to say still shows
+	msg, msgtime := E.statusmsg, E.statusmsg_time
+	E, curbuf = buffers[cur], cur
+	if msgtime.After(E.statusmsg_time) {
+		E.statusmsg, E.statusmsg_time = msg, msgtime
+	}
+}
+
+// editorFindBuffer returns the buffer that has filename open, or -1
+func editorFindBuffer(filename string) int {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return -1
+	}
+	for i := range buffers {
+		name := buffers[i].filename
+		if i == curbuf {
+			name = E.filename
+		}
+		if name == "" {
+			continue
+		}
+		if other, err := filepath.Abs(name); err == nil && other == abs {
+			return i
+		}
+	}
+	return -1
+}
+
+// editorEdit opens filename in a buffer, reusing an open one or the empty scratch buffer
+func editorEdit(filename string) {
+	if i := editorFindBuffer(filename); i >= 0 {
+		editorSwitchBuffer(i)
+		return
+	}
+	if E.filename != "" || E.dirty || E.numrows > 0 || E.buftype != "" {
+		editorAddBuffer()
+	}
+	editorOpen(filename)
+	if _, err := os.Stat(filename); err != nil {
+		editorSetStatusMessage("\"%s\" [New File]", filename)
+	}
+}
+
+// editorFillBuffer puts lines in a buffer that isn't a file, like a list,
+// without undo history and without making it modified
+func editorFillBuffer(lines []string) {
+	E.row, E.numrows = nil, 0
+	E.undo = undoTree{off: true}
+	for y, line := range lines {
+		editorInsertRow(y, []byte(line))
+	}
+	E.undo = undoTree{}
+	E.dirty = false
+}
+
+// editorBufType is the buftype of buffer i
+func editorBufType(i int) string {
+	if i == curbuf {
+		return E.buftype
+	}
+	return buffers[i].buftype
+}
+
+// editorBufFilename is the file name of buffer i
+func editorBufFilename(i int) string {
+	if i == curbuf {
+		return E.filename
+	}
+	return buffers[i].filename
+}
+
+// editorNextBuffer is :bnext and :bprevious, which skip buffers like the quickfix list
+func editorNextBuffer(dir int) {
+	for n, i := 1, curbuf; n < len(buffers); n++ {
+		i = (i + dir + len(buffers)) % len(buffers)
+		if editorBufType(i) == "" {
+			editorSwitchBuffer(i)
+			return
+		}
+	}
+}
+
+func editorAnyDirty() bool {
+	if E.dirty {
+		return true
+	}
+	for i := range buffers {
+		if i != curbuf && buffers[i].dirty {
+			return true
+		}
+	}
+	return false
+}
+
+func editorListBuffers() {
+	var lines []string
+	for i := range buffers {
+		b := buffers[i]
+		if i == curbuf {
+			b = E
+		}
+		name := b.filename
+		if b.buftype == "quickfix" {
+			name = "[Quickfix List]"
+		} else if b.buftype == "blame" {
+			name = "[Blame]"
+		} else if b.term != nil {
+			name = "!" + b.term.name
+		} else if name == "" {
+			name = "[No Name]"
+		}
+		flags := " "
+		if i == curbuf {
+			flags = "%"
+		}
+		if b.dirty {
+			flags += "+"
+		} else {
+			flags += " "
+		}
+		lines = append(lines, fmt.Sprintf("%3d %s \"%s\" line %d", i+1, flags, name, b.cy+1))
+	}
+	editorShowLines("buffers", lines)
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func editorCommand(initial string) {
+	cmd := editorPromptWith(":%s", initial, &cmdHistory, nil)
+	if cmd == "" {
+		return
+	}
+	editorRunCommand(cmd)
+}
+
+func editorRunCommand(cmd string) {
+	start, end, cmd, ranged, err := editorParseRange(strings.TrimSpace(cmd))
+	if err != nil {
+		editorSetStatusMessage("%s", err)
+		return
+	}
+	if rest, ok := strings.CutPrefix(cmd, "!"); ok {
+		if ranged {
+			editorFilter(start, end, strings.TrimSpace(rest))
+		} else {
+			editorBang(strings.TrimSpace(rest))
+		}
+		return
+	}
+	if cmd == "" {
+		// just a line number goes there
+		if ranged && E.numrows > 0 {
+			E.cy = end
+			E.cx = editorFirstNonBlank(E.cy)
+		}
+		return
+	}
+
+	name, args, _ := strings.Cut(cmd, " ")
+	args = strings.TrimSpace(args)
+
+	switch name {
+	case "set", "se":
+		for _, opt := range editorSplitOptions(args) {
+			editorSetOption(opt)
+		}
+	case "checktime":
+		if !editorCheckFileChanged() {
+			editorSetStatusMessage("%s is unchanged on disk", E.filename)
+		}
+	case "e!":
+		if E.filename == "" {
+			editorSetStatusMessage("no file name")
+			return
+		}
+		if editorReload() {
+			editorSetStatusMessage("%s reloaded", E.filename)
+		}
+	case "e", "edit":
+		if args == "" {
+			editorSetStatusMessage("no file name")
+			return
+		}
+		editorEdit(args)
+	case "ls", "buffers":
+		editorListBuffers()
+	case "bn", "bnext":
+		editorNextBuffer(1)
+	case "bp", "bprevious":
+		editorNextBuffer(-1)
+	case "b", "buffer":
+		n, err := strconv.Atoi(args)
+		if err != nil || n < 1 || n > len(buffers) {
+			editorSetStatusMessage("no such buffer: %s", args)
+			return
+		}
+		editorSwitchBuffer(n - 1)
+	case "mks", "mksession":
+		editorMakeSession(args)
+	case "hex":
+		editorHexToggle()
+	case "go", "goto":
+		editorGoto(args)
+	case "reg", "registers":
+		editorSetStatusMessage("%s", editorRegisterInfo())
+	case "colo", "colorscheme":
+		editorColorscheme(args)
+	case "lsp":
+		editorLspCommand(args)
+	case "def", "definition":
+		editorLspDefinition()
+	case "hover":
+		editorLspHover()
+	case "refs", "references":
+		editorLspReferences()
+	case "rename":
+		editorLspRename(args)
+	case "format":
+		editorFormat()
+	case "formatter":
+		editorFormatterCommand(args)
+	case "r", "read":
+		editorReadCommand(args)
+	case "sp", "split", "vs", "vsplit":
+		if editorSplit(name == "vs" || name == "vsplit") != nil && args != "" {
+			editorEdit(args)
+		}
+	case "tabnew", "tabe", "tabedit":
+		editorTabNew()
+		if args != "" {
+			editorEdit(args)
+		}
+	case "tabn", "tabnext":
+		editorGotoTab((curtab + 1) % len(tabs))
+	case "tabp", "tabprevious", "tabN", "tabNext":
+		editorGotoTab((curtab + len(tabs) - 1) % len(tabs))
+	case "tabc", "tabclose":
+		editorTabClose()
+	case "tabo", "tabonly":
+		editorTabOnly()
+	case "clo", "close":
+		editorCloseWindow(curwin)
+	case "on", "only":
+		editorOnly()
+	case "Ex", "Explore":
+		editorExplore(args)
+	case "Sex", "Sexplore", "Vex", "Vexplore":
+		if editorSplit(name[0] == 'V') != nil {
+			editorExplore(args)
+		}
+	case "Files":
+		editorFinderOpen(args)
+	case "diffs", "diffsplit":
+		editorDiffSplit(args)
+	case "difft", "diffthis":
+		editorDiffThis()
+	case "diffo", "diffoff":
+		editorDiffOff()
+	case "ter", "terminal":
+		editorTerminal(args)
+	case "Gblame":
+		editorGitBlame()
+	case "Gstage":
+		editorGitStageHunk()
+	case "Grevert":
+		editorGitRevertHunk()
+	case "mak", "make":
+		editorMake(args)
+	case "gr", "grep":
+		editorGrep(args)
+	case "cope", "copen":
+		editorQuickfixOpen()
+	case "ccl", "cclose":
+		editorQuickfixClose()
+	case "cn", "cnext":
+		editorQuickfixNext(1)
+	case "cp", "cprev", "cprevious", "cN", "cNext":
+		editorQuickfixNext(-1)
+	case "cc", "cfir", "cfirst", "cla", "clast":
+		editorQuickfixCommand(name, args)
+	case "plugin":
+		editorPluginCommand(args)
+	default:
+		if !editorPluginRunCommand(name, args, start, end, ranged) {
+			editorSetStatusMessage("not an editor command: %s", name)
+		}
+	}
+}
+
+// editorSplitOptions splits :set arguments on blanks, except blanks escaped with a backslash
+func editorSplitOptions(args string) []string {
+	var opts []string
+	var opt strings.Builder
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == '\\' && i+1 < len(args) && args[i+1] == ' ':
+			opt.WriteByte(' ')
+			i++
+		case args[i] == ' ' || args[i] == '\t':
+			if opt.Len() > 0 {
+				opts = append(opts, opt.String())
+				opt.Reset()
+			}
+		default:
+			opt.WriteByte(args[i])
+		}
+	}
+	if opt.Len() > 0 {
+		opts = append(opts, opt.String())
+	}
+	return opts
+}
+
+func editorSetOption(opt string) {
+	if name, value, ok := strings.Cut(opt, "="); ok {
+		editorSetOptionValue(name, value)
+		return
+	}
+
+	switch opt {
+	case "autoread", "ar":
+		E.autoread = true
+	case "noautoread", "noar":
+		E.autoread = false
+	case "readonly", "ro":
+		E.readonly = true
+	case "noreadonly", "noro":
+		E.readonly = false
+	case "undofile", "udf":
+		UNDO_FILE = true
+	case "noundofile", "noudf":
+		UNDO_FILE = false
+	case "formatonsave", "fos":
+		FORMAT_ON_SAVE = true
+	case "noformatonsave", "nofos":
+		FORMAT_ON_SAVE = false
+	case "gitgutter":
+		GITGUTTER = true
+	case "nogitgutter":
+		GITGUTTER = false
+		for i := range buffers {
+			editorInBuffer(i, func() {
+				E.git = nil
+				editorSetSigns("git", nil)
+			})
+		}
+	case "wrap":
+		WRAP = true
+	case "nowrap":
+		WRAP = false
+	case "linebreak", "lbr":
+		LINEBREAK = true
+	case "nolinebreak", "nolbr":
+		LINEBREAK = false
+	case "list":
+		LIST = true
+	case "nolist":
+		LIST = false
+	case "list!", "invlist":
+		LIST = !LIST
+	case "cursorline", "cul":
+		CURSORLINE = true
+	case "nocursorline", "nocul":
+		CURSORLINE = false
+	case "cursorcolumn", "cuc":
+		CURSORCOLUMN = true
+	case "nocursorcolumn", "nocuc":
+		CURSORCOLUMN = false
+	case "number", "nu":
+		NUMBER = true
+	case "nonumber", "nonu":
+		NUMBER = false
+	case "number!", "nu!", "invnumber", "invnu":
+		NUMBER = !NUMBER
+	case "relativenumber", "rnu":
+		RELATIVENUMBER = true
+	case "norelativenumber", "nornu":
+		RELATIVENUMBER = false
+	case "relativenumber!", "rnu!", "invrelativenumber", "invrnu":
+		RELATIVENUMBER = !RELATIVENUMBER
+	default:
+		editorSetStatusMessage("unknown option: %s", opt)
+	}
+}
+
+func editorSetOptionValue(name, value string) {
+	switch name {
+	case "undolevels", "ul":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		UNDO_LEVELS = n
+		editorUndoTrim()
+	case "showbreak", "sbr":
+		SHOWBREAK = value
+	case "statusline", "stl":
+		STATUSLINE = value
+	case "makeprg", "mp":
+		MAKEPRG = value
+	case "grepprg", "gp":
+		GREPPRG = value
+	case "errorformat", "efm", "grepformat", "gfm":
+		if _, err := editorParseErrorformat(value); err != nil {
+			editorSetStatusMessage("invalid value: %s=%s: %s", name, value, err)
+			return
+		}
+		if name == "errorformat" || name == "efm" {
+			ERRORFORMAT = value
+		} else {
+			GREPFORMAT = value
+		}
+	case "fileformat", "ff":
+		if value != "unix" && value != "dos" {
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		E.crlf = value == "dos"
+		E.dirty = true
+	case "listchars", "lcs":
+		if err := editorParseListchars(value); err != nil {
+			editorSetStatusMessage("invalid value: %s=%s: %s", name, value, err)
+			return
+		}
+	case "colorcolumn", "cc":
+		cols, err := editorParseColorcolumn(value)
+		if err != nil {
+			editorSetStatusMessage("invalid value: %s=%s: %s", name, value, err)
+			return
+		}
+		COLORCOLUMN = cols
+	case "foldmethod", "fdm":
+		if value != "indent" && value != "syntax" && value != "marker" && value != "none" {
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		FOLDMETHOD = value
+	case "numberwidth", "nuw":
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 20 {
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		NUMBERWIDTH = n
+	case "signcolumn", "scl":
+		if value != "auto" && value != "yes" && value != "no" {
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		SIGNCOLUMN = value
+	case "termcolors":
+		switch value {
+		case "16":
+			COLOR_DEPTH = COLORS_16
+		case "256":
+			COLOR_DEPTH = COLORS_256
+		case "truecolor", "24bit":
+			COLOR_DEPTH = COLORS_TRUE
+		default:
+			editorSetStatusMessage("invalid value: %s=%s", name, value)
+			return
+		}
+		editorApplyTheme()
+	default:
+		editorSetStatusMessage("unknown option: %s", name)
+	}
+}
+
+func editorConfigDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return ""
+		}
+	}
+	return filepath.Join(dir, "goditor")
+}
+
+// editorLoadConfig runs <config>/goditorrc, one : command per line
+func editorLoadConfig() {
+	dir := editorConfigDir()
+	if dir == "" {
+		return
+	}
+	file, err := os.Open(filepath.Join(dir, "goditorrc"))
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "\"") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		editorRunCommand(strings.TrimPrefix(line, ":"))
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// a completion candidate. detail is shown next to the menu for the selected one
+type complItem struct {
+	word   string
+	kind   string
+	detail string
+}
+
+// the INSERT mode completion menu. Ctrl-N, Ctrl-P or Tab after a word open it
+// with the words in the open buffers, file names when the word is a path, and
+// what the language server suggests. typing on narrows the menu down, Enter or
+// Ctrl-Y takes the selected item and Ctrl-E closes it
+var compl struct {
+	active     bool
+	y, x       int  // where the text being completed starts
+	path       bool // completing a file name after the last / of a path
+	query      string
+	items      []complItem
+	shown      []int // indexes into items that match query, best first
+	sel        int
+	lsp        int  // how many items at the start of items came from the language server
+	lspWaiting bool // a request is out
+	lspMore    bool // the server's list was incomplete, ask again as the query changes
+	lspSeq     int
+}
+
+// the most words taken from the buffers
+const COMPLETE_MAX_WORDS = 5000
+
+func editorCloseCompletion() {
+	compl.active = false
+	compl.items = nil
+	compl.shown = nil
+}
+
+// editorCompletionKey handles the keys of the menu in INSERT mode. keys it
+// doesn't take go on to be typed as usual
+func editorCompletionKey(c int) bool {
+	if !compl.active {
+		switch c {
+		case CONTROL_KEY('n'), CONTROL_KEY('p'):
+			editorStartCompletion()
+			if c == CONTROL_KEY('p') && len(compl.shown) > 0 {
+				compl.sel = len(compl.shown) - 1
+			}
+			return true
+		case '\t':
+			// a Tab that doesn't follow a word is a tab
+			if E.cy >= E.numrows || E.cx == 0 {
+				return false
+			}
+			if prev := E.row[E.cy].chars[E.cx-1]; !isWordChar(prev) && prev != '/' && prev != '.' {
+				return false
+			}
+			editorStartCompletion()
+			return true
+		}
+		return false
+	}
+
+	switch c {
+	case CONTROL_KEY('n'), '\t', ARROW_DOWN:
+		editorCompletionMove(1)
+	case CONTROL_KEY('p'), ARROW_UP:
+		editorCompletionMove(-1)
+	case '\r', CONTROL_KEY('y'):
+		if len(compl.shown) == 0 {
+			editorCloseCompletion()
+			return false
+		}
+		editorAcceptCompletion()
+	case CONTROL_KEY('e'):
+		editorCloseCompletion()
+	default:
+		return false
+	}
+	return true
+}
+
+func editorCompletionMove(delta int) {
+	if n := len(compl.shown); n > 0 {
+		compl.sel = (compl.sel + delta + n) % n
+	}
+}
+
+// editorStartCompletion works out what's being completed and gathers the candidates
+func editorStartCompletion() {
+	if E.cy >= E.numrows {
+		return
+	}
+	chars := E.row[E.cy].chars
+	cx := min(E.cx, len(chars))
+
+	// a run of characters with a / in it is a path, the last part of it is completed
+	start := cx
+	for start > 0 && !strings.ContainsRune(" \t\"'`()[]{}<>,;=", rune(chars[start-1])) {
+		start--
+	}
+	word := cx
+	for word > 0 && isWordChar(chars[word-1]) {
+		word--
+	}
+
+	editorCloseCompletion()
+	compl.active = true
+	compl.y = E.cy
+	compl.lsp = 0
+	compl.lspWaiting = false
+	compl.lspMore = false
+	if slash := strings.LastIndexByte(string(chars[start:cx]), '/'); slash >= 0 {
+		compl.path = true
+		compl.x = start + slash + 1
+		compl.items = editorFileItems(string(chars[start : start+slash+1]))
+	} else {
+		compl.path = false
+		compl.x = word
+		compl.items = editorWordItems()
+		editorLspComplete()
+	}
+	editorFilterCompletion()
+
+	if len(compl.shown) == 0 && !compl.lspWaiting {
+		editorSetStatusMessage("no completions")
+		editorCloseCompletion()
+	}
+}
+
+// editorWordItems collects the words of the current buffer, the nearest to the
+// cursor first, then those of the other buffers
+func editorWordItems() []complItem {
+	var items []complItem
+	seen := map[string]bool{}
+	add := func(b *EditorConfig, y int) {
+		chars := b.row[y].chars
+		for x := 0; x < len(chars); {
+			if !isWordChar(chars[x]) {
+				x++
+				continue
+			}
+			end := x
+			for end < len(chars) && isWordChar(chars[end]) {
+				end++
+			}
+			// the word being typed isn't a candidate for itself
+			w := string(chars[x:end])
+			if len(w) > 1 && !seen[w] && !(b == &E && y == compl.y && x == compl.x) {
+				seen[w] = true
+				name := b.filename
+				if name == "" {
+					name = "[No Name]"
+				}
+				items = append(items, complItem{word: w, kind: "buf", detail: fmt.Sprintf("%s:%d: %s", name, y+1, strings.TrimSpace(string(chars)))})
+			}
+			x = end
+		}
+	}
+
+	for d := 0; d < E.numrows && len(items) < COMPLETE_MAX_WORDS; d++ {
+		if E.cy-d >= 0 && E.cy-d < E.numrows {
+			add(&E, E.cy-d)
+		}
+		if d > 0 && E.cy+d < E.numrows {
+			add(&E, E.cy+d)
+		}
+	}
+	for i := range buffers {
+		if i == curbuf {
+			continue
+		}
+		for y := 0; y < buffers[i].numrows && len(items) < COMPLETE_MAX_WORDS; y++ {
+			add(&buffers[i], y)
+		}
+	}
+	return items
+}
+
+// editorFileItems lists the directory dir, which ends in a / and may start with ~/
+func editorFileItems(dir string) []complItem {
+	path := dir
+	if rest, ok := strings.CutPrefix(dir, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, rest)
+		}
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil
+	}
+	items := make([]complItem, 0, len(entries))
+	for _, e := range entries {
+		item := complItem{word: e.Name(), kind: "file"}
+		if info, err := e.Info(); err == nil {
+			item.detail = fmt.Sprintf("%s%s  %d bytes  %s", dir, e.Name(), info.Size(), info.ModTime().Format("2006-01-02 15:04"))
+		}
+		if e.IsDir() {
+			item.word += "/"
+			item.kind = "dir"
+			item.detail = dir + item.word
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// editorFilterCompletion picks the items that match what's typed so far, best first
+func editorFilterCompletion() {
+	compl.query = ""
+	if compl.y < E.numrows && compl.x <= E.cx && E.cx <= E.row[compl.y].size {
+		compl.query = string(E.row[compl.y].chars[compl.x:E.cx])
+	}
+
+	type match struct{ i, score int }
+	var matches []match
+	for i, item := range compl.items {
+		if item.word == compl.query {
+			continue
+		}
+		// hidden files only when asked for
+		if compl.path && strings.HasPrefix(item.word, ".") && !strings.HasPrefix(compl.query, ".") {
+			continue
+		}
+		if score, ok := fuzzyMatch(compl.query, item.word); ok {
+			matches = append(matches, match{i, score})
+		}
+	}
+	sort.SliceStable(matches, func(a, b int) bool { return matches[a].score > matches[b].score })
+
+	compl.shown = compl.shown[:0]
+	for _, m := range matches {
+		compl.shown = append(compl.shown, m.i)
+	}
+	compl.sel = 0
+}
+
+// editorUpdateCompletion follows the typing after a key. moving away from the
+// word or typing something that can't be in it closes the menu
+func editorUpdateCompletion() {
+	if !compl.active {
+		return
+	}
+	if E.mode != INSERT || E.cy != compl.y || E.cy >= E.numrows || E.cx < compl.x || E.cx > E.row[E.cy].size {
+		editorCloseCompletion()
+		return
+	}
+	query := string(E.row[E.cy].chars[compl.x:E.cx])
+	if query == compl.query {
+		return
+	}
+	for i := 0; i < len(query); i++ {
+		if c := query[i]; !isWordChar(c) && !(compl.path && (c == '.' || c == '-')) {
+			editorCloseCompletion()
+			return
+		}
+	}
+	editorFilterCompletion()
+	if compl.lspMore {
+		editorLspComplete()
+	}
+}
+
+// editorAcceptCompletion puts the selected item in place of what was typed
+func editorAcceptCompletion() {
+	item := compl.items[compl.shown[compl.sel]]
+	row := &E.row[compl.y]
+	end := min(E.cx, row.size)
+	chars := append(append([]byte(nil), row.chars[:compl.x]...), item.word...)
+	editorSetRow(compl.y, append(chars, row.chars[end:]...))
+	E.cx = compl.x + len(item.word)
+	editorCloseCompletion()
+}
+
+// editorSetLspCompletions puts what the server suggested at the top of the menu
+func editorSetLspCompletions(items []complItem, incomplete bool) {
+	n := len(items)
+	seen := map[string]bool{}
+	for _, item := range items {
+		seen[item.word] = true
+	}
+	// then the words from the buffers that the server didn't have
+	for _, item := range compl.items[compl.lsp:] {
+		if !seen[item.word] {
+			items = append(items, item)
+		}
+	}
+	compl.lsp = n
+	compl.items = items
+	compl.lspWaiting = false
+	compl.lspMore = incomplete
+	editorFilterCompletion()
+	if len(compl.shown) == 0 {
+		editorCloseCompletion()
+	}
+}
+
+// editorDrawCompletion draws the menu under the start of the word, and the
+// detail of the selected item beside it
+func editorDrawCompletion() {
+	if !compl.active || len(compl.shown) == 0 || compl.y >= E.numrows {
+		return
+	}
+	x, y, ok := editorScreenPosition(compl.y, editorRowCxToRx(&E.row[compl.y], compl.x))
+	if !ok {
+		return
+	}
+
+	width := 0
+	for _, i := range compl.shown {
+		width = max(width, len(compl.items[i].word))
+	}
+	width = min(width, POPUP_MAX_WIDTH/2)
+	lines := make([]string, len(compl.shown))
+	for n, i := range compl.shown {
+		lines[n] = fmt.Sprintf("%-*s %s", width, compl.items[i].word, compl.items[i].kind)
+	}
+	left, top, w, _ := editorDrawBox(x-1, y, lines, compl.sel)
+
+	detail := compl.items[compl.shown[compl.sel]].detail
+	if detail == "" {
+		return
+	}
+	var preview []string
+	dw := 0
+	for _, line := range strings.Split(strings.TrimSpace(detail), "\n") {
+		line = strings.ReplaceAll(line, "\t", "    ")
+		preview = append(preview, line)
+		dw = max(dw, len([]rune(line)))
+	}
+	dw = min(dw+2, POPUP_MAX_WIDTH)
+	dh := min(len(preview), POPUP_MAX_HEIGHT, E.screenrows-top)
+	// right of the menu, or left of it when there's no room
+	px := left + w + 1
+	if px+dw > E.raw_screencols {
+		if left-dw-1 >= 0 {
+			px = left - dw - 1
+		} else {
+			dw = max(E.raw_screencols-px, 0)
+		}
+	}
+	if dw > 2 {
+		editorDrawBoxAt(px, top, dw, dh, preview, -1)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	score := func(pattern, s string) int {
+		t.Helper()
+		n, ok := fuzzyMatch(pattern, s)
+		if !ok {
+			t.Fatalf("%q doesn't match %q", pattern, s)
+		}
+		return n
+	}
+	// the start of the string, then the start of a word, then anywhere
+	if a, b, c := score("fb", "fooBar"), score("fb", "fab"), score("fb", "xfooBar"); a <= b || b <= c {
+		t.Errorf("fooBar scores %d, fab %d and xfooBar %d for fb", a, b, c)
+	}
+	if a, b := score("fb", "foo_bar"), score("fb", "foobar"); a <= b {
+		t.Errorf("foo_bar scores %d and foobar %d for fb", a, b)
+	}
+	if a, b := score("ab", "abc"), score("ab", "abcd"); a <= b {
+		t.Errorf("abc scores %d and abcd %d for ab", a, b)
+	}
+
+	for _, tc := range []struct {
+		pattern, s string
+		ok         bool
+	}{
+		{"", "anything", true},
+		{"ba", "ab", false},
+		{"abc", "ab", false},
+		// upper case in the pattern makes it match case
+		{"fb", "FooBar", true},
+		{"fB", "fooBar", true},
+		{"fB", "foobar", false},
+	} {
+		if _, ok := fuzzyMatch(tc.pattern, tc.s); ok != tc.ok {
+			t.Errorf("%q matching %q is %v, want %v", tc.pattern, tc.s, ok, tc.ok)
+		}
+	}
+}
+
+func TestFilterCompletion(t *testing.T) {
+	saved := compl
+	defer func() { compl = saved }()
+	words := func() []string {
+		var shown []string
+		for _, i := range compl.shown {
+			shown = append(shown, compl.items[i].word)
+		}
+		return shown
+	}
+
+	testBuffers(t, "fb")
+	E.mode, E.cx = INSERT, 2
+	compl.active, compl.y, compl.x = true, 0, 0
+	for _, w := range []string{"xfooBar", "bar", "fb", "fab", "fooBar"} {
+		compl.items = append(compl.items, complItem{word: w, kind: "buf"})
+	}
+	// best first, leaving out what's already typed
+	editorFilterCompletion()
+	if got, want := words(), []string{"fooBar", "fab", "xfooBar"}; !slices.Equal(got, want) {
+		t.Errorf("menu is %q, want %q", got, want)
+	}
+
+	// hidden files only once a . is typed
+	compl.path = true
+	compl.items = []complItem{{word: ".fb/", kind: "dir"}, {word: "fb.go", kind: "file"}}
+	editorFilterCompletion()
+	if got, want := words(), []string{"fb.go"}; !slices.Equal(got, want) {
+		t.Errorf("file menu is %q, want %q", got, want)
+	}
+	editorSetLines([]string{".fb"})
+	E.cx = 3
+	editorFilterCompletion()
+	if got, want := words(), []string{".fb/"}; !slices.Equal(got, want) {
+		t.Errorf("file menu after a . is %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type diffOp struct {
+	kind byte // ' ' = same, '-' = only in a, '+' = only in b
+	a, b int  // line index into a / b, -1 when the line is not there
+}
+
+// DIFF_MAX_COST is how many edits a diff looks through for the best way to
+// line up a stretch of lines. past it the stretch is taken as replaced whole,
+// so rewriting a big file doesn't make every redraw slow
+var DIFF_MAX_COST = 1000
+
+// DIFF_DELAY is how long the text has to stay the same before the git signs
+// and diff mode compare it again, so typing doesn't run a diff on every key
+var DIFF_DELAY = 150 * time.Millisecond
+
+// a debounce holds back work on text that's still changing
+type debounce struct {
+	seen    any // the text as it was last seen, like its changetick
+	changed time.Time
+	waiting bool
+}
+
+// ready says whether the text, seen as key, has stayed the same for DIFF_DELAY.
+// when it hasn't, again is run as an event once it could have
+func (d *debounce) ready(key any, again func()) bool {
+	if d.seen != key {
+		d.seen, d.changed = key, time.Now()
+	}
+	wait := DIFF_DELAY - time.Since(d.changed)
+	if wait <= 0 {
+		return true
+	}
+	if !d.waiting {
+		d.waiting = true
+		time.AfterFunc(wait, func() {
+			events <- func() {
+				d.waiting = false
+				again()
+			}
+		})
+	}
+	return false
+}
+
+// diffLines is a Myers diff in linear space: the middle of the shortest edit
+// script is found from both ends and the two halves are diffed the same way
+func diffLines(a, b []string) []diffOp {
+	return diffRange(a, b, 0, 0, nil)
+}
+
+// diffRange appends the diff of a and b, which start at lines ai and bi, to ops
+func diffRange(a, b []string, ai, bi int, ops []diffOp) []diffOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, diffOp{' ', ai, bi})
+		a, b = a[1:], b[1:]
+		ai++
+		bi++
+	}
+	suf := 0
+	for suf < len(a) && suf < len(b) && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	a, b = a[:len(a)-suf], b[:len(b)-suf]
+
+	x, y, ok := 0, 0, false
+	if len(a) > 0 && len(b) > 0 {
+		x, y, ok = diffBisect(a, b)
+	}
+	if ok {
+		ops = diffRange(a[:x], b[:y], ai, bi, ops)
+		ops = diffRange(a[x:], b[y:], ai+x, bi+y, ops)
+	} else {
+		for i := range a {
+			ops = append(ops, diffOp{'-', ai + i, -1})
+		}
+		for i := range b {
+			ops = append(ops, diffOp{'+', -1, bi + i})
+		}
+	}
+	for i := 0; i < suf; i++ {
+		ops = append(ops, diffOp{' ', ai + len(a) + i, bi + len(b) + i})
+	}
+	return ops
+}
+
+// diffBisect finds where the shortest edit script of a and b splits in two,
+// going forward from the start and backward from the end until the paths
+// meet. false when they don't within DIFF_MAX_COST edits
+func diffBisect(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	maxd := (n + m + 1) / 2
+	off := maxd
+	vf := make([]int, 2*maxd+2)
+	vb := make([]int, 2*maxd+2)
+	for i := range vf {
+		vf[i], vb[i] = -1, -1
+	}
+	vf[off+1], vb[off+1] = 0, 0
+	delta := n - m
+	// with an odd delta the paths meet going forward, otherwise going back
+	front := delta%2 != 0
+	// how far the diagonals that ran off the edges are trimmed
+	fstart, fend, bstart, bend := 0, 0, 0, 0
+
+	for d := 0; d < min(maxd, DIFF_MAX_COST); d++ {
+		for k := -d + fstart; k <= d-fend; k += 2 {
+			i := off + k
+			var x int
+			if k == -d || (k != d && vf[i-1] < vf[i+1]) {
+				x = vf[i+1]
+			} else {
+				x = vf[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[i] = x
+			if x > n {
+				fend += 2
+			} else if y > m {
+				fstart += 2
+			} else if front {
+				if j := off + delta - k; j >= 0 && j < len(vb) && vb[j] != -1 && x >= n-vb[j] {
+					return x, y, true
+				}
+			}
+		}
+		for k := -d + bstart; k <= d-bend; k += 2 {
+			i := off + k
+			var x int
+			if k == -d || (k != d && vb[i-1] < vb[i+1]) {
+				x = vb[i+1]
+			} else {
+				x = vb[i-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[i] = x
+			if x > n {
+				bend += 2
+			} else if y > m {
+				bstart += 2
+			} else if !front {
+				if j := off + delta - k; j >= 0 && j < len(vf) && vf[j] != -1 && vf[j] >= n-x {
+					return vf[j], vf[j] - (delta - k), true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// diffUnified renders the diff between a and b as unified diff hunks
+// with the given number of context lines around each change
+func diffUnified(a, b []string, context int) []string {
+	ops := diffLines(a, b)
+
+	var out []string
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			j := end
+			for j < len(ops) && ops[j].kind == ' ' {
+				j++
+			}
+			if j < len(ops) && j-end <= 2*context {
+				end = j
+				continue
+			}
+			end = min(end+context, len(ops))
+			break
+		}
+
+		astart, bstart := diffPosition(ops, start)
+		acount, bcount := 0, 0
+		var lines []string
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				acount++
+				bcount++
+				lines = append(lines, " "+a[op.a])
+			case '-':
+				acount++
+				lines = append(lines, "-"+a[op.a])
+			case '+':
+				bcount++
+				lines = append(lines, "+"+b[op.b])
+			}
+		}
+		out = append(out, fmt.Sprintf("@@ -%d,%d +%d,%d @@", astart+1, acount, bstart+1, bcount))
+		out = append(out, lines...)
+		i = end
+	}
+	return out
+}
+
+// diffPosition returns how many lines of a and b come before ops[at]
+func diffPosition(ops []diffOp, at int) (int, int) {
+	apos, bpos := 0, 0
+	for _, op := range ops[:at] {
+		if op.kind != '+' {
+			apos++
+		}
+		if op.kind != '-' {
+			bpos++
+		}
+	}
+	return apos, bpos
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkDiff says what's wrong with ops as a diff of a and b, "" when nothing
+func checkDiff(a, b []string, ops []diffOp) string {
+	ai, bi := 0, 0
+	for _, op := range ops {
+		switch op.kind {
+		case ' ':
+			if op.a != ai || op.b != bi || a[ai] != b[bi] {
+				return "bad common line " + strconv.Itoa(ai)
+			}
+			ai++
+			bi++
+		case '-':
+			if op.a != ai {
+				return "bad deleted line " + strconv.Itoa(ai)
+			}
+			ai++
+		case '+':
+			if op.b != bi {
+				return "bad added line " + strconv.Itoa(bi)
+			}
+			bi++
+		}
+	}
+	if ai != len(a) || bi != len(b) {
+		return "not all lines are in the diff"
+	}
+	return ""
+}
+
+// lcs is the length of the longest common subsequence of a and b
+func lcs(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	for i := range a {
+		cur := make([]int, len(b)+1)
+		for j := range b {
+			if a[i] == b[j] {
+				cur[j+1] = prev[j] + 1
+			} else {
+				cur[j+1] = max(cur[j], prev[j+1])
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLines(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, r.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+	for n := 0; n < 2000; n++ {
+		a, b := random(), random()
+		ops := diffLines(a, b)
+		if msg := checkDiff(a, b, ops); msg != "" {
+			t.Fatalf("diff of %q and %q: %s", a, b, msg)
+		}
+		same := 0
+		for _, op := range ops {
+			if op.kind == ' ' {
+				same++
+			}
+		}
+		if want := lcs(a, b); same != want {
+			t.Fatalf("diff of %q and %q keeps %d lines, the shortest keeps %d", a, b, same, want)
+		}
+	}
+
+	// a diff that gives up early is still a diff
+	defer func(cost int) { DIFF_MAX_COST = cost }(DIFF_MAX_COST)
+	DIFF_MAX_COST = 2
+	for n := 0; n < 500; n++ {
+		a, b := random(), random()
+		if msg := checkDiff(a, b, diffLines(a, b)); msg != "" {
+			t.Fatalf("diff of %q and %q: %s", a, b, msg)
+		}
+	}
+}
+
+func TestDiffLinesBig(t *testing.T) {
+	a := make([]string, 4000)
+	b := make([]string, 4000)
+	for i := range a {
+		a[i] = "a" + strconv.Itoa(i)
+		b[i] = "b" + strconv.Itoa(i)
+	}
+	start := time.Now()
+	ops := diffLines(a, b)
+	if msg := checkDiff(a, b, ops); msg != "" {
+		t.Fatal(msg)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("diffing 4000 lines that all differ took %s", d)
+	}
+	if hunks := gitHunks(ops); len(hunks) != 1 || hunks[0].count != 4000 || hunks[0].hcount != 4000 {
+		t.Errorf("the diff is %d hunks, want a single replace", len(hunks))
+	}
+
+	// a few changes far apart in a big file are still found one by one
+	c := append([]string(nil), a...)
+	c[10], c[2000] = "x", "y"
+	c = append(c[:3000], c[3001:]...)
+	if hunks := gitHunks(diffLines(a, c)); len(hunks) != 3 {
+		t.Errorf("3 changes make %d hunks", len(hunks))
+	}
+}
+
+func TestDiffUnified(t *testing.T) {
+	a := strings.Split("1 2 3 4 5 6 7 8 9", " ")
+	b := strings.Split("1 2 3 x 5 6 7 8 9 10", " ")
+	got := strings.Join(diffUnified(a, b, 1), "\n")
+	want := "@@ -3,3 +3,3 @@\n 3\n-4\n+x\n 5\n@@ -9,1 +9,2 @@\n 9\n+10"
+	if got != want {
+		t.Errorf("diffUnified =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import "unicode/utf8"
+
+// what diff mode knows about a buffer compared with the one in the other diff window
+type diffState struct {
+	other     int // the buffer it's compared with
+	tick      int // changeticks of both buffers the state was worked out for
+	otherTick int
+	hunks     []gitHunk       // start and count are rows here, hstart and hcount in the other buffer
+	fill      map[int]int     // filler lines above a row, standing for lines only the other buffer has
+	kind      map[int]hlGroup // HG_DIFFADDLINE or HG_DIFFCHANGELINE for the rows that differ
+	text      map[int][2]int  // the bytes of a changed row that differ from its counterpart
+}
+
+// diffWait holds back comparing the diff buffers while one is changing
+var diffWait debounce
+
+// editorDiffBuffers are the buffers of the current tab's diff windows, in window order
+func editorDiffBuffers() []int {
+	var bufs []int
+	for _, w := range editorWindows() {
+		if !w.diff {
+			continue
+		}
+		seen := false
+		for _, b := range bufs {
+			seen = seen || b == w.buf
+		}
+		if !seen {
+			bufs = append(bufs, w.buf)
+		}
+	}
+	return bufs
+}
+
+// editorDiffUpdate compares the two buffers in diff windows again when either
+// changed, like editorLspSync it runs before every redraw. buffers that are
+// no longer diffed lose their state
+func editorDiffUpdate() {
+	editorDiffCompare(false)
+}
+
+// editorDiffHunks are the hunks of the current buffer, for commands that need
+// them to be of the text as it is now
+func editorDiffHunks() []gitHunk {
+	editorDiffCompare(true)
+	if E.diff == nil {
+		return nil
+	}
+	return E.diff.hunks
+}
+
+// editorDiffCompare is editorDiffUpdate, which waits for changes to stop unless now is set
+func editorDiffCompare(now bool) {
+	bufs := editorDiffBuffers()
+	if len(bufs) != 2 {
+		bufs = nil
+	}
+	for i := range buffers {
+		if (i == curbuf && E.diff == nil) || (i != curbuf && buffers[i].diff == nil) {
+			continue
+		}
+		if len(bufs) == 0 || i != bufs[0] && i != bufs[1] {
+			editorInBuffer(i, func() {
+				E.diff = nil
+				E.topskip = 0
+			})
+		}
+	}
+	if bufs == nil {
+		return
+	}
+
+	var lines [2][]string
+	var ticks [2]int
+	var states [2]*diffState
+	for n, i := range bufs {
+		editorInBuffer(i, func() {
+			ticks[n], states[n] = E.changetick, E.diff
+		})
+	}
+	if states[0] != nil && states[1] != nil && states[0].other == bufs[1] && states[1].other == bufs[0] {
+		if states[0].tick == ticks[0] && states[0].otherTick == ticks[1] {
+			return
+		}
+		// while the text is being changed the old highlights stay a moment
+		if !now && !diffWait.ready([4]int{bufs[0], bufs[1], ticks[0], ticks[1]}, editorDiffUpdate) {
+			return
+		}
+	}
+	for n, i := range bufs {
+		editorInBuffer(i, func() { lines[n] = editorBufferLines() })
+	}
+
+	// the hunks are from the second buffer's side, the first one's are the same turned around
+	hunks := gitHunks(diffLines(lines[0], lines[1]))
+	flipped := make([]gitHunk, len(hunks))
+	for i, h := range hunks {
+		flipped[i] = gitHunk{start: h.hstart, count: h.hcount, hstart: h.start, hcount: h.count}
+	}
+	sides := [2][]gitHunk{flipped, hunks}
+	for n, i := range bufs {
+		st := editorDiffState(sides[n], lines[n], lines[1-n])
+		st.other, st.tick, st.otherTick = bufs[1-n], ticks[n], ticks[1-n]
+		editorInBuffer(i, func() { E.diff = st })
+	}
+}
+
+// editorDiffState works out the highlights and filler lines of one side of a diff.
+// the first rows of a hunk are changed ones, paired with the other side's, the
+// rest were added. the side with fewer rows gets filler lines after them
+func editorDiffState(hunks []gitHunk, mine, theirs []string) *diffState {
+	st := &diffState{
+		hunks: hunks,
+		fill:  map[int]int{},
+		kind:  map[int]hlGroup{},
+		text:  map[int][2]int{},
+	}
+	for _, h := range hunks {
+		paired := min(h.count, h.hcount)
+		for i := 0; i < h.count; i++ {
+			if i < paired {
+				st.kind[h.start+i] = HG_DIFFCHANGELINE
+				st.text[h.start+i] = diffChangedText(mine[h.start+i], theirs[h.hstart+i])
+			} else {
+				st.kind[h.start+i] = HG_DIFFADDLINE
+			}
+		}
+		if h.hcount > h.count {
+			st.fill[h.start+h.count] += h.hcount - h.count
+		}
+	}
+	return st
+}
+
+// diffChangedText is the part of a that isn't in b: what's left between the
+// prefix and suffix they have in common
+func diffChangedText(a, b string) [2]int {
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	for pre > 0 && pre < len(a) && !utf8.RuneStart(a[pre]) {
+		pre--
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	for suf > 0 && !utf8.RuneStart(a[len(a)-suf]) {
+		suf--
+	}
+	return [2]int{pre, len(a) - suf}
+}
+
+// editorFillAbove is how many filler lines go above filerow, numrows for the ones after the last row
+func editorFillAbove(filerow int) int {
+	if E.diff == nil {
+		return 0
+	}
+	return E.diff.fill[filerow]
+}
+
+// editorHiddenFill is how many of the filler lines above filerow are scrolled
+// out of view when it becomes the top row. those above the first row show
+func editorHiddenFill(filerow int) int {
+	if filerow == 0 {
+		return 0
+	}
+	return editorFillAbove(filerow)
+}
+
+// editorDrawFiller draws a filler line, where the other buffer has lines this one doesn't
+func editorDrawFiller(y int) {
+	st := hlStyle(HG_DIFFDELETE)
+	for x := E.linenum_indent; x < E.linenum_indent+E.screencols; x++ {
+		screenPutString(x, y, "-", st)
+	}
+}
+
+// editorDrawDiff tints screen line y of filerow when it differs from the
+// other buffer, the changed text inside a changed row standing out more
+func editorDrawDiff(y, filerow, from int) {
+	if E.diff == nil || filerow >= E.numrows {
+		return
+	}
+	row := &E.row[filerow]
+	// the row may have changed since the diff, until it's run again
+	if t, ok := E.diff.text[filerow]; ok && t[0] < t[1] && t[1] <= row.size {
+		x0 := max(editorRenderCol(row, editorRowCxToRx(row, t[0]))-from, 0)
+		x1 := min(editorRenderCol(row, editorRowCxToRx(row, t[1]))-from, E.screencols)
+		if x0 < x1 {
+			screenTint(E.linenum_indent+x0, y, x1-x0, editorTint(HG_DIFFTEXT))
+		}
+	}
+	if g, ok := E.diff.kind[filerow]; ok {
+		screenTint(E.linenum_indent, y, E.screencols, editorTint(g))
+	}
+}
+
+// editorDiffTop is the virtual line at the top of the view: the lines of both
+// sides line up when rows and filler lines are counted together
+func editorDiffTop() int {
+	top := E.rowoff + E.topskip
+	for r, n := range E.diff.fill {
+		if r < E.rowoff {
+			top += n
+		}
+	}
+	return top
+}
+
+// editorDiffScrollTo is the row and hidden filler lines that put virtual line top at the top of the view
+func editorDiffScrollTo(top int) (int, int) {
+	v := 0
+	for r := 0; r < E.numrows; r++ {
+		f := E.diff.fill[r]
+		// filler lines above r, then r itself
+		if top <= v+f {
+			return r, max(top-v, 0)
+		}
+		v += f + 1
+	}
+	return max(E.numrows-1, 0), 0
+}
+
+// diffCounterpart is the row of the other buffer that row y lines up with
+func diffCounterpart(hunks []gitHunk, y int) int {
+	delta := 0
+	for _, h := range hunks {
+		if y < h.start {
+			break
+		}
+		if y < h.start+h.count {
+			return h.hstart + min(y-h.start, max(h.hcount-1, 0))
+		}
+		delta += h.hcount - h.count
+	}
+	return max(y+delta, 0)
+}
+
+// editorDiffSyncWindow lines w up with the current window, both being diff windows
+func editorDiffSyncWindow(w *window) {
+	if E.diff == nil || E.diff.other != w.buf {
+		return
+	}
+	top, cy := editorDiffTop(), diffCounterpart(E.diff.hunks, E.cy)
+	editorInBuffer(w.buf, func() {
+		if E.diff == nil {
+			return
+		}
+		w.rowoff, w.topskip = editorDiffScrollTo(top)
+		w.cy = min(cy, max(E.numrows-1, 0))
+	})
+}
+
+// editorDiffThis makes the current window a diff window
+func editorDiffThis() {
+	curwin.diff = true
+	curwin.scrollbind = true
+}
+
+// editorDiffOff ends diff mode in the windows of the tab
+func editorDiffOff() {
+	for _, w := range editorWindows() {
+		if w.diff {
+			w.diff = false
+			w.scrollbind = false
+		}
+	}
+}
+
+// editorDiffSplit is :diffsplit, which compares the current buffer with file
+// in a window on its left
+func editorDiffSplit(file string) {
+	if file == "" {
+		editorSetStatusMessage("usage: :diffsplit file")
+		return
+	}
+	editorDiffThis()
+	if editorSplit(true) == nil {
+		return
+	}
+	editorEdit(file)
+	editorDiffThis()
+}
+
+// editorDiffHunk is the hunk the cursor is in, for do and dp
+func editorDiffHunk() (gitHunk, bool) {
+	h, ok := editorGitHunkAt(editorDiffHunks(), E.cy)
+	if !ok {
+		editorSetStatusMessage("no difference here")
+	}
+	return h, ok
+}
+
+// editorDiffObtain is do, the hunk under the cursor becomes what the other buffer has
+func editorDiffObtain() {
+	if !editorWritable() {
+		return
+	}
+	h, ok := editorDiffHunk()
+	if !ok {
+		return
+	}
+	var theirs []string
+	editorInBuffer(E.diff.other, func() {
+		theirs = editorBufferLines()[h.hstart : h.hstart+h.hcount]
+	})
+	editorDiffReplace(h.start, h.count, theirs)
+	E.cy = min(h.start, max(E.numrows-1, 0))
+	E.cx = editorFirstNonBlank(E.cy)
+}
+
+// editorDiffPut is dp, the other buffer gets this one's side of the hunk under the cursor
+func editorDiffPut() {
+	h, ok := editorDiffHunk()
+	if !ok {
+		return
+	}
+	mine := editorBufferLines()[h.start : h.start+h.count]
+	editorInBuffer(E.diff.other, func() {
+		if editorWritable() {
+			editorDiffReplace(h.hstart, h.hcount, mine)
+		}
+	})
+}
+
+// editorDiffReplace puts lines in place of count rows from start
+func editorDiffReplace(start, count int, lines []string) {
+	cur := editorBufferLines()
+	next := append([]string(nil), cur[:start]...)
+	next = append(next, lines...)
+	next = append(next, cur[start+count:]...)
+	editorSetLines(next)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testDiffWindows compares left, in a new window on the left that's current, with right
+func testDiffWindows(t *testing.T, left, right []string) {
+	t.Helper()
+	testBuffers(t, right...)
+	testTerminal(t, 60, 12)
+	editorLayout()
+	editorDiffThis()
+	if editorSplit(true) == nil {
+		t.Fatal("no room to split")
+	}
+	editorAddBuffer()
+	editorFillBuffer(left)
+	editorDiffThis()
+	editorDiffHunks()
+}
+
+// testOtherLines is the text of the other diff buffer
+func testOtherLines() []string {
+	var lines []string
+	editorInBuffer(E.diff.other, func() { lines = editorBufferLines() })
+	return lines
+}
+
+func TestDiffFiller(t *testing.T) {
+	testDiffWindows(t, []string{"a", "B", "c", "e", "x", "y"}, []string{"a", "b", "c", "d", "e"})
+	if E.diff == nil || E.diff.other != 0 {
+		t.Fatal("the buffers aren't compared")
+	}
+	// d is only on the right, so the left has a filler line for it above e
+	if want := map[int]int{3: 1}; !maps.Equal(E.diff.fill, want) {
+		t.Errorf("filler lines on the left are %v, want %v", E.diff.fill, want)
+	}
+	if want := map[int]hlGroup{1: HG_DIFFCHANGELINE, 4: HG_DIFFADDLINE, 5: HG_DIFFADDLINE}; !maps.Equal(E.diff.kind, want) {
+		t.Errorf("rows that differ on the left are %v, want %v", E.diff.kind, want)
+	}
+	// and x and y are only on the left, so the right has two after its last row
+	editorInBuffer(0, func() {
+		if want := map[int]int{5: 2}; !maps.Equal(E.diff.fill, want) {
+			t.Errorf("filler lines on the right are %v, want %v", E.diff.fill, want)
+		}
+		if t0 := E.diff.text[1]; t0 != [2]int{0, 1} {
+			t.Errorf("changed text of b is %v", t0)
+		}
+	})
+	for y, want := range []int{0, 1, 2, 4, 5, 5} {
+		if got := diffCounterpart(E.diff.hunks, y); got != want {
+			t.Errorf("row %d lines up with %d on the right, want %d", y, got, want)
+		}
+	}
+
+	// on the screen the rows of both sides line up
+	editorRefreshScreen()
+	for y, want := range [][2]string{{"a", "a"}, {"B", "b"}, {"c", "c"}, {"-", "d"}, {"e", "e"}, {"x", "-"}, {"y", "-"}} {
+		cells := testCells(y)
+		left := strings.TrimSpace(strings.Join(cells[:curwin.w], ""))
+		right := strings.TrimSpace(strings.Join(cells[curwin.w+1:], ""))
+		if !strings.HasSuffix(left, want[0]) || !strings.HasSuffix(right, want[1]) {
+			t.Errorf("line %d is %q and %q, want %s and %s", y, left, right, want[0], want[1])
+		}
+	}
+}
+
+func TestDiffObtainPut(t *testing.T) {
+	testDiffWindows(t, []string{"a", "B", "c", "e", "x", "y"}, []string{"a", "b", "c", "d", "e"})
+
+	E.cy = 1
+	editorDiffObtain()
+	if got, want := editorBufferLines(), []string{"a", "b", "c", "e", "x", "y"}; !slices.Equal(got, want) {
+		t.Errorf("left after do on B is %q, want %q", got, want)
+	}
+	// a line only the other side has is got from the row above where it'd be
+	E.cy = 2
+	editorDiffObtain()
+	if got, want := editorBufferLines(), []string{"a", "b", "c", "d", "e", "x", "y"}; !slices.Equal(got, want) {
+		t.Errorf("left after do on c is %q, want %q", got, want)
+	}
+	E.cy = 5
+	editorDiffPut()
+	if got, want := testOtherLines(), []string{"a", "b", "c", "d", "e", "x", "y"}; !slices.Equal(got, want) {
+		t.Errorf("right after dp on x is %q, want %q", got, want)
+	}
+	if hunks := editorDiffHunks(); len(hunks) != 0 {
+		t.Errorf("hunks %+v are left", hunks)
+	}
+	editorDiffObtain()
+	if E.statusmsg != "no difference here" {
+		t.Errorf("do with nothing to get said %q", E.statusmsg)
+	}
+
+	// what's read only isn't changed
+	editorInBuffer(E.diff.other, func() { E.readonly = true })
+	editorSetLines([]string{"z"})
+	E.cy = 0
+	editorDiffPut()
+	if got := testOtherLines(); len(got) != 7 {
+		t.Errorf("dp changed a read only buffer to %q", got)
+	}
+}
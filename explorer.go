@@ -0,0 +1,461 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// an explorer buffer lists a directory, like netrw. directories open in place
+// as a tree, files open in the window
+type explorer struct {
+	dir      string // absolute
+	sort     string // name, time or size
+	reverse  bool
+	hidden   bool // show dot files
+	expanded map[string]bool
+	entries  []explorerEntry // one for each row after the header
+}
+
+type explorerEntry struct {
+	path  string // absolute
+	name  string
+	depth int
+	dir   bool
+	size  int64
+	mtime time.Time
+}
+
+// the header is the directory and the keys
+const EXPLORER_HEADER = 2
+
+var explorerSorts = []string{"name", "time", "size"}
+
+// editorOpenExplorer makes the current buffer a listing of dir
+func editorOpenExplorer(dir string) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		abs = dir
+	}
+	E.filename = dir
+	E.buftype = "explorer"
+	E.readonly = true
+	E.explorer = &explorer{dir: abs, sort: "name", expanded: map[string]bool{}}
+	editorExplorerRender()
+}
+
+// editorExplore is :Explore [dir], the directory of the current file when there's none
+func editorExplore(dir string) {
+	if dir == "" {
+		dir = "."
+		if E.filename != "" && E.buftype == "" {
+			dir = filepath.Dir(E.filename)
+		}
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		editorSetStatusMessage("not a directory: %s", dir)
+		return
+	}
+	editorEdit(dir)
+}
+
+// editorExplorerRead lists dir and, below each expanded directory, what's in it
+func editorExplorerRead(ex *explorer, dir string, depth int) []explorerEntry {
+	list, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var entries []explorerEntry
+	for _, d := range list {
+		if !ex.hidden && strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		e := explorerEntry{path: filepath.Join(dir, d.Name()), name: d.Name(), depth: depth}
+		if info, err := os.Stat(e.path); err == nil {
+			// links show as what they point to
+			e.dir, e.size, e.mtime = info.IsDir(), info.Size(), info.ModTime()
+		}
+		entries = append(entries, e)
+	}
+
+	sort.SliceStable(entries, func(a, b int) bool {
+		x, y := entries[a], entries[b]
+		if x.dir != y.dir {
+			return x.dir
+		}
+		if ex.reverse {
+			x, y = y, x
+		}
+		switch ex.sort {
+		case "time":
+			if !x.mtime.Equal(y.mtime) {
+				return x.mtime.After(y.mtime)
+			}
+		case "size":
+			if x.size != y.size {
+				return x.size > y.size
+			}
+		}
+		return strings.ToLower(x.name) < strings.ToLower(y.name)
+	})
+
+	var out []explorerEntry
+	for _, e := range entries {
+		out = append(out, e)
+		if e.dir && ex.expanded[e.path] {
+			out = append(out, editorExplorerRead(ex, e.path, depth+1)...)
+		}
+	}
+	return out
+}
+
+// explorerSize is a size for people, like 12K
+func explorerSize(n int64) string {
+	units := "BKMGT"
+	f := float64(n)
+	i := 0
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d", n)
+	}
+	return fmt.Sprintf("%.1f%c", f, units[i])
+}
+
+// editorExplorerRender lists the directory again, keeping the cursor on the same entry
+func editorExplorerRender() {
+	ex := E.explorer
+	current := ""
+	if e, ok := editorExplorerEntry(); ok {
+		current = e.path
+	}
+
+	ex.entries = append([]explorerEntry{{path: filepath.Dir(ex.dir), name: "..", dir: true}}, editorExplorerRead(ex, ex.dir, 0)...)
+
+	order := ex.sort
+	if ex.reverse {
+		order += ", reversed"
+	}
+	if ex.hidden {
+		order += ", hidden files shown"
+	}
+	lines := []string{
+		fmt.Sprintf("\" %s%c  (sorted by %s)", ex.dir, filepath.Separator, order),
+		"\" enter:open -:up s:sort r:reverse gh:hidden %:new file d:new dir R:rename C:copy D:delete",
+	}
+	width := 0
+	for _, e := range ex.entries {
+		width = max(width, 2*e.depth+len(e.name)+1)
+	}
+	width = min(width, 50)
+	for _, e := range ex.entries {
+		name := strings.Repeat("| ", e.depth) + e.name
+		if e.dir {
+			lines = append(lines, name+"/")
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%-*s %8s  %s", width, name, explorerSize(e.size), e.mtime.Format("2006-01-02 15:04")))
+	}
+	editorFillBuffer(lines)
+
+	E.cy = min(E.cy, E.numrows-1)
+	for i, e := range ex.entries {
+		if e.path == current && i > 0 {
+			E.cy = EXPLORER_HEADER + i
+		}
+	}
+	if E.cy < EXPLORER_HEADER {
+		E.cy = min(EXPLORER_HEADER+1, E.numrows-1)
+	}
+	E.cx = 0
+}
+
+// editorExplorerEntry is the entry under the cursor
+func editorExplorerEntry() (explorerEntry, bool) {
+	i := E.cy - EXPLORER_HEADER
+	if E.explorer == nil || i < 0 || i >= len(E.explorer.entries) {
+		return explorerEntry{}, false
+	}
+	return E.explorer.entries[i], true
+}
+
+// editorExplorerTarget is the directory new files go in: the one under the
+// cursor when it's open, else the one the entry under the cursor is in
+func editorExplorerTarget() string {
+	e, ok := editorExplorerEntry()
+	switch {
+	case !ok || e.name == "..":
+		return E.explorer.dir
+	case e.dir && E.explorer.expanded[e.path]:
+		return e.path
+	}
+	return filepath.Dir(e.path)
+}
+
+// editorRelPath is path relative to the working directory when it's below it
+func editorRelPath(path string) string {
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// editorExplorerChdir shows dir in the same buffer
+func editorExplorerChdir(dir string) {
+	E.explorer.dir = dir
+	E.filename = editorRelPath(dir)
+	if E.filename == "." || E.filename == "" {
+		E.filename = dir
+	}
+	E.cy = 0
+	editorExplorerRender()
+}
+
+// editorExplorerKey handles the keys of an explorer buffer in NORMAL mode, it
+// says whether it took the key
+func editorExplorerKey(c int) bool {
+	ex := E.explorer
+	switch c {
+	case '\r', 'o', 'v', 't':
+		e, ok := editorExplorerEntry()
+		if !ok {
+			return true
+		}
+		if e.name == ".." {
+			editorExplorerChdir(e.path)
+			return true
+		}
+		if e.dir && c == '\r' {
+			ex.expanded[e.path] = !ex.expanded[e.path]
+			editorExplorerRender()
+			return true
+		}
+		switch c {
+		case 'o':
+			if editorSplit(false) == nil {
+				return true
+			}
+		case 'v':
+			if editorSplit(true) == nil {
+				return true
+			}
+		case 't':
+			editorTabNew()
+		}
+		editorEdit(editorRelPath(e.path))
+	case '-':
+		editorExplorerChdir(filepath.Dir(ex.dir))
+	case 's':
+		for i, name := range explorerSorts {
+			if name == ex.sort {
+				ex.sort = explorerSorts[(i+1)%len(explorerSorts)]
+				break
+			}
+		}
+		editorExplorerRender()
+	case 'r':
+		ex.reverse = !ex.reverse
+		editorExplorerRender()
+	case CONTROL_KEY('l'):
+		editorExplorerRender()
+	case '%', 'd':
+		editorExplorerCreate(c == 'd')
+	case 'R':
+		editorExplorerRename()
+	case 'C':
+		editorExplorerCopy()
+	case 'D':
+		editorExplorerDelete()
+	default:
+		return false
+	}
+	return true
+}
+
+func editorExplorerToggleHidden() {
+	E.explorer.hidden = !E.explorer.hidden
+	editorExplorerRender()
+}
+
+// editorExplorerCreate asks for a name and makes an empty file or a directory by it
+func editorExplorerCreate(dir bool) {
+	target := editorExplorerTarget()
+	what := "file"
+	if dir {
+		what = "directory"
+	}
+	name := editorPrompt("new "+what+": %s (ESC to cancel)", nil, nil)
+	if name == "" {
+		return
+	}
+	path := filepath.Join(target, name)
+	var err error
+	if dir {
+		err = os.MkdirAll(path, 0755)
+	} else {
+		var f *os.File
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); err == nil {
+			f.Close()
+		}
+	}
+	if err != nil {
+		editorSetStatusMessage("%s", err)
+		return
+	}
+	E.explorer.expanded[target] = true
+	editorExplorerRender()
+	editorExplorerSelect(path)
+}
+
+// editorExplorerSelect puts the cursor on the entry for path
+func editorExplorerSelect(path string) {
+	for i, e := range E.explorer.entries {
+		if e.path == path && i > 0 {
+			E.cy = EXPLORER_HEADER + i
+		}
+	}
+}
+
+// editorExplorerRename moves the entry under the cursor. a name without a
+// directory stays in the same one. buffers open on it follow it
+func editorExplorerRename() {
+	e, ok := editorExplorerEntry()
+	if !ok || e.name == ".." {
+		return
+	}
+	name := editorPromptWith("rename to: %s (ESC to cancel)", e.name, nil, nil)
+	if name == "" || name == e.name {
+		return
+	}
+	path := editorExplorerDest(e, name)
+	if _, err := os.Lstat(path); err == nil {
+		editorSetStatusMessage("%s already exists", name)
+		return
+	}
+	if err := os.Rename(e.path, path); err != nil {
+		editorSetStatusMessage("%s", err)
+		return
+	}
+	for i := range buffers {
+		editorInBuffer(i, func() {
+			if E.buftype != "" || E.filename == "" {
+				return
+			}
+			abs, err := filepath.Abs(E.filename)
+			if err != nil {
+				return
+			}
+			if rel, err := filepath.Rel(e.path, abs); err == nil && !strings.HasPrefix(rel, "..") {
+				E.filename = editorRelPath(filepath.Join(path, rel))
+			}
+		})
+	}
+	editorExplorerRender()
+	editorExplorerSelect(path)
+}
+
+// editorExplorerDest is where name goes for an operation on e: next to e unless it's a path of its own
+func editorExplorerDest(e explorerEntry, name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(e.path), name)
+}
+
+// editorExplorerCopy copies the entry under the cursor, a directory with everything in it
+func editorExplorerCopy() {
+	e, ok := editorExplorerEntry()
+	if !ok || e.name == ".." {
+		return
+	}
+	name := editorPromptWith("copy to: %s (ESC to cancel)", e.name, nil, nil)
+	if name == "" || name == e.name {
+		return
+	}
+	path := editorExplorerDest(e, name)
+	if _, err := os.Lstat(path); err == nil {
+		editorSetStatusMessage("%s already exists", name)
+		return
+	}
+	if err := copyTree(e.path, path); err != nil {
+		editorSetStatusMessage("%s", err)
+		return
+	}
+	editorExplorerRender()
+	editorExplorerSelect(path)
+}
+
+// copyTree copies the file or directory src to dst, keeping the modes
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		if !info.Mode().IsRegular() {
+			// links and the like are made again, not followed
+			if link, err := os.Readlink(path); err == nil {
+				return os.Symlink(link, target)
+			}
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// editorExplorerDelete removes the entry under the cursor once it's confirmed
+func editorExplorerDelete() {
+	e, ok := editorExplorerEntry()
+	if !ok || e.name == ".." {
+		return
+	}
+	question := "delete %s? (y/n)"
+	if e.dir {
+		question = "delete the directory %s and everything in it? (y/n)"
+	}
+	if editorAsk(question, editorRelPath(e.path)) != 'y' {
+		editorSetStatusMessage("nothing deleted")
+		return
+	}
+	var err error
+	if e.dir {
+		err = os.RemoveAll(e.path)
+	} else {
+		err = os.Remove(e.path)
+	}
+	if err != nil {
+		editorSetStatusMessage("%s", err)
+		return
+	}
+	delete(E.explorer.expanded, e.path)
+	editorExplorerRender()
+	editorSetStatusMessage("deleted %s", editorRelPath(e.path))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testExplorerDo runs the explorer key c on the entry for path, answering its prompt with answer
+func testExplorerDo(t *testing.T, path string, c int, answer string) {
+	t.Helper()
+	editorExplorerSelect(path)
+	if e, ok := editorExplorerEntry(); !ok || e.path != path {
+		t.Fatalf("%s isn't listed", path)
+	}
+	for _, r := range answer {
+		unreadKeys = append(unreadKeys, int(r))
+	}
+	editorExplorerKey(c)
+	if len(unreadKeys) != 0 {
+		t.Fatalf("%q weren't all taken by the prompt", answer)
+	}
+}
+
+// testRetype is the keys that take name out of a prompt and type text instead
+func testRetype(name, text string) string {
+	return strings.Repeat(string(rune(BACKSPACE)), len(name)) + text
+}
+
+func testExplorerNames() []string {
+	var names []string
+	for _, e := range E.explorer.entries {
+		names = append(names, strings.Repeat("| ", e.depth)+e.name)
+	}
+	return names
+}
+
+func TestExplorerFileOperations(t *testing.T) {
+	dir := t.TempDir()
+	for name, text := range map[string]string{"a.txt": "hello", "sub/b.txt": "inside"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testBuffers(t)
+	testTerminal(t, 80, 24)
+	// a buffer open on the file being renamed follows it
+	buffers = append(buffers, EditorConfig{filename: filepath.Join(dir, "a.txt")})
+	editorOpenExplorer(dir)
+	if got, want := testExplorerNames(), []string{"..", "sub", "a.txt"}; !slices.Equal(got, want) {
+		t.Fatalf("entries are %q, want %q", got, want)
+	}
+
+	testExplorerDo(t, filepath.Join(dir, "a.txt"), 'R', testRetype("a.txt", "c.txt\r"))
+	if _, err := os.Stat(filepath.Join(dir, "c.txt")); err != nil {
+		t.Errorf("a.txt wasn't renamed: %v", err)
+	}
+	if e, _ := editorExplorerEntry(); e.name != "c.txt" {
+		t.Errorf("cursor is on %s after the rename", e.name)
+	}
+	if got := buffers[1].filename; got != filepath.Join(dir, "c.txt") {
+		t.Errorf("the buffer on a.txt is on %s after the rename", got)
+	}
+	// nothing is renamed over
+	testExplorerDo(t, filepath.Join(dir, "c.txt"), 'R', testRetype("c.txt", "sub\r"))
+	if E.statusmsg != "sub already exists" {
+		t.Errorf("status after renaming onto sub is %q", E.statusmsg)
+	}
+
+	testExplorerDo(t, filepath.Join(dir, "sub"), 'C', testRetype("sub", "copy\r"))
+	if b, err := os.ReadFile(filepath.Join(dir, "copy", "b.txt")); err != nil || string(b) != "inside" {
+		t.Errorf("copy of sub/b.txt is %q, %v", b, err)
+	}
+
+	// the new file goes in an open directory under the cursor
+	E.explorer.expanded[filepath.Join(dir, "copy")] = true
+	editorExplorerRender()
+	testExplorerDo(t, filepath.Join(dir, "copy"), '%', "new.txt\r")
+	if _, err := os.Stat(filepath.Join(dir, "copy", "new.txt")); err != nil {
+		t.Errorf("new.txt wasn't made in copy: %v", err)
+	}
+	if got, want := testExplorerNames(), []string{"..", "copy", "| b.txt", "| new.txt", "sub", "c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("entries are %q, want %q", got, want)
+	}
+
+	testExplorerDo(t, filepath.Join(dir, "copy"), 'D', "n")
+	if _, err := os.Stat(filepath.Join(dir, "copy")); err != nil || E.statusmsg != "nothing deleted" {
+		t.Errorf("copy is %v after saying no, status %q", err, E.statusmsg)
+	}
+	testExplorerDo(t, filepath.Join(dir, "copy"), 'D', "y")
+	if _, err := os.Stat(filepath.Join(dir, "copy")); !os.IsNotExist(err) {
+		t.Errorf("copy is still there: %v", err)
+	}
+	if got, want := testExplorerNames(), []string{"..", "sub", "c.txt"}; !slices.Equal(got, want) {
+		t.Errorf("entries after deleting are %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// the finder is an overlay to open a file by typing part of its path. the
+// files under the directory are gathered in the background and the list
+// narrows down with every key, the best match first, with a preview of the
+// selected file beside it
+var finder struct {
+	active  bool
+	query   string
+	files   []string
+	shown   []int // indexes into files that match query, best first
+	sel     int
+	off     int
+	walking bool
+	walk    int // which walk the files come from, batches of an old one are dropped
+	stop    chan struct{}
+
+	preview     []string
+	previewName string
+}
+
+// how often the files found so far are added to the list
+const FINDER_BATCH_INTERVAL = 50 * time.Millisecond
+
+// the most lines of a file read for the preview
+const FINDER_PREVIEW_LINES = 200
+
+// editorFinderOpen starts the finder on the files under dir
+func editorFinderOpen(dir string) {
+	if dir == "" {
+		dir = "."
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		editorSetStatusMessage("not a directory: %s", dir)
+		return
+	}
+	editorFinderClose()
+	finder.active = true
+	finder.query = ""
+	finder.files = nil
+	finder.shown = nil
+	finder.sel, finder.off = 0, 0
+	finder.walking = true
+	finder.walk++
+	finder.stop = make(chan struct{})
+
+	walk, stop := finder.walk, finder.stop
+	found := make(chan string, 256)
+	walkFiles(dir, found, stop)
+	go func() {
+		var batch []string
+		tick := time.NewTicker(FINDER_BATCH_INTERVAL)
+		defer tick.Stop()
+		send := func(done bool) {
+			files := batch
+			batch = nil
+			select {
+			case events <- func() { editorFinderAdd(walk, files, done) }:
+			case <-stop:
+			}
+		}
+		for {
+			select {
+			case f, ok := <-found:
+				if !ok {
+					send(true)
+					return
+				}
+				batch = append(batch, f)
+			case <-tick.C:
+				if len(batch) > 0 {
+					send(false)
+				}
+			}
+		}
+	}()
+}
+
+func editorFinderClose() {
+	if finder.stop != nil {
+		close(finder.stop)
+		finder.stop = nil
+	}
+	finder.active = false
+	finder.files = nil
+	finder.shown = nil
+	finder.preview = nil
+	finder.previewName = ""
+}
+
+// editorFinderAdd takes a batch of files from the walk
+func editorFinderAdd(walk int, files []string, done bool) {
+	if !finder.active || walk != finder.walk {
+		return
+	}
+	finder.files = append(finder.files, files...)
+	if done {
+		finder.walking = false
+	}
+	editorFinderFilter(true)
+}
+
+// editorFinderFilter ranks the files against the query. a match in the file's
+// own name counts for more than one spread over its directories. with keep
+// the selected file stays selected, for when more files came in
+func editorFinderFilter(keep bool) {
+	selected := ""
+	if finder.sel < len(finder.shown) {
+		selected = finder.files[finder.shown[finder.sel]]
+	}
+
+	type match struct{ i, score int }
+	matches := make([]match, 0, len(finder.files))
+	for i, f := range finder.files {
+		score, ok := fuzzyMatch(finder.query, f)
+		if !ok {
+			continue
+		}
+		if s, ok := fuzzyMatch(finder.query, filepath.Base(f)); ok {
+			score = max(score, s)
+		}
+		matches = append(matches, match{i, score})
+	}
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].score != matches[b].score {
+			return matches[a].score > matches[b].score
+		}
+		return finder.files[matches[a].i] < finder.files[matches[b].i]
+	})
+
+	finder.shown = finder.shown[:0]
+	finder.sel = 0
+	for n, m := range matches {
+		finder.shown = append(finder.shown, m.i)
+		if keep && finder.files[m.i] == selected {
+			finder.sel = n
+		}
+	}
+	editorFinderPreview()
+}
+
+// editorFinderPreview reads the start of the selected file
+func editorFinderPreview() {
+	name := ""
+	if finder.sel < len(finder.shown) {
+		name = finder.files[finder.shown[finder.sel]]
+	}
+	if name == finder.previewName {
+		return
+	}
+	finder.previewName = name
+	finder.preview = nil
+	if name == "" {
+		return
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		finder.preview = []string{err.Error()}
+		return
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	if head, _ := r.Peek(8 * 1024); editorIsBinary(head) {
+		finder.preview = []string{"binary file"}
+		return
+	}
+	sc := bufio.NewScanner(r)
+	for len(finder.preview) < FINDER_PREVIEW_LINES && sc.Scan() {
+		line := strings.TrimSuffix(sc.Text(), "\r")
+		finder.preview = append(finder.preview, strings.ReplaceAll(line, "\t", strings.Repeat(" ", TAB_STOP)))
+	}
+}
+
+// editorFinderKey handles the keys while the finder is open: typing narrows
+// the list, Enter opens the file in the current window, Ctrl-S in a split,
+// Ctrl-V in a vertical split and Ctrl-T in a new tab
+func editorFinderKey(c int) {
+	switch c {
+	case '\x1b', CONTROL_KEY('c'):
+		editorFinderClose()
+	case '\r', CONTROL_KEY('s'), CONTROL_KEY('x'), CONTROL_KEY('v'), CONTROL_KEY('t'):
+		if finder.sel >= len(finder.shown) {
+			return
+		}
+		name := finder.files[finder.shown[finder.sel]]
+		editorFinderClose()
+		switch c {
+		case CONTROL_KEY('s'), CONTROL_KEY('x'):
+			if editorSplit(false) == nil {
+				return
+			}
+		case CONTROL_KEY('v'):
+			if editorSplit(true) == nil {
+				return
+			}
+		case CONTROL_KEY('t'):
+			editorTabNew()
+		}
+		editorEdit(name)
+	case CONTROL_KEY('n'), CONTROL_KEY('j'), ARROW_DOWN:
+		if finder.sel+1 < len(finder.shown) {
+			finder.sel++
+		}
+	case CONTROL_KEY('p'), CONTROL_KEY('k'), ARROW_UP:
+		if finder.sel > 0 {
+			finder.sel--
+		}
+	case BACKSPACE, CONTROL_KEY('h'):
+		if finder.query != "" {
+			_, size := utf8.DecodeLastRuneInString(finder.query)
+			finder.query = finder.query[:len(finder.query)-size]
+			editorFinderFilter(false)
+		}
+	case CONTROL_KEY('u'):
+		finder.query = ""
+		editorFinderFilter(false)
+	default:
+		if c >= ' ' && c < 127 {
+			finder.query += string(rune(c))
+			editorFinderFilter(false)
+		}
+	}
+	editorFinderPreview()
+}
+
+// editorDrawFinder draws the finder over the windows: the query on top, the
+// matches under it and the preview on the right when there's room
+func editorDrawFinder() {
+	if !finder.active {
+		return
+	}
+	left, top, width, height := 2, 1, termWidth-4, termHeight-3
+	if width < 30 || height < 5 {
+		left, top, width, height = 0, 0, termWidth, termHeight-1
+	}
+	st := hlStyle(HG_POPUP)
+	for y := 0; y < height; y++ {
+		screenFill(left, top+y, width, st)
+	}
+
+	prompt := "> " + finder.query
+	count := fmt.Sprintf("%d/%d", len(finder.shown), len(finder.files))
+	if finder.walking {
+		count += "+"
+	}
+	screenPutString(left+1, top, prompt, st)
+	screenPutString(left+width-1-len(count), top, count, st)
+
+	listw := width
+	if width >= 60 {
+		listw = width * 2 / 5
+	}
+	rows := height - 1
+	if finder.sel < finder.off {
+		finder.off = finder.sel
+	} else if finder.sel >= finder.off+rows {
+		finder.off = finder.sel - rows + 1
+	}
+	for y := 0; y < rows; y++ {
+		n := finder.off + y
+		if n >= len(finder.shown) {
+			break
+		}
+		// long paths lose their start, the file name matters most
+		name := []rune(finder.files[finder.shown[n]])
+		if len(name) > listw-2 && listw > 3 {
+			name = append([]rune{'…'}, name[len(name)-(listw-3):]...)
+		}
+		screenPutString(left+1, top+1+y, string(name), st)
+		if n == finder.sel {
+			screenTint(left, top+1+y, listw, editorTint(HG_SELECTION))
+		}
+	}
+
+	if listw < width {
+		px := left + listw + 1
+		pw := width - listw - 2
+		for y := 0; y < rows; y++ {
+			screenPutString(left+listw, top+1+y, "│", st)
+			if y < len(finder.preview) {
+				line := []rune(finder.preview[y])
+				if len(line) > pw {
+					line = line[:pw]
+				}
+				screenPutString(px, top+1+y, string(line), st)
+			}
+		}
+	}
+	screenSetCursor(left+1+utf8.RuneCountInString(prompt), top)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testFinder opens the finder on files without walking a directory
+func testFinder(t *testing.T, files ...string) {
+	t.Helper()
+	t.Cleanup(editorFinderClose)
+	editorFinderClose()
+	finder.active, finder.walking = true, true
+	finder.query, finder.files = "", files
+	finder.sel, finder.off = 0, 0
+	editorFinderFilter(false)
+}
+
+func testFinderShown() []string {
+	var shown []string
+	for _, i := range finder.shown {
+		shown = append(shown, finder.files[i])
+	}
+	return shown
+}
+
+func TestFinderRanking(t *testing.T) {
+	testFinder(t, "src/domain/x.go", "other.txt", "pkg/main.go", "main_test.go", "lib/remaining.go", "cmd/main.go")
+	for _, c := range "main" {
+		editorFinderKey(int(c))
+	}
+	// a match in the file name first, shorter names before longer ones and ties by path
+	want := []string{"cmd/main.go", "pkg/main.go", "main_test.go", "lib/remaining.go", "src/domain/x.go"}
+	if got := testFinderShown(); !slices.Equal(got, want) {
+		t.Errorf("matches for main are %q, want %q", got, want)
+	}
+
+	// the selected file stays selected as more come in
+	editorFinderKey(ARROW_DOWN)
+	editorFinderKey(ARROW_DOWN)
+	editorFinderAdd(finder.walk, []string{"a/main.go"}, true)
+	if got := finder.files[finder.shown[finder.sel]]; got != "main_test.go" || finder.walking {
+		t.Errorf("selected %s after more files came in, walking %v", got, finder.walking)
+	}
+	// but files from an earlier walk are dropped
+	editorFinderAdd(finder.walk-1, []string{"old/main.go"}, false)
+	if slices.Contains(finder.files, "old/main.go") {
+		t.Error("a file from an earlier walk was added")
+	}
+
+	editorFinderKey(BACKSPACE)
+	if finder.query != "mai" || finder.sel != 0 {
+		t.Errorf("query after backspace is %q with %d selected", finder.query, finder.sel)
+	}
+	editorFinderKey(CONTROL_KEY('u'))
+	if finder.query != "" || len(finder.shown) != len(finder.files) {
+		t.Errorf("%d of %d files shown without a query", len(finder.shown), len(finder.files))
+	}
+}
+
+func TestFinderPreview(t *testing.T) {
+	dir := t.TempDir()
+	text, binary := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.bin")
+	if err := os.WriteFile(text, []byte("\tone\r\ntwo\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(binary, []byte("x\x00y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testFinder(t, text, binary)
+	want := []string{strings.Repeat(" ", TAB_STOP) + "one", "two"}
+	if !slices.Equal(finder.preview, want) {
+		t.Errorf("preview is %q, want %q", finder.preview, want)
+	}
+	editorFinderKey(ARROW_DOWN)
+	if !slices.Equal(finder.preview, []string{"binary file"}) {
+		t.Errorf("preview of a binary file is %q", finder.preview)
+	}
+}
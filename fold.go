@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FOLDMETHOD is how folds are found: indent, syntax (brackets outside strings
+// and comments), marker ({{{ and }}}) or none
+var FOLDMETHOD = "indent"
+
+// a fold covers rows start to end. the start row stays visible as the fold line when it's closed
+type fold struct {
+	start, end int
+}
+
+// editorUpdateFolds finds the folds again when the text or the method changed since the last time
+func editorUpdateFolds() {
+	if E.folds_tick == E.changetick && E.folds_method == FOLDMETHOD {
+		return
+	}
+	E.folds_tick = E.changetick
+	E.folds_method = FOLDMETHOD
+
+	switch FOLDMETHOD {
+	case "indent":
+		E.folds = editorIndentFolds()
+	case "syntax":
+		E.folds = editorSyntaxFolds()
+	case "marker":
+		E.folds = editorMarkerFolds()
+	default:
+		E.folds = nil
+	}
+	// outer folds before the ones nested in them
+	sort.Slice(E.folds, func(i, j int) bool {
+		if E.folds[i].start != E.folds[j].start {
+			return E.folds[i].start < E.folds[j].start
+		}
+		return E.folds[i].end > E.folds[j].end
+	})
+
+	for start := range E.closed {
+		if editorFoldStartingAt(start) < 0 {
+			delete(E.closed, start)
+		}
+	}
+	editorUpdateClosedFolds()
+}
+
+func editorIndentOf(row *erow) (int, bool) {
+	indent := 0
+	for _, c := range row.chars {
+		switch c {
+		case ' ':
+			indent++
+		case '\t':
+			indent += TAB_STOP - indent%TAB_STOP
+		default:
+			return indent, true
+		}
+	}
+	return 0, false
+}
+
+// editorIndentFolds folds every row together with the more indented rows under it
+func editorIndentFolds() []fold {
+	type open struct{ row, indent int }
+	var folds []fold
+	var stack []open
+	last := -1
+	for y := range E.row {
+		indent, ok := editorIndentOf(&E.row[y])
+		if !ok {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if last > top.row {
+				folds = append(folds, fold{top.row, last})
+			}
+		}
+		stack = append(stack, open{y, indent})
+		last = y
+	}
+	for _, top := range stack {
+		if last > top.row {
+			folds = append(folds, fold{top.row, last})
+		}
+	}
+	return folds
+}
+
+// editorSyntaxFolds folds from a row with an unclosed bracket to the row that closes it
+func editorSyntaxFolds() []fold {
+	ends := map[int]int{}
+	var stack []int
+	for y := range E.row {
+		row := &E.row[y]
+		for x, c := range row.chars {
+			if c != '{' && c != '[' && c != '(' && c != '}' && c != ']' && c != ')' {
+				continue
+			}
+			if E.syntax != nil && isStringOrComment(editorHlAt(row, x)) {
+				continue
+			}
+			switch c {
+			case '{', '[', '(':
+				stack = append(stack, y)
+			default:
+				if len(stack) == 0 {
+					continue
+				}
+				start := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if y > start && y > ends[start] {
+					ends[start] = y
+				}
+			}
+		}
+	}
+	return editorFoldsFromEnds(ends)
+}
+
+func editorMarkerFolds() []fold {
+	ends := map[int]int{}
+	var stack []int
+	for y := range E.row {
+		line := string(E.row[y].chars)
+		if strings.Contains(line, "{{{") {
+			stack = append(stack, y)
+		}
+		if strings.Contains(line, "}}}") && len(stack) > 0 {
+			start := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if y > start {
+				ends[start] = y
+			}
+		}
+	}
+	return editorFoldsFromEnds(ends)
+}
+
+func editorFoldsFromEnds(ends map[int]int) []fold {
+	folds := make([]fold, 0, len(ends))
+	for start, end := range ends {
+		folds = append(folds, fold{start, end})
+	}
+	return folds
+}
+
+func editorFoldStartingAt(start int) int {
+	i := sort.Search(len(E.folds), func(i int) bool { return E.folds[i].start >= start })
+	if i < len(E.folds) && E.folds[i].start == start {
+		return i
+	}
+	return -1
+}
+
+// editorUpdateClosedFolds collects the outermost closed folds, which are what hides rows
+func editorUpdateClosedFolds() {
+	E.closed_folds = E.closed_folds[:0]
+	for _, f := range E.folds {
+		if !E.closed[f.start] {
+			continue
+		}
+		if n := len(E.closed_folds); n > 0 && f.start <= E.closed_folds[n-1].end {
+			continue
+		}
+		E.closed_folds = append(E.closed_folds, f)
+	}
+}
+
+// editorClosedFold returns the closed fold that hides row, if there is one
+func editorClosedFold(row int) (fold, bool) {
+	i := sort.Search(len(E.closed_folds), func(i int) bool { return E.closed_folds[i].end >= row })
+	if i < len(E.closed_folds) && E.closed_folds[i].start <= row {
+		return E.closed_folds[i], true
+	}
+	return fold{}, false
+}
+
+// editorFoldStart is the row that stands for row on screen: the first row of the closed fold it's in
+func editorFoldStart(row int) int {
+	if f, ok := editorClosedFold(row); ok {
+		return f.start
+	}
+	return row
+}
+
+func editorFoldEnd(row int) int {
+	if f, ok := editorClosedFold(row); ok {
+		return f.end
+	}
+	return row
+}
+
+// editorNextRow is the row below row on screen, jumping over a closed fold
+func editorNextRow(row int) int {
+	return editorFoldEnd(row) + 1
+}
+
+// editorInnerFold returns the innermost fold around row, or -1
+func editorInnerFold(row int, closed bool) int {
+	found := -1
+	for i, f := range E.folds {
+		if f.start > row {
+			break
+		}
+		if row <= f.end && E.closed[f.start] == closed {
+			found = i
+		}
+	}
+	return found
+}
+
+// editorFoldCommand runs the z commands
+func editorFoldCommand(c int) {
+	editorUpdateFolds()
+	if E.closed == nil {
+		E.closed = map[int]bool{}
+	}
+
+	switch c {
+	case 'o':
+		if f, ok := editorClosedFold(E.cy); ok {
+			delete(E.closed, f.start)
+		}
+	case 'c':
+		if i := editorInnerFold(E.cy, false); i >= 0 {
+			E.closed[E.folds[i].start] = true
+		}
+	case 'a':
+		if f, ok := editorClosedFold(E.cy); ok {
+			delete(E.closed, f.start)
+		} else if i := editorInnerFold(E.cy, false); i >= 0 {
+			E.closed[E.folds[i].start] = true
+		}
+	case 'R':
+		clear(E.closed)
+	case 'M':
+		for _, f := range E.folds {
+			E.closed[f.start] = true
+		}
+	default:
+		return
+	}
+	if len(E.folds) == 0 {
+		editorSetStatusMessage("no folds found")
+	}
+	editorUpdateClosedFolds()
+	E.cy = editorFoldStart(E.cy)
+}
+
+// editorShiftFolds keeps closed folds closed when rows are inserted or deleted above them
+func editorShiftFolds(at, n int) {
+	if len(E.closed) == 0 {
+		return
+	}
+	closed := make(map[int]bool, len(E.closed))
+	for start := range E.closed {
+		if start > at || (n > 0 && start == at) {
+			start = max(start+n, at)
+		}
+		closed[start] = true
+	}
+	E.closed = closed
+}
+
+func editorDrawFoldLine(y int, f fold) {
+	text := fmt.Sprintf("+--%3d lines: %s ", f.end-f.start+1, strings.TrimSpace(string(E.row[f.start].render)))
+	screenFill(E.linenum_indent, y, E.screencols, hlStyle(HG_FOLDED))
+	screenPutString(E.linenum_indent, y, text, hlStyle(HG_FOLDED))
+}
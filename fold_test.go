@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestFoldsAreFoundLazily(t *testing.T) {
+	testBuffers(t, "func a() {", "\tx", "\ty", "}", "b")
+	editorScroll()
+	if E.folds != nil || E.folds_method != "" {
+		t.Fatalf("folds %v were found before any z command", E.folds)
+	}
+
+	E.cy = 1
+	editorFoldCommand('c')
+	if len(E.closed_folds) != 1 || E.closed_folds[0] != (fold{0, 2}) {
+		t.Fatalf("closed folds are %v after zc, want 0-2", E.closed_folds)
+	}
+	// while a fold is closed they follow the text
+	editorInsertRow(3, []byte("\tz"))
+	editorScroll()
+	if len(E.closed_folds) != 1 || E.closed_folds[0] != (fold{0, 3}) {
+		t.Errorf("closed folds are %v after adding a row to the fold, want 0-3", E.closed_folds)
+	}
+
+	// and once they're all open again they're left alone
+	editorFoldCommand('R')
+	tick := E.folds_tick
+	editorInsertRow(0, []byte("c"))
+	editorScroll()
+	if E.folds_tick != tick || len(E.closed_folds) != 0 {
+		t.Errorf("folds were found again with none closed")
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FORMATTERS are the commands each filetype is formatted with, the first one
+// that's installed is used. :formatter <filetype> <command> sets a single one.
+// a formatter reads the text on stdin and writes it formatted to stdout. % in it is the file name
+var FORMATTERS = map[string][][]string{
+	"go":         {{"goimports"}, {"gofmt"}},
+	"javascript": {{"prettier", "--stdin-filepath", "%"}},
+	"json":       {{"prettier", "--stdin-filepath", "%"}},
+}
+
+var (
+	FORMAT_ON_SAVE = true
+	FORMAT_TIMEOUT = 5 * time.Second
+	FORMAT_RETRIES = 2 // how many times a save formats again text that changed while the formatter ran
+)
+
+// editorFormatter returns the formatter command for the current buffer, nil if there's none to run
+func editorFormatter() []string {
+	if E.syntax == nil || E.hex {
+		return nil
+	}
+	for _, args := range FORMATTERS[E.syntax.filetype] {
+		if len(args) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := make([]string, len(args))
+		for i, arg := range args {
+			cmd[i] = editorExpandFilename(arg)
+		}
+		return cmd
+	}
+	return nil
+}
+
+// editorRunFormatter pipes the buffer through args in the background. done is
+// called with the formatted lines when it's finished
+func editorRunFormatter(args []string, done func(lines []string, err error)) {
+	var input strings.Builder
+	for i := range E.row {
+		input.Write(E.row[i].chars)
+		input.WriteByte('\n')
+	}
+	text := input.String()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), FORMAT_TIMEOUT)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdin = strings.NewReader(text)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = fmt.Errorf("timed out after %s", FORMAT_TIMEOUT)
+		} else if err != nil {
+			err = fmt.Errorf("%s", editorShellError(err, stderr.Bytes()))
+		}
+		events <- func() { done(editorOutputLines(stdout.Bytes()), err) }
+	}()
+}
+
+// editorFormatThen formats the current buffer and then runs then in it, which
+// is the write of a save. the formatted text replaces the old one only where
+// they differ, so the cursor and the undo history stay as they are. when the
+// text changed while the formatter was running, the result is dropped and
+// it's formatted again, up to FORMAT_RETRIES times before it's written as it is
+func editorFormatThen(args []string, then func()) {
+	editorFormatRetry(args, then, FORMAT_RETRIES)
+}
+
+func editorFormatRetry(args []string, then func(), retries int) {
+	filename, tick := E.filename, E.changetick
+	E.formatting = true
+	editorSetStatusMessage("formatting with %s", args[0])
+	editorRunFormatter(args, func(lines []string, err error) {
+		i := editorFindBuffer(filename)
+		if i < 0 {
+			return
+		}
+		editorInBuffer(i, func() {
+			E.formatting = false
+			switch {
+			case err != nil:
+				editorSetStatusMessage("%s: %s", args[0], err)
+			case E.changetick != tick && then != nil && retries > 0:
+				editorFormatRetry(args, then, retries-1)
+				return
+			case E.changetick != tick && then != nil:
+				editorSetStatusMessage("%s: the text kept changing while formatting, saved it unformatted", args[0])
+			case E.changetick != tick:
+				editorSetStatusMessage("%s: the text changed while formatting", args[0])
+			default:
+				if editorSetLines(lines) {
+					editorUndoCommit()
+				}
+				editorSetStatusMessage("")
+			}
+			if then != nil {
+				msg := E.statusmsg
+				then()
+				if msg != "" {
+					editorSetStatusMessage("%s", msg)
+				}
+			}
+		})
+	})
+}
+
+// editorFormat is :format: with the filetype's formatter, or else with the language server
+func editorFormat() {
+	if !editorWritable() {
+		return
+	}
+	if args := editorFormatter(); args != nil {
+		editorFormatThen(args, nil)
+		return
+	}
+	editorLspFormat()
+}
+
+// editorFormatterCommand is :formatter, which works like :lsp
+func editorFormatterCommand(args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		var lines []string
+		for ft, cmds := range FORMATTERS {
+			used := false
+			for _, cmd := range cmds {
+				state := ""
+				if _, err := exec.LookPath(cmd[0]); err != nil {
+					state = "not found"
+				} else if used {
+					state = "not used"
+				}
+				used = used || state == ""
+				lines = append(lines, fmt.Sprintf("%-12s %-40s %s", ft, strings.Join(cmd, " "), state))
+			}
+		}
+		sort.SliceStable(lines, func(i, j int) bool { return lines[i][:12] < lines[j][:12] })
+		editorShowLines("formatters", lines)
+		return
+	}
+	if len(fields) == 1 {
+		delete(FORMATTERS, fields[0])
+		return
+	}
+	FORMATTERS[fields[0]] = [][]string{fields[1:]}
+}
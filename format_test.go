@@ -0,0 +1,166 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFormatters puts shell scripts called names on PATH, each of them upper-casing its input
+func testFormatters(t *testing.T, names ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the formatters are shell scripts")
+	}
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\nPATH=/usr/bin:/bin tr a-z A-Z\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir)
+	// what earlier tests left behind isn't the formatter finishing
+	for len(events) > 0 {
+		<-events
+	}
+	testBuffers(t, "package main")
+	E.filename = filepath.Join(t.TempDir(), "a.go")
+	editorSelectSyntaxHighlight()
+}
+
+func nextEvent(t *testing.T) {
+	t.Helper()
+	select {
+	case f := <-events:
+		f()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the formatter didn't finish")
+	}
+}
+
+func TestFormatterPrefersGoimports(t *testing.T) {
+	testFormatters(t, "goimports", "gofmt")
+	if args := editorFormatter(); len(args) != 1 || args[0] != "goimports" {
+		t.Errorf("formatter is %v, want goimports", args)
+	}
+	testFormatters(t, "gofmt")
+	if args := editorFormatter(); len(args) != 1 || args[0] != "gofmt" {
+		t.Errorf("formatter without goimports is %v, want gofmt", args)
+	}
+	testFormatters(t)
+	if args := editorFormatter(); args != nil {
+		t.Errorf("formatter with neither is %v", args)
+	}
+}
+
+func TestFormatAgainWhenTheTextChanged(t *testing.T) {
+	testFormatters(t, "gofmt")
+	written := ""
+	write := func() { written = strings.Join(editorBufferLines(), ",") }
+
+	editorFormatThen([]string{"gofmt"}, write)
+	editorInsertRow(1, []byte("func main() {}"))
+	nextEvent(t)
+	if written != "" || !E.formatting {
+		t.Fatalf("the text changed while formatting, and yet it was written as %q", written)
+	}
+	nextEvent(t)
+	if written != "PACKAGE MAIN,FUNC MAIN() {}" {
+		t.Errorf("wrote %q after formatting again", written)
+	}
+
+	// when it keeps changing it's written as it is, and the status line says so
+	written = ""
+	editorFormatThen([]string{"gofmt"}, write)
+	for i := 0; i <= FORMAT_RETRIES; i++ {
+		editorInsertRow(E.numrows, []byte("x"))
+		nextEvent(t)
+	}
+	if written != "PACKAGE MAIN,FUNC MAIN() {},x,x,x" || !strings.Contains(E.statusmsg, "unformatted") {
+		t.Errorf("wrote %q with the message %q", written, E.statusmsg)
+	}
+}
+
+// testShell runs ex commands with sh on a terminal w by h
+func testShell(t *testing.T, w, h int, lines ...string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are for sh")
+	}
+	t.Setenv("SHELL", "/bin/sh")
+	testBuffers(t, lines...)
+	testTerminal(t, w, h)
+}
+
+func TestBang(t *testing.T) {
+	testShell(t, 60, 6, "a")
+	E.filename = "a.txt"
+	// the output is shown until a key closes it
+	unreadKeys = append(unreadKeys, 'q')
+	editorRunCommand("!echo %; echo oops >&2")
+	for y, want := range []string{"a.txt", "oops", "~"} {
+		if got := strings.TrimRight(strings.Join(testCells(y), ""), " "); got != want {
+			t.Errorf("line %d of the output is %q, want %q", y, got, want)
+		}
+	}
+	if got := strings.Join(testCells(4), ""); !strings.Contains(got, "!echo a.txt; echo oops >&2 (shell returned 0)") {
+		t.Errorf("title is %q", got)
+	}
+	if len(unreadKeys) != 0 {
+		t.Error("the output wasn't shown")
+	}
+
+	editorRunCommand("!exit 3")
+	if E.statusmsg != "shell returned: exit status 3" {
+		t.Errorf("status after a failing command is %q", E.statusmsg)
+	}
+	if got := editorBufferLines(); !slices.Equal(got, []string{"a"}) || E.dirty {
+		t.Errorf(":! changed the buffer to %q", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	testShell(t, 40, 6, "c", "a", "b", "keep")
+	editorRunCommand("1,3!sort")
+	if got, want := editorBufferLines(), []string{"a", "b", "c", "keep"}; !slices.Equal(got, want) {
+		t.Errorf("lines after :1,3!sort are %q, want %q", got, want)
+	}
+	if E.statusmsg != "3 lines filtered" {
+		t.Errorf("status is %q", E.statusmsg)
+	}
+	editorUndoCommit()
+	editorUndo()
+	if got, want := editorBufferLines(), []string{"c", "a", "b", "keep"}; !slices.Equal(got, want) {
+		t.Errorf("lines after undo are %q, want %q", got, want)
+	}
+
+	// a command that fails leaves the lines as they were, even if it printed something
+	dirty := E.dirty
+	editorRunCommand("%!sort; echo broken >&2; exit 1")
+	if got, want := editorBufferLines(), []string{"c", "a", "b", "keep"}; !slices.Equal(got, want) || E.dirty != dirty {
+		t.Errorf("lines after a failed filter are %q, want %q", got, want)
+	}
+	if !strings.Contains(E.statusmsg, "broken") {
+		t.Errorf("status after a failed filter is %q", E.statusmsg)
+	}
+}
+
+func TestReadCommand(t *testing.T) {
+	testShell(t, 40, 6, "a", "b")
+	editorRunCommand("r !printf 'x\\r\\ny\\n'")
+	if got, want := editorBufferLines(), []string{"a", "x", "y", "b"}; !slices.Equal(got, want) {
+		t.Errorf("lines after :r !printf are %q, want %q", got, want)
+	}
+	if E.cy != 1 {
+		t.Errorf("cursor is on line %d, want 1", E.cy)
+	}
+
+	editorRunCommand("r !echo nope >&2; exit 2")
+	if got := editorBufferLines(); len(got) != 4 || E.statusmsg != "echo nope >&2; exit 2: nope" {
+		t.Errorf("after a failed :r ! the lines are %q and the status %q", got, E.statusmsg)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+func lowerByte(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
+// fuzzyBoundary says whether s[i] starts a word inside s, like the B in fooBar or foo_bar
+func fuzzyBoundary(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, c := s[i-1], s[i]
+	switch prev {
+	case '_', '-', '/', '.', ' ', '\\':
+		return true
+	}
+	return prev >= 'a' && prev <= 'z' && c >= 'A' && c <= 'Z'
+}
+
+// fuzzyMatch says whether the characters of pattern are in s in the same order
+// and scores how well they are: characters next to each other, at the start
+// of s or of a word in it, count for more, and shorter strings win ties. the
+// match ignores case unless pattern has an upper case letter
+func fuzzyMatch(pattern, s string) (int, bool) {
+	smart := false
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] >= 'A' && pattern[i] <= 'Z' {
+			smart = true
+		}
+	}
+
+	score, pi, prev := 0, 0, -2
+	for i := 0; i < len(s) && pi < len(pattern); i++ {
+		a, b := s[i], pattern[pi]
+		if !smart {
+			a, b = lowerByte(a), lowerByte(b)
+		}
+		if a != b {
+			continue
+		}
+		bonus := 1
+		if i == prev+1 {
+			bonus += 4
+		}
+		if i == 0 {
+			bonus += 6
+		} else if fuzzyBoundary(s, i) {
+			bonus += 3
+		}
+		score += bonus
+		prev = i
+		pi++
+	}
+	if pi < len(pattern) {
+		return 0, false
+	}
+	return score*16 - len(s), true
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GITGUTTER shows signs for the lines that differ from HEAD
+var GITGUTTER = true
+
+// what the git gutter knows about a buffer
+type gitState struct {
+	loading bool
+	tracked bool
+	head    []string // the file at HEAD
+	tick    int      // changetick the hunks were worked out for, -1 to do it again
+	hunks   []gitHunk
+	wait    debounce
+}
+
+// a change against HEAD or the index. start and count are rows of the buffer,
+// hstart and hcount lines of the old version. a count of 0 is lines that were
+// only deleted, before row start
+type gitHunk struct {
+	start, count   int
+	hstart, hcount int
+}
+
+// gitRun runs git in dir with input on its stdin
+func gitRun(dir, input string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s", editorShellError(err, stderr.Bytes()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// editorGitPath splits the absolute path of the current file into its directory and name
+func editorGitPath() (string, string, bool) {
+	if E.filename == "" || E.buftype != "" {
+		return "", "", false
+	}
+	abs, err := filepath.Abs(E.filename)
+	if err != nil {
+		return "", "", false
+	}
+	return filepath.Dir(abs), filepath.Base(abs), true
+}
+
+// editorGitSync keeps the signs of the current buffer up to date, like
+// editorLspSync it runs before every redraw. HEAD is read in the background
+// the first time and again after a save
+func editorGitSync() {
+	if !GITGUTTER || E.hex {
+		return
+	}
+	dir, base, ok := editorGitPath()
+	if !ok {
+		return
+	}
+	if E.git == nil {
+		state := &gitState{loading: true, tick: -1}
+		E.git = state
+		filename := E.filename
+		go func() {
+			out, err := gitRun(dir, "", "show", "HEAD:./"+base)
+			events <- func() {
+				state.loading = false
+				state.tracked = err == nil
+				state.head = editorOutputLines(out)
+				if i := editorFindBuffer(filename); i >= 0 {
+					editorInBuffer(i, func() {
+						if E.git == state {
+							editorGitUpdate()
+						}
+					})
+				}
+			}
+		}()
+		return
+	}
+	if E.git.loading || E.git.tick == E.changetick {
+		return
+	}
+	state, filename := E.git, E.filename
+	again := func() {
+		if i := editorFindBuffer(filename); i >= 0 {
+			editorInBuffer(i, func() {
+				if E.git == state {
+					editorGitSync()
+				}
+			})
+		}
+	}
+	if state.tick < 0 || state.wait.ready(E.changetick, again) {
+		editorGitUpdate()
+	}
+}
+
+// editorBufferLines is the text of the current buffer, a string per row
+func editorBufferLines() []string {
+	lines := make([]string, E.numrows)
+	for i := range E.row {
+		lines[i] = string(E.row[i].chars)
+	}
+	return lines
+}
+
+// gitHunks groups the changes of a diff
+func gitHunks(ops []diffOp) []gitHunk {
+	var hunks []gitHunk
+	apos, bpos := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			apos++
+			bpos++
+			i++
+			continue
+		}
+		h := gitHunk{start: bpos, hstart: apos}
+		for ; i < len(ops) && ops[i].kind != ' '; i++ {
+			if ops[i].kind == '-' {
+				h.hcount++
+				apos++
+			} else {
+				h.count++
+				bpos++
+			}
+		}
+		hunks = append(hunks, h)
+	}
+	return hunks
+}
+
+// gitHunkRow is the row a hunk's sign is on. deleted lines are marked on the row above them
+func gitHunkRow(h gitHunk) int {
+	if h.count == 0 {
+		return max(h.start-1, 0)
+	}
+	return h.start
+}
+
+// editorGitUpdate diffs the buffer against HEAD and puts up the signs: + for
+// added rows, ~ for changed ones and _ under deleted lines
+func editorGitUpdate() {
+	st := E.git
+	st.tick = E.changetick
+	st.hunks = nil
+	if !st.tracked {
+		editorSetSigns("git", nil)
+		return
+	}
+	st.hunks = gitHunks(diffLines(st.head, editorBufferLines()))
+
+	var signs []sign
+	for _, h := range st.hunks {
+		if h.count == 0 {
+			text := "_ "
+			if h.start == 0 {
+				text = "‾ "
+			}
+			signs = append(signs, sign{line: gitHunkRow(h), text: text, group: HG_DIFFDELETE})
+			continue
+		}
+		changed := min(h.count, h.hcount)
+		for i := 0; i < h.count; i++ {
+			s := sign{line: h.start + i, text: "+ ", group: HG_DIFFADD}
+			if i < changed {
+				s.text, s.group = "~ ", HG_DIFFCHANGE
+			}
+			// more lines went than came, the rest were deleted after the last changed one
+			if i == changed-1 && h.hcount > h.count {
+				s.text = "~_"
+			}
+			signs = append(signs, s)
+		}
+	}
+	editorSetSigns("git", signs)
+}
+
+// editorGitHunks is the up to date list of changes against HEAD
+func editorGitHunks() []gitHunk {
+	if E.git == nil || E.git.loading {
+		return nil
+	}
+	if E.git.tick != E.changetick {
+		editorGitUpdate()
+	}
+	return E.git.hunks
+}
+
+// editorGitHunkAt finds the hunk with its sign on or covering row y
+func editorGitHunkAt(hunks []gitHunk, y int) (gitHunk, bool) {
+	for _, h := range hunks {
+		if y >= h.start && y < h.start+h.count || h.count == 0 && y == gitHunkRow(h) {
+			return h, true
+		}
+	}
+	return gitHunk{}, false
+}
+
+// editorJumpHunk is ]c and [c, to the next or previous of hunks
+func editorJumpHunk(hunks []gitHunk, dir int) {
+	row := -1
+	for _, h := range hunks {
+		r := gitHunkRow(h)
+		if dir > 0 && r > E.cy && row < 0 {
+			row = r
+		}
+		if dir < 0 && r < E.cy {
+			row = r
+		}
+	}
+	if row < 0 {
+		editorSetStatusMessage("no more hunks")
+		return
+	}
+	E.cy = row
+	E.cx = editorFirstNonBlank(row)
+}
+
+// editorGitRevertHunk is :Grevert, the change under the cursor goes back to what's in HEAD
+func editorGitRevertHunk() {
+	if !editorWritable() {
+		return
+	}
+	h, ok := editorGitHunkAt(editorGitHunks(), E.cy)
+	if !ok {
+		editorSetStatusMessage("no change here")
+		return
+	}
+	cur := editorBufferLines()
+	lines := append([]string(nil), cur[:h.start]...)
+	lines = append(lines, E.git.head[h.hstart:h.hstart+h.hcount]...)
+	lines = append(lines, cur[h.start+h.count:]...)
+	editorSetLines(lines)
+	E.cy = min(h.start, max(E.numrows-1, 0))
+	E.cx = editorFirstNonBlank(E.cy)
+}
+
+// editorGitStageHunk is :Gstage. the change under the cursor, compared with the
+// index, goes into the index as if only that part of the file had been added
+func editorGitStageHunk() {
+	dir, base, ok := editorGitPath()
+	if !ok {
+		editorSetStatusMessage("no file")
+		return
+	}
+	ls, err := gitRun(dir, "", "ls-files", "-s", "--", base)
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+	fields := strings.Fields(string(ls))
+	if len(fields) < 2 {
+		editorSetStatusMessage("%s isn't tracked by git", E.filename)
+		return
+	}
+	prefix, err := gitRun(dir, "", "rev-parse", "--show-prefix")
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+	index, err := gitRun(dir, "", "show", ":./"+base)
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+
+	old := editorOutputLines(index)
+	cur := editorBufferLines()
+	h, ok := editorGitHunkAt(gitHunks(diffLines(old, cur)), E.cy)
+	if !ok {
+		editorSetStatusMessage("nothing to stage here")
+		return
+	}
+	lines := append([]string(nil), old[:h.hstart]...)
+	lines = append(lines, cur[h.start:h.start+h.count]...)
+	lines = append(lines, old[h.hstart+h.hcount:]...)
+	eol := "\n"
+	if E.crlf {
+		eol = "\r\n"
+	}
+	text := ""
+	if len(lines) > 0 {
+		text = strings.Join(lines, eol) + eol
+	}
+
+	sha, err := gitRun(dir, text, "hash-object", "-w", "--stdin", "--path", base)
+	if err == nil {
+		info := fields[0] + "," + strings.TrimSpace(string(sha)) + "," + strings.TrimSpace(string(prefix)) + base
+		_, err = gitRun(dir, "", "update-index", "--cacheinfo", info)
+	}
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+	editorSetStatusMessage("staged %d lines for %d", h.count, h.hcount)
+}
+
+// a line of git blame --porcelain
+type blameLine struct {
+	sha     string
+	author  string
+	time    time.Time
+	summary string
+}
+
+// the file the blame buffer was last filled for
+var blameOf string
+
+// editorGitBlame is :Gblame. who last changed each line goes in a window on
+// the left that scrolls with the file. Enter there shows the commit
+func editorGitBlame() {
+	dir, base, ok := editorGitPath()
+	if !ok {
+		editorSetStatusMessage("no file")
+		return
+	}
+	text, _ := editorRowToString()
+	out, err := gitRun(dir, text, "blame", "--porcelain", "--contents", "-", "--", base)
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+
+	commits := map[string]*blameLine{}
+	lines := make([]*blameLine, E.numrows)
+	var cur *blameLine
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "\t") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && len(fields[0]) == 40 {
+			if commits[fields[0]] == nil {
+				commits[fields[0]] = &blameLine{sha: fields[0]}
+			}
+			cur = commits[fields[0]]
+			if n, err := strconv.Atoi(fields[2]); err == nil && n >= 1 && n <= len(lines) {
+				lines[n-1] = cur
+			}
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		switch key {
+		case "author":
+			cur.author = value
+		case "author-time":
+			if t, err := strconv.ParseInt(value, 10, 64); err == nil {
+				cur.time = time.Unix(t, 0)
+			}
+		case "summary":
+			cur.summary = value
+		}
+	}
+
+	width := 0
+	for _, b := range commits {
+		width = max(width, len([]rune(b.author)))
+	}
+	width = min(width, 20)
+	text = ""
+	var rows []string
+	for _, b := range lines {
+		if b == nil {
+			rows = append(rows, "")
+			continue
+		}
+		author := []rune(b.author)
+		if len(author) > width {
+			author = author[:width]
+		}
+		rows = append(rows, fmt.Sprintf("%.8s %-*s %s", b.sha, width, string(author), b.time.Format("2006-01-02")))
+	}
+
+	// the one blame buffer is filled again for this file
+	i := -1
+	for n := range buffers {
+		if editorBufType(n) == "blame" {
+			i = n
+		}
+	}
+	for _, w := range editorWindows() {
+		if w.buf == i && w != curwin {
+			editorCloseWindow(w)
+		}
+	}
+	if i < 0 {
+		cur := curbuf
+		editorAddBuffer()
+		E.buftype = "blame"
+		E.readonly = true
+		i = curbuf
+		editorSwitchBuffer(cur)
+	}
+	blameOf = E.filename
+	editorInBuffer(i, func() {
+		editorFillBuffer(rows)
+		E.cy = 0
+	})
+
+	numw := 0
+	if NUMBER || RELATIVENUMBER {
+		numw = max(NUMBERWIDTH, len(strconv.Itoa(len(rows)))+1)
+	}
+	w := &window{buf: i, cy: E.cy, rowoff: E.rowoff, width: 8 + 1 + width + 1 + 10 + 1 + numw, scrollbind: true}
+	curwin.scrollbind = true
+	editorInsertWindow(curwin, w, true, true)
+	editorFocusWindow(w)
+}
+
+// editorGitBlameShow is Enter in the blame window, it shows the commit of the line
+func editorGitBlameShow() {
+	if E.cy >= E.numrows {
+		return
+	}
+	sha, _, _ := strings.Cut(string(E.row[E.cy].chars), " ")
+	if sha == "" || strings.Trim(sha, "0") == "" {
+		editorSetStatusMessage("not committed yet")
+		return
+	}
+	out, err := gitRun(filepath.Dir(blameOf), "", "show", "--stat", "--patch", sha)
+	if err != nil {
+		editorSetStatusMessage("git: %s", err)
+		return
+	}
+	editorShowLines("git show "+sha, strings.Split(strings.ReplaceAll(string(out), "\t", "    "), "\n"))
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testGitRepo opens a file committed with lines in a new repository
+func testGitRepo(t *testing.T, lines ...string) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	name := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "a.txt"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "a"},
+	} {
+		if _, err := gitRun(dir, "", args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testBuffers(t)
+	editorOpen(name)
+	editorGitSync()
+	select {
+	case f := <-events:
+		f()
+	case <-time.After(5 * time.Second):
+		t.Fatal("HEAD wasn't read")
+	}
+	if E.git == nil || !E.git.tracked {
+		t.Fatal("a.txt isn't tracked")
+	}
+	return dir
+}
+
+func TestGitRevertHunk(t *testing.T) {
+	testGitRepo(t, "one", "two", "three", "four", "five")
+	editorSetLines([]string{"one", "TWO", "2b", "three", "four"})
+
+	E.cy = 1
+	editorGitRevertHunk()
+	if got := strings.Join(editorBufferLines(), ","); got != "one,two,three,four" {
+		t.Errorf("buffer is %s after reverting the change on row 1", got)
+	}
+	// the lines deleted at the end come back too
+	E.cy = 3
+	editorGitRevertHunk()
+	if got := strings.Join(editorBufferLines(), ","); got != "one,two,three,four,five" {
+		t.Errorf("buffer is %s after reverting the deletion", got)
+	}
+	if hunks := editorGitHunks(); len(hunks) != 0 {
+		t.Errorf("hunks %+v are left after reverting all of them", hunks)
+	}
+}
+
+func TestGitStageHunk(t *testing.T) {
+	dir := testGitRepo(t, "one", "two", "three", "four", "five")
+	editorSetLines([]string{"one", "TWO", "three", "four", "FIVE"})
+
+	E.cy = 4
+	editorGitStageHunk()
+	out, err := gitRun(dir, "", "show", ":a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(editorOutputLines(out), ","); got != "one,two,three,four,FIVE" {
+		t.Errorf("index has %s after staging the change on row 4", got)
+	}
+	// the other one is staged against what's in the index now
+	E.cy = 1
+	editorGitStageHunk()
+	out, _ = gitRun(dir, "", "show", ":a.txt")
+	if got := strings.Join(editorOutputLines(out), ","); got != "one,TWO,three,four,FIVE" {
+		t.Errorf("index has %s after staging the change on row 1", got)
+	}
+	// and the file itself wasn't written
+	if b, _ := os.ReadFile(filepath.Join(dir, "a.txt")); string(b) != "one\ntwo\nthree\nfour\nfive\n" {
+		t.Errorf("a.txt has %q after staging", b)
+	}
+	E.cy = 1
+	editorGitStageHunk()
+	if !strings.Contains(E.statusmsg, "nothing to stage") {
+		t.Errorf("staging again said %q", E.statusmsg)
+	}
+}
+
+func TestGitStagePatch(t *testing.T) {
+	dir := testGitRepo(t, "one\r", "two\r", "three\r", "four\r", "five\r")
+	if !E.crlf {
+		t.Fatal("a.txt wasn't read as CRLF")
+	}
+	editorSetLines([]string{"one", "three", "four", "FIVE", "six"})
+	staged := func() []string {
+		t.Helper()
+		out, err := gitRun(dir, "", "diff", "--cached", "-U0", "--no-color")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines []string
+		for _, line := range editorOutputLines(out) {
+			switch {
+			case strings.HasPrefix(line, "@@"):
+				// without the context git puts after the range
+				if i := strings.Index(line[2:], "@@"); i >= 0 {
+					line = line[:i+4]
+				}
+			case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+				continue
+			case !strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "-"):
+				continue
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\r"))
+		}
+		return lines
+	}
+
+	// a hunk that only deletes lines is on the row above them
+	E.cy = 0
+	editorGitStageHunk()
+	if got, want := staged(), []string{"@@ -2 +1,0 @@", "-two"}; !slices.Equal(got, want) {
+		t.Errorf("staged %q, want %q", got, want)
+	}
+	E.cy = 3
+	editorGitStageHunk()
+	want := []string{"@@ -2 +1,0 @@", "-two", "@@ -5 +4,2 @@", "-five", "+FIVE", "+six"}
+	if got := staged(); !slices.Equal(got, want) {
+		t.Errorf("staged %q, want %q", got, want)
+	}
+	// the lines keep the file's line endings
+	out, _ := gitRun(dir, "", "show", ":a.txt")
+	if string(out) != "one\r\nthree\r\nfour\r\nFIVE\r\nsix\r\n" {
+		t.Errorf("index has %q", out)
+	}
+}
+
+func TestGitBlame(t *testing.T) {
+	dir := testGitRepo(t, "one", "two", "three")
+	testTerminal(t, 80, 24)
+	out, err := gitRun(dir, "", "log", "-1", "--format=%H %at")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sha string
+	var at int64
+	fmt.Sscan(string(out), &sha, &at)
+	editorSetLines([]string{"one", "TWO", "three"})
+
+	editorGitBlame()
+	if E.buftype != "blame" || !strings.HasSuffix(blameOf, "a.txt") {
+		t.Fatalf("the blame window isn't focused, it's %q for %q", E.buftype, blameOf)
+	}
+	// the author column is as wide as the longest name
+	date := time.Unix(at, 0).Format("2006-01-02")
+	want := []string{
+		sha[:8] + " test              " + date,
+		"00000000 Not Committed Yet " + time.Now().Format("2006-01-02"),
+		sha[:8] + " test              " + date,
+	}
+	if got := editorBufferLines(); len(got) != 3 || got[0] != want[0] || got[2] != want[2] || !strings.HasPrefix(got[1], "00000000 Not Committed Yet ") {
+		t.Errorf("blame is %q, want %q", got, want)
+	}
+	if !curwin.scrollbind {
+		t.Error("the blame window doesn't scroll with the file")
+	}
+
+	E.cy = 1
+	editorGitBlameShow()
+	if E.statusmsg != "not committed yet" {
+		t.Errorf("showing the uncommitted line said %q", E.statusmsg)
+	}
+	E.cy = 0
+	unreadKeys = append(unreadKeys, 'q')
+	editorGitBlameShow()
+	if got := strings.Join(testCells(termHeight-2), ""); !strings.Contains(got, "git show "+sha[:8]) {
+		t.Errorf("title of the commit shown is %q", got)
+	}
+}
@@ -2,6 +2,8 @@ module github.com/kristof1345/goditor
 
 go 1.23.1
 
-require golang.org/x/term v0.24.0
+require (
+	golang.org/x/sys v0.25.0
+	golang.org/x/term v0.24.0
+)
 
-require golang.org/x/sys v0.25.0 // indirect
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	NUMBER         = true
+	RELATIVENUMBER = true
+	NUMBERWIDTH    = 4      // minimum width of the number column, including the space after it
+	SIGNCOLUMN     = "auto" // auto (only when there are signs), yes or no
+)
+
+const SIGN_WIDTH = 2
+
+// a sign is a two cell marker in front of a line number, like a diagnostic
+// or a git change. every source owns its own set and replaces it as a whole.
+// a sign with a message also shows it after the end of the line
+type sign struct {
+	line    int
+	text    string
+	group   hlGroup
+	source  string
+	message string
+}
+
+// editorSetSigns replaces all the signs of source in the current buffer
+func editorSetSigns(source string, signs []sign) {
+	kept := E.signs[:0]
+	for _, s := range E.signs {
+		if s.source != source {
+			kept = append(kept, s)
+		}
+	}
+	for _, s := range signs {
+		s.source = source
+		kept = append(kept, s)
+	}
+	E.signs = kept
+}
+
+// editorSignAt returns the sign shown on filerow. one with a message, like a
+// diagnostic, wins over one without, otherwise the sign added last wins
+func editorSignAt(filerow int) (sign, bool) {
+	found, ok := sign{}, false
+	for i := len(E.signs) - 1; i >= 0; i-- {
+		s := E.signs[i]
+		if s.line != filerow {
+			continue
+		}
+		if s.message != "" {
+			return s, true
+		}
+		if !ok {
+			found, ok = s, true
+		}
+	}
+	return found, ok
+}
+
+// editorShiftSigns keeps signs on their lines when rows are inserted or deleted above them
+func editorShiftSigns(at, n int) {
+	for i := range E.signs {
+		s := &E.signs[i]
+		if s.line > at || (n > 0 && s.line == at) {
+			s.line = max(s.line+n, at)
+		}
+	}
+}
+
+func editorSignColumnWidth() int {
+	switch SIGNCOLUMN {
+	case "yes":
+		return SIGN_WIDTH
+	case "auto":
+		if len(E.signs) > 0 {
+			return SIGN_WIDTH
+		}
+	}
+	return 0
+}
+
+func editorNumberWidth() int {
+	if !NUMBER && !RELATIVENUMBER {
+		return 0
+	}
+	return max(NUMBERWIDTH, len(strconv.Itoa(E.numrows))+1)
+}
+
+// editorUpdateLinenumIndent sizes the gutter for the signs and the biggest line number
+func editorUpdateLinenumIndent() {
+	E.linenum_indent = editorSignColumnWidth() + editorNumberWidth()
+	E.screencols = E.raw_screencols - E.linenum_indent
+}
+
+// editorDrawGutter draws the sign column and the line number for filerow.
+// with both number and relativenumber set the cursor line gets its absolute number
+func editorDrawGutter(y int, filerow int) {
+	x := 0
+	if signs := editorSignColumnWidth(); signs > 0 {
+		if s, ok := editorSignAt(filerow); ok {
+			screenPutString(x, y, s.text, hlStyle(s.group))
+		}
+		x += signs
+	}
+
+	width := editorNumberWidth()
+	if width == 0 || filerow >= E.numrows {
+		return
+	}
+
+	format := fmt.Sprintf("%%%dd ", width-1)
+	st := hlStyle(HG_LINENR)
+	n := filerow + 1
+	if RELATIVENUMBER {
+		n = editorVisibleDistance(E.cy, filerow)
+	}
+	if E.cy == filerow {
+		st = hlStyle(HG_CURSORLINENR)
+		if NUMBER {
+			n = filerow + 1
+			if RELATIVENUMBER {
+				// like vim, the current line number sits on the left in hybrid mode
+				format = fmt.Sprintf("%%-%dd ", width-1)
+			}
+		}
+	}
+	screenPutString(x, y, fmt.Sprintf(format, n), st)
+}
+
+// editorVisibleDistance counts the lines between rows a and b as shown, a closed fold being one
+func editorVisibleDistance(a, b int) int {
+	if a > b {
+		a, b = b, a
+	}
+	if len(E.closed_folds) == 0 {
+		return b - a
+	}
+	n := 0
+	for r := editorFoldStart(a); r < editorFoldStart(b); r = editorNextRow(r) {
+		n++
+	}
+	return n
+}
+
+// editorDrawShowbreak puts SHOWBREAK at the end of the number column of a continuation line
+func editorDrawShowbreak(y int) {
+	if editorNumberWidth() < 2 {
+		return
+	}
+	screenPutString(E.linenum_indent-2, y, SHOWBREAK, hlStyle(HG_NONTEXT))
+}
+
+// editorDrawSignMessage puts the message of the sign on filerow after the text, which ends at screen column x
+func editorDrawSignMessage(y, filerow, x int) {
+	s, ok := editorSignAt(filerow)
+	if !ok || s.message == "" {
+		return
+	}
+	msg, _, _ := strings.Cut(s.message, "\n")
+	screenPutString(max(x, E.linenum_indent)+2, y, msg, hlStyle(s.group))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGutterWidth(t *testing.T) {
+	number, relative, width, signcolumn := NUMBER, RELATIVENUMBER, NUMBERWIDTH, SIGNCOLUMN
+	defer func() { NUMBER, RELATIVENUMBER, NUMBERWIDTH, SIGNCOLUMN = number, relative, width, signcolumn }()
+	NUMBER, RELATIVENUMBER, SIGNCOLUMN = true, false, "auto"
+
+	testBuffers(t)
+	for _, tc := range []struct{ numberwidth, rows, want int }{
+		{4, 9, 4},
+		{4, 10, 4},
+		{4, 1e6, 8},
+		{1, 9, 2},
+		{1, 10, 3},
+		{1, 1e6, 8},
+	} {
+		NUMBERWIDTH = tc.numberwidth
+		E.numrows = tc.rows
+		if w := editorNumberWidth(); w != tc.want {
+			t.Errorf("number column of %d rows with numberwidth %d is %d wide, want %d", tc.rows, tc.numberwidth, w, tc.want)
+		}
+	}
+
+	// the sign column comes and goes with the signs unless it's set either way
+	NUMBERWIDTH, E.numrows = 4, 9
+	for _, tc := range []struct {
+		signcolumn string
+		signs      []sign
+		want       int
+	}{
+		{"auto", nil, 4},
+		{"auto", []sign{{line: 0, text: "E>"}}, 6},
+		{"yes", nil, 6},
+		{"no", []sign{{line: 0, text: "E>"}}, 4},
+	} {
+		SIGNCOLUMN, E.signs = tc.signcolumn, tc.signs
+		editorUpdateLinenumIndent()
+		if E.linenum_indent != tc.want || E.screencols != E.raw_screencols-tc.want {
+			t.Errorf("signcolumn=%s with %d signs gives a gutter of %d, want %d", tc.signcolumn, len(tc.signs), E.linenum_indent, tc.want)
+		}
+	}
+	NUMBER, RELATIVENUMBER = false, false
+	E.signs = nil
+	SIGNCOLUMN = "auto"
+	editorUpdateLinenumIndent()
+	if E.linenum_indent != 0 {
+		t.Errorf("without numbers or signs the gutter is %d wide", E.linenum_indent)
+	}
+}
+
+func TestDrawGutter(t *testing.T) {
+	number, relative, width, signcolumn := NUMBER, RELATIVENUMBER, NUMBERWIDTH, SIGNCOLUMN
+	defer func() { NUMBER, RELATIVENUMBER, NUMBERWIDTH, SIGNCOLUMN = number, relative, width, signcolumn }()
+	NUMBER, RELATIVENUMBER, NUMBERWIDTH, SIGNCOLUMN = true, true, 4, "auto"
+
+	testBuffers(t, "a", "b", "c", "d")
+	testScreen(t, 10, 4)
+	E.signs = []sign{{line: 3, text: "W>", group: HG_DIAGWARN}}
+	E.cy = 1
+	editorUpdateLinenumIndent()
+	for y := 0; y < 4; y++ {
+		editorDrawGutter(y, y)
+	}
+	// the cursor line has its own number on the left, the others how far away they are
+	for y, want := range []string{"    1 ", "  2   ", "    1 ", "W>  2 "} {
+		if got := strings.Join(testCells(y)[:6], ""); got != want {
+			t.Errorf("gutter of row %d is %q, want %q", y, got, want)
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const REPLACE = 'R'
+
+// editorIsBinary looks at the start of a file: a NUL byte, or more than a tenth
+// of it not being utf-8, makes it binary
+func editorIsBinary(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	invalid := 0
+	for i := 0; i < len(head); {
+		if head[i] == 0 {
+			return true
+		}
+		r, size := utf8.DecodeRune(head[i:])
+		// a character cut off by the end of head isn't invalid
+		if r == utf8.RuneError && size == 1 && len(head)-i >= utf8.UTFMax {
+			invalid++
+		}
+		i += size
+	}
+	return invalid*10 > len(head)
+}
+
+// editorHexBytesPerLine is 16, or 8 when the terminal is too narrow for that
+func editorHexBytesPerLine() int {
+	if E.raw_screencols < 10+16*3+1+18 {
+		return 8
+	}
+	return 16
+}
+
+// editorHexToggle switches the buffer between the text and the hex view.
+// the undo history is in terms of one or the other, so it starts over
+func editorHexToggle() {
+	if E.hex {
+		data := E.data
+		E.hex = false
+		E.data = nil
+		E.row = nil
+		E.numrows = 0
+		E.undo = undoTree{off: true}
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if E.crlf {
+				line = strings.TrimSuffix(line, "\r")
+			}
+			editorInsertRow(E.numrows, []byte(line))
+		}
+		if len(data) == 0 {
+			editorDelRow(0)
+		}
+		E.undo = undoTree{}
+		E.cx, E.cy = 0, 0
+		return
+	}
+
+	buf, _ := editorRowToString()
+	E.hex = true
+	E.data = []byte(buf)
+	E.row = nil
+	E.numrows = 0
+	E.undo = undoTree{}
+	E.hexoff = 0
+	E.rowoff = 0
+}
+
+// editorHexSplice replaces n bytes at off with b, recording it for undo
+func editorHexSplice(off, n int, b []byte) {
+	old := append([]byte(nil), E.data[off:off+n]...)
+	E.data = append(E.data[:off], append(append([]byte(nil), b...), E.data[off+n:]...)...)
+	editorUndoRecord(undoChange{Op: 'x', At: off, Old: old, New: append([]byte(nil), b...)})
+	E.dirty = true
+}
+
+// editorHexUndo applies a recorded splice one way or the other
+func editorHexUndo(ch undoChange, reverse bool) {
+	from, to := ch.Old, ch.New
+	if reverse {
+		from, to = to, from
+	}
+	end := min(ch.At+len(from), len(E.data))
+	E.data = append(E.data[:ch.At], append(append([]byte(nil), to...), E.data[end:]...)...)
+	E.hexoff = ch.At
+}
+
+func hexDigit(c int) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return byte(c - '0'), true
+	case c >= 'a' && c <= 'f':
+		return byte(c - 'a' + 10), true
+	case c >= 'A' && c <= 'F':
+		return byte(c - 'A' + 10), true
+	}
+	return 0, false
+}
+
+// editorHexType handles a typed key in INSERT or REPLACE mode. in the hex pane
+// it takes two digits to make a byte
+func editorHexType(c int) {
+	var b byte
+	if E.hexascii {
+		if c < 0x20 || c > 0x7e {
+			return
+		}
+		b = byte(c)
+	} else {
+		d, ok := hexDigit(c)
+		if !ok {
+			return
+		}
+		if E.hexnibble == 1 {
+			b = E.data[E.hexoff]&0xf0 | d
+			editorHexSplice(E.hexoff, 1, []byte{b})
+			E.hexoff++
+			E.hexnibble = 0
+			return
+		}
+		b = d << 4
+		if E.mode == REPLACE && E.hexoff < len(E.data) {
+			b |= E.data[E.hexoff] & 0x0f
+		}
+	}
+
+	if E.mode == REPLACE && E.hexoff < len(E.data) {
+		editorHexSplice(E.hexoff, 1, []byte{b})
+	} else {
+		editorHexSplice(E.hexoff, 0, []byte{b})
+	}
+	if E.hexascii {
+		E.hexoff++
+	} else {
+		E.hexnibble = 1
+	}
+}
+
+func editorHexMove(delta int) {
+	E.hexnibble = 0
+	E.hexoff = max(min(E.hexoff+delta, len(E.data)), 0)
+}
+
+// editorHexKey handles the keys that mean something else in the hex view.
+// in NORMAL mode only the commands that don't work on the text go on to the
+// usual key handling, it takes anything else
+func editorHexKey(c int) bool {
+	per := editorHexBytesPerLine()
+
+	switch c {
+	case ARROW_LEFT:
+		editorHexMove(-1)
+		return true
+	case ARROW_RIGHT:
+		editorHexMove(1)
+		return true
+	case ARROW_UP:
+		editorHexMove(-per)
+		return true
+	case ARROW_DOWN:
+		editorHexMove(per)
+		return true
+	case PAGE_UP:
+		editorHexMove(-per * E.screenrows)
+		return true
+	case PAGE_DOWN:
+		editorHexMove(per * E.screenrows)
+		return true
+	case '\t':
+		E.hexascii = !E.hexascii
+		E.hexnibble = 0
+		return true
+	case '\x1b', CONTROL_KEY('l'):
+		E.mode = NORMAL
+		E.hexnibble = 0
+		E.hexoff = min(E.hexoff, max(len(E.data)-1, 0))
+		return true
+	}
+
+	if E.mode == INSERT || E.mode == REPLACE {
+		switch c {
+		case BACKSPACE, CONTROL_KEY('h'):
+			if E.hexnibble == 1 {
+				E.hexnibble = 0
+			} else if E.hexoff > 0 {
+				E.hexoff--
+				if E.mode == INSERT {
+					editorHexSplice(E.hexoff, 1, nil)
+				}
+			}
+		case DEL_KEY:
+			if E.hexoff < len(E.data) {
+				editorHexSplice(E.hexoff, 1, nil)
+			}
+		default:
+			editorHexType(c)
+		}
+		return true
+	}
+
+	if E.mode != NORMAL {
+		return false
+	}
+	switch c {
+	case 'h':
+		editorHexMove(-1)
+	case 'l':
+		editorHexMove(1)
+	case 'k':
+		editorHexMove(-per)
+	case 'j':
+		editorHexMove(per)
+	case 'w':
+		editorHexMove(per / 2)
+	case 'b':
+		editorHexMove(-per / 2)
+	case '0':
+		editorHexMove(-(E.hexoff % per))
+	case '$':
+		editorHexMove(per - 1 - E.hexoff%per)
+	case 'G':
+		editorHexMove(len(E.data))
+	case 'g':
+		if editorReadKey() == 'g' {
+			editorHexMove(-E.hexoff)
+		}
+	case 'i', 'a', 'R':
+		if !editorWritable() {
+			break
+		}
+		E.mode = INSERT
+		if c == 'R' {
+			E.mode = REPLACE
+		}
+		if c == 'a' {
+			editorHexMove(1)
+		}
+	case 'x':
+		if editorWritable() && E.hexoff < len(E.data) {
+			editorHexSplice(E.hexoff, 1, nil)
+			E.hexoff = min(E.hexoff, max(len(E.data)-1, 0))
+		}
+	case 'r':
+		// replace the byte under the cursor with the next two hex digits or character
+		if !editorWritable() || E.hexoff >= len(E.data) {
+			break
+		}
+		E.mode = REPLACE
+		editorHexType(editorReadKey())
+		if !E.hexascii && E.hexnibble == 1 {
+			editorHexType(editorReadKey())
+		}
+		E.mode = NORMAL
+		E.hexnibble = 0
+		E.hexoff = min(max(E.hexoff-1, 0), max(len(E.data)-1, 0))
+	case ':', 'q', 'u', CONTROL_KEY('r'), CONTROL_KEY('s'), CONTROL_KEY('q'), CONTROL_KEY('w'), CONTROL_KEY('p'):
+		// these don't look at the rows, the usual handling does for them
+		return false
+	default:
+		// the rest work on rows, which the hex view doesn't have
+		editorSetStatusMessage("not available in the hex view (:hex to switch to text)")
+	}
+	return true
+}
+
+func editorHexScroll() {
+	per := editorHexBytesPerLine()
+	line := E.hexoff / per
+	if line < E.rowoff {
+		E.rowoff = line
+	}
+	if line >= E.rowoff+E.screenrows {
+		E.rowoff = line - E.screenrows + 1
+	}
+}
+
+// the columns where the hex and ascii panes start
+func editorHexColumns() (int, int) {
+	per := editorHexBytesPerLine()
+	return 10, 10 + per*3 + 2
+}
+
+// editorHexCursor is where the cursor goes on screen in the hex view
+func editorHexCursor() (int, int) {
+	per := editorHexBytesPerLine()
+	hexx, asciix := editorHexColumns()
+	col := E.hexoff % per
+	y := E.hexoff/per - E.rowoff
+	if E.hexascii {
+		return asciix + 1 + col, y
+	}
+	x := hexx + col*3 + E.hexnibble
+	if col >= per/2 {
+		x++
+	}
+	return x, y
+}
+
+// editorDrawHex draws lines of offset, hex bytes and their printable characters.
+// the byte under the cursor is marked in the pane the cursor isn't in
+func editorDrawHex() {
+	per := editorHexBytesPerLine()
+	hexx, asciix := editorHexColumns()
+	normal := hlStyle(HG_NORMAL)
+
+	for y := 0; y < E.screenrows; y++ {
+		off := (E.rowoff + y) * per
+		if off > len(E.data) || (off == len(E.data) && off > 0) {
+			screenPutString(0, y, "~", hlStyle(HG_NONTEXT))
+			continue
+		}
+		screenPutString(0, y, fmt.Sprintf("%08x", off), hlStyle(HG_LINENR))
+		screenPutString(asciix, y, "|", hlStyle(HG_NONTEXT))
+
+		for i := 0; i < per && off+i < len(E.data); i++ {
+			b := E.data[off+i]
+			x := hexx + i*3
+			if i >= per/2 {
+				x++
+			}
+			st := normal
+			if b == 0 {
+				st = hlStyle(HG_NONTEXT)
+			} else if b < 0x20 || b > 0x7e {
+				st = hlStyle(HG_SPECIAL)
+			}
+			screenPutString(x, y, fmt.Sprintf("%02x", b), st)
+
+			ch := "."
+			if b >= 0x20 && b <= 0x7e {
+				ch = string(rune(b))
+			}
+			screenPutString(asciix+1+i, y, ch, st)
+
+			if off+i == E.hexoff {
+				if E.hexascii {
+					screenTint(x, y, 2, editorTint(HG_MATCHPAREN))
+				} else {
+					screenTint(asciix+1+i, y, 1, editorTint(HG_MATCHPAREN))
+				}
+			}
+		}
+		if n := min(per, len(E.data)-off); n >= 0 {
+			screenPutString(asciix+1+n, y, "|", hlStyle(HG_NONTEXT))
+		}
+	}
+}
+
+// editorGoto jumps to a byte offset, in decimal or with 0x in hex
+func editorGoto(arg string) {
+	off, err := strconv.ParseInt(strings.TrimSpace(arg), 0, 64)
+	if err != nil || off < 0 {
+		editorSetStatusMessage("invalid offset: %s", arg)
+		return
+	}
+
+	if E.hex {
+		E.hexoff = min(int(off), max(len(E.data)-1, 0))
+		E.hexnibble = 0
+		return
+	}
+
+	eol := 1
+	if E.crlf {
+		eol = 2
+	}
+	for y := range E.row {
+		if int(off) < E.row[y].size+eol {
+			E.cy, E.cx = y, min(int(off), E.row[y].size)
+			return
+		}
+		off -= int64(E.row[y].size + eol)
+	}
+	E.cy = max(E.numrows-1, 0)
+	E.cx = 0
+}
+
+// editorReadBinary loads the file as it is into the hex view
+func editorReadBinary(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	E.hex = true
+	E.data = data
+	E.hexoff = 0
+	E.hexnibble = 0
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testKeys runs keys through the key handling, as if they were typed
+func testKeys(t *testing.T, keys string) {
+	t.Helper()
+	for _, c := range keys {
+		unreadKeys = append(unreadKeys, int(c))
+	}
+	for len(unreadKeys) > 0 {
+		editorProcessKeyPress()
+	}
+}
+
+func TestHexViewKeys(t *testing.T) {
+	testBuffers(t, "abc", "def")
+	editorHexToggle()
+
+	// the commands that work on rows aren't available
+	for _, keys := range []string{"o", "dd", "p", "J", "~", "v", "yy"} {
+		E.statusmsg = ""
+		testKeys(t, keys)
+		if string(E.data) != "abc\ndef\n" || E.mode != NORMAL {
+			t.Fatalf("%s in the hex view left %q in mode %d", keys, E.data, E.mode)
+		}
+		if !strings.Contains(E.statusmsg, "not available") {
+			t.Errorf("%s in the hex view said %q", keys, E.statusmsg)
+		}
+	}
+
+	// its own commands still are, and so are the ones that don't look at rows
+	testKeys(t, "lx")
+	if string(E.data) != "ac\ndef\n" || E.hexoff != 1 {
+		t.Errorf("lx left %q with the cursor at %d", E.data, E.hexoff)
+	}
+	testKeys(t, "u")
+	if string(E.data) != "abc\ndef\n" {
+		t.Errorf("u left %q", E.data)
+	}
+}
@@ -0,0 +1,1038 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LSP_SERVERS is the language server command for each filetype, set with
+// :lsp <filetype> <command>. a server is started the first time a file of
+// its type is opened, when the command can be found
+var LSP_SERVERS = map[string][]string{
+	"go": {"gopls"},
+}
+
+// a server's workspace is the nearest directory up from the file that has one of these
+var LSP_ROOT_MARKERS = []string{"go.mod", ".git"}
+
+// the languageId of a filetype, when it isn't the filetype itself
+var lspLanguageIds = map[string]string{
+	"sh": "shellscript",
+}
+
+// the client is only used from the main goroutine. what the server sends is
+// read in another one and handed over through events
+type lspClient struct {
+	filetype string
+	cmd      *exec.Cmd
+	out      *lspWriter
+	nextid   int
+	pending  map[int]func(json.RawMessage)
+	ready    bool // initialize has been answered
+	stopped  bool
+	utf8     bool // positions count bytes, not utf-16 code units
+	caps     map[string]json.RawMessage
+}
+
+// the running servers by filetype. a nil entry is a server that couldn't be
+// started or died, it isn't tried again until :lsp changes it
+var lspClients = map[string]*lspClient{}
+
+type lspMessage struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *lspError       `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+// a Location, or a LocationLink which names its fields differently
+type lspLocation struct {
+	URI         string   `json:"uri"`
+	Range       lspRange `json:"range"`
+	TargetURI   string   `json:"targetUri"`
+	TargetRange lspRange `json:"targetSelectionRange"`
+}
+
+type lspTextEdit struct {
+	Range   lspRange `json:"range"`
+	NewText string   `json:"newText"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Message  string   `json:"message"`
+	Source   string   `json:"source"`
+}
+
+var lspClientCapabilities = map[string]any{
+	"general": map[string]any{
+		"positionEncodings": []string{"utf-8", "utf-16"},
+	},
+	"textDocument": map[string]any{
+		"synchronization":    map[string]any{"didSave": true},
+		"publishDiagnostics": map[string]any{},
+		"hover":              map[string]any{"contentFormat": []string{"plaintext", "markdown"}},
+		"definition":         map[string]any{"linkSupport": true},
+		"references":         map[string]any{},
+		"rename":             map[string]any{},
+		"formatting":         map[string]any{},
+		"completion": map[string]any{
+			"completionItem": map[string]any{"snippetSupport": false, "documentationFormat": []string{"plaintext", "markdown"}},
+		},
+	},
+	"workspace": map[string]any{
+		"applyEdit":        true,
+		"workspaceEdit":    map[string]any{"documentChanges": true},
+		"configuration":    true,
+		"workspaceFolders": true,
+	},
+}
+
+// lspURI turns a file name into a file:// uri
+func lspURI(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+	abs = filepath.ToSlash(abs)
+	if !strings.HasPrefix(abs, "/") {
+		// C:/x on windows
+		abs = "/" + abs
+	}
+	return (&url.URL{Scheme: "file", Path: abs}).String()
+}
+
+// lspPath turns a file:// uri into a file name, relative to the working directory when it's below it
+func lspPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return ""
+	}
+	path := u.Path
+	if runtime.GOOS == "windows" {
+		path = strings.TrimPrefix(path, "/")
+	}
+	path = filepath.FromSlash(path)
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return path
+}
+
+// editorLspRoot is the directory the server for the current file works in
+func editorLspRoot() string {
+	dir, err := filepath.Abs(filepath.Dir(E.filename))
+	if err != nil {
+		return "."
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, marker := range LSP_ROOT_MARKERS {
+			if _, err := os.Stat(filepath.Join(d, marker)); err == nil {
+				return d
+			}
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
+
+// editorLspClient returns the server for the current buffer, starting it if it isn't running yet
+func editorLspClient() *lspClient {
+	if E.syntax == nil || E.filename == "" || E.hex {
+		return nil
+	}
+	ft := E.syntax.filetype
+	if c, ok := lspClients[ft]; ok {
+		return c
+	}
+	lspClients[ft] = nil
+	args := LSP_SERVERS[ft]
+	if len(args) == 0 {
+		return nil
+	}
+	if _, err := exec.LookPath(args[0]); err != nil {
+		return nil
+	}
+	c, err := lspStart(ft, args, editorLspRoot())
+	if err != nil {
+		editorSetStatusMessage("lsp: can't start %s: %s", args[0], err)
+		return nil
+	}
+	lspClients[ft] = c
+	return c
+}
+
+// editorLspRequire is editorLspClient for commands, which say why there's nothing to do
+func editorLspRequire() *lspClient {
+	c := editorLspClient()
+	if c == nil || !c.ready {
+		if c == nil {
+			editorSetStatusMessage("no language server for this file")
+		} else {
+			editorSetStatusMessage("the language server is still starting")
+		}
+		return nil
+	}
+	// the server has to have the text the request is about
+	editorLspFlush()
+	return c
+}
+
+func lspStart(ft string, args []string, root string) (*lspClient, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = root
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// what servers write to stderr is kept in the state directory for when something goes wrong
+	if dir := editorStateDir(); dir != "" && os.MkdirAll(dir, 0755) == nil {
+		if log, err := os.OpenFile(filepath.Join(dir, "lsp.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			cmd.Stderr = log
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	c := &lspClient{filetype: ft, cmd: cmd, out: newLspWriter(in), pending: map[int]func(json.RawMessage){}}
+	go c.read(bufio.NewReader(out))
+
+	folder := map[string]string{"uri": lspURI(root), "name": filepath.Base(root)}
+	c.request("initialize", map[string]any{
+		"processId":        os.Getpid(),
+		"clientInfo":       map[string]string{"name": "goditor", "version": GODITOR_VERSION},
+		"rootUri":          folder["uri"],
+		"workspaceFolders": []map[string]string{folder},
+		"capabilities":     lspClientCapabilities,
+	}, func(result json.RawMessage) {
+		var init struct {
+			Capabilities map[string]json.RawMessage `json:"capabilities"`
+		}
+		json.Unmarshal(result, &init)
+		c.caps = init.Capabilities
+		var encoding string
+		json.Unmarshal(c.caps["positionEncoding"], &encoding)
+		c.utf8 = encoding == "utf-8"
+		c.notify("initialized", struct{}{})
+		c.ready = true
+
+		for i := range buffers {
+			editorInBuffer(i, editorLspSync)
+		}
+	})
+	return c, nil
+}
+
+// read runs in its own goroutine until the server goes away
+func (c *lspClient) read(r *bufio.Reader) {
+	for {
+		body, err := lspReadMessage(r)
+		if err != nil {
+			events <- c.exited
+			return
+		}
+		msg := &lspMessage{}
+		if json.Unmarshal(body, msg) != nil {
+			continue
+		}
+		events <- func() { c.handle(msg) }
+	}
+}
+
+// lspReadMessage reads the headers and the body of one message
+func lspReadMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without a Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// an lspWriter frames messages and writes them from a goroutine of its own,
+// so a server that stops reading only holds up its queue and not the editor
+type lspWriter struct {
+	w      io.WriteCloser
+	mu     sync.Mutex
+	queue  [][]byte
+	closed bool
+	wake   chan struct{}
+}
+
+func newLspWriter(w io.WriteCloser) *lspWriter {
+	lw := &lspWriter{w: w, wake: make(chan struct{}, 1)}
+	go lw.run()
+	return lw
+}
+
+// send queues msg to be written
+func (lw *lspWriter) send(msg lspMessage) {
+	msg.Jsonrpc = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	lw.mu.Lock()
+	if !lw.closed {
+		lw.queue = append(lw.queue, fmt.Appendf(nil, "Content-Length: %d\r\n\r\n%s", len(body), body))
+	}
+	lw.mu.Unlock()
+	lw.signal()
+}
+
+// close closes the pipe once what's queued has been written
+func (lw *lspWriter) close() {
+	lw.mu.Lock()
+	lw.closed = true
+	lw.mu.Unlock()
+	lw.signal()
+}
+
+func (lw *lspWriter) signal() {
+	select {
+	case lw.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (lw *lspWriter) run() {
+	for range lw.wake {
+		lw.mu.Lock()
+		queue, closed := lw.queue, lw.closed
+		lw.queue = nil
+		lw.mu.Unlock()
+		for _, b := range queue {
+			// a dead process is noticed by whoever reads from it
+			lw.w.Write(b)
+		}
+		if closed {
+			lw.w.Close()
+			return
+		}
+	}
+}
+
+func (c *lspClient) send(msg lspMessage) {
+	c.out.send(msg)
+}
+
+// request sends method and has handle called with the result when it comes back. errors go to the status line
+func (c *lspClient) request(method string, params any, handle func(json.RawMessage)) {
+	c.nextid++
+	c.pending[c.nextid] = handle
+	p, _ := json.Marshal(params)
+	c.send(lspMessage{ID: json.RawMessage(strconv.Itoa(c.nextid)), Method: method, Params: p})
+}
+
+func (c *lspClient) notify(method string, params any) {
+	p, _ := json.Marshal(params)
+	c.send(lspMessage{Method: method, Params: p})
+}
+
+func (c *lspClient) handle(msg *lspMessage) {
+	if c.stopped {
+		return
+	}
+	switch {
+	case msg.Method == "":
+		id, err := strconv.Atoi(string(msg.ID))
+		handle, ok := c.pending[id]
+		if err != nil || !ok {
+			return
+		}
+		delete(c.pending, id)
+		if msg.Error != nil {
+			editorSetStatusMessage("lsp: %s", msg.Error.Message)
+			return
+		}
+		handle(msg.Result)
+	case msg.ID != nil:
+		c.reply(msg)
+	default:
+		c.notification(msg.Method, msg.Params)
+	}
+}
+
+// reply answers the requests a server sends us
+func (c *lspClient) reply(msg *lspMessage) {
+	resp := lspMessage{ID: msg.ID, Result: json.RawMessage("null")}
+	switch msg.Method {
+	case "workspace/configuration":
+		// no settings, for every item asked about
+		var p struct {
+			Items []json.RawMessage `json:"items"`
+		}
+		json.Unmarshal(msg.Params, &p)
+		resp.Result, _ = json.Marshal(make([]any, len(p.Items)))
+	case "workspace/applyEdit":
+		var p struct {
+			Edit json.RawMessage `json:"edit"`
+		}
+		json.Unmarshal(msg.Params, &p)
+		editorLspApplyWorkspaceEdit(c, p.Edit)
+		resp.Result = json.RawMessage(`{"applied":true}`)
+	case "workspace/workspaceFolders":
+		resp.Result, _ = json.Marshal([]map[string]string{{"uri": lspURI("."), "name": "."}})
+	case "window/workDoneProgress/create", "client/registerCapability", "client/unregisterCapability", "window/showMessageRequest":
+	default:
+		resp.Result = nil
+		resp.Error = &lspError{Code: -32601, Message: "method not found: " + msg.Method}
+	}
+	c.send(resp)
+}
+
+func (c *lspClient) notification(method string, params json.RawMessage) {
+	switch method {
+	case "textDocument/publishDiagnostics":
+		editorLspDiagnostics(params)
+	case "window/showMessage":
+		var p struct {
+			Type    int    `json:"type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(params, &p)
+		if p.Type <= 2 {
+			editorSetStatusMessage("lsp: %s", p.Message)
+		}
+	}
+}
+
+// exited is run when the server's output ends
+func (c *lspClient) exited() {
+	if c.stopped || lspClients[c.filetype] != c {
+		return
+	}
+	c.stopped = true
+	lspClients[c.filetype] = nil
+	go c.cmd.Wait()
+	editorLspClearSigns()
+	editorSetStatusMessage("lsp: %s exited", c.cmd.Path)
+}
+
+func (c *lspClient) stop() {
+	c.stopped = true
+	if c.ready {
+		c.request("shutdown", nil, func(json.RawMessage) {})
+		c.notify("exit", nil)
+	}
+	c.out.close()
+	go c.cmd.Wait()
+	editorLspClearSigns()
+}
+
+// editorLspClearSigns drops the diagnostics of a server that's gone
+func editorLspClearSigns() {
+	for i := range buffers {
+		editorInBuffer(i, func() { editorSetSigns("lsp", nil) })
+	}
+}
+
+// editorLspShutdown stops every server, when goditor exits
+func editorLspShutdown() {
+	for _, c := range lspClients {
+		if c != nil {
+			c.stop()
+		}
+	}
+}
+
+// character is how the server counts x bytes into chars
+func (c *lspClient) character(chars []byte, x int) int {
+	x = min(x, len(chars))
+	if c.utf8 {
+		return x
+	}
+	n := 0
+	for _, r := range string(chars[:x]) {
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return n
+}
+
+// offset turns a character from the server back into a byte offset into chars
+func (c *lspClient) offset(chars []byte, character int) int {
+	if c.utf8 {
+		return min(max(character, 0), len(chars))
+	}
+	n := 0
+	for i, r := range string(chars) {
+		if n >= character {
+			return i
+		}
+		n++
+		if r >= 0x10000 {
+			n++
+		}
+	}
+	return len(chars)
+}
+
+func lspDocument() map[string]string {
+	return map[string]string{"uri": lspURI(E.filename)}
+}
+
+// positionParams names the document and the cursor position in it
+func (c *lspClient) positionParams() map[string]any {
+	pos := lspPosition{Line: E.cy}
+	if E.cy < E.numrows {
+		pos.Character = c.character(E.row[E.cy].chars, E.cx)
+	}
+	return map[string]any{"textDocument": lspDocument(), "position": pos}
+}
+
+// editorLspSync keeps the server's copy of the current buffer up to date, it
+// runs before every redraw. while the text is still changing it waits, so
+// typing doesn't send the whole of it on every key
+func editorLspSync() {
+	c := editorLspClient()
+	if c == nil || !c.ready || E.lsp == c && E.lsp_tick == E.changetick {
+		return
+	}
+	if E.lsp == c {
+		filename := E.filename
+		again := func() {
+			if i := editorFindBuffer(filename); i >= 0 {
+				editorInBuffer(i, editorLspSync)
+			}
+		}
+		if !E.lsp_wait.ready(E.changetick, again) {
+			return
+		}
+	}
+	editorLspFlush()
+}
+
+// editorLspFlush sends the server the current buffer as it is now: the whole
+// text when it's first opened and again when it changed
+func editorLspFlush() {
+	c := editorLspClient()
+	if c == nil || !c.ready {
+		return
+	}
+	if E.lsp == c && E.lsp_tick == E.changetick {
+		return
+	}
+	var text strings.Builder
+	for i := range E.row {
+		text.Write(E.row[i].chars)
+		text.WriteByte('\n')
+	}
+
+	if E.lsp != c {
+		E.lsp = c
+		E.lsp_version = 1
+		languageId := E.syntax.filetype
+		if id, ok := lspLanguageIds[languageId]; ok {
+			languageId = id
+		}
+		c.notify("textDocument/didOpen", map[string]any{
+			"textDocument": map[string]any{"uri": lspURI(E.filename), "languageId": languageId, "version": E.lsp_version, "text": text.String()},
+		})
+	} else {
+		E.lsp_version++
+		c.notify("textDocument/didChange", map[string]any{
+			"textDocument":   map[string]any{"uri": lspURI(E.filename), "version": E.lsp_version},
+			"contentChanges": []map[string]string{{"text": text.String()}},
+		})
+	}
+	E.lsp_tick = E.changetick
+}
+
+// editorLspDidSave is run after the current buffer is written
+func editorLspDidSave() {
+	editorLspFlush()
+	if c := editorLspClient(); c != nil && c.ready && E.lsp == c {
+		c.notify("textDocument/didSave", map[string]any{"textDocument": lspDocument()})
+	}
+}
+
+// editorLspDiagnostics shows what the server found in a file as signs with their messages
+func editorLspDiagnostics(params json.RawMessage) {
+	var p struct {
+		URI         string          `json:"uri"`
+		Diagnostics []lspDiagnostic `json:"diagnostics"`
+	}
+	if json.Unmarshal(params, &p) != nil {
+		return
+	}
+	i := editorFindBuffer(lspPath(p.URI))
+	if i < 0 {
+		return
+	}
+
+	severity := func(d lspDiagnostic) int {
+		if d.Severity == 0 {
+			return 1
+		}
+		return d.Severity
+	}
+	// the worst one on a line goes last so it's the one shown
+	sort.SliceStable(p.Diagnostics, func(a, b int) bool {
+		return severity(p.Diagnostics[a]) > severity(p.Diagnostics[b])
+	})
+	var signs []sign
+	for _, d := range p.Diagnostics {
+		s := sign{line: d.Range.Start.Line, text: "E>", group: HG_DIAGERROR, message: d.Message}
+		switch severity(d) {
+		case 2:
+			s.text, s.group = "W>", HG_DIAGWARN
+		case 3, 4:
+			s.text, s.group = "I>", HG_DIAGINFO
+		}
+		signs = append(signs, s)
+	}
+	editorInBuffer(i, func() { editorSetSigns("lsp", signs) })
+}
+
+// lspParseLocations reads a Location, a list of them or a list of LocationLinks
+func lspParseLocations(result json.RawMessage) []lspLocation {
+	var locs []lspLocation
+	if json.Unmarshal(result, &locs) != nil {
+		var loc lspLocation
+		if json.Unmarshal(result, &loc) != nil {
+			return nil
+		}
+		locs = []lspLocation{loc}
+	}
+	for i := range locs {
+		if locs[i].TargetURI != "" {
+			locs[i].URI = locs[i].TargetURI
+			locs[i].Range = locs[i].TargetRange
+		}
+	}
+	return slices.DeleteFunc(locs, func(l lspLocation) bool { return l.URI == "" })
+}
+
+// editorLspJump opens the file of loc and puts the cursor where it points
+func editorLspJump(c *lspClient, loc lspLocation) {
+	path := lspPath(loc.URI)
+	if path == "" {
+		editorSetStatusMessage("can't open %s", loc.URI)
+		return
+	}
+	editorEdit(path)
+	if E.numrows == 0 {
+		return
+	}
+	E.cy = min(max(loc.Range.Start.Line, 0), E.numrows-1)
+	E.cx = c.offset(E.row[E.cy].chars, loc.Range.Start.Character)
+}
+
+func editorLspDefinition() {
+	c := editorLspRequire()
+	if c == nil {
+		return
+	}
+	c.request("textDocument/definition", c.positionParams(), func(result json.RawMessage) {
+		locs := lspParseLocations(result)
+		if len(locs) == 0 {
+			editorSetStatusMessage("no definition found")
+			return
+		}
+		editorLspJump(c, locs[0])
+	})
+}
+
+// lspMarkup gets the text out of hover contents, which may be MarkupContent,
+// a MarkedString or a list of MarkedStrings
+func lspMarkup(contents json.RawMessage) string {
+	var s string
+	if json.Unmarshal(contents, &s) == nil {
+		return s
+	}
+	var list []json.RawMessage
+	if json.Unmarshal(contents, &list) == nil {
+		parts := make([]string, 0, len(list))
+		for _, part := range list {
+			parts = append(parts, lspMarkup(part))
+		}
+		return strings.Join(parts, "\n\n")
+	}
+	var markup struct {
+		Value string `json:"value"`
+	}
+	json.Unmarshal(contents, &markup)
+	return markup.Value
+}
+
+func editorLspHover() {
+	c := editorLspRequire()
+	if c == nil {
+		return
+	}
+	c.request("textDocument/hover", c.positionParams(), func(result json.RawMessage) {
+		var hover struct {
+			Contents json.RawMessage `json:"contents"`
+		}
+		json.Unmarshal(result, &hover)
+		text := strings.TrimSpace(lspMarkup(hover.Contents))
+		if text == "" {
+			editorSetStatusMessage("no information")
+			return
+		}
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			// code fences in markdown
+			if strings.HasPrefix(line, "```") {
+				continue
+			}
+			lines = append(lines, strings.ReplaceAll(strings.TrimRight(line, "\r"), "\t", "    "))
+		}
+		editorShowPopup(lines)
+	})
+}
+
+// lspLineText is a line of filename, from its buffer when it's open
+func lspLineText(filename string, line int, files map[string][]string) string {
+	lines, ok := files[filename]
+	if !ok {
+		if i := editorFindBuffer(filename); i >= 0 {
+			b := &buffers[i]
+			if i == curbuf {
+				b = &E
+			}
+			for y := range b.row {
+				lines = append(lines, string(b.row[y].chars))
+			}
+		} else {
+			lines, _ = editorReadLines(filename)
+		}
+		files[filename] = lines
+	}
+	if line < 0 || line >= len(lines) {
+		return ""
+	}
+	return lines[line]
+}
+
+func editorLspReferences() {
+	c := editorLspRequire()
+	if c == nil {
+		return
+	}
+	params := c.positionParams()
+	params["context"] = map[string]bool{"includeDeclaration": true}
+	c.request("textDocument/references", params, func(result json.RawMessage) {
+		locs := lspParseLocations(result)
+		if len(locs) == 0 {
+			editorSetStatusMessage("no references found")
+			return
+		}
+		sort.SliceStable(locs, func(i, j int) bool {
+			if locs[i].URI != locs[j].URI {
+				return locs[i].URI < locs[j].URI
+			}
+			return locs[i].Range.Start.Line < locs[j].Range.Start.Line
+		})
+
+		files := map[string][]string{}
+		lines := make([]string, len(locs))
+		for i, loc := range locs {
+			path := lspPath(loc.URI)
+			text := lspLineText(path, loc.Range.Start.Line, files)
+			lines[i] = fmt.Sprintf("%s:%d:%d: %s", path, loc.Range.Start.Line+1, loc.Range.Start.Character+1, strings.TrimSpace(text))
+		}
+		if i := editorPickLine(fmt.Sprintf("references (%d)", len(locs)), lines); i >= 0 {
+			editorLspJump(c, locs[i])
+		}
+	})
+}
+
+// the names of CompletionItemKind, 1 is text
+var lspCompletionKinds = []string{"", "text", "method", "func", "constructor", "field", "var", "class",
+	"interface", "module", "property", "unit", "value", "enum", "keyword", "snippet", "color",
+	"file", "reference", "folder", "enummember", "const", "struct", "event", "operator", "typeparam"}
+
+// editorLspComplete asks the server what could go at the cursor, for the completion menu
+func editorLspComplete() {
+	c := editorLspClient()
+	if c == nil || !c.ready || c.caps["completionProvider"] == nil {
+		return
+	}
+	// the server has to have the text up to the cursor
+	editorLspFlush()
+	compl.lspSeq++
+	seq := compl.lspSeq
+	compl.lspWaiting = true
+	c.request("textDocument/completion", c.positionParams(), func(result json.RawMessage) {
+		if !compl.active || compl.lspSeq != seq {
+			return
+		}
+		var list struct {
+			IsIncomplete bool `json:"isIncomplete"`
+			Items        []struct {
+				Label            string          `json:"label"`
+				Kind             int             `json:"kind"`
+				Detail           string          `json:"detail"`
+				Documentation    json.RawMessage `json:"documentation"`
+				InsertText       string          `json:"insertText"`
+				InsertTextFormat int             `json:"insertTextFormat"`
+				TextEdit         *lspTextEdit    `json:"textEdit"`
+			} `json:"items"`
+		}
+		// a CompletionList, or just the items
+		if json.Unmarshal(result, &list) != nil {
+			json.Unmarshal(result, &list.Items)
+		}
+
+		var items []complItem
+		for _, it := range list.Items {
+			word := it.Label
+			// snippets have placeholders in them, the label is plain
+			if it.InsertTextFormat != 2 {
+				if it.TextEdit != nil {
+					word = it.TextEdit.NewText
+				} else if it.InsertText != "" {
+					word = it.InsertText
+				}
+			}
+			word, _, _ = strings.Cut(word, "\n")
+			kind := "lsp"
+			if it.Kind > 0 && it.Kind < len(lspCompletionKinds) {
+				kind = lspCompletionKinds[it.Kind]
+			}
+			detail := it.Detail
+			if doc := strings.TrimSpace(lspMarkup(it.Documentation)); doc != "" {
+				detail = strings.TrimSpace(detail + "\n\n" + doc)
+			}
+			items = append(items, complItem{word: word, kind: kind, detail: detail})
+		}
+		editorSetLspCompletions(items, list.IsIncomplete)
+	})
+}
+
+func editorLspRename(name string) {
+	if !editorWritable() {
+		return
+	}
+	c := editorLspRequire()
+	if c == nil {
+		return
+	}
+	if name == "" {
+		if name = editorPrompt("rename to: %s", nil, nil); name == "" {
+			return
+		}
+	}
+	params := c.positionParams()
+	params["newName"] = name
+	c.request("textDocument/rename", params, func(result json.RawMessage) {
+		if n := editorLspApplyWorkspaceEdit(c, result); n > 0 {
+			editorSetStatusMessage("renamed to %s in %d files", name, n)
+		} else {
+			editorSetStatusMessage("nothing to rename")
+		}
+	})
+}
+
+func editorLspFormat() {
+	if !editorWritable() {
+		return
+	}
+	c := editorLspRequire()
+	if c == nil {
+		return
+	}
+	filename, tick := E.filename, E.changetick
+	params := map[string]any{
+		"textDocument": lspDocument(),
+		"options":      map[string]any{"tabSize": TAB_STOP, "insertSpaces": false},
+	}
+	c.request("textDocument/formatting", params, func(result json.RawMessage) {
+		var edits []lspTextEdit
+		json.Unmarshal(result, &edits)
+		i := editorFindBuffer(filename)
+		if i < 0 {
+			return
+		}
+		editorInBuffer(i, func() {
+			// edits for text that has changed since would land in the wrong places
+			if E.changetick != tick {
+				return
+			}
+			c.applyEdits(edits)
+			editorUndoCommit()
+		})
+	})
+}
+
+// editorLspApplyWorkspaceEdit makes the changes of a rename or a code action, opening
+// the files that aren't open yet. it returns how many files it changed
+func editorLspApplyWorkspaceEdit(c *lspClient, raw json.RawMessage) int {
+	var edit struct {
+		Changes         map[string][]lspTextEdit `json:"changes"`
+		DocumentChanges []struct {
+			TextDocument struct {
+				URI string `json:"uri"`
+			} `json:"textDocument"`
+			Edits []lspTextEdit `json:"edits"`
+		} `json:"documentChanges"`
+	}
+	if json.Unmarshal(raw, &edit) != nil {
+		return 0
+	}
+	files := edit.Changes
+	if files == nil {
+		files = map[string][]lspTextEdit{}
+	}
+	for _, doc := range edit.DocumentChanges {
+		// creating, renaming and deleting files has no textDocument
+		if doc.TextDocument.URI != "" {
+			files[doc.TextDocument.URI] = append(files[doc.TextDocument.URI], doc.Edits...)
+		}
+	}
+
+	n := 0
+	for uri, edits := range files {
+		path := lspPath(uri)
+		if path == "" || len(edits) == 0 {
+			continue
+		}
+		i := editorFindBuffer(path)
+		if i < 0 {
+			cur := curbuf
+			editorEdit(path)
+			i = curbuf
+			editorSwitchBuffer(cur)
+		}
+		editorInBuffer(i, func() {
+			c.applyEdits(edits)
+			editorUndoCommit()
+		})
+		n++
+	}
+	return n
+}
+
+// applyEdits makes the text edits to the current buffer. they all refer to the text
+// as it was before any of them, so they're made on a copy from the end back
+func (c *lspClient) applyEdits(edits []lspTextEdit) {
+	if len(edits) == 0 {
+		return
+	}
+	starts := make([]int, E.numrows)
+	var b strings.Builder
+	for i := range E.row {
+		starts[i] = b.Len()
+		b.Write(E.row[i].chars)
+		b.WriteByte('\n')
+	}
+	text := b.String()
+	offset := func(p lspPosition) int {
+		if p.Line >= E.numrows {
+			return len(text)
+		}
+		return starts[p.Line] + c.offset(E.row[p.Line].chars, p.Character)
+	}
+
+	// edits at the same place go in the order they came in
+	edits = slices.Clone(edits)
+	slices.Reverse(edits)
+	sort.SliceStable(edits, func(i, j int) bool {
+		return offset(edits[i].Range.Start) > offset(edits[j].Range.Start)
+	})
+	for _, e := range edits {
+		start, end := offset(e.Range.Start), offset(e.Range.End)
+		if end < start {
+			continue
+		}
+		text = text[:start] + strings.ReplaceAll(e.NewText, "\r\n", "\n") + text[end:]
+	}
+
+	var lines []string
+	if text != "" {
+		lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	}
+	editorSetLines(lines)
+	editorLspFlush()
+}
+
+// editorLspCommand is :lsp. with no arguments it lists the servers, with a
+// filetype and a command it sets the server for that filetype, with just a
+// filetype it turns its server off
+func editorLspCommand(args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		var lines []string
+		for ft, cmd := range LSP_SERVERS {
+			state := "not running"
+			if c := lspClients[ft]; c != nil {
+				state = fmt.Sprintf("running, pid %d", c.cmd.Process.Pid)
+			} else if _, err := exec.LookPath(cmd[0]); err != nil {
+				state = "not found"
+			}
+			lines = append(lines, fmt.Sprintf("%-12s %-30s %s", ft, strings.Join(cmd, " "), state))
+		}
+		sort.Strings(lines)
+		editorShowLines("language servers", lines)
+		return
+	}
+
+	ft := fields[0]
+	if c := lspClients[ft]; c != nil {
+		c.stop()
+	}
+	delete(lspClients, ft)
+	if len(fields) == 1 {
+		delete(LSP_SERVERS, ft)
+		return
+	}
+	LSP_SERVERS[ft] = fields[1:]
+}
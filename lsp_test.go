@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testWaitFor runs events until done says what was being waited for happened
+func testWaitFor(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !done() {
+		select {
+		case f := <-events:
+			f()
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestLspReadMessage(t *testing.T) {
+	// the length is in bytes
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\nContent-Type: application/vscode-jsonrpc\r\n\r\n{}" +
+		"content-length:  9\r\n\r\n[1,\"日\"]"))
+	for _, want := range []string{"{}", `[1,"日"]`} {
+		body, err := lspReadMessage(r)
+		if err != nil || string(body) != want {
+			t.Errorf("message is %q, %v, want %q", body, err, want)
+		}
+	}
+	if _, err := lspReadMessage(r); err == nil {
+		t.Error("reading past the last message didn't fail")
+	}
+	if _, err := lspReadMessage(bufio.NewReader(strings.NewReader("Content-Type: x\r\n\r\n{}"))); err == nil {
+		t.Error("a message without a Content-Length was read")
+	}
+}
+
+func TestLspPositions(t *testing.T) {
+	// 日 is one utf-16 unit and three bytes, 😀 two units and four bytes
+	chars := []byte("a日😀b")
+	c := &lspClient{}
+	for _, tc := range []struct{ x, character int }{{0, 0}, {1, 1}, {4, 2}, {8, 4}, {9, 5}} {
+		if n := c.character(chars, tc.x); n != tc.character {
+			t.Errorf("byte %d is character %d, want %d", tc.x, n, tc.character)
+		}
+		if x := c.offset(chars, tc.character); x != tc.x {
+			t.Errorf("character %d is byte %d, want %d", tc.character, x, tc.x)
+		}
+	}
+	// the middle of a surrogate pair is the character after it, and past the end is the end
+	if x := c.offset(chars, 3); x != 8 {
+		t.Errorf("character 3 is byte %d, want 8", x)
+	}
+	if x := c.offset(chars, 99); x != 9 {
+		t.Errorf("character 99 is byte %d, want 9", x)
+	}
+
+	c.utf8 = true
+	if n, x := c.character(chars, 4), c.offset(chars, 8); n != 4 || x != 8 {
+		t.Errorf("with utf-8 positions byte 4 is %d and character 8 is %d", n, x)
+	}
+}
+
+func TestLspParseLocations(t *testing.T) {
+	for _, tc := range []struct {
+		result string
+		want   []lspLocation
+	}{
+		{`null`, nil},
+		{`{"uri":"file:///a.go","range":{"start":{"line":1,"character":2},"end":{"line":1,"character":4}}}`,
+			[]lspLocation{{URI: "file:///a.go", Range: lspRange{lspPosition{1, 2}, lspPosition{1, 4}}}}},
+		{`[{"uri":"file:///a.go","range":{"start":{"line":1,"character":2}}},{"uri":"","range":{}},{"uri":"file:///b.go"}]`,
+			[]lspLocation{{URI: "file:///a.go", Range: lspRange{Start: lspPosition{1, 2}}}, {URI: "file:///b.go"}}},
+		// links point at the name in targetSelectionRange
+		{`[{"targetUri":"file:///c.go","targetRange":{"start":{"line":3}},"targetSelectionRange":{"start":{"line":5,"character":6}}}]`,
+			[]lspLocation{{URI: "file:///c.go", Range: lspRange{Start: lspPosition{5, 6}}}}},
+	} {
+		locs := lspParseLocations(json.RawMessage(tc.result))
+		for i := range locs {
+			locs[i].TargetURI, locs[i].TargetRange = "", lspRange{}
+		}
+		if !slices.Equal(locs, tc.want) {
+			t.Errorf("locations of %s are %+v, want %+v", tc.result, locs, tc.want)
+		}
+	}
+}
+
+func TestLspApplyEdits(t *testing.T) {
+	testBuffers(t, "func old() {}", "x := \"😀\" + old", "")
+	c := &lspClient{}
+	edits := []lspTextEdit{
+		// after the emoji, which is two characters in utf-16
+		{lspRange{lspPosition{1, 12}, lspPosition{1, 15}}, "new"},
+		{lspRange{lspPosition{0, 5}, lspPosition{0, 8}}, "new"},
+		// both of these go at the start, in the order they came in
+		{lspRange{lspPosition{0, 0}, lspPosition{0, 0}}, "// a\r\n"},
+		{lspRange{lspPosition{0, 0}, lspPosition{0, 0}}, "// b\n"},
+		// joining the last two lines
+		{lspRange{lspPosition{1, 15}, lspPosition{2, 0}}, ""},
+	}
+	c.applyEdits(edits)
+	editorUndoCommit()
+	want := []string{"// a", "// b", "func new() {}", "x := \"😀\" + new"}
+	if got := editorBufferLines(); !slices.Equal(got, want) {
+		t.Errorf("lines are %q, want %q", got, want)
+	}
+	// they're one change
+	editorUndo()
+	want = []string{"func old() {}", "x := \"😀\" + old", ""}
+	if got := editorBufferLines(); !slices.Equal(got, want) {
+		t.Errorf("lines after undo are %q, want %q", got, want)
+	}
+}
+
+func TestLspDiagnostics(t *testing.T) {
+	testBuffers(t, "a", "b", "c")
+	E.filename = filepath.Join(t.TempDir(), "a.go")
+	params, _ := json.Marshal(map[string]any{
+		"uri": lspURI(E.filename),
+		"diagnostics": []map[string]any{
+			{"range": lspRange{Start: lspPosition{Line: 0}}, "severity": 1, "message": "broken"},
+			{"range": lspRange{Start: lspPosition{Line: 0}}, "severity": 3, "message": "a hint"},
+			{"range": lspRange{Start: lspPosition{Line: 2}}, "severity": 2, "message": "odd"},
+			{"range": lspRange{Start: lspPosition{Line: 1}}, "message": "no severity is an error"},
+		},
+	})
+	editorLspDiagnostics(params)
+	for _, tc := range []struct {
+		line          int
+		text, message string
+		group         hlGroup
+	}{
+		{0, "E>", "broken", HG_DIAGERROR},
+		{1, "E>", "no severity is an error", HG_DIAGERROR},
+		{2, "W>", "odd", HG_DIAGWARN},
+	} {
+		s, ok := editorSignAt(tc.line)
+		if !ok || s.text != tc.text || s.message != tc.message || s.group != tc.group || s.source != "lsp" {
+			t.Errorf("sign on line %d is %+v, want %s %q", tc.line, s, tc.text, tc.message)
+		}
+	}
+
+	// for a file that isn't open there's nowhere to put them
+	params, _ = json.Marshal(map[string]any{"uri": lspURI("elsewhere.go"), "diagnostics": []map[string]any{{"message": "x"}}})
+	editorLspDiagnostics(params)
+	if len(E.signs) != 4 {
+		t.Errorf("%d signs after diagnostics for another file, want 4", len(E.signs))
+	}
+}
+
+func TestLspServer(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go isn't on PATH to build the fake server")
+	}
+	server := filepath.Join(t.TempDir(), "fakelsp")
+	if out, err := exec.Command(gobin, "build", "-o", server, "./testdata/fakelsp").CombinedOutput(); err != nil {
+		t.Fatalf("building the fake server: %s\n%s", err, out)
+	}
+	servers, clients := LSP_SERVERS, lspClients
+	LSP_SERVERS, lspClients = map[string][]string{"go": {server}}, map[string]*lspClient{}
+	t.Cleanup(func() {
+		editorLspShutdown()
+		LSP_SERVERS, lspClients = servers, clients
+	})
+	for len(events) > 0 {
+		<-events
+	}
+
+	testBuffers(t, "package main", "", "var 😀x = bad   ", "var y = meh", "func old() { old() }")
+	E.filename = filepath.Join(t.TempDir(), "a.go")
+	if err := os.WriteFile(E.filename, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorSelectSyntaxHighlight()
+	editorLspSync()
+	testWaitFor(t, "the server to start", func() bool { return lspClients["go"] != nil && lspClients["go"].ready })
+	testWaitFor(t, "diagnostics", func() bool { return len(E.signs) == 2 })
+	if s, _ := editorSignAt(2); s.text != "E>" || s.message != "bad line" {
+		t.Errorf("sign on the bad line is %+v", s)
+	}
+	if s, _ := editorSignAt(3); s.text != "W>" || s.message != "meh line" {
+		t.Errorf("sign on the meh line is %+v", s)
+	}
+
+	// changes go over once typing stops
+	E.cy, E.cx = 3, 8
+	for _, c := range "bad" {
+		editorInsertChar(int(c))
+		editorLspSync()
+	}
+	if E.lsp_tick == E.changetick {
+		t.Error("the text went to the server while it was still changing")
+	}
+	testWaitFor(t, "the change to go over", func() bool { return E.lsp_tick == E.changetick })
+	testWaitFor(t, "diagnostics for the change", func() bool {
+		s, _ := editorSignAt(3)
+		return s.text == "E>"
+	})
+
+	// the trailing blanks come after the emoji, so the edit is in utf-16
+	editorLspFormat()
+	testWaitFor(t, "formatting", func() bool { return string(E.row[2].chars) == "var 😀x = bad" })
+
+	E.cy, E.cx = 4, 6
+	editorLspRename("renamed")
+	testWaitFor(t, "rename", func() bool { return strings.Contains(string(E.row[4].chars), "renamed") })
+	if got := string(E.row[4].chars); got != "func renamed() { renamed() }" {
+		t.Errorf("renamed line is %q", got)
+	}
+	editorUndo()
+	if got := string(E.row[4].chars); got != "func old() { old() }" {
+		t.Errorf("after undoing the rename the line is %q", got)
+	}
+}
@@ -2,13 +2,14 @@ package main
 
 import (
 	"bufio"
-	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
-	"math"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"golang.org/x/term"
 )
@@ -32,8 +33,10 @@ const (
 )
 
 const (
-	NORMAL = 'N'
-	INSERT = 'I'
+	NORMAL      = 'N'
+	INSERT      = 'I'
+	VISUAL      = 'v'
+	VISUAL_LINE = 'V'
 
 	LEFT  = 104
 	DOWN  = 106
@@ -42,14 +45,20 @@ const (
 )
 
 type erow struct {
-	size   int
-	chars  []byte
-	rsize  int
-	render []byte
+	idx             int
+	size            int
+	chars           []byte
+	rsize           int
+	render          []byte
+	kind            []byte // RK_* for every byte of render
+	trail           int    // where trailing whitespace starts in render
+	hl              []byte
+	hl_open_comment bool
 }
 
 type EditorConfig struct {
 	cx, cy, rx             int
+	vx, vy                 int // where the visual selection started
 	rowoff, coloff         int
 	screenrows, screencols int
 	raw_screencols         int
@@ -62,25 +71,67 @@ type EditorConfig struct {
 	dirty                  bool
 	mode                   byte
 	cxm                    int
+	file_mtime             time.Time
+	file_size              int64
+	file_hash              [sha256.Size]byte
+	autoread               bool
+	undo                   undoTree
+	marks                  map[byte]mark
+	readonly               bool
+	syntax                 *editorSyntax
+	signs                  []sign
+	crlf                   bool
+	changetick             int // bumped on every change to the text
+	folds                  []fold
+	folds_tick             int
+	folds_method           string
+	search_query           string       // what search_matches were found for
+	search_tick            int          // and the changetick they were found at
+	search_matches         [][2]int     // row and render index of every match, for editorSearchCount
+	closed                 map[int]bool // start rows of closed folds
+	closed_folds           []fold
+	hex                    bool   // the buffer is shown and edited as bytes
+	data                   []byte // the bytes, instead of row, in the hex view
+	hexoff                 int
+	hexnibble              int        // which half of the byte the cursor is on in the hex pane
+	hexascii               bool       // the cursor is in the ascii pane
+	lsp                    *lspClient // the language server that has the buffer open
+	lsp_version            int
+	lsp_tick               int // changetick of the text the server last got
+	lsp_wait               debounce
+	formatting             bool   // a formatter is running before a save
+	buftype                string // "" for a file, or what else the buffer shows, like "quickfix"
+	explorer               *explorer
+	git                    *gitState
+	diff                   *diffState
+	term                   *terminal // the program a terminal buffer runs
+	topskip                int       // diff filler lines above rowoff scrolled out of view
 }
 
 var (
 	terminalState *term.State
 	E             = EditorConfig{}
+	keyChan       = make(chan []byte)
+	events        = make(chan func(), 256) // work from other goroutines, run by editorReadKey
+	pendingKeys   []byte
+	unreadKeys    []int // keys read ahead that are to be read again, before pendingKeys
+	inPrompt      int
 	// abuf          = byte.Buffer{}
 )
 
 func die(error string) {
-	os.Stdout.Write([]byte("\x1b[3J"))
-	os.Stdout.Write([]byte("\x1b[2J"))
-	os.Stdout.Write([]byte("\x1b[H"))
-	term.Restore(int(os.Stdout.Fd()), terminalState)
+	ttyOut.Write([]byte("\x1b[3J"))
+	ttyOut.Write([]byte("\x1b[2J"))
+	ttyOut.Write([]byte("\x1b[H"))
+	if terminalState != nil {
+		term.Restore(int(ttyIn.Fd()), terminalState)
+	}
 	fmt.Println(error)
 	os.Exit(1)
 }
 
 func enableRawMode() {
-	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	oldState, err := term.MakeRaw(int(ttyIn.Fd()))
 	terminalState = oldState
 
 	if err != nil {
@@ -89,37 +140,102 @@ func enableRawMode() {
 }
 
 func editorOpen(filename string) {
+	if info, err := os.Stat(filename); err == nil && info.IsDir() {
+		editorOpenExplorer(filename)
+		return
+	}
+	E.filename = filename
+	E.git = nil
+	E.undo = undoTree{off: true}
+	editorSelectSyntaxHighlight()
+
 	file, err := os.Open(filename)
-	if err != nil {
+	if os.IsNotExist(err) {
+		// a new file, it gets created on the first save
+		E.undo.off = false
+		return
+	} else if err != nil {
 		die("opening file")
 	}
 	defer file.Close()
 
-	E.filename = filename
+	// the first line ending decides how the whole file gets written back
+	r := bufio.NewReader(file)
+	head, _ := r.Peek(64 * 1024)
+	if i := strings.IndexByte(string(head), '\n'); i > 0 && head[i-1] == '\r' {
+		E.crlf = true
+	}
 
-	sc := bufio.NewScanner(file)
+	if editorIsBinary(head) {
+		if err := editorReadBinary(filename); err != nil {
+			die(fmt.Sprintf("reading file error %v", err))
+		}
+		E.dirty = false
+		E.undo.off = false
+		editorRecordFileStat()
+		editorReadUndoFile()
+		editorSetStatusMessage("binary file, showing it as hex (:hex to switch to text)")
+		return
+	}
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<30)
+	loadingRows = true
 	for sc.Scan() {
 		line := sc.Text()
 		editorInsertRow(E.numrows, []byte(line))
 	}
+	loadingRows = false
 	if err := sc.Err(); err != nil {
 		die(fmt.Sprintf("scanning file error %v", err))
 	}
 	E.dirty = false
+	E.undo.off = false
+	editorRecordFileStat()
+	editorReadUndoFile()
+	editorRestorePosition()
 }
 
 func editorSave() {
+	if !editorWritable() {
+		return
+	}
 	if E.filename == "" {
-		E.filename = editorPrompt("save as: %s (ESC to cancel)", nil)
+		E.filename = editorPrompt("save as: %s (ESC to cancel)", nil, nil)
 		if E.filename == "" {
 			editorSetStatusMessage("save aborted")
 			return
 		}
+		editorSelectSyntaxHighlight()
+	}
+
+	if editorFileChanged() {
+		if editorAsk("%s changed on disk since it was read, overwrite? (y/n)", E.filename) != 'y' {
+			editorSetStatusMessage("save aborted")
+			return
+		}
+	}
+
+	if E.formatting {
+		editorSetStatusMessage("still formatting")
+		return
+	}
+	if args := editorFormatter(); args != nil && FORMAT_ON_SAVE {
+		editorFormatThen(args, editorWrite)
+		return
 	}
+	editorWrite()
+}
 
+// editorWrite writes the buffer to its file
+func editorWrite() {
+	editorUndoCommit()
 	buf, length := editorRowToString()
+	if E.hex {
+		buf, length = string(E.data), len(E.data)
+	}
 
-	file, err := os.OpenFile(E.filename, os.O_CREATE|os.O_RDWR, 0644)
+	file, err := os.OpenFile(E.filename, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
 	if err != nil {
 		editorSetStatusMessage("can't save! file open error: %s", err)
 		return
@@ -132,6 +248,12 @@ func editorSave() {
 		if writtenLength == length {
 			editorSetStatusMessage("%d bytes written to disk", length)
 			E.dirty = false
+			E.undo.saved = E.undo.current
+			editorRecordFileStat()
+			editorWriteUndoFile()
+			editorLspDidSave()
+			editorPluginDidSave()
+			E.git = nil
 			return
 		}
 	}
@@ -139,7 +261,16 @@ func editorSave() {
 	editorSetStatusMessage("can't save! I/O error: %s", err)
 }
 
+var savedHlLine = -1
+var savedHl []byte
+
 func editorFindCallback(query []byte, key byte) {
+	if savedHlLine >= 0 && savedHlLine < E.numrows {
+		copy(E.row[savedHlLine].hl, savedHl)
+	}
+	savedHlLine = -1
+	savedHl = nil
+
 	if key == '\r' || key == '\x1b' {
 		return
 	}
@@ -148,8 +279,15 @@ func editorFindCallback(query []byte, key byte) {
 		row := &E.row[i]
 		if strings.Contains(string(row.render), string(query)) {
 			E.cy = i
-			E.cx = editorRowRxToCx(row, strings.Index(string(row.render), string(query)))
+			x := strings.Index(string(row.render), string(query))
+			E.cx = editorRowRxToCx(row, x)
 			E.rowoff = E.numrows
+
+			savedHlLine = i
+			savedHl = append([]byte(nil), row.hl...)
+			for j := x; j < x+len(query) && j < row.rsize; j++ {
+				row.hl[j] = HL_MATCH
+			}
 			break
 		}
 	}
@@ -161,7 +299,10 @@ func editorFind() {
 	savedRowoff := E.rowoff
 	savedColoff := E.coloff
 
-	query := editorPrompt("enter a search query: %s", editorFindCallback)
+	query := editorPrompt("enter a search query: %s", &searchHistory, editorFindCallback)
+	if query != "" {
+		lastSearch = query
+	}
 
 	if query == "" {
 		E.cx = savedCx
@@ -171,12 +312,61 @@ func editorFind() {
 	}
 }
 
+// editorFindNext moves to the next match of query after the cursor, wrapping around the end
+func editorFindNext(query string) bool {
+	for i := 0; i <= E.numrows; i++ {
+		y := (E.cy + i) % max(E.numrows, 1)
+		if y >= E.numrows {
+			break
+		}
+		row := &E.row[y]
+		from := 0
+		if i == 0 && E.cy < E.numrows {
+			from = editorRowCxToRx(row, E.cx) + 1
+		}
+		if from > row.rsize {
+			continue
+		}
+		if x := strings.Index(string(row.render[from:row.rsize]), query); x >= 0 {
+			E.cy = y
+			E.cx = editorRowRxToCx(row, from+x)
+			return true
+		}
+	}
+	return false
+}
+
+// editorFindPrev moves to the last match of query before the cursor, wrapping around the start
+func editorFindPrev(query string) bool {
+	for i := 0; i <= E.numrows; i++ {
+		y := (E.cy - i + 2*max(E.numrows, 1)) % max(E.numrows, 1)
+		if y >= E.numrows {
+			break
+		}
+		row := &E.row[y]
+		to := row.rsize
+		if i == 0 && E.cy < E.numrows {
+			to = editorRowCxToRx(row, E.cx)
+		}
+		if x := strings.LastIndex(string(row.render[:to]), query); x >= 0 {
+			E.cy = y
+			E.cx = editorRowRxToCx(row, x)
+			return true
+		}
+	}
+	return false
+}
+
 func editorRowToString() (string, int) {
+	eol := "\n"
+	if E.crlf {
+		eol = "\r\n"
+	}
 	buffer := ""
 	length := 0
 	for _, row := range E.row {
-		length += row.size + 1
-		buffer += string(row.chars) + "\n"
+		length += row.size + len(eol)
+		buffer += string(row.chars) + eol
 	}
 	return buffer, length
 }
@@ -185,9 +375,20 @@ func editorDelRow(at int) {
 	if at < 0 || at >= E.numrows {
 		return
 	}
+	editorUndoRecord(undoChange{Op: 'd', At: at, Old: E.row[at].chars})
 	E.row = append(E.row[:at], E.row[at+1:]...)
+	for j := at; j < len(E.row); j++ {
+		E.row[j].idx--
+	}
 	E.numrows--
 	E.dirty = true
+	E.changetick++
+	editorShiftMarks(at, -1)
+	editorShiftSigns(at, -1)
+	editorShiftFolds(at, -1)
+	if at < E.numrows {
+		editorUpdateSyntax(&E.row[at])
+	}
 }
 
 func editorRowInsertChar(row *erow, at int, c byte) {
@@ -216,74 +417,75 @@ func editorRowAppendString(row *erow, str []byte, length int) {
 	E.dirty = true
 }
 
+// editorRowDelChar deletes the whole character starting at at
 func editorRowDelChar(row *erow, at int) {
 	if at < 0 || at >= row.size {
 		return
-	} else {
-		row.chars = append(row.chars[:at], row.chars[at+1:]...)
 	}
-	row.size--
+	_, n := utf8.DecodeRune(row.chars[at:row.size])
+	row.chars = append(row.chars[:at], row.chars[at+n:]...)
+	row.size -= n
 	editorUpdateRow(row)
 	E.dirty = true
 }
 
 func editorRowCxToRx(row *erow, cx int) int {
-	var rx int
-	for i := 0; i < cx; i++ {
-		if row.chars[i] == '\t' {
-			rx += (TAB_STOP - 1) - (rx % TAB_STOP)
+	rx, col := 0, 0
+	for i := 0; i < cx; {
+		w, n := editorCharWidth(row.chars[i:], col)
+		if i+n > cx {
+			// inside a multi-byte character, which is drawn where it starts
+			return rx
 		}
-		rx++
+		rx += max(w, n)
+		col += w
+		i += n
 	}
 	return rx
 }
 
 func editorRowRxToCx(row *erow, rx int) int {
-	curRx := 0
+	curRx, col := 0, 0
 	var cx int
-	for cx = 0; cx < row.size; cx++ {
-		if row.chars[cx] == '\t' {
-			curRx += (TAB_STOP - 1) - (curRx % TAB_STOP)
-		}
-		curRx++
-		if curRx > rx {
+	for cx = 0; cx < row.size; {
+		w, n := editorCharWidth(row.chars[cx:], col)
+		if curRx+max(w, n) > rx {
 			return cx
 		}
+		curRx += max(w, n)
+		col += w
+		cx += n
 	}
 	return cx
 }
 
 func editorUpdateRow(row *erow) {
-	tab := 0
-	for j := 0; j < row.size; j++ {
-		if row.chars[j] == '\t' {
-			tab++
+	row.render = make([]byte, 0, row.size)
+	row.kind = make([]byte, 0, row.size)
+	row.trail = 0
+	for i, col := 0, 0; i < row.size; {
+		w, n := editorRenderChar(row, row.chars[i:], col)
+		i += n
+		col += w
+		if c := row.chars[i-1]; c != ' ' && c != '\t' {
+			row.trail = len(row.render)
 		}
 	}
-	row.render = make([]byte, row.size+tab*(TAB_STOP-1)+1)
-
-	var idx int = 0
-	for i := 0; i < row.size; i++ {
-		if row.chars[i] == '\t' {
-			row.render[idx] = ' '
-			idx++
-			for idx%TAB_STOP != 0 {
-				row.render[idx] = ' '
-				idx++
-			}
-		} else {
-			row.render[idx] = row.chars[i]
-			idx++
-		}
-	}
-	row.rsize = idx
+	row.rsize = len(row.render)
+	E.changetick++
+	editorUpdateSyntax(row)
 }
 
+// loadingRows is set while editorOpen reads a file. the rows it adds are where
+// marks, signs and folds already point, so they don't move them
+var loadingRows bool
+
 func editorInsertRow(at int, line []byte) {
 	if at < 0 || at > E.numrows {
 		return
 	}
 	row := erow{
+		idx:   at,
 		size:  len(line),
 		chars: line,
 	}
@@ -294,17 +496,28 @@ func editorInsertRow(at int, line []byte) {
 	} else {
 		E.row = append(E.row[:at], append(append(make([]erow, 0), row), E.row[at:]...)...)
 	}
+	for j := at + 1; j < len(E.row); j++ {
+		E.row[j].idx++
+	}
 	editorUpdateRow(&E.row[at])
+	editorUndoRecord(undoChange{Op: 'i', At: at, New: append([]byte(nil), line...)})
 
 	E.numrows++
 	E.dirty = true
+	if !loadingRows {
+		editorShiftMarks(at, 1)
+		editorShiftSigns(at, 1)
+		editorShiftFolds(at, 1)
+	}
 }
 
 func editorInsertChar(c int) {
 	if E.cy == E.numrows {
 		editorInsertRow(E.numrows, []byte(""))
 	}
+	old := append([]byte(nil), E.row[E.cy].chars...)
 	editorRowInsertChar(&E.row[E.cy], E.cx, byte(c))
+	editorUndoRow(E.cy, old)
 	E.cx++
 }
 
@@ -312,10 +525,13 @@ func editorInsertNewLine() {
 	if E.cx == 0 {
 		editorInsertRow(E.cy, []byte(""))
 	} else {
-		editorInsertRow(E.cy+1, E.row[E.cy].chars[E.cx:])
+		// copy the tail, otherwise both rows share one backing array
+		old := append([]byte(nil), E.row[E.cy].chars...)
+		editorInsertRow(E.cy+1, append([]byte(nil), E.row[E.cy].chars[E.cx:]...))
 		E.row[E.cy].chars = E.row[E.cy].chars[:E.cx]
 		E.row[E.cy].size = len(E.row[E.cy].chars)
 		editorUpdateRow(&E.row[E.cy])
+		editorUndoRow(E.cy, old)
 	}
 	E.cy++
 	E.cx = 0
@@ -330,18 +546,37 @@ func editorDelChar() {
 	}
 
 	if E.cx > 0 {
-		editorRowDelChar(&E.row[E.cy], E.cx-1)
-		E.cx--
+		old := append([]byte(nil), E.row[E.cy].chars...)
+		_, n := utf8.DecodeLastRune(E.row[E.cy].chars[:E.cx])
+		editorRowDelChar(&E.row[E.cy], E.cx-n)
+		editorUndoRow(E.cy, old)
+		E.cx -= n
 	} else if E.cx == 0 {
-		E.cx = E.row[E.cy-1].size
+		old := append([]byte(nil), E.row[E.cy-1].chars...)
+		size := E.row[E.cy-1].size
 		editorRowAppendString(&E.row[E.cy-1], E.row[E.cy].chars, E.row[E.cy].size)
+		editorUndoRow(E.cy-1, old)
 		editorDelRow(E.cy)
+		E.cx = size
 		E.cy--
 	}
 }
 
-func editorPrompt(prompt string, callback func([]byte, byte)) string {
-	var buf []byte
+// editorPrompt reads a line in the message bar. up/down walk through history if there is one
+func editorPrompt(prompt string, history *[]string, callback func([]byte, byte)) string {
+	return editorPromptWith(prompt, "", history, callback)
+}
+
+// editorPromptWith is editorPrompt with some text already typed
+func editorPromptWith(prompt, initial string, history *[]string, callback func([]byte, byte)) string {
+	buf := []byte(initial)
+	hidx := 0
+	if history != nil {
+		hidx = len(*history)
+	}
+
+	inPrompt++
+	defer func() { inPrompt-- }()
 
 	for {
 		editorSetStatusMessage(prompt, buf)
@@ -361,11 +596,25 @@ func editorPrompt(prompt string, callback func([]byte, byte)) string {
 		} else if c == '\r' {
 			if len(buf) != 0 {
 				editorSetStatusMessage("")
+				if history != nil {
+					editorAddHistory(history, string(buf))
+				}
 				if callback != nil {
 					callback(buf, byte(c))
 				}
 				return string(buf)
 			}
+		} else if (c == ARROW_UP || c == ARROW_DOWN) && history != nil {
+			if c == ARROW_UP && hidx > 0 {
+				hidx--
+			} else if c == ARROW_DOWN && hidx < len(*history) {
+				hidx++
+			}
+			if hidx < len(*history) {
+				buf = []byte((*history)[hidx])
+			} else {
+				buf = nil
+			}
 		} else if c < 128 {
 			buf = append(buf, byte(c))
 		}
@@ -376,6 +625,18 @@ func editorPrompt(prompt string, callback func([]byte, byte)) string {
 	}
 }
 
+// editorAsk shows a question in the message bar and returns the key that answered it
+func editorAsk(args ...interface{}) int {
+	inPrompt++
+	defer func() { inPrompt-- }()
+
+	editorSetStatusMessage(args...)
+	editorRefreshScreen()
+	c := editorReadKey()
+	editorSetStatusMessage("")
+	return c
+}
+
 func editorMoveCursor(c int) {
 	var row *erow
 
@@ -388,27 +649,33 @@ func editorMoveCursor(c int) {
 	switch c {
 	case ARROW_UP, UP:
 		if E.cy != 0 {
-			E.cy--
+			E.cy = editorFoldStart(E.cy - 1)
 		}
 	case ARROW_DOWN, DOWN:
 		if E.cy < E.numrows {
-			E.cy++
+			E.cy = min(editorNextRow(E.cy), E.numrows)
 		}
 	case ARROW_LEFT, LEFT:
 		if E.cx != 0 {
 			E.cx--
+			for E.cx > 0 && row != nil && E.cx < row.size && !utf8.RuneStart(row.chars[E.cx]) {
+				E.cx--
+			}
 			// E.cxm = E.cx
 		} else if E.cy > 0 {
-			E.cy--
+			E.cy = editorFoldStart(E.cy - 1)
 			E.cx = E.row[E.cy].size
 			// E.cxm = E.cx
 		}
 	case ARROW_RIGHT, RIGHT:
 		if row != nil && E.cx < row.size {
 			E.cx++
+			for E.cx < row.size && !utf8.RuneStart(row.chars[E.cx]) {
+				E.cx++
+			}
 			// E.cxm = E.cx
 		} else if row != nil && E.cx == row.size {
-			E.cy++
+			E.cy = min(editorNextRow(E.cy), E.numrows)
 			E.cx = 0
 			// E.cxm = E.cx
 		}
@@ -428,66 +695,219 @@ func editorMoveCursor(c int) {
 	// }
 }
 
+// editorReadInput runs in its own goroutine so editorReadKey can wait on keys and timers at the same time
+func editorReadInput() {
+	for {
+		b := make([]byte, 64)
+		n, err := ttyIn.Read(b)
+		if err != nil {
+			die("reading key press")
+		}
+		keyChan <- b[:n]
+	}
+}
+
+// work from events runs only while we wait for the key that starts a command.
+// what comes during a prompt, or while a command reads its next key, waits in
+// heldEvents so it doesn't change the buffer or the cursor under them
+var (
+	readingCommand bool
+	heldEvents     []func()
+)
+
 func editorReadKey() int {
-	b := make([]byte, 4)
+	idle := func() bool { return readingCommand && inPrompt == 0 }
+	if idle() && len(heldEvents) > 0 {
+		for len(heldEvents) > 0 {
+			f := heldEvents[0]
+			heldEvents = heldEvents[1:]
+			f()
+		}
+		editorRefreshScreen()
+	}
+	if len(unreadKeys) > 0 {
+		key := unreadKeys[0]
+		unreadKeys = unreadKeys[1:]
+		return key
+	}
+	for len(pendingKeys) == 0 {
+		select {
+		case b := <-keyChan:
+			pendingKeys = append(pendingKeys, b...)
+		case f := <-events:
+			if !idle() {
+				heldEvents = append(heldEvents, f)
+				break
+			}
+			f()
+			editorRefreshScreen()
+		case <-watchTicker.C:
+			// don't stack a question on top of another prompt
+			if idle() && editorCheckFileChanged() {
+				editorRefreshScreen()
+			}
+		}
+	}
 
-	_, err := os.Stdin.Read(b)
-	if err != nil {
-		die("reading key press")
-	}
-
-	if b[0] == '\x1b' {
-		if b[1] == '[' {
-			if b[2] >= '0' && b[2] <= '9' {
-				if b[3] == '~' {
-					switch b[2] {
-					case '3':
-						return DEL_KEY
-					case '5':
-						return PAGE_UP
-					case '6':
-						return PAGE_DOWN
-					}
-				}
-			} else {
-				switch b[2] {
-				case 'A':
-					return ARROW_UP
-				case 'B':
-					return ARROW_DOWN
-				case 'C':
-					return ARROW_RIGHT
-				case 'D':
-					return ARROW_LEFT
-				}
+	key, n := editorParseKey(pendingKeys)
+	pendingKeys = pendingKeys[n:]
+	return key
+}
+
+// editorParseKey decodes the first key in b and says how many bytes it took.
+// escape sequences we don't know are swallowed whole so they don't turn into typing
+func editorParseKey(b []byte) (int, int) {
+	if b[0] != '\x1b' || len(b) < 2 {
+		return int(b[0]), 1
+	}
+
+	if b[1] == '[' {
+		end := 2
+		for end < len(b) && (b[end] < 0x40 || b[end] > 0x7e) {
+			end++
+		}
+		if end == len(b) {
+			return '\x1b', 1
+		}
+		seq := string(b[2:end])
+		switch b[end] {
+		case '~':
+			switch seq {
+			case "3":
+				return DEL_KEY, end + 1
+			case "5":
+				return PAGE_UP, end + 1
+			case "6":
+				return PAGE_DOWN, end + 1
 			}
+		case 'A':
+			return ARROW_UP, end + 1
+		case 'B':
+			return ARROW_DOWN, end + 1
+		case 'C':
+			return ARROW_RIGHT, end + 1
+		case 'D':
+			return ARROW_LEFT, end + 1
 		}
+		return '\x1b', end + 1
+	}
 
-		return '\x1b'
-	} else {
-		return int(b[0])
+	return '\x1b', 1
+}
+
+func editorExit() {
+	editorLspShutdown()
+	editorPluginShutdown()
+	editorWriteInfo()
+	if path := editorLastSessionPath(); path != "" {
+		editorWriteSession(path)
+	}
+	ttyOut.Write([]byte("\x1b[3J"))
+	ttyOut.Write([]byte("\x1b[2J"))
+	ttyOut.Write([]byte("\x1b[H"))
+	term.Restore(int(ttyIn.Fd()), terminalState)
+	editorWriteStdout()
+	os.Exit(0)
+}
+
+// editorWritable refuses changes to read-only buffers
+func editorWritable() bool {
+	if E.readonly {
+		editorSetStatusMessage("buffer is read-only (:set noreadonly to allow changes)")
+		return false
 	}
+	return true
 }
 
 var QUIT_TIMES int = 2
 var prevKey byte
 
 func editorProcessKeyPress() {
+	readingCommand = true
 	c := editorReadKey()
+	readingCommand = false
+	if finder.active {
+		editorFinderKey(c)
+		return
+	}
+	if E.mode == TERMINAL {
+		editorTermKey(c)
+		return
+	}
+	if E.mode == NORMAL && E.term != nil && editorTermNormalKey(c) {
+		return
+	}
+	if editorClosePopup() && c == '\x1b' {
+		return
+	}
+	if E.mode == INSERT && editorCompletionKey(c) {
+		return
+	}
+	if E.mode == NORMAL && E.explorer != nil && editorExplorerKey(c) {
+		return
+	}
+	if E.mode == NORMAL && editorPluginKey(c) {
+		return
+	}
+	if E.hex && editorHexKey(c) {
+		if E.mode == NORMAL {
+			editorUndoCommit()
+		}
+		return
+	}
 
 	switch c {
 	case '\r':
 		if E.mode == INSERT {
 			editorInsertNewLine()
 			break
+		} else if E.mode == NORMAL && E.buftype == "quickfix" {
+			editorQuickfixEnter()
+			break
+		} else if E.mode == NORMAL && E.buftype == "blame" {
+			editorGitBlameShow()
+			break
 		}
 	case CONTROL_KEY('l'), '\x1b':
-		if E.mode == INSERT {
+		if E.mode == INSERT || editorInVisual() {
 			E.mode = NORMAL
 		}
 		break
+	case 'v', 'V':
+		if E.mode == NORMAL || editorInVisual() {
+			editorStartVisual(byte(c))
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case 'n', 'N':
+		if E.mode == NORMAL || editorInVisual() {
+			if lastSearch == "" {
+				editorSetStatusMessage("no previous search")
+				break
+			}
+			found := false
+			if c == 'n' {
+				found = editorFindNext(lastSearch)
+			} else {
+				found = editorFindPrev(lastSearch)
+			}
+			if !found {
+				editorSetStatusMessage("pattern not found: %s", lastSearch)
+			}
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
 	case 'i':
 		if E.mode == NORMAL {
+			if !editorWritable() {
+				break
+			}
 			E.mode = INSERT
 			break
 		} else if E.mode == INSERT {
@@ -497,6 +917,9 @@ func editorProcessKeyPress() {
 		}
 	case 'a':
 		if E.mode == NORMAL {
+			if !editorWritable() {
+				break
+			}
 			E.mode = INSERT
 			if E.row[E.cy].size != E.cx {
 				E.cx++
@@ -510,6 +933,9 @@ func editorProcessKeyPress() {
 		}
 	case 'o':
 		if E.mode == NORMAL {
+			if !editorWritable() {
+				break
+			}
 			editorInsertRow(E.cy+1, []byte(""))
 			E.mode = INSERT
 			E.cy++
@@ -529,20 +955,132 @@ func editorProcessKeyPress() {
 			prevKey = byte(c)
 			break
 		}
+	case 'u':
+		if E.mode == NORMAL {
+			if !editorWritable() {
+				break
+			}
+			editorUndo()
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case CONTROL_KEY('r'):
+		if E.mode == NORMAL && editorWritable() {
+			editorRedo()
+		}
+		break
+	case 'q':
+		// the window of a list closes, read-only buffers quit like a pager
+		if E.mode == NORMAL && E.buftype != "" && len(editorWindows()) > 1 {
+			editorCloseWindow(curwin)
+		} else if E.mode == NORMAL && E.readonly {
+			if editorAnyDirty() && QUIT_TIMES > 0 {
+				editorSetStatusMessage("unsaved changes! press q %d more times to quit", QUIT_TIMES)
+				QUIT_TIMES--
+				return
+			}
+			editorExit()
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+		}
+		break
+	case 'z':
+		if E.mode == NORMAL {
+			editorFoldCommand(editorReadKey())
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case 'g':
+		if E.mode == NORMAL || editorInVisual() {
+			switch editorReadKey() {
+			case 'j', ARROW_DOWN:
+				editorMoveDisplayLine(1)
+			case 'k', ARROW_UP:
+				editorMoveDisplayLine(-1)
+			case 'g':
+				E.cy, E.cx = 0, editorFirstNonBlank(0)
+			case 'h':
+				if E.explorer != nil {
+					editorExplorerToggleHidden()
+				}
+			case 't':
+				editorGotoTab((curtab + 1) % len(tabs))
+			case 'T':
+				editorGotoTab((curtab + len(tabs) - 1) % len(tabs))
+			case 'd':
+				editorLspDefinition()
+			case 'r':
+				editorLspReferences()
+			}
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case ']', '[':
+		if E.mode == NORMAL {
+			editorBracketCommand(c, editorReadKey())
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case 'm', '\'', '`':
+		if E.mode == NORMAL {
+			name := editorReadKey()
+			if name > 255 {
+				break
+			}
+			if c == 'm' {
+				editorSetMark(byte(name))
+			} else {
+				editorJumpMark(byte(name), c == '`')
+			}
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
+	case ':':
+		if E.mode == NORMAL {
+			editorCommand("")
+			break
+		} else if editorInVisual() {
+			// the command gets the selected lines as its range
+			sy, _, ey, _ := editorSelection()
+			if E.marks == nil {
+				E.marks = map[byte]mark{}
+			}
+			E.marks['<'] = mark{Cy: sy}
+			E.marks['>'] = mark{Cy: ey}
+			E.mode = NORMAL
+			editorCommand("'<,'>")
+			break
+		} else if E.mode == INSERT {
+			editorInsertChar(c)
+			prevKey = byte(c)
+			break
+		}
 	case CONTROL_KEY('f'):
 		editorFind()
 		break
 	case CONTROL_KEY('q'):
-		if E.dirty && QUIT_TIMES > 0 {
+		if editorAnyDirty() && QUIT_TIMES > 0 {
 			editorSetStatusMessage("unsaved changes! press CTRL-Q %d more times to quit", QUIT_TIMES)
 			QUIT_TIMES--
 			return
 		}
-		os.Stdout.Write([]byte("\x1b[3J"))
-		os.Stdout.Write([]byte("\x1b[2J"))
-		os.Stdout.Write([]byte("\x1b[H"))
-		term.Restore(int(os.Stdout.Fd()), terminalState)
-		os.Exit(0)
+		editorExit()
 	case CONTROL_KEY('s'):
 		editorSave()
 		break
@@ -559,7 +1097,7 @@ func editorProcessKeyPress() {
 		editorMoveCursor(c)
 		break
 	case 'h', 'j', 'k', 'l':
-		if E.mode == NORMAL {
+		if E.mode == NORMAL || editorInVisual() {
 			editorMoveCursor(c)
 			break
 		} else if E.mode == INSERT {
@@ -586,43 +1124,35 @@ func editorProcessKeyPress() {
 			prevKey = byte(c)
 			break
 		}
+		editorNormalCommand(c)
 	}
 
-	QUIT_TIMES = 2
-}
-
-// func editorDrawLineNum(abuf *bytes.Buffer, filerow int) {
-// 	format := fmt.Sprintf("%%%dd ", E.linenum_indent-1)
-// 	linenum := strings.Repeat(" ", E.linenum_indent)
-// 	if filerow < E.numrows {
-// 		linenum = fmt.Sprintf(format, filerow+1)
-// 	}
-// 	abuf.WriteString("\x1b[90m")
-// 	abuf.Write([]byte(linenum))
-// 	abuf.WriteString("\x1b[m")
-// }
-
-func editorDrawRelativeLineNum(abuf *bytes.Buffer, filerow int) {
-	format := fmt.Sprintf("%%%dd ", E.linenum_indent-1)
-	linenum := strings.Repeat(" ", E.linenum_indent)
-
-	if filerow < E.numrows {
-		if E.cy == filerow {
-			linenum = fmt.Sprintf(format, filerow+1)
-		} else {
-			currLineNum := int(math.Abs(float64(E.cy - filerow)))
-			linenum = fmt.Sprintf(format, currLineNum)
-			abuf.WriteString("\x1b[90m")
-		}
+	editorUpdateCompletion()
+	if E.mode == NORMAL {
+		editorUndoCommit()
 	}
 
-	abuf.Write([]byte(linenum))
-	abuf.WriteString("\x1b[m")
+	QUIT_TIMES = 2
 }
 
-func editorDrawRows(abuf *bytes.Buffer) {
-	for y := 0; y < E.screenrows; y++ {
-		filerow := y + E.rowoff
+func editorDrawRows() {
+	y := 0
+	for filerow := E.rowoff; y < E.screenrows; filerow++ {
+		// diff filler lines, for lines only the other buffer has
+		fill := 0
+		if filerow <= E.numrows {
+			fill = editorFillAbove(filerow)
+		}
+		if filerow == E.rowoff {
+			fill -= E.topskip
+		}
+		for ; fill > 0 && y < E.screenrows; fill-- {
+			editorDrawFiller(y)
+			y++
+		}
+		if y >= E.screenrows {
+			break
+		}
 
 		if filerow >= E.numrows {
 			if E.numrows == 0 && y == E.screenrows/3 {
@@ -631,99 +1161,225 @@ func editorDrawRows(abuf *bytes.Buffer) {
 					welcomeMessage = welcomeMessage[:E.screencols-1]
 				}
 				padding := (E.screencols - len(welcomeMessage)) / 2
-				if padding > 0 {
-					abuf.WriteString("~" + strings.Repeat(" ", padding-1) + welcomeMessage + strings.Repeat(" ", padding))
-				} else {
-					abuf.WriteString("~" + welcomeMessage)
-				}
+				screenPutString(0, y, "~", hlStyle(HG_NONTEXT))
+				screenPutString(max(padding, 1), y, welcomeMessage, hlStyle(HG_NORMAL))
+			} else {
+				screenPutString(0, y, "~", hlStyle(HG_NONTEXT))
+			}
+			y++
+			continue
+		}
+
+		if f, ok := editorClosedFold(filerow); ok {
+			editorDrawGutter(y, filerow)
+			editorDrawFoldLine(y, f)
+			editorDrawLayers(y, filerow, 0)
+			filerow = f.end
+			y++
+			continue
+		}
+
+		if E.term != nil {
+			editorDrawGutter(y, filerow)
+			editorDrawTermRow(y, filerow)
+			editorDrawLayers(y, filerow, E.coloff)
+			y++
+			continue
+		}
+
+		row := &E.row[filerow]
+		segs := editorRowSegments(row)
+		for i := 0; i < len(segs) && y < E.screenrows; i++ {
+			if i == 0 {
+				editorDrawGutter(y, filerow)
 			} else {
-				abuf.WriteString("~")
+				editorDrawShowbreak(y)
 			}
-		} else {
-			// editorDrawLineNum(abuf, filerow)
-			editorDrawRelativeLineNum(abuf, filerow)
 
-			length := E.row[filerow].rsize - E.coloff
-			if length < 0 {
-				length = 0
+			// coloff is a screen column, from and to are where it and the right edge are in render.
+			// a wide character cut by the left edge isn't drawn, there's a blank instead
+			col := E.coloff
+			from, to := editorRenderIndex(row, col), editorRenderIndex(row, col+E.screencols)
+			if from < row.rsize && editorRenderCol(row, from) < col {
+				_, n := utf8.DecodeRune(row.render[from:row.rsize])
+				from += n
 			}
-			if length > 0 {
-				if length > E.screencols {
-					length = E.screencols
+			if WRAP {
+				from = segs[i]
+				to = row.rsize
+				if i+1 < len(segs) {
+					to = segs[i+1]
 				}
-				rindex := E.coloff + length
-				abuf.Write(E.row[filerow].render[E.coloff:rindex])
+				col = editorRenderCol(row, from)
 			}
+			if to > row.rsize {
+				to = row.rsize
+			}
+			if from <= to {
+				editorDrawRender(E.linenum_indent+editorRenderCol(row, from)-col, y, row, from, to)
+			}
+			if i == len(segs)-1 {
+				editorDrawSignMessage(y, filerow, E.linenum_indent+max(editorRenderCol(row, to)-col, 0))
+			}
+			editorDrawLayers(y, filerow, col)
+			y++
 		}
-
-		abuf.WriteString("\x1b[K")
-		abuf.WriteString("\n")
 	}
 }
 
-func editorDrawStatusBar(abuf *bytes.Buffer) {
-	abuf.WriteString("\x1b[7m")
-
-	length := ""
-	if E.filename != "" {
-		if E.dirty {
-			length = fmt.Sprintf(" %s   %.20s[+]", string(E.mode), E.filename, E.numrows)
-		} else {
-			length = fmt.Sprintf(" %s   %.20s", string(E.mode), E.filename, E.numrows)
-		}
-	} else {
-		length = fmt.Sprintf(" %s   %.20s", string(E.mode), "[No Name]", E.numrows)
-	}
-	rlength := fmt.Sprintf("%d/%d ", E.cy+1, E.numrows)
-	if len(length) > E.raw_screencols {
-		length = length[:E.raw_screencols]
+func editorDrawMessageBar() {
+	localMessage := E.statusmsg
+	if len(E.statusmsg) > termWidth {
+		localMessage = localMessage[:termWidth]
 	}
-	abuf.WriteString(length)
-	counter := len(length)
-	for counter < E.raw_screencols {
-		if E.raw_screencols-counter == len(rlength) {
-			abuf.WriteString(rlength)
-			break
-		} else {
-			abuf.WriteString(" ")
-			counter++
-		}
+	timeWentBy := time.Now().Sub(E.statusmsg_time)
+	if timeWentBy < time.Second*5 {
+		screenPutString(0, termHeight-1, localMessage, hlStyle(HG_NORMAL))
 	}
+}
 
-	abuf.WriteString("\x1b[m")
-	abuf.WriteString("\r\n")
+// editorShowLines pages through lines full screen until q or ESC, used for things like diffs
+func editorShowLines(title string, lines []string) {
+	editorPager(title, lines, false)
 }
 
-func editorDrawMessageBar(abuf *bytes.Buffer) {
-	abuf.WriteString("\x1b[K")
-	localMessage := E.statusmsg
-	if len(E.statusmsg) > E.screencols {
-		localMessage = localMessage[:E.screencols]
+// editorPickLine lets the user choose one of lines with j/k and Enter. -1 when they
+// close it instead
+func editorPickLine(title string, lines []string) int {
+	return editorPager(title, lines, true)
+}
+
+func editorPager(title string, lines []string, pick bool) int {
+	inPrompt++
+	defer func() { inPrompt-- }()
+
+	// the pager covers all the windows
+	rows, cols := termHeight-2, termWidth
+	off, sel := 0, 0
+	help := "j/k = scroll | q = close"
+	if pick {
+		help = "j/k = move | enter = open | q = close"
 	}
-	timeWentBy := time.Now().Sub(E.statusmsg_time)
-	if timeWentBy < time.Second*5 {
-		abuf.WriteString(localMessage)
+	for {
+		screenResize(termWidth, termHeight)
+		screenFullView()
+		screenClear()
+		for y := 0; y < rows; y++ {
+			if off+y < len(lines) {
+				screenPutString(0, y, lines[off+y], hlStyle(HG_NORMAL))
+				if pick && off+y == sel {
+					screenTint(0, y, cols, editorTint(HG_SELECTION))
+				}
+			} else {
+				screenPutString(0, y, "~", hlStyle(HG_NONTEXT))
+			}
+		}
+
+		st := hlStyle(HG_STATUSLINE)
+		status := fmt.Sprintf(" %s", title)
+		rstatus := fmt.Sprintf("%d/%d ", min(off+rows, len(lines)), len(lines))
+		if pick {
+			rstatus = fmt.Sprintf("%d/%d ", min(sel+1, len(lines)), len(lines))
+		}
+		screenFill(0, rows, cols, st)
+		screenPutString(0, rows, status, st)
+		screenPutString(max(cols-len(rstatus), len(status)), rows, rstatus, st)
+		screenPutString(0, rows+1, help, hlStyle(HG_NORMAL))
+		screenSetCursor(0, rows+1)
+		screenFlush()
+
+		key := editorReadKey()
+		if pick {
+			switch key {
+			case '\r':
+				if len(lines) == 0 {
+					return -1
+				}
+				return sel
+			case 'j', ARROW_DOWN:
+				sel = min(sel+1, max(len(lines)-1, 0))
+			case 'k', ARROW_UP:
+				sel = max(sel-1, 0)
+			case PAGE_DOWN, ' ':
+				sel = min(sel+rows, max(len(lines)-1, 0))
+			case PAGE_UP:
+				sel = max(sel-rows, 0)
+			case 'q', '\x1b':
+				return -1
+			}
+			if sel < off {
+				off = sel
+			} else if sel >= off+rows {
+				off = sel - rows + 1
+			}
+			continue
+		}
+		switch key {
+		case 'q', '\x1b', '\r':
+			return -1
+		case 'j', ARROW_DOWN:
+			if off+rows < len(lines) {
+				off++
+			}
+		case 'k', ARROW_UP:
+			if off > 0 {
+				off--
+			}
+		case PAGE_DOWN, ' ':
+			off = max(min(off+rows, len(lines)-rows), 0)
+		case PAGE_UP:
+			off = max(off-rows, 0)
+		}
 	}
 }
 
 func editorScroll() {
+	// a closed fold is a single line, the cursor sits on its first row. until
+	// one is closed with a z command there's no need to know where folds are
+	if len(E.closed) > 0 {
+		editorUpdateFolds()
+	}
+	E.cy = editorFoldStart(E.cy)
+	if E.cy < E.numrows {
+		E.cx = min(E.cx, E.row[E.cy].size)
+	}
+
 	E.rx = 0
 	if E.cy < E.numrows {
 		E.rx = editorRowCxToRx(&E.row[E.cy], E.cx)
 		// E.cursor_memory = E.rx
 	}
-	if E.cy < E.rowoff {
-		E.rowoff = E.cy
+	if WRAP {
+		E.coloff = 0
+		editorScrollWrapped()
+		return
 	}
-	if E.cy >= E.screenrows+E.rowoff {
-		E.rowoff = E.cy - E.screenrows + 1
+	if len(E.closed_folds) > 0 || E.diff != nil {
+		editorScrollWrapped()
+	} else {
+		if E.cy < E.rowoff {
+			E.rowoff = E.cy
+		}
+		if E.cy >= E.screenrows+E.rowoff {
+			E.rowoff = E.cy - E.screenrows + 1
+		}
 	}
 
-	if E.rx < E.coloff {
-		E.coloff = E.rx
+	// the columns the character under the cursor takes, all of them are kept on screen
+	col, end := E.rx, E.rx+1
+	if E.cy < E.numrows {
+		row := &E.row[E.cy]
+		col, end = editorRenderCol(row, E.rx), editorRenderCol(row, E.rx)+1
+		if E.rx < row.rsize {
+			_, n := utf8.DecodeRune(row.render[E.rx:row.rsize])
+			end = max(editorRenderCol(row, E.rx+n), end)
+		}
 	}
-	if E.rx >= E.screencols+E.coloff {
-		E.coloff = E.rx - E.screencols + 1
+	if col < E.coloff {
+		E.coloff = col
+	}
+	if end > E.screencols+E.coloff {
+		E.coloff = end - E.screencols
 	}
 }
 
@@ -733,33 +1389,70 @@ func editorSetStatusMessage(args ...interface{}) { // interface{} type basically
 }
 
 func editorRefreshScreen() {
-	// editorUpdateLinenumIndent()
-	E.screencols = E.raw_screencols - E.linenum_indent
-	editorScroll()
-
-	abuf := bytes.Buffer{}
-	abuf.Reset()
-
-	abuf.WriteString("\x1b[?25l")
-	abuf.WriteString("\x1b[3J")
-	// abuf.WriteString("\x1b[2J")
-	abuf.WriteString("\x1b[H")
-
-	editorDrawRows(&abuf)
-	editorDrawStatusBar(&abuf)
-	editorDrawMessageBar(&abuf)
+	editorLayout()
+	screenResize(termWidth, termHeight)
+	screenFullView()
+	screenClear()
+
+	editorSyncScrollbind()
+	for _, w := range editorWindows() {
+		if w != curwin {
+			editorDrawWindow(w)
+		}
+	}
+	screenFullView()
+	editorDrawSeparators(root)
+	editorDrawTabLine()
+
+	screenViewport(curwin.x, curwin.y, curwin.w, curwin.h)
+	editorDrawView(true)
+	screenFullView()
+	editorDrawMessageBar()
+	editorDrawFinder()
+	screenFlush()
+}
 
-	// abuf.WriteString("\x1b[H")
-	abuf.WriteString(fmt.Sprintf("\x1b[%d;%dH", E.cy-E.rowoff+1, E.rx-E.coloff+1+E.linenum_indent)) // I can augment how much I add to the cursor position, pushing it off that much - the key to line numbers
-	// in the above line, rx was replaced with cursor_memory
+// editorDrawView draws E in the current viewport with its status line. the
+// cursor, popups and the completion menu only go in the active window
+func editorDrawView(active bool) {
+	editorUpdateLinenumIndent()
+	if E.term != nil {
+		editorTermResize(E.screencols, E.screenrows)
+		// a running program is followed, unless the cursor was moved off it in NORMAL mode
+		if E.mode == TERMINAL || !active && !E.term.done {
+			editorTermCursor()
+		}
+	}
+	if E.hex {
+		editorHexScroll()
+	} else {
+		editorScroll()
+	}
 
-	abuf.WriteString("\x1b[?25h")
+	if E.hex {
+		editorDrawHex()
+	} else {
+		editorDrawRows()
+		if active {
+			editorDrawMatchParen()
+			editorDrawPopup()
+			editorDrawCompletion()
+		}
+	}
+	editorDrawStatusBar(active)
 
-	os.Stdout.Write(abuf.Bytes())
+	if !active {
+		return
+	}
+	if E.hex {
+		screenSetCursor(editorHexCursor())
+	} else {
+		screenSetCursor(editorCursorPosition())
+	}
 }
 
 func initEditor() {
-	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	width, height, err := term.GetSize(int(ttyOut.Fd()))
 	if err != nil {
 		die("getting window size")
 	}
@@ -781,18 +1474,132 @@ func initEditor() {
 	E.cxm = 0
 
 	E.screenrows -= 2
+	termWidth, termHeight = width, height
+
+	buffers = []EditorConfig{E}
+	curbuf = 0
+	editorInitWindows()
+}
+
+// editorParseArgs handles the command line:
+//
+//	goditor [files...]
+//	goditor -                   read the text from stdin
+//	goditor -R [files...]       open read-only
+//	goditor +N file             start at line N, + alone is the last line
+//	goditor +/pattern file      start at the first match of pattern
+//	goditor -S [session.json]   no session file means the one left behind by the last quit
+func editorParseArgs(args []string) {
+	first := -1
+	readonly := false
+	jump := ""
+	diff := false
+	var opened []int
+	for i := 0; i < len(args); i++ {
+		if strings.HasPrefix(args[i], "+") {
+			jump = args[i]
+			continue
+		}
+		switch args[i] {
+		case "-R":
+			readonly = true
+		case "-d":
+			diff = true
+		case "-":
+			editorOpenStdin()
+			if first < 0 {
+				first = curbuf
+			}
+		case "-S":
+			path := editorLastSessionPath()
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				path = args[i]
+			}
+			if err := editorLoadSession(path); err != nil {
+				die(fmt.Sprintf("loading session: %v", err))
+			}
+		default:
+			editorEdit(args[i])
+			if first < 0 {
+				first = curbuf
+			}
+			opened = append(opened, curbuf)
+		}
+	}
+	if readonly {
+		E.readonly = true
+		for i := range buffers {
+			buffers[i].readonly = true
+		}
+	}
+	if first >= 0 {
+		editorSwitchBuffer(first)
+	}
+	// -d a b compares the first two files side by side
+	if diff && len(opened) >= 2 {
+		editorSwitchBuffer(opened[1])
+		editorDiffThis()
+		editorLayout() // the window has no size to split yet
+		editorSplit(true)
+		editorSwitchBuffer(opened[0])
+		editorDiffThis()
+	}
+	if jump != "" {
+		editorJumpArg(jump[1:])
+	}
+}
+
+// editorJumpArg handles the +N and +/pattern arguments
+func editorJumpArg(arg string) {
+	if strings.HasPrefix(arg, "/") {
+		E.cx, E.cy = 0, 0
+		query := arg[1:]
+		if query == "" {
+			return
+		}
+		editorAddHistory(&searchHistory, query)
+		lastSearch = query
+		// a match right at 0,0 would be skipped by editorFindNext
+		if E.numrows > 0 && strings.HasPrefix(string(E.row[0].render[:E.row[0].rsize]), query) {
+			return
+		}
+		if !editorFindNext(query) {
+			editorSetStatusMessage("pattern not found: %s", query)
+		}
+		return
+	}
+
+	n := E.numrows
+	if arg != "" {
+		var err error
+		if n, err = strconv.Atoi(arg); err != nil {
+			editorSetStatusMessage("invalid line: +%s", arg)
+			return
+		}
+	}
+	E.cy = min(max(n-1, 0), max(E.numrows-1, 0))
+	E.cx = 0
 }
 
 func main() {
+	editorOpenTTY()
 	enableRawMode()
 	initEditor()
-	if len(os.Args) >= 2 {
-		editorOpen(os.Args[1])
-	}
+	go editorReadInput()
+	screenDetectSync()
+	editorLoadTheme(THEME)
+	editorLoadConfig()
+	editorReadInfo()
+	editorParseArgs(os.Args[1:])
 
 	editorSetStatusMessage("Help: CTRL-S = save | CTRL-Q = quit | CTRL-F = find")
 
 	for {
+		editorLspSync()
+		editorGitSync()
+		editorDiffUpdate()
+		editorPluginSync()
 		editorRefreshScreen()
 		editorProcessKeyPress()
 	}
@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+// testBuffers makes E the only buffer, with lines in it
+func testBuffers(t *testing.T, lines ...string) {
+	t.Helper()
+	E = EditorConfig{mode: NORMAL, screenrows: 20, screencols: 80, raw_screencols: 80}
+	buffers = []EditorConfig{E}
+	curbuf = 0
+	editorInitWindows()
+	editorFillBuffer(lines)
+}
@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// the unnamed register, filled by d, c and y and put back by p and P
+var register struct {
+	lines    []string
+	linewise bool
+}
+
+// where a motion goes from the cursor. linewise motions work on whole rows,
+// inclusive ones take the character they land on with them
+type motion struct {
+	y, x      int
+	linewise  bool
+	inclusive bool
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+// charClass puts blanks, word characters and punctuation in their own classes like vim's w does
+func charClass(c byte) int {
+	switch {
+	case c == ' ' || c == '\t':
+		return 0
+	case isWordChar(c):
+		return 1
+	}
+	return 2
+}
+
+// editorCharAt returns the character at y, x, with the end of a row reading as a blank
+func editorCharAt(y, x int) byte {
+	if y >= E.numrows || x >= E.row[y].size {
+		return ' '
+	}
+	return E.row[y].chars[x]
+}
+
+// editorRowSize is the length of row y, 0 past the last row of the buffer
+func editorRowSize(y int) int {
+	if y >= E.numrows {
+		return 0
+	}
+	return E.row[y].size
+}
+
+// editorNextPos steps one character forward, over row ends. false at the end of the buffer
+func editorNextPos(y, x int) (int, int, bool) {
+	if y < E.numrows && x < E.row[y].size-1 {
+		return y, x + 1, true
+	}
+	if y+1 < E.numrows {
+		return y + 1, 0, true
+	}
+	return y, x, false
+}
+
+func editorPrevPos(y, x int) (int, int, bool) {
+	if x > 0 {
+		return y, x - 1, true
+	}
+	if y > 0 {
+		return y - 1, max(E.row[y-1].size-1, 0), true
+	}
+	return y, x, false
+}
+
+func editorWordForward(y, x int) (int, int) {
+	start := y
+	if class := charClass(editorCharAt(y, x)); class != 0 {
+		for charClass(editorCharAt(y, x)) == class && y == start {
+			ny, nx, ok := editorNextPos(y, x)
+			if !ok {
+				return y, editorRowSize(y)
+			}
+			y, x = ny, nx
+		}
+	}
+	// an empty row counts as a word
+	for charClass(editorCharAt(y, x)) == 0 && !(y != start && E.row[y].size == 0) {
+		ny, nx, ok := editorNextPos(y, x)
+		if !ok {
+			return y, editorRowSize(y)
+		}
+		y, x = ny, nx
+	}
+	return y, x
+}
+
+func editorWordEnd(y, x int) (int, int) {
+	ny, nx, ok := editorNextPos(y, x)
+	if !ok {
+		return y, x
+	}
+	y, x = ny, nx
+	for charClass(editorCharAt(y, x)) == 0 {
+		if y, x, ok = editorNextPos(y, x); !ok {
+			return y, x
+		}
+	}
+	class := charClass(editorCharAt(y, x))
+	for {
+		ny, nx, ok := editorNextPos(y, x)
+		if !ok || ny != y || charClass(editorCharAt(ny, nx)) != class {
+			return y, x
+		}
+		y, x = ny, nx
+	}
+}
+
+func editorWordBackward(y, x int) (int, int) {
+	ny, nx, ok := editorPrevPos(y, x)
+	if !ok {
+		return y, x
+	}
+	y, x = ny, nx
+	for charClass(editorCharAt(y, x)) == 0 && E.row[y].size > 0 {
+		if y, x, ok = editorPrevPos(y, x); !ok {
+			return y, x
+		}
+	}
+	class := charClass(editorCharAt(y, x))
+	for {
+		py, px, ok := editorPrevPos(y, x)
+		if !ok || py != y || charClass(editorCharAt(py, px)) != class {
+			return y, x
+		}
+		y, x = py, px
+	}
+}
+
+func editorFirstNonBlank(y int) int {
+	if y >= E.numrows {
+		return 0
+	}
+	x := 0
+	for x < E.row[y].size && charClass(E.row[y].chars[x]) == 0 {
+		x++
+	}
+	return x
+}
+
+// editorMotion works out where key moves the cursor to without moving it
+func editorMotion(key int) (motion, bool) {
+	m := motion{y: E.cy, x: E.cx}
+	var chars []byte
+	if E.cy < E.numrows {
+		chars = E.row[E.cy].chars[:E.row[E.cy].size]
+	}
+	size := len(chars)
+
+	switch key {
+	case 'h', ARROW_LEFT:
+		_, n := utf8.DecodeLastRune(chars[:min(E.cx, size)])
+		m.x = max(E.cx-n, 0)
+	case 'l', ARROW_RIGHT:
+		_, n := utf8.DecodeRune(chars[min(E.cx, size):])
+		m.x = min(E.cx+max(n, 1), size)
+	case 'j', ARROW_DOWN:
+		m.y = min(editorNextRow(E.cy), max(E.numrows-1, 0))
+		m.linewise = true
+	case 'k', ARROW_UP:
+		m.y = editorFoldStart(max(E.cy-1, 0))
+		m.linewise = true
+	case '0':
+		m.x = 0
+	case '^':
+		m.x = editorFirstNonBlank(E.cy)
+	case '$':
+		m.x = max(size-1, 0)
+		m.inclusive = size > 0
+	case 'w':
+		m.y, m.x = editorWordForward(E.cy, E.cx)
+	case 'b':
+		m.y, m.x = editorWordBackward(E.cy, E.cx)
+	case 'e':
+		m.y, m.x = editorWordEnd(E.cy, E.cx)
+		m.inclusive = true
+	case 'G':
+		m.y = max(E.numrows-1, 0)
+		m.x = editorFirstNonBlank(m.y)
+		m.linewise = true
+	case 'g':
+		if editorReadKey() != 'g' {
+			return m, false
+		}
+		m.y = 0
+		m.x = editorFirstNonBlank(0)
+		m.linewise = true
+	case '%':
+		y, x, ok := editorBracketMotion()
+		if !ok {
+			return m, false
+		}
+		m.y, m.x = y, x
+		m.inclusive = true
+	default:
+		return m, false
+	}
+	return m, true
+}
+
+// editorSetRow replaces the text of row at, recording it for undo
+func editorSetRow(at int, chars []byte) {
+	old := append([]byte(nil), E.row[at].chars...)
+	E.row[at].chars = chars
+	E.row[at].size = len(chars)
+	editorUpdateRow(&E.row[at])
+	editorUndoRow(at, old)
+	E.dirty = true
+}
+
+// editorGetRange returns the text from sy, sx up to ey, ex (exclusive), one string per row
+func editorGetRange(sy, sx, ey, ex int, linewise bool) []string {
+	var lines []string
+	if linewise {
+		for y := sy; y <= ey && y < E.numrows; y++ {
+			lines = append(lines, string(E.row[y].chars))
+		}
+		return lines
+	}
+	if sy >= E.numrows {
+		return []string{""}
+	}
+	sx = min(sx, E.row[sy].size)
+	if sy == ey {
+		return []string{string(E.row[sy].chars[sx:max(min(ex, E.row[sy].size), sx)])}
+	}
+	lines = append(lines, string(E.row[sy].chars[sx:]))
+	for y := sy + 1; y < ey; y++ {
+		lines = append(lines, string(E.row[y].chars))
+	}
+	if ey < E.numrows {
+		lines = append(lines, string(E.row[ey].chars[:min(ex, E.row[ey].size)]))
+	}
+	return lines
+}
+
+func editorDeleteRange(sy, sx, ey, ex int, linewise bool) {
+	if linewise {
+		for y := sy; y <= ey && sy < E.numrows; y++ {
+			editorDelRow(sy)
+		}
+		E.cy = min(sy, max(E.numrows-1, 0))
+		E.cx = editorFirstNonBlank(E.cy)
+		return
+	}
+	if sy >= E.numrows {
+		return
+	}
+	ey = min(ey, E.numrows-1)
+	ex = min(ex, E.row[ey].size)
+	sx = min(sx, E.row[sy].size)
+	joined := append(append([]byte(nil), E.row[sy].chars[:sx]...), E.row[ey].chars[ex:]...)
+	editorSetRow(sy, joined)
+	for y := sy + 1; y <= ey; y++ {
+		editorDelRow(sy + 1)
+	}
+	E.cy, E.cx = sy, sx
+}
+
+// editorApplyOperator runs d, c or y over a range. ex is exclusive
+func editorApplyOperator(op byte, sy, sx, ey, ex int, linewise bool) {
+	if op != 'y' && !editorWritable() {
+		return
+	}
+	// a closed fold goes as a whole
+	if linewise {
+		sy, ey = editorFoldStart(sy), editorFoldEnd(ey)
+	}
+	register.lines = editorGetRange(sy, sx, ey, ex, linewise)
+	register.linewise = linewise
+
+	switch op {
+	case 'y':
+		E.cy, E.cx = sy, sx
+		if linewise {
+			E.cx = editorFirstNonBlank(sy)
+		}
+		if n := len(register.lines); n > 2 {
+			editorSetStatusMessage("%d lines yanked", n)
+		}
+	case 'd':
+		editorDeleteRange(sy, sx, ey, ex, linewise)
+		if n := len(register.lines); linewise && n > 2 {
+			editorSetStatusMessage("%d fewer lines", n)
+		}
+	case 'c':
+		if linewise {
+			editorDeleteRange(sy, sx, ey, ex, true)
+			at := min(sy, E.numrows)
+			editorInsertRow(at, []byte(""))
+			E.cy, E.cx = at, 0
+		} else {
+			editorDeleteRange(sy, sx, ey, ex, false)
+		}
+		E.mode = INSERT
+	}
+}
+
+// editorOperator reads the motion after d, c or y. doubling the operator (dd) works on the row
+func editorOperator(op byte) {
+	key := editorReadKey()
+	// do and dp get and put diff hunks
+	if op == 'd' && E.diff != nil && (key == 'o' || key == 'p') {
+		if key == 'o' {
+			editorDiffObtain()
+		} else {
+			editorDiffPut()
+		}
+		return
+	}
+	if key == int(op) {
+		if E.cy < E.numrows {
+			editorApplyOperator(op, E.cy, 0, E.cy, 0, true)
+		}
+		return
+	}
+
+	// cw changes to the end of the word like ce, the blank after it stays
+	if op == 'c' && key == 'w' && charClass(editorCharAt(E.cy, E.cx)) != 0 {
+		key = 'e'
+	}
+	m, ok := editorMotion(key)
+	if !ok {
+		return
+	}
+	// dw on the last word of a row stops at the end of the row
+	if key == 'w' && m.y > E.cy {
+		m.y, m.x = E.cy, editorRowSize(E.cy)
+	}
+
+	sy, sx, ey, ex := E.cy, E.cx, m.y, m.x
+	if ey < sy || (ey == sy && ex < sx) {
+		sy, sx, ey, ex = ey, ex, sy, sx
+	}
+	if m.inclusive {
+		ex++
+	}
+	editorApplyOperator(op, sy, sx, ey, ex, m.linewise)
+}
+
+// editorVisualOperator runs op over the selection and leaves visual mode
+func editorVisualOperator(op byte) {
+	sy, sx, ey, ex := editorSelection()
+	linewise := E.mode == VISUAL_LINE
+	E.mode = NORMAL
+	editorApplyOperator(op, sy, sx, ey, ex+1, linewise)
+}
+
+// editorPut is p (after the cursor) and P (before it)
+func editorPut(after bool) {
+	if len(register.lines) == 0 || !editorWritable() {
+		return
+	}
+
+	if register.linewise {
+		at := E.cy
+		if after {
+			at = min(E.cy+1, E.numrows)
+		}
+		for i, line := range register.lines {
+			editorInsertRow(at+i, []byte(line))
+		}
+		E.cy, E.cx = at, editorFirstNonBlank(at)
+		return
+	}
+
+	if E.cy == E.numrows {
+		editorInsertRow(E.numrows, []byte(""))
+	}
+	row := &E.row[E.cy]
+	at := min(E.cx, row.size)
+	if after && row.size > 0 {
+		at = min(at+1, row.size)
+	}
+	editorInsertText(E.cy, at, strings.Join(register.lines, "\n"))
+	E.cx = at
+	if len(register.lines) == 1 {
+		E.cx = at + max(len(register.lines[0])-1, 0)
+	}
+}
+
+// editorInsertText puts text, which may run over several rows, at y, x and returns where it ends
+func editorInsertText(y, x int, text string) (int, int) {
+	if y == E.numrows {
+		editorInsertRow(E.numrows, []byte(""))
+	}
+	row := &E.row[y]
+	x = min(x, row.size)
+	head := append([]byte(nil), row.chars[:x]...)
+	tail := append([]byte(nil), row.chars[x:]...)
+
+	lines := strings.Split(text, "\n")
+	last := len(lines) - 1
+	if last == 0 {
+		editorSetRow(y, append(append(head, lines[0]...), tail...))
+		return y, x + len(lines[0])
+	}
+	editorSetRow(y, append(head, lines[0]...))
+	for i, line := range lines[1:] {
+		chars := []byte(line)
+		if i == last-1 {
+			chars = append(chars, tail...)
+		}
+		editorInsertRow(y+1+i, chars)
+	}
+	return y + last, len(lines[last])
+}
+
+// editorSetLines makes the buffer read lines, only touching the rows that differ
+// so the cursor, marks and folds around them stay where they were
+func editorSetLines(lines []string) bool {
+	old := make([]string, E.numrows)
+	for i := range E.row {
+		old[i] = string(E.row[i].chars)
+	}
+	ops := diffLines(old, lines)
+
+	// a cursor on a changed row goes to the row as far into the new text of the change
+	cy, y, changed := E.cy, 0, false
+	hunk, deleted, inserted, k := 0, 0, 0, -1
+	for i, op := range ops {
+		if op.kind != ' ' && (i == 0 || ops[i-1].kind == ' ') {
+			hunk, deleted, inserted, k = y, 0, 0, -1
+		}
+		switch op.kind {
+		case ' ':
+			if op.a == E.cy {
+				cy = y
+			}
+			y++
+		case '-':
+			if op.a == E.cy {
+				k = deleted
+			}
+			editorDelRow(y)
+			deleted++
+			changed = true
+		case '+':
+			editorInsertRow(y, []byte(lines[op.b]))
+			y++
+			inserted++
+			changed = true
+		}
+		if k >= 0 && op.kind != ' ' && (i == len(ops)-1 || ops[i+1].kind == ' ') {
+			cy = hunk + min(k, max(inserted-1, 0))
+			k = -1
+		}
+	}
+	E.cy = min(cy, max(E.numrows-1, 0))
+	if E.cy < E.numrows {
+		E.cx = min(E.cx, E.row[E.cy].size)
+	} else {
+		E.cx = 0
+	}
+	return changed
+}
+
+// editorNormalCommand handles the NORMAL and visual mode keys that aren't in
+// editorProcessKeyPress: motions, operators and put
+func editorNormalCommand(c int) {
+	switch c {
+	case 'd', 'c', 'y', 'x':
+		if editorInVisual() {
+			if c == 'x' {
+				c = 'd'
+			}
+			editorVisualOperator(byte(c))
+		} else if c == 'x' {
+			if E.cy < E.numrows && E.row[E.cy].size > 0 {
+				_, n := utf8.DecodeRune(E.row[E.cy].chars[E.cx:E.row[E.cy].size])
+				editorApplyOperator('d', E.cy, E.cx, E.cy, E.cx+max(n, 1), false)
+			}
+		} else {
+			editorOperator(byte(c))
+		}
+	case 'p', 'P':
+		if !editorInVisual() {
+			editorPut(c == 'p')
+		}
+	case 'K':
+		editorLspHover()
+	case CONTROL_KEY('w'):
+		editorWindowCommand(editorReadKey())
+	case CONTROL_KEY('p'):
+		editorFinderOpen("")
+	default:
+		m, ok := editorMotion(c)
+		if !ok {
+			return
+		}
+		E.cy, E.cx = m.y, m.x
+	}
+}
+
+// editorRegisterInfo describes the register for :registers
+func editorRegisterInfo() string {
+	kind := "c"
+	if register.linewise {
+		kind = "l"
+	}
+	return fmt.Sprintf("\"\"  %s  %s", kind, strings.Join(register.lines, "^J"))
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestWordMotionsInEmptyBuffer(t *testing.T) {
+	testBuffers(t)
+	for _, key := range []int{'w', 'e', 'b'} {
+		if m, ok := editorMotion(key); ok && (m.y != 0 || m.x != 0) {
+			t.Errorf("%c in an empty buffer went to %d,%d", key, m.y, m.x)
+		}
+	}
+	if y, x := editorWordForward(0, 0); y != 0 || x != 0 {
+		t.Errorf("editorWordForward in an empty buffer = %d, %d", y, x)
+	}
+}
+
+func TestWordForward(t *testing.T) {
+	testBuffers(t, "foo bar", "", "baz")
+	for _, tc := range []struct{ y, x, wy, wx int }{
+		{0, 0, 0, 4},
+		{0, 4, 1, 0},
+		{1, 0, 2, 0},
+		{2, 0, 2, 3},
+	} {
+		if y, x := editorWordForward(tc.y, tc.x); y != tc.wy || x != tc.wx {
+			t.Errorf("editorWordForward(%d, %d) = %d, %d, want %d, %d", tc.y, tc.x, y, x, tc.wy, tc.wx)
+		}
+	}
+}
+
+func TestCharacterMotions(t *testing.T) {
+	testBuffers(t, "a日é")
+	E.cx = 1
+	if m, _ := editorMotion('l'); m.x != 4 {
+		t.Errorf("l from 日 goes to %d, want 4", m.x)
+	}
+	E.cx = 4
+	if m, _ := editorMotion('h'); m.x != 1 {
+		t.Errorf("h from é goes to %d, want 1", m.x)
+	}
+
+	// x takes the whole of the character under the cursor
+	E.cx = 1
+	editorNormalCommand('x')
+	if got := string(E.row[0].chars); got != "aé" {
+		t.Errorf("x on 日 left %q", got)
+	}
+	// and backspace the whole of the one before it
+	E.cx = 3
+	editorDelChar()
+	if got := string(E.row[0].chars); got != "a" || E.cx != 1 {
+		t.Errorf("backspace after é left %q with the cursor at %d", got, E.cx)
+	}
+}
@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// a plugin is a program started with :plugin <command>, usually from the
+// config. it talks JSON-RPC over its stdin and stdout, framed like LSP: it
+// registers commands and keymaps, subscribes to events and calls back to
+// read and change buffers. the protocol is described in the plugin package
+type plugin struct {
+	name    string
+	cmd     *exec.Cmd
+	out     *lspWriter
+	nextid  int
+	pending map[int]func(json.RawMessage)
+	stopped bool
+
+	subscribed map[string]bool
+	opened     map[int]string // the file each buffer had when its open event went out
+	ticks      map[int]int    // the changetick the last change event was for
+	cursor     [3]int         // buffer, row and column of the last cursor event
+	mode       byte
+}
+
+var plugins []*plugin
+
+// the commands and NORMAL mode keymaps plugins registered, and the plugin each belongs to
+var (
+	pluginCommands = map[string]*plugin{}
+	pluginKeymaps  = map[string]*plugin{}
+)
+
+// the events a plugin can subscribe to
+var pluginEvents = []string{"open", "change", "save", "cursor", "mode"}
+
+// editorPluginCommand is :plugin. with no arguments it lists the plugins,
+// otherwise it starts the command given
+func editorPluginCommand(args string) {
+	argv := editorSplitArgs(args)
+	if len(argv) == 0 {
+		var lines []string
+		for _, p := range plugins {
+			var cmds, keys []string
+			for name, q := range pluginCommands {
+				if q == p {
+					cmds = append(cmds, ":"+name)
+				}
+			}
+			for k, q := range pluginKeymaps {
+				if q == p {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(cmds)
+			sort.Strings(keys)
+			lines = append(lines, fmt.Sprintf("%-16s pid %-8d %s", p.name, p.cmd.Process.Pid, strings.Join(append(cmds, keys...), " ")))
+		}
+		if len(lines) == 0 {
+			editorSetStatusMessage("no plugins running")
+			return
+		}
+		editorShowLines("plugins", lines)
+		return
+	}
+	if _, err := pluginStart(argv); err != nil {
+		editorSetStatusMessage("plugin: can't start %s: %s", argv[0], err)
+	}
+}
+
+func pluginStart(argv []string) (*plugin, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	// like language servers, what plugins write to stderr goes to a log in the state directory
+	if dir := editorStateDir(); dir != "" && os.MkdirAll(dir, 0755) == nil {
+		if log, err := os.OpenFile(filepath.Join(dir, "plugin.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			cmd.Stderr = log
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &plugin{
+		name:       filepath.Base(argv[0]),
+		cmd:        cmd,
+		out:        newLspWriter(in),
+		pending:    map[int]func(json.RawMessage){},
+		subscribed: map[string]bool{},
+		opened:     map[int]string{},
+		ticks:      map[int]int{},
+		cursor:     [3]int{-1, -1, -1},
+	}
+	plugins = append(plugins, p)
+	go p.read(bufio.NewReader(out))
+
+	p.request("initialize", map[string]any{"version": GODITOR_VERSION, "processId": os.Getpid()}, func(result json.RawMessage) {
+		var init struct {
+			Name string `json:"name"`
+		}
+		json.Unmarshal(result, &init)
+		if init.Name != "" {
+			p.name = init.Name
+		}
+	})
+	return p, nil
+}
+
+// read runs in its own goroutine until the plugin goes away
+func (p *plugin) read(r *bufio.Reader) {
+	for {
+		body, err := lspReadMessage(r)
+		if err != nil {
+			events <- p.exited
+			return
+		}
+		msg := &lspMessage{}
+		if json.Unmarshal(body, msg) != nil {
+			continue
+		}
+		events <- func() { p.handle(msg) }
+	}
+}
+
+func (p *plugin) send(msg lspMessage) {
+	p.out.send(msg)
+}
+
+func (p *plugin) request(method string, params any, handle func(json.RawMessage)) {
+	p.nextid++
+	p.pending[p.nextid] = handle
+	b, _ := json.Marshal(params)
+	p.send(lspMessage{ID: json.RawMessage(strconv.Itoa(p.nextid)), Method: method, Params: b})
+}
+
+func (p *plugin) notify(method string, params any) {
+	b, _ := json.Marshal(params)
+	p.send(lspMessage{Method: method, Params: b})
+}
+
+func (p *plugin) handle(msg *lspMessage) {
+	if p.stopped {
+		return
+	}
+	switch {
+	case msg.Method == "":
+		id, err := strconv.Atoi(string(msg.ID))
+		handle, ok := p.pending[id]
+		if err != nil || !ok {
+			return
+		}
+		delete(p.pending, id)
+		if msg.Error != nil {
+			editorSetStatusMessage("%s: %s", p.name, msg.Error.Message)
+			return
+		}
+		handle(msg.Result)
+	case msg.ID != nil:
+		resp := lspMessage{ID: msg.ID}
+		result, err := p.call(msg.Method, msg.Params)
+		if err != nil {
+			resp.Error = err
+		} else {
+			resp.Result, _ = json.Marshal(result)
+		}
+		p.send(resp)
+	default:
+		// notifications are calls nobody waits for
+		p.call(msg.Method, msg.Params)
+	}
+}
+
+// pluginInvalid is the error for params that don't make sense
+func pluginInvalid(format string, args ...any) *lspError {
+	return &lspError{Code: -32602, Message: fmt.Sprintf(format, args...)}
+}
+
+// call does what a plugin asked for and returns the result
+func (p *plugin) call(method string, params json.RawMessage) (any, *lspError) {
+	// the buffer a call is about is the current one unless it says otherwise
+	var args struct {
+		Buffer  int      `json:"buffer"`
+		Name    string   `json:"name"`
+		Keys    string   `json:"keys"`
+		Events  []string `json:"events"`
+		Start   int      `json:"start"`
+		End     int      `json:"end"`
+		Line    int      `json:"line"`
+		Col     int      `json:"col"`
+		Lines   []string `json:"lines"`
+		Message string   `json:"message"`
+		Command string   `json:"command"`
+	}
+	args.Buffer, args.End = -1, -1
+	if len(params) > 0 && json.Unmarshal(params, &args) != nil {
+		return nil, pluginInvalid("invalid params for %s", method)
+	}
+	buf := args.Buffer
+	if buf == -1 {
+		buf = curbuf
+	}
+	if buf < 0 || buf >= len(buffers) {
+		return nil, pluginInvalid("no buffer %d", args.Buffer)
+	}
+
+	switch method {
+	case "registerCommand":
+		if args.Name == "" || strings.ContainsAny(args.Name, " \t!") {
+			return nil, pluginInvalid("invalid command name: %q", args.Name)
+		}
+		pluginCommands[args.Name] = p
+	case "registerKeymap":
+		if args.Keys == "" {
+			return nil, pluginInvalid("no keys to map")
+		}
+		pluginKeymaps[args.Keys] = p
+	case "subscribe":
+		for _, ev := range args.Events {
+			known := false
+			for _, e := range pluginEvents {
+				known = known || e == ev
+			}
+			if !known {
+				return nil, pluginInvalid("unknown event: %s", ev)
+			}
+			p.subscribed[ev] = true
+		}
+	case "bufferInfo":
+		var info map[string]any
+		editorInBuffer(buf, func() { info = editorPluginBufferInfo() })
+		return info, nil
+	case "getLines":
+		var lines []string
+		var err *lspError
+		editorInBuffer(buf, func() {
+			start, end, e := editorPluginRange(args.Start, args.End)
+			if err = e; err == nil {
+				lines = editorBufferLines()[start:end]
+			}
+		})
+		return lines, err
+	case "setLines":
+		var err *lspError
+		editorInBuffer(buf, func() {
+			start, end, e := editorPluginRange(args.Start, args.End)
+			if err = e; err != nil {
+				return
+			}
+			if E.readonly || E.buftype != "" || E.hex {
+				err = &lspError{Code: -32000, Message: "buffer can't be changed"}
+				return
+			}
+			editorDiffReplace(start, end-start, args.Lines)
+			E.cy = min(E.cy, max(E.numrows-1, 0))
+			editorUndoCommit()
+		})
+		return nil, err
+	case "setCursor":
+		var err *lspError
+		editorInBuffer(buf, func() {
+			if args.Line < 0 || args.Line >= max(E.numrows, 1) {
+				err = pluginInvalid("no line %d", args.Line)
+				return
+			}
+			E.cy = args.Line
+			E.cx = 0
+			if E.cy < E.numrows {
+				E.cx = min(max(args.Col, 0), E.row[E.cy].size)
+			}
+		})
+		return nil, err
+	case "setStatus":
+		editorSetStatusMessage("%s", args.Message)
+	case "popup":
+		editorShowPopup(args.Lines)
+	case "execute":
+		editorRunCommand(args.Command)
+	default:
+		return nil, &lspError{Code: -32601, Message: "method not found: " + method}
+	}
+	return nil, nil
+}
+
+// editorPluginRange checks the rows from start up to end a plugin asked for, end -1 being the last one
+func editorPluginRange(start, end int) (int, int, *lspError) {
+	if end == -1 {
+		end = E.numrows
+	}
+	if start < 0 || end > E.numrows || start > end {
+		return 0, 0, pluginInvalid("invalid range %d-%d of %d lines", start, end, E.numrows)
+	}
+	return start, end, nil
+}
+
+// editorPluginBufferInfo is what bufferInfo says about the current buffer
+func editorPluginBufferInfo() map[string]any {
+	filetype := ""
+	if E.syntax != nil {
+		filetype = E.syntax.filetype
+	}
+	return map[string]any{
+		"buffer":   curbuf,
+		"file":     E.filename,
+		"filetype": filetype,
+		"buftype":  E.buftype,
+		"lines":    E.numrows,
+		"line":     E.cy,
+		"col":      E.cx,
+		"modified": E.dirty,
+		"readonly": E.readonly,
+		"mode":     modeNames[E.mode],
+	}
+}
+
+// exited is run when the plugin's output ends, what it registered goes with it
+func (p *plugin) exited() {
+	if p.stopped {
+		return
+	}
+	p.stop()
+	editorSetStatusMessage("plugin: %s exited", p.name)
+}
+
+func (p *plugin) stop() {
+	p.stopped = true
+	p.out.close()
+	go p.cmd.Wait()
+	for name, q := range pluginCommands {
+		if q == p {
+			delete(pluginCommands, name)
+		}
+	}
+	for keys, q := range pluginKeymaps {
+		if q == p {
+			delete(pluginKeymaps, keys)
+		}
+	}
+	for i, q := range plugins {
+		if q == p {
+			plugins = append(plugins[:i], plugins[i+1:]...)
+			break
+		}
+	}
+}
+
+// editorPluginShutdown tells every plugin goditor is exiting, closing their input
+func editorPluginShutdown() {
+	for len(plugins) > 0 {
+		p := plugins[0]
+		p.notify("shutdown", nil)
+		p.stop()
+	}
+}
+
+// editorPluginSync sends plugins the events they subscribed to. like
+// editorLspSync it runs before every redraw, and finds what happened since
+// the last time by comparing with what each plugin was last told
+func editorPluginSync() {
+	for _, p := range plugins {
+		for i := range buffers {
+			b := &buffers[i]
+			if i == curbuf {
+				b = &E
+			}
+			// lists, terminals and the hex view aren't text plugins can work on
+			if b.buftype != "" || b.hex {
+				continue
+			}
+			if name, ok := p.opened[i]; !ok || name != b.filename {
+				p.opened[i], p.ticks[i] = b.filename, b.changetick
+				p.event("open", map[string]any{"buffer": i, "file": b.filename})
+			}
+			if p.ticks[i] != b.changetick {
+				p.ticks[i] = b.changetick
+				p.event("change", map[string]any{"buffer": i, "file": b.filename, "lines": b.numrows})
+			}
+		}
+		if cur := [3]int{curbuf, E.cy, E.cx}; cur != p.cursor {
+			p.cursor = cur
+			p.event("cursor", map[string]any{"buffer": curbuf, "line": E.cy, "col": E.cx})
+		}
+		if p.mode != E.mode {
+			p.mode = E.mode
+			p.event("mode", map[string]any{"buffer": curbuf, "mode": modeNames[E.mode]})
+		}
+	}
+}
+
+// editorPluginDidSave is run after the current buffer is written
+func editorPluginDidSave() {
+	for _, p := range plugins {
+		p.event("save", map[string]any{"buffer": curbuf, "file": E.filename})
+	}
+}
+
+// event sends an event the plugin subscribed to
+func (p *plugin) event(name string, params map[string]any) {
+	if !p.subscribed[name] {
+		return
+	}
+	params["event"] = name
+	p.notify("event", params)
+}
+
+// editorPluginRunCommand runs :name when a plugin registered it
+func editorPluginRunCommand(name, args string, start, end int, ranged bool) bool {
+	p := pluginCommands[name]
+	if p == nil {
+		return false
+	}
+	params := map[string]any{"name": name, "args": args, "buffer": curbuf}
+	if ranged {
+		params["range"] = []int{start, end}
+	}
+	p.notify("command", params)
+	return true
+}
+
+// editorPluginKey runs the keymap that starts with c in NORMAL mode. while the
+// keys typed are the start of a longer one it waits for more, and when they
+// turn out not to be one they're run as usual
+func editorPluginKey(c int) bool {
+	// keys like the arrows aren't in keymaps
+	if len(pluginKeymaps) == 0 || c >= 256 {
+		return false
+	}
+	typed := []byte{byte(c)}
+	var read []int // the keys read after c
+	for {
+		if p := pluginKeymaps[string(typed)]; p != nil {
+			p.notify("keymap", map[string]any{"keys": string(typed), "buffer": curbuf})
+			return true
+		}
+		prefix := false
+		for keys := range pluginKeymaps {
+			prefix = prefix || strings.HasPrefix(keys, string(typed))
+		}
+		if !prefix {
+			break
+		}
+		key := editorReadKey()
+		if key == '\x1b' {
+			return true
+		}
+		read = append(read, key)
+		if key >= 256 {
+			break
+		}
+		typed = append(typed, byte(key))
+	}
+	// c is run by the caller, the keys read after it are read again
+	unreadKeys = append(read, unreadKeys...)
+	return false
+}
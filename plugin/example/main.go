@@ -0,0 +1,112 @@
+// example is a goditor plugin that counts words and trims trailing blanks.
+// build it and start it from goditorrc with
+//
+//	plugin /path/to/example
+//
+// it adds :WordCount and :Trim, which both take a range, and gW, which shows
+// the counts of the buffer in a popup. after a save the status line says how
+// many words were written
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kristof1345/goditor/plugin"
+)
+
+func main() {
+	p := plugin.New("example")
+
+	p.Command("WordCount", func(c plugin.Command) {
+		lines, err := commandLines(p, c)
+		if err != nil {
+			p.Status("WordCount: %s", err)
+			return
+		}
+		l, w, ch := count(lines)
+		p.Status("%d lines, %d words, %d characters", l, w, ch)
+	})
+
+	p.Command("Trim", func(c plugin.Command) {
+		start, end := 0, -1
+		if c.Range != nil {
+			start, end = c.Range[0], c.Range[1]+1
+		}
+		lines, err := p.Lines(c.Buffer, start, end)
+		if err != nil {
+			p.Status("Trim: %s", err)
+			return
+		}
+		trimmed := 0
+		for i, line := range lines {
+			if t := strings.TrimRight(line, " \t"); t != line {
+				lines[i] = t
+				trimmed++
+			}
+		}
+		if trimmed == 0 {
+			p.Status("no trailing blanks")
+			return
+		}
+		if err := p.SetLines(c.Buffer, start, start+len(lines), lines); err != nil {
+			p.Status("Trim: %s", err)
+			return
+		}
+		p.Status("trimmed %d lines", trimmed)
+	})
+
+	p.Keymap("gW", func(buffer int) {
+		info, err := p.BufferInfo(buffer)
+		if err != nil {
+			return
+		}
+		lines, err := p.Lines(buffer, 0, -1)
+		if err != nil {
+			return
+		}
+		l, w, ch := count(lines)
+		name := info.File
+		if name == "" {
+			name = "[No Name]"
+		}
+		p.Popup([]string{
+			name,
+			fmt.Sprintf("lines       %d", l),
+			fmt.Sprintf("words       %d", w),
+			fmt.Sprintf("characters  %d", ch),
+		})
+	})
+
+	p.On(plugin.EventSave, func(e plugin.Event) {
+		lines, err := p.Lines(e.Buffer, 0, -1)
+		if err != nil {
+			return
+		}
+		_, w, _ := count(lines)
+		p.Status("\"%s\" %d words written", e.File, w)
+	})
+
+	if err := p.Run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// commandLines are the lines of the command's range, or of the whole buffer
+func commandLines(p *plugin.Plugin, c plugin.Command) ([]string, error) {
+	if c.Range != nil {
+		return p.Lines(c.Buffer, c.Range[0], c.Range[1]+1)
+	}
+	return p.Lines(c.Buffer, 0, -1)
+}
+
+func count(lines []string) (int, int, int) {
+	words, chars := 0, 0
+	for _, line := range lines {
+		words += len(strings.Fields(line))
+		chars += utf8.RuneCountInString(line)
+	}
+	return len(lines), words, chars
+}
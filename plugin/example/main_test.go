@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestCount(t *testing.T) {
+	for _, tc := range []struct {
+		lines               []string
+		nlines, words, char int
+	}{
+		{nil, 0, 0, 0},
+		{[]string{""}, 1, 0, 0},
+		{[]string{"hello world  ", "\tfoo"}, 2, 3, 17},
+		{[]string{"héllo wörld"}, 1, 2, 11},
+	} {
+		l, w, c := count(tc.lines)
+		if l != tc.nlines || w != tc.words || c != tc.char {
+			t.Errorf("count(%q) = %d, %d, %d, want %d, %d, %d", tc.lines, l, w, c, tc.nlines, tc.words, tc.char)
+		}
+	}
+}
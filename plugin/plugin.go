@@ -0,0 +1,404 @@
+// Package plugin is for writing goditor plugins in Go.
+//
+// A plugin is a program goditor starts with :plugin <command>, usually from a
+// line in goditorrc. The two talk JSON-RPC 2.0 over the plugin's stdin and
+// stdout, each message preceded by a Content-Length header like in LSP.
+// What the plugin writes to stderr goes to plugin.log in goditor's state
+// directory.
+//
+// goditor sends the plugin:
+//
+//	initialize  request, {version, processId}. the result may have a name for :plugin to show
+//	command     notification, {name, args, buffer, range?} when a registered command is run
+//	keymap      notification, {keys, buffer} when a registered NORMAL mode keymap is typed
+//	event       notification, {event, buffer, ...} for the events the plugin subscribed to
+//	shutdown    notification, goditor is exiting. its stdin is closed right after
+//
+// The events are open {file}, change {file, lines}, save {file}, cursor
+// {line, col} and mode {mode}. and the plugin can call:
+//
+//	registerCommand  {name}
+//	registerKeymap   {keys}
+//	subscribe        {events}
+//	bufferInfo       {buffer} -> {buffer, file, filetype, buftype, lines, line, col, modified, readonly, mode}
+//	getLines         {buffer, start, end} -> [lines]
+//	setLines         {buffer, start, end, lines}
+//	setCursor        {buffer, line, col}
+//	setStatus        {message}
+//	popup            {lines}
+//	execute          {command}
+//
+// Buffers are numbers that stay the same while goditor runs, -1 or none is
+// the current buffer. Lines and columns count from 0, and a range of lines
+// goes from start up to but not including end, an end of -1 being the last line.
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Current is the buffer that's current when a call gets to goditor
+const Current = -1
+
+// the events a plugin can subscribe to with On
+const (
+	EventOpen   = "open"
+	EventChange = "change"
+	EventSave   = "save"
+	EventCursor = "cursor"
+	EventMode   = "mode"
+)
+
+// Command is a registered command being run
+type Command struct {
+	Name   string `json:"name"`
+	Args   string `json:"args"`
+	Buffer int    `json:"buffer"`
+	Range  []int  `json:"range"` // the first and last line, when the command was given some
+}
+
+// Event is something that happened in goditor, the fields that don't go with it are zero
+type Event struct {
+	Event  string `json:"event"`
+	Buffer int    `json:"buffer"`
+	File   string `json:"file"`
+	Lines  int    `json:"lines"`
+	Line   int    `json:"line"`
+	Col    int    `json:"col"`
+	Mode   string `json:"mode"`
+}
+
+// Buffer is what bufferInfo says about a buffer
+type Buffer struct {
+	Buffer   int    `json:"buffer"`
+	File     string `json:"file"`
+	Filetype string `json:"filetype"`
+	Buftype  string `json:"buftype"`
+	Lines    int    `json:"lines"`
+	Line     int    `json:"line"`
+	Col      int    `json:"col"`
+	Modified bool   `json:"modified"`
+	Readonly bool   `json:"readonly"`
+	Mode     string `json:"mode"`
+}
+
+// Error is an error goditor answered a call with
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+type message struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+type response struct {
+	result json.RawMessage
+	err    error
+}
+
+// Plugin is the connection to goditor. handlers are registered before Run,
+// which runs them one at a time, and they can make calls while they run
+type Plugin struct {
+	name string
+	r    *bufio.Reader
+	w    io.Writer
+
+	commands map[string]func(Command)
+	keymaps  map[string]func(buffer int)
+	events   map[string]func(Event)
+
+	mu      sync.Mutex
+	nextid  int
+	pending map[int]chan response
+	queue   []*message    // what came from goditor for Run, which the reader never waits for
+	wake    chan struct{} // there's something in queue
+}
+
+// New is a plugin called name talking to goditor over stdin and stdout
+func New(name string) *Plugin {
+	return NewConn(name, os.Stdin, os.Stdout)
+}
+
+// NewConn is a plugin talking to goditor over r and w
+func NewConn(name string, r io.Reader, w io.Writer) *Plugin {
+	return &Plugin{
+		name:     name,
+		r:        bufio.NewReader(r),
+		w:        w,
+		commands: map[string]func(Command){},
+		keymaps:  map[string]func(int){},
+		events:   map[string]func(Event){},
+		pending:  map[int]chan response{},
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Command has f run for :name
+func (p *Plugin) Command(name string, f func(Command)) {
+	p.commands[name] = f
+}
+
+// Keymap has f run when keys are typed in NORMAL mode
+func (p *Plugin) Keymap(keys string, f func(buffer int)) {
+	p.keymaps[keys] = f
+}
+
+// On has f run for event, one of the Event constants
+func (p *Plugin) On(event string, f func(Event)) {
+	p.events[event] = f
+}
+
+// Run registers the commands, keymaps and events with goditor and runs their
+// handlers until goditor exits
+func (p *Plugin) Run() error {
+	done := make(chan error, 1)
+	go p.read(done)
+
+	for name := range p.commands {
+		if err := p.call("registerCommand", map[string]string{"name": name}, nil); err != nil {
+			return err
+		}
+	}
+	for keys := range p.keymaps {
+		if err := p.call("registerKeymap", map[string]string{"keys": keys}, nil); err != nil {
+			return err
+		}
+	}
+	if len(p.events) > 0 {
+		var names []string
+		for name := range p.events {
+			names = append(names, name)
+		}
+		if err := p.call("subscribe", map[string][]string{"events": names}, nil); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-p.wake:
+			p.mu.Lock()
+			queue := p.queue
+			p.queue = nil
+			p.mu.Unlock()
+			for _, msg := range queue {
+				if msg.Method == "shutdown" {
+					return nil
+				}
+				p.dispatch(msg)
+			}
+		case err := <-done:
+			return err
+		}
+	}
+}
+
+// read hands responses to the calls waiting for them and everything else to Run.
+// it never blocks on Run, so the answer to a call a handler waits for gets through
+func (p *Plugin) read(done chan<- error) {
+	for {
+		body, err := readMessage(p.r)
+		if err != nil {
+			p.mu.Lock()
+			for id, ch := range p.pending {
+				ch <- response{err: err}
+				delete(p.pending, id)
+			}
+			p.mu.Unlock()
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			done <- err
+			return
+		}
+		msg := &message{}
+		if json.Unmarshal(body, msg) != nil {
+			continue
+		}
+		if msg.Method == "" {
+			id, _ := strconv.Atoi(string(msg.ID))
+			p.mu.Lock()
+			ch, ok := p.pending[id]
+			delete(p.pending, id)
+			p.mu.Unlock()
+			if ok {
+				r := response{result: msg.Result}
+				if msg.Error != nil {
+					r.err = msg.Error
+				}
+				ch <- r
+			}
+			continue
+		}
+		if msg.Method == "initialize" {
+			// answered here, so it doesn't wait behind the registrations
+			result, _ := json.Marshal(map[string]string{"name": p.name})
+			p.send(&message{ID: msg.ID, Result: result})
+			continue
+		}
+		p.mu.Lock()
+		p.queue = append(p.queue, msg)
+		p.mu.Unlock()
+		select {
+		case p.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// dispatch runs the handler for a notification from goditor
+func (p *Plugin) dispatch(msg *message) {
+	switch msg.Method {
+	case "command":
+		var c Command
+		json.Unmarshal(msg.Params, &c)
+		if f := p.commands[c.Name]; f != nil {
+			f(c)
+		}
+	case "keymap":
+		var k struct {
+			Keys   string `json:"keys"`
+			Buffer int    `json:"buffer"`
+		}
+		json.Unmarshal(msg.Params, &k)
+		if f := p.keymaps[k.Keys]; f != nil {
+			f(k.Buffer)
+		}
+	case "event":
+		var e Event
+		json.Unmarshal(msg.Params, &e)
+		if f := p.events[e.Event]; f != nil {
+			f(e)
+		}
+	}
+	if msg.ID != nil {
+		p.send(&message{ID: msg.ID, Result: json.RawMessage("null")})
+	}
+}
+
+// readMessage reads the headers and the body of one message
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without a Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+func (p *Plugin) send(msg *message) error {
+	msg.Jsonrpc = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err = fmt.Fprintf(p.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	return err
+}
+
+// call sends method to goditor and waits for the answer, which goes in result unless it's nil
+func (p *Plugin) call(method string, params any, result any) error {
+	b, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	ch := make(chan response, 1)
+	p.mu.Lock()
+	p.nextid++
+	id := p.nextid
+	p.pending[id] = ch
+	p.mu.Unlock()
+
+	if err := p.send(&message{ID: json.RawMessage(strconv.Itoa(id)), Method: method, Params: b}); err != nil {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+		return err
+	}
+	r := <-ch
+	if r.err != nil {
+		return r.err
+	}
+	if result != nil {
+		return json.Unmarshal(r.result, result)
+	}
+	return nil
+}
+
+// BufferInfo says what's in buffer and where its cursor is
+func (p *Plugin) BufferInfo(buffer int) (Buffer, error) {
+	var b Buffer
+	err := p.call("bufferInfo", map[string]int{"buffer": buffer}, &b)
+	return b, err
+}
+
+// Lines are the lines of buffer from start up to end, -1 for all the way
+func (p *Plugin) Lines(buffer, start, end int) ([]string, error) {
+	var lines []string
+	err := p.call("getLines", map[string]int{"buffer": buffer, "start": start, "end": end}, &lines)
+	return lines, err
+}
+
+// SetLines puts lines in place of the lines of buffer from start up to end, -1 for all the way
+func (p *Plugin) SetLines(buffer, start, end int, lines []string) error {
+	if lines == nil {
+		lines = []string{}
+	}
+	return p.call("setLines", map[string]any{"buffer": buffer, "start": start, "end": end, "lines": lines}, nil)
+}
+
+// SetCursor moves the cursor of buffer
+func (p *Plugin) SetCursor(buffer, line, col int) error {
+	return p.call("setCursor", map[string]int{"buffer": buffer, "line": line, "col": col}, nil)
+}
+
+// Status shows a message in the status line
+func (p *Plugin) Status(format string, args ...any) error {
+	return p.call("setStatus", map[string]string{"message": fmt.Sprintf(format, args...)}, nil)
+}
+
+// Popup shows lines in a box next to the cursor, until the next key
+func (p *Plugin) Popup(lines []string) error {
+	return p.call("popup", map[string][]string{"lines": lines}, nil)
+}
+
+// Execute runs an ex command as if it was typed after a :
+func (p *Plugin) Execute(command string) error {
+	return p.call("execute", map[string]string{"command": command}, nil)
+}
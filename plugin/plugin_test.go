@@ -0,0 +1,298 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// editor is goditor's side of a connection, answering calls with answer
+type editor struct {
+	t      *testing.T
+	w      *io.PipeWriter
+	r      *bufio.Reader
+	mu     sync.Mutex
+	calls  chan *message
+	answer func(method string, params json.RawMessage) (any, *Error)
+}
+
+// start connects a plugin to an editor and runs it, Run's error goes to the channel returned
+func start(t *testing.T, p func(r io.Reader, w io.Writer) *Plugin, answer func(string, json.RawMessage) (any, *Error)) (*editor, *Plugin, chan error) {
+	toPlugin, fromEditor := io.Pipe()
+	fromPlugin, toEditor := io.Pipe()
+	e := &editor{t: t, w: fromEditor, r: bufio.NewReader(fromPlugin), calls: make(chan *message, 100), answer: answer}
+	if e.answer == nil {
+		e.answer = func(string, json.RawMessage) (any, *Error) { return nil, nil }
+	}
+	plugin := p(toPlugin, toEditor)
+	go e.serve()
+	done := make(chan error, 1)
+	go func() { done <- plugin.Run() }()
+	t.Cleanup(func() {
+		fromEditor.Close()
+		toEditor.Close()
+	})
+	return e, plugin, done
+}
+
+// serve answers the plugin's calls and passes them, and the answers to the editor's own, to calls
+func (e *editor) serve() {
+	for {
+		body, err := readMessage(e.r)
+		if err != nil {
+			close(e.calls)
+			return
+		}
+		msg := &message{}
+		if err := json.Unmarshal(body, msg); err != nil {
+			e.t.Errorf("invalid message %s: %s", body, err)
+			continue
+		}
+		if msg.Method != "" && msg.ID != nil {
+			result, err := e.answer(msg.Method, msg.Params)
+			resp := &message{ID: msg.ID, Error: err}
+			if err == nil {
+				resp.Result, _ = json.Marshal(result)
+			}
+			e.send(resp)
+		}
+		e.calls <- msg
+	}
+}
+
+func (e *editor) send(msg *message) {
+	msg.Jsonrpc = "2.0"
+	body, _ := json.Marshal(msg)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	fmt.Fprintf(e.w, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func (e *editor) notify(method string, params any) {
+	b, _ := json.Marshal(params)
+	e.send(&message{Method: method, Params: b})
+}
+
+// next is the next call from the plugin with method
+func (e *editor) next(method string) *message {
+	e.t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg, ok := <-e.calls:
+			if !ok {
+				e.t.Fatalf("connection closed waiting for %s", method)
+			}
+			if msg.Method == method {
+				return msg
+			}
+		case <-timeout:
+			e.t.Fatalf("no %s from the plugin", method)
+		}
+	}
+}
+
+func wait[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+	}
+	var zero T
+	return zero
+}
+
+func TestRegistration(t *testing.T) {
+	e, _, done := start(t, func(r io.Reader, w io.Writer) *Plugin {
+		p := NewConn("test", r, w)
+		p.Command("Foo", func(Command) {})
+		p.Keymap("gx", func(int) {})
+		p.On(EventSave, func(Event) {})
+		p.On(EventOpen, func(Event) {})
+		return p
+	}, nil)
+
+	var cmd, keymap struct {
+		Name string `json:"name"`
+		Keys string `json:"keys"`
+	}
+	json.Unmarshal(e.next("registerCommand").Params, &cmd)
+	json.Unmarshal(e.next("registerKeymap").Params, &keymap)
+	if cmd.Name != "Foo" || keymap.Keys != "gx" {
+		t.Errorf("registered command %q and keymap %q, want Foo and gx", cmd.Name, keymap.Keys)
+	}
+	var sub struct {
+		Events []string `json:"events"`
+	}
+	json.Unmarshal(e.next("subscribe").Params, &sub)
+	if got := strings.Join(sub.Events, ","); got != "open,save" && got != "save,open" {
+		t.Errorf("subscribed to %v, want open and save", sub.Events)
+	}
+
+	// initialize is answered with the name
+	e.send(&message{ID: json.RawMessage("1"), Method: "initialize", Params: json.RawMessage("{}")})
+	var resp struct {
+		Name string `json:"name"`
+	}
+	json.Unmarshal(e.next("").Result, &resp)
+	if resp.Name != "test" {
+		t.Errorf("initialize answered with name %q, want test", resp.Name)
+	}
+
+	e.w.Close()
+	if err := wait(t, done); err != nil {
+		t.Errorf("Run returned %v when goditor went away", err)
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	commands := make(chan Command, 1)
+	keys := make(chan int, 1)
+	events := make(chan Event, 1)
+	e, _, _ := start(t, func(r io.Reader, w io.Writer) *Plugin {
+		p := NewConn("test", r, w)
+		p.Command("Foo", func(c Command) { commands <- c })
+		p.Keymap("gx", func(buffer int) { keys <- buffer })
+		p.On(EventCursor, func(ev Event) { events <- ev })
+		return p
+	}, nil)
+	e.next("subscribe")
+
+	e.notify("command", map[string]any{"name": "Foo", "args": "a b", "buffer": 2, "range": []int{3, 5}})
+	if c := wait(t, commands); c.Args != "a b" || c.Buffer != 2 || len(c.Range) != 2 || c.Range[0] != 3 || c.Range[1] != 5 {
+		t.Errorf("command handler got %+v", c)
+	}
+	e.notify("keymap", map[string]any{"keys": "gx", "buffer": 1})
+	if b := wait(t, keys); b != 1 {
+		t.Errorf("keymap handler got buffer %d, want 1", b)
+	}
+	e.notify("event", map[string]any{"event": "cursor", "buffer": 0, "line": 4, "col": 7})
+	if ev := wait(t, events); ev.Line != 4 || ev.Col != 7 {
+		t.Errorf("event handler got %+v", ev)
+	}
+	// events nobody handles are ignored
+	e.notify("event", map[string]any{"event": "mode", "mode": "INSERT"})
+	e.notify("keymap", map[string]any{"keys": "gy"})
+	e.notify("event", map[string]any{"event": "cursor", "line": 1})
+	if ev := wait(t, events); ev.Line != 1 {
+		t.Errorf("event handler got %+v", ev)
+	}
+}
+
+func TestCallsFromHandlers(t *testing.T) {
+	var mu sync.Mutex
+	lines := []string{"one", "two", "three"}
+	var e *editor
+	answer := func(method string, params json.RawMessage) (any, *Error) {
+		var p struct {
+			Start int      `json:"start"`
+			End   int      `json:"end"`
+			Lines []string `json:"lines"`
+		}
+		json.Unmarshal(params, &p)
+		mu.Lock()
+		defer mu.Unlock()
+		if p.End == -1 {
+			p.End = len(lines)
+		}
+		switch method {
+		case "getLines":
+			// events keep coming while the handler waits, the answer must still get through
+			for i := 0; i < 200; i++ {
+				e.notify("event", map[string]any{"event": "cursor", "line": i})
+			}
+			return lines[p.Start:p.End], nil
+		case "setLines":
+			if p.Start > p.End || p.End > len(lines) {
+				return nil, &Error{Code: -32602, Message: "invalid range"}
+			}
+			lines = append(append(append([]string(nil), lines[:p.Start]...), p.Lines...), lines[p.End:]...)
+		}
+		return nil, nil
+	}
+	done := make(chan error, 1)
+	cursor := make(chan int, 1000)
+	e, _, _ = start(t, func(r io.Reader, w io.Writer) *Plugin {
+		p := NewConn("test", r, w)
+		p.Command("Upper", func(c Command) {
+			got, err := p.Lines(c.Buffer, 1, -1)
+			if err == nil {
+				for i := range got {
+					got[i] = strings.ToUpper(got[i])
+				}
+				err = p.SetLines(c.Buffer, 1, -1, got)
+			}
+			if err == nil {
+				err = p.SetLines(c.Buffer, 5, 1, nil)
+			}
+			done <- err
+		})
+		p.On(EventCursor, func(ev Event) { cursor <- ev.Line })
+		return p
+	}, answer)
+	e.next("subscribe")
+
+	e.notify("command", map[string]any{"name": "Upper", "buffer": Current})
+	err := wait(t, done)
+	var rpc *Error
+	if !errors.As(err, &rpc) || rpc.Message != "invalid range" {
+		t.Errorf("SetLines with an invalid range returned %v, want the error goditor answered with", err)
+	}
+	mu.Lock()
+	if got := strings.Join(lines, ","); got != "one,TWO,THREE" {
+		t.Errorf("lines are %s after the command, want one,TWO,THREE", got)
+	}
+	mu.Unlock()
+	// and the events that came meanwhile are all handled afterwards
+	for i := 0; i < 200; i++ {
+		if line := wait(t, cursor); line != i {
+			t.Fatalf("cursor event %d has line %d", i, line)
+		}
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	handled := make(chan string, 2)
+	e, _, done := start(t, func(r io.Reader, w io.Writer) *Plugin {
+		p := NewConn("test", r, w)
+		p.On(EventSave, func(ev Event) { handled <- ev.File })
+		return p
+	}, nil)
+	e.next("subscribe")
+
+	e.notify("event", map[string]any{"event": "save", "file": "a.txt"})
+	e.notify("shutdown", nil)
+	e.notify("event", map[string]any{"event": "save", "file": "b.txt"})
+	if err := wait(t, done); err != nil {
+		t.Errorf("Run returned %v after shutdown", err)
+	}
+	close(handled)
+	var files []string
+	for f := range handled {
+		files = append(files, f)
+	}
+	if strings.Join(files, ",") != "a.txt" {
+		t.Errorf("handled saves of %v, want only the one before shutdown", files)
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	body := `{"jsonrpc":"2.0","method":"event"}`
+	in := "Content-Type: application/json\r\nContent-Length: " + strconv.Itoa(len(body)) + "\r\n\r\n" + body
+	got, err := readMessage(bufio.NewReader(strings.NewReader(in)))
+	if err != nil || string(got) != body {
+		t.Errorf("readMessage = %q, %v", got, err)
+	}
+	if _, err := readMessage(bufio.NewReader(strings.NewReader("X: 1\r\n\r\n{}"))); err == nil {
+		t.Error("readMessage of a message without a Content-Length didn't fail")
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func testPlugin() *plugin {
+	return &plugin{
+		name:       "test",
+		pending:    map[int]func(json.RawMessage){},
+		subscribed: map[string]bool{},
+		opened:     map[int]string{},
+		ticks:      map[int]int{},
+	}
+}
+
+func TestPluginGetLines(t *testing.T) {
+	testBuffers(t, "one", "two", "three")
+	p := testPlugin()
+	for _, tc := range []struct {
+		params string
+		want   string
+		err    bool
+	}{
+		{`{}`, "one,two,three", false},
+		{`{"start":1}`, "two,three", false},
+		{`{"start":0,"end":2}`, "one,two", false},
+		{`{"start":3,"end":3}`, "", false},
+		{`{"start":2,"end":1}`, "", true},
+		{`{"start":0,"end":4}`, "", true},
+		{`{"start":-1}`, "", true},
+		{`{"buffer":1}`, "", true},
+	} {
+		result, err := p.call("getLines", json.RawMessage(tc.params))
+		if (err != nil) != tc.err {
+			t.Errorf("getLines %s: error %v", tc.params, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := strings.Join(result.([]string), ","); got != tc.want {
+			t.Errorf("getLines %s = %s, want %s", tc.params, got, tc.want)
+		}
+	}
+}
+
+func TestPluginSetLines(t *testing.T) {
+	for _, tc := range []struct {
+		params string
+		want   string
+		err    bool
+	}{
+		{`{"start":1,"end":2,"lines":["TWO"]}`, "one,TWO,three", false},
+		{`{"start":1,"lines":[]}`, "one", false},
+		{`{"start":0,"end":0,"lines":["zero"]}`, "zero,one,two,three", false},
+		{`{"start":3,"end":3,"lines":["four","five"]}`, "one,two,three,four,five", false},
+		{`{"start":2,"end":5,"lines":["x"]}`, "one,two,three", true},
+	} {
+		testBuffers(t, "one", "two", "three")
+		p := testPlugin()
+		_, err := p.call("setLines", json.RawMessage(tc.params))
+		if (err != nil) != tc.err {
+			t.Errorf("setLines %s: error %v", tc.params, err)
+		}
+		if got := strings.Join(editorBufferLines(), ","); got != tc.want {
+			t.Errorf("setLines %s left %s, want %s", tc.params, got, tc.want)
+		}
+		if E.dirty == tc.err {
+			t.Errorf("setLines %s: modified is %v", tc.params, E.dirty)
+		}
+	}
+}
+
+func TestPluginSetLinesReadonly(t *testing.T) {
+	testBuffers(t, "one", "two")
+	E.readonly = true
+	p := testPlugin()
+	if _, err := p.call("setLines", json.RawMessage(`{"start":0,"lines":["x"]}`)); err == nil {
+		t.Error("setLines changed a read-only buffer")
+	}
+	if got := strings.Join(editorBufferLines(), ","); got != "one,two" {
+		t.Errorf("read-only buffer has %s", got)
+	}
+
+	// nor a list, in a buffer other than the current one
+	E.readonly = false
+	editorAddBuffer()
+	E.buftype = "quickfix"
+	editorFillBuffer([]string{"a.txt|1| x"})
+	editorSwitchBuffer(0)
+	if _, err := p.call("setLines", json.RawMessage(`{"buffer":1,"start":0,"lines":["x"]}`)); err == nil {
+		t.Error("setLines changed a quickfix buffer")
+	}
+	if _, err := p.call("setLines", json.RawMessage(`{"buffer":0,"start":0,"end":1,"lines":["x"]}`)); err != nil {
+		t.Errorf("setLines of buffer 0: %v", err)
+	}
+	if got := strings.Join(editorBufferLines(), ","); got != "x,two" {
+		t.Errorf("buffer 0 has %s after setLines, want x,two", got)
+	}
+}
+
+func TestPluginRegister(t *testing.T) {
+	testBuffers(t)
+	p := testPlugin()
+	if _, err := p.call("registerCommand", json.RawMessage(`{"name":"Foo"}`)); err != nil || pluginCommands["Foo"] != p {
+		t.Errorf("registerCommand Foo: %v", err)
+	}
+	if _, err := p.call("registerCommand", json.RawMessage(`{"name":"a b"}`)); err == nil {
+		t.Error("registerCommand took a name with a blank")
+	}
+	if _, err := p.call("subscribe", json.RawMessage(`{"events":["save","bogus"]}`)); err == nil {
+		t.Error("subscribe took an unknown event")
+	}
+	if _, err := p.call("nothing", nil); err == nil || err.Code != -32601 {
+		t.Errorf("unknown method answered with %v", err)
+	}
+	delete(pluginCommands, "Foo")
+}
+
+func TestPluginEvents(t *testing.T) {
+	testBuffers(t, "one", "two")
+	E.filename = "a.txt"
+	r, w := io.Pipe()
+	p := testPlugin()
+	p.out = newLspWriter(w)
+	saved := plugins
+	plugins = []*plugin{p}
+	t.Cleanup(func() {
+		plugins = saved
+		p.out.close()
+		r.Close()
+	})
+	br := bufio.NewReader(r)
+
+	// sync sends what the plugin subscribed to, and done marks the end of it
+	sync := func() []string {
+		t.Helper()
+		editorPluginSync()
+		p.notify("done", nil)
+		var events []string
+		for {
+			body, err := lspReadMessage(br)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var msg lspMessage
+			json.Unmarshal(body, &msg)
+			if msg.Method == "done" {
+				return events
+			}
+			events = append(events, string(msg.Params))
+		}
+	}
+	expect := func(what string, want ...string) {
+		t.Helper()
+		if got := sync(); !slices.Equal(got, want) {
+			t.Errorf("events after %s are %q, want %q", what, got, want)
+		}
+	}
+
+	if _, err := p.call("subscribe", json.RawMessage(`{"events":["open","change"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	expect("subscribing", `{"buffer":0,"event":"open","file":"a.txt"}`)
+	E.cy, E.cx = 1, 3
+	editorInsertChar('s')
+	expect("typing", `{"buffer":0,"event":"change","file":"a.txt","lines":2}`)
+	// the plugin's own changes are changes too
+	if _, err := p.call("setLines", json.RawMessage(`{"start":0,"end":1,"lines":["zero","half"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	expect("setLines", `{"buffer":0,"event":"change","file":"a.txt","lines":3}`)
+	expect("nothing")
+
+	// moving and changing modes aren't sent until they're subscribed to
+	E.cy = 0
+	expect("moving")
+	if _, err := p.call("subscribe", json.RawMessage(`{"events":["cursor","mode"]}`)); err != nil {
+		t.Fatal(err)
+	}
+	E.mode, E.cx = INSERT, 1
+	expect("going to INSERT mode",
+		`{"buffer":0,"col":1,"event":"cursor","line":0}`,
+		`{"buffer":0,"event":"mode","mode":"INSERT"}`)
+
+	// a list isn't text the plugin can work on, a new file is
+	editorAddBuffer()
+	E.buftype = "quickfix"
+	editorFillBuffer([]string{"a.txt|1| x"})
+	editorAddBuffer()
+	E.filename = "b.txt"
+	E.mode = INSERT
+	expect("opening b.txt",
+		`{"buffer":2,"event":"open","file":"b.txt"}`,
+		`{"buffer":2,"col":0,"event":"cursor","line":0}`)
+	editorPluginDidSave()
+	expect("saving")
+}
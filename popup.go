@@ -0,0 +1,80 @@
+package main
+
+import "unicode/utf8"
+
+// the popup is a box of text drawn over the buffer next to the cursor, like
+// hover information. the next key closes it
+var popup struct {
+	lines []string
+}
+
+// how much of the screen a popup may cover
+const (
+	POPUP_MAX_HEIGHT = 12
+	POPUP_MAX_WIDTH  = 80
+)
+
+func editorShowPopup(lines []string) {
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	popup.lines = lines
+}
+
+// editorClosePopup is run on every key and says whether there was a popup to close
+func editorClosePopup() bool {
+	open := popup.lines != nil
+	popup.lines = nil
+	return open
+}
+
+func editorDrawPopup() {
+	if popup.lines == nil {
+		return
+	}
+	x, y := editorCursorPosition()
+	editorDrawBox(x, y, popup.lines, -1)
+}
+
+// editorDrawBox draws lines in a box under screen position x, y, or over it when there's
+// no room below. line sel is drawn selected. it returns where the box went
+func editorDrawBox(x, y int, lines []string, sel int) (int, int, int, int) {
+	width := 0
+	for _, line := range lines {
+		width = max(width, utf8.RuneCountInString(line))
+	}
+	width = min(width+2, POPUP_MAX_WIDTH, E.raw_screencols)
+	height := min(len(lines), POPUP_MAX_HEIGHT)
+
+	top := y + 1
+	if top+height > E.screenrows && y-height >= 0 {
+		top = y - height
+	}
+	height = min(height, E.screenrows-top)
+	left := max(min(x, E.raw_screencols-width), 0)
+	editorDrawBoxAt(left, top, width, height, lines, sel)
+	return left, top, width, height
+}
+
+// editorDrawBoxAt fills the box and draws as many of lines as fit, scrolled so sel shows
+func editorDrawBoxAt(left, top, width, height int, lines []string, sel int) {
+	off := 0
+	if sel >= height {
+		off = sel - height + 1
+	}
+	st := hlStyle(HG_POPUP)
+	for i := 0; i < height; i++ {
+		screenFill(left, top+i, width, st)
+		if off+i >= len(lines) {
+			continue
+		}
+		line := []rune(lines[off+i])
+		if len(line) > width-2 {
+			line = line[:max(width-2, 0)]
+		}
+		screenPutString(left+1, top+i, string(line), st)
+		if off+i == sel {
+			screenTint(left, top+i, width, editorTint(HG_SELECTION))
+		}
+	}
+}
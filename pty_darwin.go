@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyOpen opens a new pseudo terminal, returning its master side and the path of the slave
+func ptyOpen() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	fd := master.Fd()
+	name := make([]byte, 128)
+	for _, req := range []struct {
+		op  uintptr
+		arg uintptr
+	}{
+		{unix.TIOCPTYGRANT, 0},
+		{unix.TIOCPTYUNLK, 0},
+		{unix.TIOCPTYGNAME, uintptr(unsafe.Pointer(&name[0]))},
+	} {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, req.op, req.arg); errno != 0 {
+			master.Close()
+			return nil, "", errno
+		}
+	}
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return master, string(name), nil
+}
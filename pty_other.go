@@ -0,0 +1,17 @@
+//go:build !linux && !darwin
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+func ptyStart(cmd *exec.Cmd, cols, rows int) (*os.File, error) {
+	return nil, errors.New("terminals aren't supported on this system")
+}
+
+func ptyResize(master *os.File, cols, rows int) error {
+	return nil
+}
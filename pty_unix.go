@@ -0,0 +1,40 @@
+//go:build linux || darwin
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyStart runs cmd on a new pseudo terminal of cols by rows, in a session of
+// its own with the terminal as its controlling one, and returns the master side
+func ptyStart(cmd *exec.Cmd, cols, rows int) (*os.File, error) {
+	master, name, err := ptyOpen()
+	if err != nil {
+		return nil, err
+	}
+	slave, err := os.OpenFile(name, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	defer slave.Close()
+	ptyResize(master, cols, rows)
+
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, err
+	}
+	return master, nil
+}
+
+// ptyResize tells the program on the terminal its new size, it gets a SIGWINCH
+func ptyResize(master *os.File, cols, rows int) error {
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, &unix.Winsize{Row: uint16(rows), Col: uint16(cols)})
+}
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// one line of the quickfix list. lines of output that don't match the error
+// format are kept for context but can't be jumped to
+type qfEntry struct {
+	filename  string
+	line, col int // 1 based, 0 when the line didn't say
+	text      string
+	valid     bool
+}
+
+// the quickfix list is what :make and :grep found. :cnext and :cprev go
+// through it and :copen shows it in a window at the bottom
+var quickfix struct {
+	title   string
+	entries []qfEntry
+	idx     int
+}
+
+var (
+	MAKEPRG     = "make"
+	GREPPRG     = "internal" // the grep built in, or a command like grep -rn
+	ERRORFORMAT = "%f:%l:%c: %m,%f:%l:%c:%m,%f:%l: %m,%f:%l:%m"
+	GREPFORMAT  = "%f:%l:%m"
+
+	QUICKFIX_HEIGHT  = 10
+	GREP_MAX_MATCHES = 10000
+)
+
+// an errorformat pattern as a regexp, with the submatch numbers of its parts, 0 for those it doesn't have
+type efmPattern struct {
+	re                   *regexp.Regexp
+	file, line, col, msg int
+}
+
+// editorParseErrorformat compiles an errorformat: comma separated patterns
+// where %f is a file name, %l a line, %c a column, %m the message and %% a %.
+// \, is a comma in a pattern
+func editorParseErrorformat(efm string) ([]efmPattern, error) {
+	var parts []string
+	var part strings.Builder
+	for i := 0; i < len(efm); i++ {
+		switch {
+		case efm[i] == '\\' && i+1 < len(efm) && efm[i+1] == ',':
+			part.WriteByte(',')
+			i++
+		case efm[i] == ',':
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(efm[i])
+		}
+	}
+	parts = append(parts, part.String())
+
+	var pats []efmPattern
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+		var p efmPattern
+		var expr strings.Builder
+		expr.WriteString("^")
+		group := 0
+		for i := 0; i < len(part); i++ {
+			if part[i] != '%' || i+1 == len(part) {
+				expr.WriteString(regexp.QuoteMeta(part[i : i+1]))
+				continue
+			}
+			i++
+			switch part[i] {
+			case 'f':
+				group++
+				p.file = group
+				expr.WriteString(`(.+?)`)
+			case 'l':
+				group++
+				p.line = group
+				expr.WriteString(`(\d+)`)
+			case 'c':
+				group++
+				p.col = group
+				expr.WriteString(`(\d+)`)
+			case 'm':
+				group++
+				p.msg = group
+				expr.WriteString(`(.*)`)
+			case '%':
+				expr.WriteString("%")
+			default:
+				return nil, fmt.Errorf("unknown %%%c", part[i])
+			}
+		}
+		expr.WriteString("$")
+		var err error
+		if p.re, err = regexp.Compile(expr.String()); err != nil {
+			return nil, err
+		}
+		pats = append(pats, p)
+	}
+	return pats, nil
+}
+
+// editorParseErrors matches each line of output with the first pattern that fits
+func editorParseErrors(lines []string, pats []efmPattern) []qfEntry {
+	entries := make([]qfEntry, 0, len(lines))
+	for _, line := range lines {
+		e := qfEntry{text: line}
+		for _, p := range pats {
+			m := p.re.FindStringSubmatch(line)
+			if m == nil || p.file == 0 || p.line == 0 {
+				continue
+			}
+			e.filename = m[p.file]
+			e.line, _ = strconv.Atoi(m[p.line])
+			if p.col > 0 {
+				e.col, _ = strconv.Atoi(m[p.col])
+			}
+			e.text = ""
+			if p.msg > 0 {
+				e.text = m[p.msg]
+			}
+			e.valid = true
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func qfLine(e qfEntry) string {
+	if !e.valid {
+		return "|| " + e.text
+	}
+	pos := strconv.Itoa(e.line)
+	if e.col > 0 {
+		pos += " col " + strconv.Itoa(e.col)
+	}
+	return e.filename + "|" + pos + "| " + e.text
+}
+
+// editorQuickfixBuffer is the buffer that shows the list, -1 before :copen made it
+func editorQuickfixBuffer() int {
+	for i := range buffers {
+		if editorBufType(i) == "quickfix" {
+			return i
+		}
+	}
+	return -1
+}
+
+func editorSetQuickfix(title string, entries []qfEntry) {
+	quickfix.title = title
+	quickfix.entries = entries
+	quickfix.idx = 0
+	editorFillQuickfixBuffer()
+}
+
+// editorFillQuickfixBuffer puts the list in its buffer, when there's one
+func editorFillQuickfixBuffer() {
+	i := editorQuickfixBuffer()
+	if i < 0 {
+		return
+	}
+	lines := make([]string, len(quickfix.entries))
+	for y, e := range quickfix.entries {
+		lines[y] = qfLine(e)
+	}
+	editorInBuffer(i, func() {
+		editorFillBuffer(lines)
+	})
+	editorSelectQuickfix(quickfix.idx)
+}
+
+// editorSelectQuickfix puts the cursor of the list's windows on entry idx
+func editorSelectQuickfix(idx int) {
+	i := editorQuickfixBuffer()
+	if i < 0 {
+		return
+	}
+	editorInBuffer(i, func() {
+		E.cy, E.cx = idx, 0
+	})
+	for _, w := range editorWindows() {
+		if w.buf == i {
+			w.cy, w.cx = idx, 0
+		}
+	}
+}
+
+// editorQuickfixOpen is :copen, it shows the list in a window across the bottom and goes there
+func editorQuickfixOpen() {
+	i := editorQuickfixBuffer()
+	if i < 0 {
+		cur := curbuf
+		editorAddBuffer()
+		E.buftype = "quickfix"
+		E.readonly = true
+		i = curbuf
+		editorSwitchBuffer(cur)
+		editorFillQuickfixBuffer()
+	}
+	for _, w := range editorWindows() {
+		if w.buf == i {
+			editorFocusWindow(w)
+			return
+		}
+	}
+	w := &window{buf: i, cy: quickfix.idx, height: QUICKFIX_HEIGHT}
+	editorAddBottomWindow(w)
+	editorFocusWindow(w)
+}
+
+// editorQuickfixClose is :cclose
+func editorQuickfixClose() {
+	i := editorQuickfixBuffer()
+	for _, w := range editorWindows() {
+		if w.buf == i && len(editorWindows()) > 1 {
+			editorCloseWindow(w)
+		}
+	}
+}
+
+// editorQuickfixJump opens the file of entry i at its position. from the
+// list's own window it opens in the window used before, or another one
+func editorQuickfixJump(i int) {
+	if i < 0 || i >= len(quickfix.entries) || !quickfix.entries[i].valid {
+		return
+	}
+	quickfix.idx = i
+	editorSelectQuickfix(i)
+	e := quickfix.entries[i]
+
+	if E.buftype != "" {
+		var target *window
+		if prevwin != nil && editorFindLayout(root, prevwin) != nil && editorBufType(prevwin.buf) == "" {
+			target = prevwin
+		}
+		for _, w := range editorWindows() {
+			if target == nil && editorBufType(w.buf) == "" {
+				target = w
+			}
+		}
+		if target != nil {
+			editorFocusWindow(target)
+		} else if editorSplit(false) == nil {
+			return
+		}
+	}
+
+	editorEdit(e.filename)
+	E.cy = min(max(e.line-1, 0), max(E.numrows-1, 0))
+	E.cx = editorFirstNonBlank(E.cy)
+	if e.col > 0 && E.cy < E.numrows {
+		E.cx = min(e.col-1, E.row[E.cy].size)
+	}
+	editorSetStatusMessage("(%d of %d): %s", i+1, len(quickfix.entries), e.text)
+}
+
+// editorQuickfixNext goes to the next entry that can be jumped to in direction dir
+func editorQuickfixNext(dir int) {
+	if len(quickfix.entries) == 0 {
+		editorSetStatusMessage("no errors")
+		return
+	}
+	for i := quickfix.idx + dir; i >= 0 && i < len(quickfix.entries); i += dir {
+		if quickfix.entries[i].valid {
+			editorQuickfixJump(i)
+			return
+		}
+	}
+	editorSetStatusMessage("no more items")
+}
+
+// editorQuickfixFirst jumps to the first entry from i on that can be jumped to, after :make or :cc
+func editorQuickfixFirst(i int) bool {
+	for ; i >= 0 && i < len(quickfix.entries); i++ {
+		if quickfix.entries[i].valid {
+			editorQuickfixJump(i)
+			return true
+		}
+	}
+	return false
+}
+
+// editorQuickfixCommand handles :cc [N], :cfirst and :clast
+func editorQuickfixCommand(name, args string) {
+	if len(quickfix.entries) == 0 {
+		editorSetStatusMessage("no errors")
+		return
+	}
+	switch name {
+	case "cc":
+		i := quickfix.idx
+		if args != "" {
+			n, err := strconv.Atoi(args)
+			if err != nil || n < 1 {
+				editorSetStatusMessage("invalid entry: %s", args)
+				return
+			}
+			i = min(n, len(quickfix.entries)) - 1
+		}
+		if !editorQuickfixFirst(i) {
+			editorSetStatusMessage("no more items")
+		}
+	case "cfirst", "cfir":
+		editorQuickfixFirst(0)
+	case "clast", "cla":
+		for i := len(quickfix.entries) - 1; i >= 0; i-- {
+			if quickfix.entries[i].valid {
+				editorQuickfixJump(i)
+				return
+			}
+		}
+	}
+}
+
+// editorBracketCommand is ] or [ followed by key: ]q and [q go through the
+// quickfix list, ]c and [c through the changes against git HEAD, or the
+// differences with the other buffer in diff mode
+func editorBracketCommand(c, key int) {
+	dir := 1
+	if c == '[' {
+		dir = -1
+	}
+	switch key {
+	case 'q':
+		editorQuickfixNext(dir)
+	case 'c':
+		if E.diff != nil {
+			editorJumpHunk(editorDiffHunks(), dir)
+		} else {
+			editorJumpHunk(editorGitHunks(), dir)
+		}
+	}
+}
+
+// editorQuickfixEnter is Enter in the list's window, it jumps to the entry under the cursor
+func editorQuickfixEnter() {
+	if !editorQuickfixFirst(E.cy) {
+		editorSetStatusMessage("no file on this line")
+	}
+}
+
+// editorMake is :make [args], it runs makeprg and lists what it complained about
+func editorMake(args string) {
+	pats, err := editorParseErrorformat(ERRORFORMAT)
+	if err != nil {
+		editorSetStatusMessage("errorformat: %s", err)
+		return
+	}
+	command := MAKEPRG
+	if args != "" {
+		command += " " + args
+	}
+	command = editorExpandFilename(command)
+	stdout, stderr, err := editorShellRun(editorShellCommand(command), "")
+	if stdout == nil && stderr == nil && err != nil {
+		editorSetStatusMessage("%s: %s", command, err)
+		return
+	}
+
+	entries := editorParseErrors(editorOutputLines(append(stdout, stderr...)), pats)
+	editorSetQuickfix(":"+command, entries)
+	if editorQuickfixFirst(0) {
+		return
+	}
+	if err != nil {
+		editorSetStatusMessage("%s: shell returned: %s", command, err)
+		return
+	}
+	editorSetStatusMessage("%s: no errors", command)
+}
+
+// editorGrep is :grep pattern [paths], with the built in grep or grepprg
+func editorGrep(args string) {
+	words := editorSplitArgs(args)
+	if len(words) == 0 {
+		editorSetStatusMessage("no pattern")
+		return
+	}
+
+	var entries []qfEntry
+	if GREPPRG == "internal" {
+		var err error
+		if entries, err = editorInternalGrep(words[0], words[1:]); err != nil {
+			editorSetStatusMessage("grep: %s", err)
+			return
+		}
+	} else {
+		pats, err := editorParseErrorformat(GREPFORMAT)
+		if err != nil {
+			editorSetStatusMessage("grepformat: %s", err)
+			return
+		}
+		command := GREPPRG + " " + args
+		if strings.Contains(GREPPRG, "$*") {
+			command = strings.ReplaceAll(GREPPRG, "$*", args)
+		}
+		stdout, stderr, err := editorShellRun(editorShellCommand(command), "")
+		// grep exits with 1 when nothing matched, which is fine
+		if len(stdout) == 0 && err != nil && len(stderr) > 0 {
+			editorSetStatusMessage("%s: %s", command, editorShellError(err, stderr))
+			return
+		}
+		entries = editorParseErrors(editorOutputLines(stdout), pats)
+	}
+
+	editorSetQuickfix(":grep "+args, entries)
+	if !editorQuickfixFirst(0) {
+		editorSetStatusMessage("no matches: %s", words[0])
+		return
+	}
+	if len(entries) >= GREP_MAX_MATCHES {
+		editorSetStatusMessage("%s (stopped at %d matches)", E.statusmsg, GREP_MAX_MATCHES)
+	}
+}
+
+// editorInternalGrep searches the files under paths, the working directory
+// when there are none, for the regexp pattern. the files are read by a pool of
+// workers while the tree is still being walked
+func editorInternalGrep(pattern string, paths []string) ([]qfEntry, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+
+	files := make(chan string, 256)
+	stop := make(chan struct{})
+	var once sync.Once
+	halt := func() { once.Do(func() { close(stop) }) }
+
+	go func() {
+		defer close(files)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !info.IsDir() {
+				// a file that was asked for is searched even when it's ignored
+				select {
+				case files <- path:
+				case <-stop:
+				}
+				continue
+			}
+			found := make(chan string, 256)
+			walkFiles(path, found, stop)
+			for f := range found {
+				select {
+				case files <- f:
+				case <-stop:
+				}
+			}
+		}
+	}()
+
+	var mu sync.Mutex
+	var results []qfEntry
+	var wg sync.WaitGroup
+	for n := 0; n < runtime.NumCPU(); n++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range files {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				found := grepFile(re, name)
+				if len(found) == 0 {
+					continue
+				}
+				mu.Lock()
+				results = append(results, found...)
+				full := len(results) >= GREP_MAX_MATCHES
+				mu.Unlock()
+				if full {
+					halt()
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	editorWaitFor("grep "+pattern, done, halt)
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].filename != results[b].filename {
+			return results[a].filename < results[b].filename
+		}
+		return results[a].line < results[b].line
+	})
+	if len(results) > GREP_MAX_MATCHES {
+		results = results[:GREP_MAX_MATCHES]
+	}
+	return results, nil
+}
+
+// grepFile returns the lines of a file that match re. binary files are skipped
+func grepFile(re *regexp.Regexp, name string) []qfEntry {
+	data, err := os.ReadFile(name)
+	if err != nil || editorIsBinary(data[:min(len(data), 64*1024)]) || !re.Match(data) {
+		return nil
+	}
+	var found []qfEntry
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if loc := re.FindStringIndex(line); loc != nil {
+			found = append(found, qfEntry{filename: name, line: n + 1, col: loc[0] + 1, text: line, valid: true})
+		}
+	}
+	return found
+}
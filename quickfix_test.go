@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseErrors(t *testing.T) {
+	pats, err := editorParseErrorformat(ERRORFORMAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := editorParseErrors([]string{
+		"# example.com/pkg",
+		"main.go:12:5: undefined: x",
+		"main.go:3: too many arguments",
+		"lib/a.go:7:1:no space",
+		`C:\src\b.go:1:2: a drive letter`,
+		"main.go:x: not a line",
+	}, pats)
+	want := []qfEntry{
+		{text: "# example.com/pkg"},
+		{"main.go", 12, 5, "undefined: x", true},
+		{"main.go", 3, 0, "too many arguments", true},
+		{"lib/a.go", 7, 1, "no space", true},
+		{`C:\src\b.go`, 1, 2, "a drive letter", true},
+		{text: "main.go:x: not a line"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries are %+v, want %+v", got, want)
+	}
+
+	// \, is a comma in a pattern and %% a %, and the first pattern that fits wins
+	pats, err = editorParseErrorformat(`%f(%l\,%c): %m,100%%: %f:%l,%f:%l:%m`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = editorParseErrors([]string{"a.cs(3,4): error CS1002", "100%: b.go:7", "c.go:9:x"}, pats)
+	want = []qfEntry{
+		{"a.cs", 3, 4, "error CS1002", true},
+		{"b.go", 7, 0, "", true},
+		{"c.go", 9, 0, "x", true},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("entries are %+v, want %+v", got, want)
+	}
+
+	if _, err := editorParseErrorformat("%f:%q"); err == nil {
+		t.Error("an unknown % item wasn't an error")
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	LIST      = false
+	LISTCHARS = "tab:» ,trail:·,nbsp:␣,eol:$"
+
+	listTab   = [2]string{"»", " "}
+	listTrail = "·"
+	listNbsp  = "␣"
+	listEol   = "$"
+)
+
+// what each byte of row.render came from, for drawing it differently
+const (
+	RK_TEXT    = iota
+	RK_TAB     // first column of a tab
+	RK_TABFILL // the rest of the tab
+	RK_SPECIAL // ^X for a control character, <u+xxxx> for another one that can't be printed or <xx> for a byte that isn't utf-8
+	RK_NBSP    // the first byte of a no-break space, the second one is RK_TEXT
+)
+
+// editorCharWidth says how many screen columns the character at the start of b
+// takes when it starts at column col, and how many bytes of b it is. in
+// row.render it takes up the larger of the two
+func editorCharWidth(b []byte, col int) (int, int) {
+	c := b[0]
+	switch {
+	case c == '\t':
+		return TAB_STOP - col%TAB_STOP, 1
+	case c < 0x20 || c == 0x7f:
+		return 2, 1
+	case c < 0x80:
+		return 1, 1
+	}
+	r, size := utf8.DecodeRune(b)
+	if r == utf8.RuneError && size == 1 {
+		return 4, 1
+	}
+	if !runePrintable(r) {
+		return len(editorSpecialRune(r)), size
+	}
+	// other characters are kept as they are, taking as many columns as the terminal gives them
+	return runeWidth(r), size
+}
+
+// editorSpecialRune is how a character that can't be sent to the terminal is shown
+func editorSpecialRune(r rune) string {
+	return fmt.Sprintf("<u+%04x>", r)
+}
+
+// editorRenderCol is the screen column render index rx of row is drawn at,
+// counting from the start of the row. past the end every byte is a column
+func editorRenderCol(row *erow, rx int) int {
+	col, i := 0, 0
+	for i < min(rx, row.rsize) {
+		r, n := utf8.DecodeRune(row.render[i:row.rsize])
+		if i+n > rx {
+			break
+		}
+		col += runeWidth(r)
+		i += n
+	}
+	if rx > row.rsize {
+		col += rx - row.rsize
+	}
+	return col
+}
+
+// editorRenderIndex is the index into render of the first character of row
+// that isn't all before screen column col. a wide character col is in the
+// middle of is where col is
+func editorRenderIndex(row *erow, col int) int {
+	if col <= 0 {
+		return 0
+	}
+	i, c := 0, 0
+	for i < row.rsize {
+		r, n := utf8.DecodeRune(row.render[i:row.rsize])
+		w := runeWidth(r)
+		if c+w > col {
+			return i
+		}
+		c += w
+		i += n
+	}
+	return i + col - c
+}
+
+// editorRenderChar appends how the character at the start of b, starting at
+// screen column col, is shown to row.render. it returns its width and its bytes
+func editorRenderChar(row *erow, b []byte, col int) (int, int) {
+	w, n := editorCharWidth(b, col)
+	c := b[0]
+	r, _ := utf8.DecodeRune(b[:n])
+	switch {
+	case c == '\t':
+		row.render = append(row.render, strings.Repeat(" ", w)...)
+		row.kind = append(row.kind, RK_TAB)
+		for i := 1; i < w; i++ {
+			row.kind = append(row.kind, RK_TABFILL)
+		}
+	case c < 0x20 || c == 0x7f:
+		row.render = append(row.render, '^', c^0x40)
+		row.kind = append(row.kind, RK_SPECIAL, RK_SPECIAL)
+	case r == utf8.RuneError && n == 1:
+		row.render = append(row.render, fmt.Sprintf("<%02x>", c)...)
+		row.kind = append(row.kind, RK_SPECIAL, RK_SPECIAL, RK_SPECIAL, RK_SPECIAL)
+	case !runePrintable(r):
+		row.render = append(row.render, editorSpecialRune(r)...)
+		for i := 0; i < w; i++ {
+			row.kind = append(row.kind, RK_SPECIAL)
+		}
+	default:
+		row.render = append(row.render, b[:n]...)
+		k := byte(RK_TEXT)
+		if n == 2 && c == 0xc2 && b[1] == 0xa0 {
+			k = RK_NBSP
+		}
+		row.kind = append(row.kind, k)
+		for i := 1; i < n; i++ {
+			row.kind = append(row.kind, RK_TEXT)
+		}
+	}
+	return w, n
+}
+
+// editorParseListchars takes a listchars value like "tab:>-,trail:-,eol:$"
+func editorParseListchars(value string) error {
+	tab, trail, nbsp, eol := [2]string{" ", " "}, "", "", ""
+	for _, item := range strings.Split(value, ",") {
+		name, glyph, ok := strings.Cut(item, ":")
+		if !ok {
+			return fmt.Errorf("bad listchars item %q", item)
+		}
+		switch name {
+		case "tab":
+			r1, n := utf8.DecodeRuneInString(glyph)
+			if glyph == "" || utf8.RuneCountInString(glyph) != 2 || r1 == utf8.RuneError {
+				return fmt.Errorf("tab needs two characters")
+			}
+			tab = [2]string{glyph[:n], glyph[n:]}
+		case "trail":
+			trail = glyph
+		case "nbsp":
+			nbsp = glyph
+		case "eol":
+			eol = glyph
+		default:
+			return fmt.Errorf("unknown listchars item %q", name)
+		}
+		if name != "tab" && utf8.RuneCountInString(glyph) != 1 {
+			return fmt.Errorf("%s needs one character", name)
+		}
+	}
+	listTab, listTrail, listNbsp, listEol = tab, trail, nbsp, eol
+	LISTCHARS = value
+	return nil
+}
+
+// editorListGlyph returns what list mode shows instead of render[i:i+n], or n == 0 for the text itself
+func editorListGlyph(row *erow, i int) (string, int) {
+	switch row.kind[i] {
+	case RK_TAB:
+		return listTab[0], 1
+	case RK_TABFILL:
+		return listTab[1], 1
+	case RK_NBSP:
+		if listNbsp != "" {
+			return listNbsp, 2
+		}
+	}
+	if i >= row.trail && row.render[i] == ' ' && listTrail != "" {
+		return listTrail, 1
+	}
+	return "", 0
+}
+
+// editorTrailing tells whether render column i is trailing whitespace that should stand out.
+// not on the line being typed on, where it's usually just the space before the next word
+func editorTrailing(row *erow, i int) bool {
+	return i >= row.trail && !(E.mode == INSERT && row.idx == E.cy)
+}
+
+func editorRenderGroup(row *erow, i int) hlGroup {
+	switch {
+	case editorSelectedRx(row, i):
+		return HG_SELECTION
+	case row.kind[i] == RK_SPECIAL:
+		return HG_SPECIAL
+	case editorTrailing(row, i):
+		return HG_TRAILING
+	case LIST && (row.kind[i] == RK_TAB || row.kind[i] == RK_TABFILL || row.kind[i] == RK_NBSP):
+		return HG_WHITESPACE
+	}
+	return editorSyntaxToGroup(row.hl[i])
+}
+
+// editorDrawRender draws render[from:to] of row in runs of the same highlight,
+// with the list mode glyphs drawn on their own
+func editorDrawRender(x, y int, row *erow, from, to int) {
+	for i := from; i < to; {
+		g := editorRenderGroup(row, i)
+		if LIST {
+			if glyph, n := editorListGlyph(row, i); n > 0 {
+				x = screenPutString(x, y, glyph, hlStyle(g))
+				i += n
+				continue
+			}
+		}
+		j := i + 1
+		for j < to && editorRenderGroup(row, j) == g {
+			if LIST {
+				if _, n := editorListGlyph(row, j); n > 0 {
+					break
+				}
+			}
+			j++
+		}
+		x = screenPut(x, y, row.render[i:j], hlStyle(g))
+		i = j
+	}
+
+	if LIST && listEol != "" && from <= to && to == row.rsize {
+		screenPutString(x, y, listEol, hlStyle(HG_WHITESPACE))
+	}
+}
+
+var (
+	CURSORLINE   = false
+	CURSORCOLUMN = false
+	COLORCOLUMN  []int // 1-based render columns
+)
+
+func editorParseColorcolumn(value string) ([]int, error) {
+	var cols []int
+	for _, f := range strings.Split(value, ",") {
+		if f == "" {
+			continue
+		}
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("bad column %q", f)
+		}
+		cols = append(cols, n)
+	}
+	return cols, nil
+}
+
+// editorTint lays group g over a cell. the background only replaces the normal
+// one, so search matches and the selection still show through
+func editorTint(g hlGroup) func(style) style {
+	layer := hlStyle(g)
+	normal := hlStyle(HG_NORMAL)
+	return func(st style) style {
+		if themeSpec[g].bg != 0 && st.bg == normal.bg {
+			st.bg = layer.bg
+		}
+		if themeSpec[g].fg != 0 && st.fg == normal.fg {
+			st.fg = layer.fg
+		}
+		st.attr |= themeSpec[g].attr
+		return st
+	}
+}
+
+// editorDrawLayers tints screen line y of filerow, which shows the render from column from on
+func editorDrawLayers(y, filerow, from int) {
+	editorDrawDiff(y, filerow, from)
+	// from is the screen column the drawn part of the row starts at. the
+	// guide columns go first so the cursor line doesn't break them
+	for _, c := range COLORCOLUMN {
+		if x := c - 1 - from; x >= 0 && x < E.screencols {
+			screenTint(E.linenum_indent+x, y, 1, editorTint(HG_COLORCOLUMN))
+		}
+	}
+	if CURSORLINE && filerow == E.cy {
+		screenTint(E.linenum_indent, y, E.screencols, editorTint(HG_CURSORLINE))
+	}
+	if CURSORCOLUMN {
+		x, _ := editorCursorPosition()
+		screenTint(x, y, 1, editorTint(HG_CURSORCOLUMN))
+	}
+}
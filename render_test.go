@@ -0,0 +1,195 @@
+package main
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMultiByteColumns(t *testing.T) {
+	testBuffers(t, "héllo\twörld")
+	row := &E.row[0]
+	// é is two bytes but one column, so the tab still goes up to column 8
+	if got := string(row.render); got != "héllo   wörld" {
+		t.Errorf("render is %q", got)
+	}
+	for _, tc := range []struct{ cx, rx, col int }{
+		{0, 0, 0},
+		{1, 1, 1},
+		{3, 3, 2},
+		{6, 6, 5},
+		{7, 9, 8},
+		{8, 10, 9},
+		{10, 12, 10},
+		{13, 15, 13},
+	} {
+		rx := editorRowCxToRx(row, tc.cx)
+		if rx != tc.rx {
+			t.Errorf("cx %d is rx %d, want %d", tc.cx, rx, tc.rx)
+		}
+		if col := editorRenderCol(row, rx); col != tc.col {
+			t.Errorf("rx %d is column %d, want %d", rx, col, tc.col)
+		}
+		if i := editorRenderIndex(row, tc.col); i != tc.rx {
+			t.Errorf("column %d is rx %d, want %d", tc.col, i, tc.rx)
+		}
+		if cx := editorRowRxToCx(row, tc.rx); cx != tc.cx {
+			t.Errorf("rx %d is cx %d, want %d", tc.rx, cx, tc.cx)
+		}
+	}
+
+	// a cx inside a character is drawn on it
+	if rx := editorRowCxToRx(row, 2); rx != 1 {
+		t.Errorf("cx 2, inside é, is rx %d, want 1", rx)
+	}
+
+	// the cursor is drawn where its character is
+	E.cx = 10
+	editorScroll()
+	if x, _ := editorCursorPosition(); x != E.linenum_indent+10 {
+		t.Errorf("cursor after ö is at x %d, want %d", x, E.linenum_indent+10)
+	}
+
+	// scrolling sideways counts columns
+	E.screencols = 4
+	E.cx = 13
+	editorScroll()
+	if E.coloff != 10 {
+		t.Errorf("coloff is %d, want 10", E.coloff)
+	}
+	if x, _ := editorCursorPosition(); x != E.linenum_indent+3 {
+		t.Errorf("cursor at the end is at x %d, want %d", x, E.linenum_indent+3)
+	}
+}
+
+func TestWrapMultiByte(t *testing.T) {
+	testBuffers(t, "ééééééé")
+	WRAP = true
+	defer func() { WRAP = false }()
+	E.screencols = 3
+	row := &E.row[0]
+	segs := editorRowSegments(row)
+	if len(segs) != 3 {
+		t.Fatalf("segments are %v, want 3 of them", segs)
+	}
+	for _, s := range segs {
+		if !utf8.RuneStart(row.render[s]) {
+			t.Errorf("segment at %d starts inside a character", s)
+		}
+	}
+	E.cx = 8
+	editorScroll()
+	if x, y := editorCursorPosition(); x != E.linenum_indent+1 || y != 1 {
+		t.Errorf("cursor on the fifth é is at %d,%d, want %d,1", x, y, E.linenum_indent+1)
+	}
+}
+
+func TestNonPrintingCharacters(t *testing.T) {
+	// an 8-bit CSI would start an escape sequence if it got to the terminal
+	testBuffers(t, "\xc2\x9b31m", "a\u202eb", "\ue0b0")
+	row := &E.row[0]
+	if got := string(row.render[:row.rsize]); got != "<u+009b>31m" {
+		t.Errorf("render of a C1 CSI is %q", got)
+	}
+	for i := 0; i < 8; i++ {
+		if row.kind[i] != RK_SPECIAL {
+			t.Errorf("kind of render byte %d is %d, want RK_SPECIAL", i, row.kind[i])
+		}
+	}
+	if rx := editorRowCxToRx(row, 2); rx != 8 || editorRenderCol(row, rx) != 8 || editorRowRxToCx(row, 8) != 2 {
+		t.Errorf("the 3 after it is at rx %d", rx)
+	}
+	// nor do the invisible formatting characters go through
+	if got := string(E.row[1].render); got != "a<u+202e>b" {
+		t.Errorf("render of a right-to-left override is %q", got)
+	}
+	// but the icons of patched fonts do
+	if got := string(E.row[2].render); got != "\ue0b0" {
+		t.Errorf("render of a private use character is %q", got)
+	}
+}
+
+func TestWideColumns(t *testing.T) {
+	testBuffers(t, "日本語x", "éx")
+	row := &E.row[0]
+	for _, tc := range []struct{ rx, col int }{{0, 0}, {3, 2}, {6, 4}, {9, 6}, {10, 7}} {
+		if col := editorRenderCol(row, tc.rx); col != tc.col {
+			t.Errorf("rx %d is column %d, want %d", tc.rx, col, tc.col)
+		}
+	}
+	// a column in the middle of a wide character is that character
+	for _, tc := range []struct{ col, rx int }{{0, 0}, {1, 0}, {2, 3}, {5, 6}, {6, 9}} {
+		if rx := editorRenderIndex(row, tc.col); rx != tc.rx {
+			t.Errorf("column %d is rx %d, want %d", tc.col, rx, tc.rx)
+		}
+	}
+	// a combining mark takes no column
+	if col := editorRenderCol(&E.row[1], 3); col != 1 {
+		t.Errorf("x after e and a combining acute is in column %d, want 1", col)
+	}
+
+	E.cx = 9
+	editorScroll()
+	if x, _ := editorCursorPosition(); x != E.linenum_indent+6 {
+		t.Errorf("cursor on x is at %d, want %d", x, E.linenum_indent+6)
+	}
+	// the whole of a wide character under the cursor is kept on screen
+	E.screencols = 3
+	E.cx, E.coloff = 6, 0
+	editorScroll()
+	if E.coloff != 3 {
+		t.Errorf("coloff with the cursor on 語 is %d, want 3", E.coloff)
+	}
+
+	WRAP = true
+	defer func() { WRAP = false }()
+	segs := editorRowSegments(row)
+	if len(segs) != 3 || segs[1] != 3 || segs[2] != 6 {
+		t.Errorf("segments 3 columns wide are %v, want [0 3 6]", segs)
+	}
+	E.screencols = 1
+	if segs := editorRowSegments(row); len(segs) != 4 {
+		t.Errorf("segments 1 column wide are %v, want a character each", segs)
+	}
+}
+
+func TestLayers(t *testing.T) {
+	depth, spec := COLOR_DEPTH, themeSpec
+	line, column, columns := CURSORLINE, CURSORCOLUMN, COLORCOLUMN
+	defer func() {
+		COLOR_DEPTH, themeSpec = depth, spec
+		editorApplyTheme()
+		CURSORLINE, CURSORCOLUMN, COLORCOLUMN = line, column, columns
+	}()
+	COLOR_DEPTH = COLORS_TRUE
+	var err error
+	themeSpec, err = parseTheme("normal bg=#000000\nselection bg=#000001\ncursorline bg=#000002\ncolorcolumn bg=#000003\ncursorcolumn bg=#000004")
+	if err != nil {
+		t.Fatal(err)
+	}
+	editorApplyTheme()
+	CURSORLINE, CURSORCOLUMN, COLORCOLUMN = true, true, []int{3, 6}
+
+	testBuffers(t, "abcdefgh", "abcdefgh")
+	testScreen(t, 30, 3)
+	E.screenrows = 2
+	E.mode, E.vx, E.vy, E.cx = VISUAL, 1, 0, 3
+	editorScroll()
+	editorDrawRows()
+
+	normal, selection, cursorline, colorcolumn, cursorcolumn := rgbColor(0, 0, 0), rgbColor(0, 0, 1), rgbColor(0, 0, 2), rgbColor(0, 0, 3), rgbColor(0, 0, 4)
+	// the selection is over everything, the guide columns over the cursor line
+	for y, want := range [][]color{
+		{cursorline, selection, selection, selection, cursorline, colorcolumn, cursorline},
+		{normal, normal, colorcolumn, cursorcolumn, normal, colorcolumn, normal},
+	} {
+		for x, bg := range want {
+			c := scr.back[y*scr.w+E.linenum_indent+x]
+			if c.style.bg != bg {
+				t.Errorf("background of %s in row %d is %#x, want %#x", c.ch, y, c.style.bg, bg)
+			}
+		}
+	}
+	if x, _ := editorCursorPosition(); x != E.linenum_indent+3 {
+		t.Errorf("cursor is at x %d, want %d", x, E.linenum_indent+3)
+	}
+}
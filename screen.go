@@ -0,0 +1,331 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// colors are 0 for the terminal default, or one of the flags below with the value in the low bits
+type color uint32
+
+const (
+	colorIndexed color = 1 << 24 // 0-255 palette index
+	colorRGB     color = 2 << 24 // 0xRRGGBB
+)
+
+const (
+	attrBold uint8 = 1 << iota
+	attrUnderline
+	attrReverse
+	attrItalic
+)
+
+type style struct {
+	fg, bg color
+	attr   uint8
+}
+
+// a cell is a character with any combining marks on it. the right half of a
+// wide character is a cell with no ch, it's drawn together with the left one
+type cell struct {
+	ch    string
+	style style
+}
+
+var blankCell = cell{ch: " "}
+
+// the screen is drawn into back, then screenFlush sends whatever differs
+// from front (what the terminal is showing) and swaps them
+var scr struct {
+	w, h        int
+	front, back []cell
+	cx, cy      int
+	invalid     bool
+	sync        bool // the terminal understands synchronized output (DEC mode 2026)
+
+	// the viewport the drawing functions work in, like a window. positions
+	// are relative to it and anything outside it is dropped
+	vx, vy, vw, vh int
+}
+
+func indexedColor(n int) color {
+	return colorIndexed | color(n)
+}
+
+func rgbColor(r, g, b int) color {
+	return colorRGB | color(r<<16|g<<8|b)
+}
+
+func (c color) sgr(fg bool) string {
+	switch c &^ 0xffffff {
+	case colorIndexed:
+		n := int(c & 0xff)
+		base := 30
+		if !fg {
+			base = 40
+		}
+		if n < 8 {
+			return strconv.Itoa(base + n)
+		} else if n < 16 {
+			return strconv.Itoa(base + 60 + n - 8)
+		}
+		return fmt.Sprintf("%d;5;%d", base+8, n)
+	case colorRGB:
+		base := 38
+		if !fg {
+			base = 48
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", base, (c>>16)&0xff, (c>>8)&0xff, c&0xff)
+	}
+	return ""
+}
+
+// sgr always starts from a reset, so it doesn't matter what was set before
+func (st style) sgr() string {
+	params := "0"
+	if st.attr&attrBold != 0 {
+		params += ";1"
+	}
+	if st.attr&attrItalic != 0 {
+		params += ";3"
+	}
+	if st.attr&attrUnderline != 0 {
+		params += ";4"
+	}
+	if st.attr&attrReverse != 0 {
+		params += ";7"
+	}
+	if st.fg != 0 {
+		params += ";" + st.fg.sgr(true)
+	}
+	if st.bg != 0 {
+		params += ";" + st.bg.sgr(false)
+	}
+	return "\x1b[" + params + "m"
+}
+
+func screenResize(w, h int) {
+	if w == scr.w && h == scr.h {
+		return
+	}
+	scr.w = w
+	scr.h = h
+	scr.front = make([]cell, w*h)
+	scr.back = make([]cell, w*h)
+	for i := range scr.back {
+		scr.back[i] = blankCell
+	}
+	scr.invalid = true
+	screenFullView()
+}
+
+// screenViewport makes the w by h rectangle at x, y the area that's drawn in
+func screenViewport(x, y, w, h int) {
+	scr.vx, scr.vy = x, y
+	scr.vw, scr.vh = max(min(w, scr.w-x), 0), max(min(h, scr.h-y), 0)
+}
+
+func screenFullView() {
+	screenViewport(0, 0, scr.w, scr.h)
+}
+
+// screenInvalidate forces the next flush to repaint everything, for when
+// something else has written to the terminal
+func screenInvalidate() {
+	scr.invalid = true
+}
+
+func screenClear() {
+	blank := cell{ch: " ", style: hlStyle(HG_NORMAL)}
+	for i := range scr.back {
+		scr.back[i] = blank
+	}
+}
+
+// screenSet puts c in the cell at x, y of the viewport. a wide character
+// that's partly overwritten goes away altogether, a blank stays in its place
+func screenSet(x, y int, c cell) {
+	if x < 0 || x >= scr.vw {
+		return
+	}
+	i := (scr.vy+y)*scr.w + scr.vx + x
+	if scr.back[i].ch == "" && x > 0 {
+		scr.back[i-1] = cell{ch: " ", style: scr.back[i-1].style}
+	}
+	if x+1 < scr.vw && scr.back[i+1].ch == "" {
+		scr.back[i+1] = cell{ch: " ", style: scr.back[i+1].style}
+	}
+	scr.back[i] = c
+}
+
+// screenPut draws text starting at x, y, each character in as many cells as
+// the terminal gives it, and returns the column after it. anything past the
+// right edge is dropped, and so is a wide character that doesn't fit.
+// characters that can't be printed are drawn as U+FFFD
+func screenPut(x, y int, text []byte, st style) int {
+	if y < 0 || y >= scr.vh {
+		return x
+	}
+	for len(text) > 0 && x < scr.vw {
+		r, size := utf8.DecodeRune(text)
+		ch := string(text[:size])
+		if !runePrintable(r) || r == utf8.RuneError && size == 1 {
+			r, ch = utf8.RuneError, "\ufffd"
+		}
+		text = text[size:]
+		switch w := runeWidth(r); {
+		case w == 0 && x > 0:
+			// a combining mark goes in the cell of the character before it
+			i := (scr.vy+y)*scr.w + scr.vx + x - 1
+			if scr.back[i].ch == "" && x > 1 {
+				i--
+			}
+			scr.back[i].ch += ch
+		case w == 0:
+			screenSet(x, y, cell{ch: " " + ch, style: st})
+			x++
+		case w == 2 && (x < 0 || x+1 >= scr.vw):
+			// only half of it would show
+			screenSet(x, y, cell{ch: " ", style: st})
+			screenSet(x+1, y, cell{ch: " ", style: st})
+			x += 2
+		case w == 2:
+			screenSet(x, y, cell{ch: ch, style: st})
+			screenSet(x+1, y, cell{style: st})
+			x += 2
+		default:
+			screenSet(x, y, cell{ch: ch, style: st})
+			x++
+		}
+	}
+	return x
+}
+
+func screenPutString(x, y int, text string, st style) int {
+	return screenPut(x, y, []byte(text), st)
+}
+
+// screenFill paints n blank cells in st, used for backgrounds like the status bar
+func screenFill(x, y, n int, st style) {
+	if y < 0 || y >= scr.vh {
+		return
+	}
+	for ; n > 0 && x < scr.vw; n-- {
+		screenSet(x, y, cell{ch: " ", style: st})
+		x++
+	}
+}
+
+// screenTint changes the style of n cells already drawn, for layers like the cursor line
+func screenTint(x, y, n int, tint func(style) style) {
+	if y < 0 || y >= scr.vh {
+		return
+	}
+	for ; n > 0 && x < scr.vw; n-- {
+		if x >= 0 {
+			c := &scr.back[(scr.vy+y)*scr.w+scr.vx+x]
+			c.style = tint(c.style)
+		}
+		x++
+	}
+}
+
+func screenSetCursor(x, y int) {
+	scr.cx = scr.vx + x
+	scr.cy = scr.vy + y
+}
+
+// screenFlush writes the changed cells in one go, moving the cursor only
+// when the next changed cell isn't the one right after the last one written.
+// a wide character is written when either of its halves changed, and moves
+// the terminal's cursor by two
+func screenFlush() {
+	var out bytes.Buffer
+
+	if scr.sync {
+		out.WriteString("\x1b[?2026h")
+	}
+	out.WriteString("\x1b[?25l")
+	out.WriteString("\x1b[m")
+	if scr.invalid {
+		out.WriteString("\x1b[H")
+		out.WriteString("\x1b[2J")
+		for i := range scr.front {
+			scr.front[i] = blankCell
+		}
+		scr.invalid = false
+	}
+
+	cur := style{}
+	px, py := -1, -1
+	for y := 0; y < scr.h; y++ {
+		for x := 0; x < scr.w; x++ {
+			i := y*scr.w + x
+			c := scr.back[i]
+			w := 1
+			if x+1 < scr.w && scr.back[i+1].ch == "" {
+				w = 2
+			}
+			if c.ch == "" || c == scr.front[i] && (w == 1 || scr.back[i+1] == scr.front[i+1]) {
+				continue
+			}
+			if x != px || y != py {
+				fmt.Fprintf(&out, "\x1b[%d;%dH", y+1, x+1)
+			}
+			if c.style != cur {
+				out.WriteString(c.style.sgr())
+				cur = c.style
+			}
+			out.WriteString(c.ch)
+			scr.front[i] = c
+			if w == 2 {
+				scr.front[i+1] = scr.back[i+1]
+			}
+			px, py = x+w, y
+		}
+	}
+
+	out.WriteString("\x1b[m")
+	fmt.Fprintf(&out, "\x1b[%d;%dH", scr.cy+1, scr.cx+1)
+	out.WriteString("\x1b[?25h")
+	if scr.sync {
+		out.WriteString("\x1b[?2026l")
+	}
+
+	ttyOut.Write(out.Bytes())
+}
+
+// screenDetectSync asks the terminal (DECRQM) whether it knows mode 2026.
+// terminals that don't know the query just stay quiet, so we only wait a moment.
+// keys typed in the meantime go back to the input queue
+func screenDetectSync() {
+	ttyOut.Write([]byte("\x1b[?2026$p"))
+
+	timeout := time.After(200 * time.Millisecond)
+	var got []byte
+	for {
+		select {
+		case b := <-keyChan:
+			got = append(got, b...)
+			i := bytes.Index(got, []byte("\x1b[?2026;"))
+			if i < 0 {
+				continue
+			}
+			j := bytes.Index(got[i:], []byte("$y"))
+			if j < 0 {
+				continue
+			}
+			value := string(got[i+len("\x1b[?2026;") : i+j])
+			scr.sync = value == "1" || value == "2"
+			pendingKeys = append(pendingKeys, got[:i]...)
+			pendingKeys = append(pendingKeys, got[i+j+2:]...)
+			return
+		case <-timeout:
+			pendingKeys = append(pendingKeys, got...)
+			return
+		}
+	}
+}
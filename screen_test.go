@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testScreen makes the screen w by h and blank, as if it had been flushed
+func testScreen(t *testing.T, w, h int) {
+	t.Helper()
+	scr.w, scr.h = 0, 0
+	screenResize(w, h)
+	testFlush(t)
+}
+
+// testFlush returns what screenFlush sends to the terminal
+func testFlush(t *testing.T) string {
+	t.Helper()
+	f, err := os.Create(filepath.Join(t.TempDir(), "tty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out := ttyOut
+	ttyOut = f
+	defer func() { ttyOut = out }()
+	screenFlush()
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// testTerminal makes the terminal w by h, with what's drawn on it going
+// nowhere, for what refreshes the screen while it waits for keys
+func testTerminal(t *testing.T, w, h int) {
+	t.Helper()
+	width, height, out := termWidth, termHeight, ttyOut
+	t.Cleanup(func() { termWidth, termHeight, ttyOut = width, height, out })
+	termWidth, termHeight = w, h
+	f, err := os.Create(filepath.Join(t.TempDir(), "tty"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	ttyOut = f
+	testScreen(t, w, h)
+}
+
+// testCells is what the cells of row y hold
+func testCells(y int) []string {
+	var cells []string
+	for x := 0; x < scr.w; x++ {
+		cells = append(cells, scr.back[y*scr.w+x].ch)
+	}
+	return cells
+}
+
+func TestScreenPutWide(t *testing.T) {
+	testScreen(t, 6, 1)
+	if x := screenPutString(0, 0, "a日b", style{}); x != 4 {
+		t.Errorf("a日b ends at %d, want 4", x)
+	}
+	want := []string{"a", "日", "", "b", " ", " "}
+	if got := testCells(0); !slices.Equal(got, want) {
+		t.Errorf("cells are %q, want %q", got, want)
+	}
+	// drawing over half of it takes all of it away
+	screenPutString(2, 0, "c", style{})
+	want = []string{"a", " ", "c", "b", " ", " "}
+	if got := testCells(0); !slices.Equal(got, want) {
+		t.Errorf("cells are %q after drawing over its right half, want %q", got, want)
+	}
+	// one that doesn't fit at the right edge isn't drawn, nor one cut by the left edge
+	screenPutString(5, 0, "語", style{})
+	screenPutString(-1, 0, "語", style{})
+	want = []string{" ", " ", "c", "b", " ", " "}
+	if got := testCells(0); !slices.Equal(got, want) {
+		t.Errorf("cells are %q after drawing 語 at both edges, want %q", got, want)
+	}
+	// combining marks go on the character before them, and control characters never get through
+	screenPutString(0, 0, "é\u009b[", style{})
+	want = []string{"é", "�", "[", "b", " ", " "}
+	if got := testCells(0); !slices.Equal(got, want) {
+		t.Errorf("cells are %q, want %q", got, want)
+	}
+}
+
+func TestScreenFlushWide(t *testing.T) {
+	testScreen(t, 6, 1)
+	screenPutString(0, 0, "日本x", style{})
+	out := testFlush(t)
+	// the terminal's cursor moves two columns for each, so x follows without a move
+	if !strings.Contains(out, "日本x") {
+		t.Errorf("flush sent %q, want 日本x in one go", out)
+	}
+	screenPutString(4, 0, "y", style{})
+	if out := testFlush(t); !strings.Contains(out, "\x1b[1;5Hy") || strings.Contains(out, "日") {
+		t.Errorf("flush of the change after them sent %q", out)
+	}
+	// a changed right half writes the whole character again
+	screenTint(1, 0, 1, func(st style) style { st.attr = attrBold; return st })
+	if out := testFlush(t); !strings.Contains(out, "\x1b[1;1H日") {
+		t.Errorf("flush of a tinted right half sent %q", out)
+	}
+}
+
+func TestScreenFlushChanges(t *testing.T) {
+	testScreen(t, 10, 3)
+	sync := scr.sync
+	defer func() { scr.sync = sync }()
+	scr.sync = false
+
+	// only what changed since the last flush is sent, in one run where it's next to each other
+	screenPutString(2, 1, "ab", style{})
+	screenPutString(5, 2, "c", style{fg: indexedColor(1)})
+	screenSetCursor(3, 0)
+	out := testFlush(t)
+	want := "\x1b[?25l\x1b[m\x1b[2;3Hab\x1b[3;6H\x1b[0;31mc\x1b[m\x1b[1;4H\x1b[?25h"
+	if out != want {
+		t.Errorf("flush sent %q, want %q", out, want)
+	}
+	// drawing the same again sends nothing but the cursor
+	screenPutString(2, 1, "ab", style{})
+	if out := testFlush(t); strings.Contains(out, "ab") || strings.Contains(out, "\x1b[2;3H") {
+		t.Errorf("flush of an unchanged screen sent %q", out)
+	}
+
+	// with synchronized output the terminal shows it all at once
+	scr.sync = true
+	screenPutString(0, 0, "x", style{})
+	out = testFlush(t)
+	if !strings.HasPrefix(out, "\x1b[?2026h") || !strings.HasSuffix(out, "\x1b[?2026l") {
+		t.Errorf("flush with sync sent %q", out)
+	}
+
+	// after something else wrote to the terminal everything is sent again
+	screenInvalidate()
+	out = testFlush(t)
+	if !strings.Contains(out, "\x1b[2J") || !strings.Contains(out, "ab") || !strings.Contains(out, "x") {
+		t.Errorf("flush after invalidating sent %q", out)
+	}
+}
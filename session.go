@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// version 2 added the windows of the tab pages and the register
+const SESSION_VERSION = 2
+
+var (
+	cmdHistory    []string
+	searchHistory []string
+	HISTORY_SIZE  = 100
+)
+
+type sessionBuffer struct {
+	File   string `json:"file"`
+	Cx     int    `json:"cx"`
+	Cy     int    `json:"cy"`
+	Rowoff int    `json:"rowoff"`
+	Coloff int    `json:"coloff"`
+}
+
+// a window of a session. buffer is an index into the session's buffers
+type sessionWindow struct {
+	Buffer int  `json:"buffer"`
+	Cx     int  `json:"cx"`
+	Cy     int  `json:"cy"`
+	Rowoff int  `json:"rowoff"`
+	Coloff int  `json:"coloff"`
+	Diff   bool `json:"diff,omitempty"`
+}
+
+// the layout tree of a tab page, a node is either a window or rows or columns of nodes
+type sessionLayout struct {
+	Window   *sessionWindow  `json:"window,omitempty"`
+	Vertical bool            `json:"vertical,omitempty"`
+	Children []sessionLayout `json:"children,omitempty"`
+}
+
+type sessionTab struct {
+	Layout  sessionLayout `json:"layout"`
+	Current int           `json:"current"` // the current window, counted from the top left
+}
+
+type sessionRegister struct {
+	Lines    []string `json:"lines"`
+	Linewise bool     `json:"linewise"`
+}
+
+type session struct {
+	Version       int              `json:"version"`
+	Cwd           string           `json:"cwd"`
+	Buffers       []sessionBuffer  `json:"buffers"`
+	Current       int              `json:"current"`
+	Tabs          []sessionTab     `json:"tabs,omitempty"`
+	CurrentTab    int              `json:"current_tab"`
+	Register      *sessionRegister `json:"register,omitempty"`
+	CmdHistory    []string         `json:"cmd_history"`
+	SearchHistory []string         `json:"search_history"`
+}
+
+func editorAddHistory(history *[]string, entry string) {
+	h := *history
+	if len(h) > 0 && h[len(h)-1] == entry {
+		return
+	}
+	h = append(h, entry)
+	if len(h) > HISTORY_SIZE {
+		h = h[len(h)-HISTORY_SIZE:]
+	}
+	*history = h
+}
+
+func editorLastSessionPath() string {
+	dir := editorStateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "last-session.json")
+}
+
+func editorWriteSession(path string) error {
+	buffers[curbuf] = E
+	editorSaveWindow()
+	tabs[curtab] = tabpage{root: root, curwin: curwin, prevwin: prevwin}
+
+	cwd, _ := os.Getwd()
+	s := session{
+		Version:       SESSION_VERSION,
+		Cwd:           cwd,
+		Current:       -1,
+		CmdHistory:    cmdHistory,
+		SearchHistory: searchHistory,
+	}
+	// unnamed buffers can't be reopened so they are left out, and so are their windows
+	saved := map[int]int{}
+	for i, b := range buffers {
+		if b.filename == "" {
+			continue
+		}
+		abs, err := filepath.Abs(b.filename)
+		if err != nil {
+			continue
+		}
+		if i == curbuf {
+			s.Current = len(s.Buffers)
+		}
+		saved[i] = len(s.Buffers)
+		s.Buffers = append(s.Buffers, sessionBuffer{
+			File:   abs,
+			Cx:     b.cx,
+			Cy:     b.cy,
+			Rowoff: b.rowoff,
+			Coloff: b.coloff,
+		})
+	}
+
+	for i, t := range tabs {
+		layout, ok := sessionSaveLayout(t.root, saved)
+		if !ok {
+			continue
+		}
+		if i == curtab {
+			s.CurrentTab = len(s.Tabs)
+		}
+		tab := sessionTab{Layout: layout}
+		n := 0
+		for _, w := range editorLayoutWindows(t.root) {
+			if _, ok := saved[w.buf]; !ok {
+				continue
+			}
+			if w == t.curwin {
+				tab.Current = n
+			}
+			n++
+		}
+		s.Tabs = append(s.Tabs, tab)
+	}
+	if len(register.lines) > 0 {
+		s.Register = &sessionRegister{Lines: register.lines, Linewise: register.linewise}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func editorLoadSession(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var s session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if s.Version < 1 || s.Version > SESSION_VERSION {
+		return fmt.Errorf("unsupported session version %d", s.Version)
+	}
+
+	if s.Cwd != "" {
+		os.Chdir(s.Cwd)
+	}
+	cmdHistory = s.CmdHistory
+	searchHistory = s.SearchHistory
+
+	if s.Register != nil {
+		register.lines, register.linewise = s.Register.Lines, s.Register.Linewise
+	}
+
+	current := -1
+	opened := make([]int, len(s.Buffers))
+	for i, b := range s.Buffers {
+		editorEdit(b.File)
+		opened[i] = curbuf
+		E.cy = min(max(b.Cy, 0), E.numrows)
+		E.cx = 0
+		if E.cy < E.numrows {
+			E.cx = min(max(b.Cx, 0), E.row[E.cy].size)
+		}
+		E.rowoff = min(max(b.Rowoff, 0), E.cy)
+		E.coloff = max(b.Coloff, 0)
+		if i == s.Current {
+			current = curbuf
+		}
+	}
+	if current >= 0 {
+		editorSwitchBuffer(current)
+	}
+
+	// sessions before version 2 have just the buffers
+	var loaded []tabpage
+	for _, t := range s.Tabs {
+		var wins []*window
+		l := sessionLoadLayout(t.Layout, opened, &wins)
+		if l == nil {
+			continue
+		}
+		loaded = append(loaded, tabpage{root: l, curwin: wins[min(max(t.Current, 0), len(wins)-1)]})
+	}
+	if len(loaded) > 0 {
+		tabs = loaded
+		curtab = min(max(s.CurrentTab, 0), len(tabs)-1)
+		root, prevwin = tabs[curtab].root, nil
+		editorLoadWindow(tabs[curtab].curwin)
+		editorLayout()
+	}
+	return nil
+}
+
+// sessionSaveLayout turns the tree l into its session form, without the windows
+// of buffers that aren't saved. false when no window is left
+func sessionSaveLayout(l *layout, saved map[int]int) (sessionLayout, bool) {
+	if w := l.win; w != nil {
+		b, ok := saved[w.buf]
+		if !ok {
+			return sessionLayout{}, false
+		}
+		return sessionLayout{Window: &sessionWindow{
+			Buffer: b,
+			Cx:     w.cx,
+			Cy:     w.cy,
+			Rowoff: w.rowoff,
+			Coloff: w.coloff,
+			Diff:   w.diff,
+		}}, true
+	}
+	s := sessionLayout{Vertical: l.vertical}
+	for _, c := range l.children {
+		if child, ok := sessionSaveLayout(c, saved); ok {
+			s.Children = append(s.Children, child)
+		}
+	}
+	switch len(s.Children) {
+	case 0:
+		return sessionLayout{}, false
+	case 1:
+		return s.Children[0], true
+	}
+	return s, true
+}
+
+// sessionLoadLayout makes the tree of windows of s, on the buffers that were
+// opened for the session's. the windows go in wins in order. nil when there are none
+func sessionLoadLayout(s sessionLayout, opened []int, wins *[]*window) *layout {
+	if sw := s.Window; sw != nil {
+		if sw.Buffer < 0 || sw.Buffer >= len(opened) {
+			return nil
+		}
+		w := &window{buf: opened[sw.Buffer], diff: sw.Diff, scrollbind: sw.Diff}
+		editorInBuffer(w.buf, func() {
+			w.cy = min(max(sw.Cy, 0), E.numrows)
+			if w.cy < E.numrows {
+				w.cx = min(max(sw.Cx, 0), E.row[w.cy].size)
+			}
+			w.rowoff = min(max(sw.Rowoff, 0), w.cy)
+			w.coloff = max(sw.Coloff, 0)
+		})
+		*wins = append(*wins, w)
+		return &layout{win: w}
+	}
+	l := &layout{vertical: s.Vertical}
+	for _, c := range s.Children {
+		child := sessionLoadLayout(c, opened, wins)
+		switch {
+		case child == nil:
+		case child.win == nil && child.vertical == l.vertical:
+			// what's left of a row in a row is more of the same row
+			for _, cc := range child.children {
+				cc.parent = l
+				l.children = append(l.children, cc)
+			}
+		default:
+			child.parent = l
+			l.children = append(l.children, child)
+		}
+	}
+	switch len(l.children) {
+	case 0:
+		return nil
+	case 1:
+		only := l.children[0]
+		only.parent = nil
+		return only
+	}
+	return l
+}
+
+func editorMakeSession(path string) {
+	if path == "" {
+		path = "Session.json"
+	}
+	if err := editorWriteSession(path); err != nil {
+		editorSetStatusMessage("can't write session: %s", err)
+		return
+	}
+	editorSetStatusMessage("session written to %s", path)
+}
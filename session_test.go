@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionWindowsAndRegister(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	os.WriteFile(a, []byte("a1\na2\na3\n"), 0644)
+	os.WriteFile(b, []byte("b1\nb2\n"), 0644)
+	termWidth, termHeight = 80, 24
+	testBuffers(t)
+
+	editorEdit(a)
+	editorLayout()
+	editorSplit(true)
+	editorEdit(b)
+	E.cy = 1
+	editorTabNew()
+	editorLayout()
+	editorEdit(a)
+	E.cy = 2
+	// a window on a list isn't saved
+	editorSplit(false)
+	editorAddBuffer()
+	E.buftype = "quickfix"
+	register.lines, register.linewise = []string{"x", "y"}, true
+
+	path := filepath.Join(dir, "session.json")
+	if err := editorWriteSession(path); err != nil {
+		t.Fatal(err)
+	}
+
+	testBuffers(t)
+	register.lines, register.linewise = nil, false
+	if err := editorLoadSession(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(tabs) != 2 || curtab != 1 {
+		t.Fatalf("%d tabs with %d current, want 2 and 1", len(tabs), curtab)
+	}
+	if wins := editorWindows(); len(wins) != 1 || editorBufFilename(wins[0].buf) != a || E.cy != 2 {
+		t.Errorf("the second tab has %d windows, on %s at row %d", len(wins), editorBufFilename(curbuf), E.cy)
+	}
+	first := tabs[0].root
+	if first.win != nil || !first.vertical || len(first.children) != 2 {
+		t.Fatalf("the first tab isn't two windows side by side")
+	}
+	left := first.children[0].win
+	if editorBufFilename(left.buf) != b || left.cy != 1 || tabs[0].curwin != left {
+		t.Errorf("the left window is on %s at row %d", editorBufFilename(left.buf), left.cy)
+	}
+	if right := first.children[1].win; editorBufFilename(right.buf) != a {
+		t.Errorf("the right window is on %s", editorBufFilename(right.buf))
+	}
+	if len(register.lines) != 2 || register.lines[1] != "y" || !register.linewise {
+		t.Errorf("the register is %q, linewise %v", register.lines, register.linewise)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// editorParseRange takes a line range off the front of an ex command: %, or one
+// or two addresses split by a comma. an address is a line number, . for the
+// cursor line, $ for the last line or 'x for a mark, and may be followed by +N
+// or -N. the lines returned are 0 based and inclusive
+func editorParseRange(cmd string) (int, int, string, bool, error) {
+	last := max(E.numrows-1, 0)
+	if rest, ok := strings.CutPrefix(cmd, "%"); ok {
+		return 0, last, strings.TrimSpace(rest), true, nil
+	}
+
+	var lines []int
+	for {
+		line, rest, ok, err := editorParseAddress(cmd)
+		if err != nil {
+			return 0, 0, cmd, false, err
+		}
+		if !ok {
+			break
+		}
+		lines = append(lines, line)
+		cmd = rest
+		if len(lines) == 2 || !strings.HasPrefix(cmd, ",") {
+			break
+		}
+		cmd = cmd[1:]
+	}
+
+	switch len(lines) {
+	case 0:
+		return 0, 0, cmd, false, nil
+	case 1:
+		lines = append(lines, lines[0])
+	}
+	start, end := lines[0], lines[1]
+	if start > end {
+		start, end = end, start
+	}
+	if start < 0 || end > last {
+		return 0, 0, cmd, false, fmt.Errorf("invalid range")
+	}
+	return start, end, strings.TrimSpace(cmd), true, nil
+}
+
+func editorParseAddress(s string) (int, string, bool, error) {
+	line, found := E.cy, true
+	switch {
+	case s == "":
+		return 0, s, false, nil
+	case s[0] >= '0' && s[0] <= '9':
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		n, _ := strconv.Atoi(s[:i])
+		line, s = n-1, s[i:]
+	case s[0] == '.':
+		s = s[1:]
+	case s[0] == '$':
+		line, s = E.numrows-1, s[1:]
+	case s[0] == '\'' && len(s) > 1:
+		m, ok := E.marks[s[1]]
+		if !ok {
+			return 0, s, false, fmt.Errorf("mark not set: %c", s[1])
+		}
+		line, s = m.Cy, s[2:]
+	case s[0] == '+' || s[0] == '-':
+		// an offset on its own is from the cursor line
+	default:
+		found = false
+	}
+	if !found {
+		return 0, s, false, nil
+	}
+
+	for len(s) > 0 && (s[0] == '+' || s[0] == '-') {
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		}
+		i := 1
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		n := 1
+		if i > 1 {
+			n, _ = strconv.Atoi(s[1:i])
+		}
+		line += sign * n
+		s = s[i:]
+	}
+	return line, s, true, nil
+}
+
+// editorShellCommand runs command with the user's shell
+func editorShellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	return exec.Command(shell, "-c", command)
+}
+
+// editorExpandFilename puts the file name in place of % in a shell command. \% is a %
+func editorExpandFilename(command string) string {
+	var b strings.Builder
+	for i := 0; i < len(command); i++ {
+		switch {
+		case command[i] == '\\' && i+1 < len(command) && command[i+1] == '%':
+			b.WriteByte('%')
+			i++
+		case command[i] == '%':
+			b.WriteString(E.filename)
+		default:
+			b.WriteByte(command[i])
+		}
+	}
+	return b.String()
+}
+
+// editorSplitArgs splits a command line into words like a shell does with
+// quotes and backslashes, but without expanding anything
+func editorSplitArgs(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' && i+1 < len(line) && (line[i+1] == '"' || line[i+1] == '\\') {
+				i++
+				word.WriteByte(line[i])
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inWord = true
+		case c == '\\' && i+1 < len(line):
+			i++
+			word.WriteByte(line[i])
+			inWord = true
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// editorShellRun runs cmd with input on its stdin and waits for it to finish.
+// it can be stopped with Ctrl-C, other keys typed meanwhile are kept
+func editorShellRun(cmd *exec.Cmd, input string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+	var err error
+	done := make(chan struct{})
+	go func() {
+		err = cmd.Wait()
+		close(done)
+	}()
+	editorWaitFor(strings.Join(cmd.Args, " "), done, func() { cmd.Process.Kill() })
+	return stdout.Bytes(), stderr.Bytes(), err
+}
+
+// editorWaitFor says what is running until done is closed. Ctrl-C calls stop,
+// other keys typed meanwhile are kept for after
+func editorWaitFor(what string, done <-chan struct{}, stop func()) {
+	editorSetStatusMessage("running %s (CTRL-C to stop)", what)
+	editorRefreshScreen()
+	for {
+		select {
+		case <-done:
+			editorSetStatusMessage("")
+			return
+		case b := <-keyChan:
+			if bytes.IndexByte(b, byte(CONTROL_KEY('c'))) >= 0 {
+				stop()
+			} else {
+				pendingKeys = append(pendingKeys, b...)
+			}
+		}
+	}
+}
+
+// editorOutputLines splits command output into lines
+func editorOutputLines(out []byte) []string {
+	text := strings.ReplaceAll(string(out), "\r\n", "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// editorShellError describes a command that failed, with the first line of what it said on stderr
+func editorShellError(err error, stderr []byte) string {
+	lines := editorOutputLines(stderr)
+	if len(lines) == 0 {
+		return err.Error()
+	}
+	if len(lines) > 1 {
+		return fmt.Sprintf("%s (%d more lines)", lines[0], len(lines)-1)
+	}
+	return lines[0]
+}
+
+// editorBang is :!cmd, which runs a command and shows what it printed
+func editorBang(command string) {
+	if command == "" {
+		editorSetStatusMessage("no command")
+		return
+	}
+	command = editorExpandFilename(command)
+	stdout, stderr, err := editorShellRun(editorShellCommand(command), "")
+	lines := editorOutputLines(append(stdout, stderr...))
+	status := "shell returned 0"
+	if err != nil {
+		status = "shell returned: " + err.Error()
+	}
+	if len(lines) == 0 {
+		editorSetStatusMessage("%s", status)
+		return
+	}
+	editorShowLines("!"+command+" ("+status+")", lines)
+}
+
+// editorFilter is :{range}!cmd. the lines go through the command and what comes
+// out takes their place. nothing changes when the command fails
+func editorFilter(start, end int, command string) {
+	if command == "" {
+		editorSetStatusMessage("no command")
+		return
+	}
+	if !editorWritable() || E.numrows == 0 {
+		return
+	}
+	var input strings.Builder
+	for y := start; y <= end; y++ {
+		input.Write(E.row[y].chars)
+		input.WriteByte('\n')
+	}
+	command = editorExpandFilename(command)
+	stdout, stderr, err := editorShellRun(editorShellCommand(command), input.String())
+	if err != nil {
+		editorSetStatusMessage("%s: %s", command, editorShellError(err, stderr))
+		return
+	}
+
+	lines := make([]string, 0, E.numrows)
+	for y := 0; y < start; y++ {
+		lines = append(lines, string(E.row[y].chars))
+	}
+	out := editorOutputLines(stdout)
+	lines = append(lines, out...)
+	for y := end + 1; y < E.numrows; y++ {
+		lines = append(lines, string(E.row[y].chars))
+	}
+	editorSetLines(lines)
+	editorSetStatusMessage("%d lines filtered", end-start+1)
+}
+
+// editorReadCommand is :r file, or :r !cmd, which put the file or the output of cmd under the cursor line
+func editorReadCommand(arg string) {
+	if !editorWritable() {
+		return
+	}
+	var lines []string
+	if command, ok := strings.CutPrefix(arg, "!"); ok {
+		command = editorExpandFilename(strings.TrimSpace(command))
+		stdout, stderr, err := editorShellRun(editorShellCommand(command), "")
+		if err != nil {
+			editorSetStatusMessage("%s: %s", command, editorShellError(err, stderr))
+			return
+		}
+		lines = editorOutputLines(stdout)
+	} else {
+		if arg == "" {
+			arg = E.filename
+		}
+		var err error
+		if lines, err = editorReadLines(arg); err != nil {
+			editorSetStatusMessage("can't read %s: %s", arg, err)
+			return
+		}
+	}
+
+	at := min(E.cy+1, E.numrows)
+	for i, line := range lines {
+		editorInsertRow(at+i, []byte(line))
+	}
+	if len(lines) > 0 {
+		E.cy, E.cx = at, editorFirstNonBlank(at)
+	}
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// STATUSLINE is drawn by editorDrawStatusBar. the directives are
+//
+//	%m mode            %f file (relative)   %F file (full path)
+//	%M [+] if modified %R [RO] if read-only %y [filetype]
+//	%e encoding        %n line ending       %l line      %L lines
+//	%c column          %p percentage        %s selection size
+//	%b [git branch]    %S [match/matches] of the last search
+//	%o byte offset of the cursor in the hex view
+//	%= what follows goes on the right       %< truncate here when too long
+//	%% a percent sign
+var STATUSLINE = " %m   %<%f%M%R%=%s%S%b%y%o %l/%L "
+
+var modeNames = map[byte]string{
+	NORMAL:      "NORMAL",
+	INSERT:      "INSERT",
+	REPLACE:     "REPLACE",
+	VISUAL:      "VISUAL",
+	VISUAL_LINE: "V-LINE",
+	TERMINAL:    "TERMINAL",
+}
+
+// the query of the last search, for n/N and the match count in the status line
+var lastSearch string
+
+// editorStatusLine expands format into the left and right parts of the bar.
+// trunc is where the left part may be cut when the bar doesn't fit
+func editorStatusLine(format string) (left, right []rune, trunc int) {
+	out := &left
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			r, size := utf8.DecodeRuneInString(format[i:])
+			*out = append(*out, r)
+			i += size - 1
+			continue
+		}
+		i++
+		s := ""
+		switch format[i] {
+		case 'm':
+			s = modeNames[E.mode]
+		case 'f':
+			s = editorDisplayName(false)
+		case 'F':
+			s = editorDisplayName(true)
+		case 'M':
+			if E.dirty {
+				s = "[+]"
+			}
+		case 'R':
+			if E.readonly {
+				s = "[RO]"
+			}
+		case 'y':
+			if E.syntax != nil {
+				s = "[" + E.syntax.filetype + "]"
+			}
+		case 'e':
+			s = "utf-8"
+		case 'n':
+			s = "unix"
+			if E.crlf {
+				s = "dos"
+			}
+		case 'l':
+			s = fmt.Sprint(E.cy + 1)
+			if E.hex {
+				s = fmt.Sprint(E.hexoff/editorHexBytesPerLine() + 1)
+			}
+		case 'L':
+			s = fmt.Sprint(E.numrows)
+			if E.hex {
+				s = fmt.Sprint((len(E.data) + editorHexBytesPerLine() - 1) / editorHexBytesPerLine())
+			}
+		case 'o':
+			if E.hex {
+				s = fmt.Sprintf("[0x%x]", E.hexoff)
+			}
+		case 'c':
+			s = fmt.Sprint(E.rx + 1)
+			if E.cy < E.numrows {
+				s = fmt.Sprint(editorRenderCol(&E.row[E.cy], E.rx) + 1)
+			}
+		case 'p':
+			s = fmt.Sprint(100 * min(E.cy+1, max(E.numrows, 1)) / max(E.numrows, 1))
+		case 's':
+			if n, lines := editorSelectionSize(); n > 0 {
+				if lines {
+					s = fmt.Sprintf("[%d lines]", n)
+				} else {
+					s = fmt.Sprintf("[%d]", n)
+				}
+			}
+		case 'b':
+			if branch := editorGitBranch(); branch != "" {
+				s = "[" + branch + "]"
+			}
+		case 'S':
+			if cur, total := editorSearchCount(lastSearch); total > 0 {
+				s = fmt.Sprintf("[%d/%d]", cur, total)
+			}
+		case '=':
+			out = &right
+		case '<':
+			if out == &left {
+				trunc = len(left)
+			}
+		case '%':
+			s = "%"
+		default:
+			r, size := utf8.DecodeRuneInString(format[i:])
+			s = "%" + string(r)
+			i += size - 1
+		}
+		*out = append(*out, []rune(s)...)
+	}
+	return left, right, trunc
+}
+
+// editorDisplayName is the file name for the status line, relative to the working directory when it's below it
+func editorDisplayName(full bool) string {
+	if E.buftype == "quickfix" {
+		return "[Quickfix List] " + quickfix.title
+	}
+	if E.buftype == "blame" {
+		return "[Blame] " + filepath.Base(blameOf)
+	}
+	if E.term != nil {
+		if E.term.done {
+			return "!" + E.term.name + " [finished]"
+		}
+		return "!" + E.term.name
+	}
+	if E.filename == "" {
+		return "[No Name]"
+	}
+	abs, err := filepath.Abs(E.filename)
+	if err != nil {
+		return E.filename
+	}
+	if full {
+		return abs
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(cwd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return E.filename
+}
+
+// editorSearchCount returns which match of query the cursor is on and how many there are.
+// the matches are only looked for again when the query or the text changed
+func editorSearchCount(query string) (int, int) {
+	if query == "" || E.numrows == 0 {
+		return 0, 0
+	}
+	if query != E.search_query || E.changetick != E.search_tick || E.search_matches == nil {
+		E.search_query, E.search_tick = query, E.changetick
+		E.search_matches = [][2]int{}
+		for y := range E.row {
+			render := string(E.row[y].render[:E.row[y].rsize])
+			for x := 0; ; {
+				i := strings.Index(render[x:], query)
+				if i < 0 {
+					break
+				}
+				E.search_matches = append(E.search_matches, [2]int{y, x + i})
+				x += i + max(len(query), 1)
+				if x > len(render) {
+					break
+				}
+			}
+		}
+	}
+	// the matches up to the cursor
+	cur := sort.Search(len(E.search_matches), func(i int) bool {
+		m := E.search_matches[i]
+		return m[0] > E.cy || (m[0] == E.cy && m[1] > E.rx)
+	})
+	return cur, len(E.search_matches)
+}
+
+var gitBranchCache struct {
+	dir    string
+	branch string
+	time   time.Time
+}
+
+// editorGitBranch reads HEAD of the repository the file is in. it's looked
+// at again every couple of seconds so a checkout in another terminal shows up
+func editorGitBranch() string {
+	dir := "."
+	if E.filename != "" {
+		dir = filepath.Dir(E.filename)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	if gitBranchCache.dir == dir && time.Since(gitBranchCache.time) < 2*time.Second {
+		return gitBranchCache.branch
+	}
+	gitBranchCache.dir = dir
+	gitBranchCache.time = time.Now()
+	gitBranchCache.branch = ""
+
+	for d := dir; ; d = filepath.Dir(d) {
+		gitdir := filepath.Join(d, ".git")
+		info, err := os.Stat(gitdir)
+		if err == nil {
+			if !info.IsDir() {
+				// worktrees and submodules have a file pointing at the real git dir
+				data, err := os.ReadFile(gitdir)
+				if err != nil {
+					return ""
+				}
+				gitdir = strings.TrimSpace(strings.TrimPrefix(string(data), "gitdir:"))
+				if !filepath.IsAbs(gitdir) {
+					gitdir = filepath.Join(d, gitdir)
+				}
+			}
+			head, err := os.ReadFile(filepath.Join(gitdir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref := strings.TrimSpace(string(head))
+			if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+				gitBranchCache.branch = branch
+			} else if len(ref) >= 7 {
+				gitBranchCache.branch = ref[:7]
+			}
+			return gitBranchCache.branch
+		}
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
+
+func editorDrawStatusBar(active bool) {
+	y := E.screenrows
+	width := E.raw_screencols
+	st := hlStyle(HG_STATUSLINE)
+	if !active {
+		st = hlStyle(HG_STATUSLINENC)
+	}
+
+	left, right, trunc := editorStatusLine(STATUSLINE)
+	if len(right) > width {
+		right = right[len(right)-width:]
+	}
+	if over := len(left) + len(right) - width; over > 0 {
+		// cut from the truncation point on and mark it with <
+		end := min(trunc+over+1, len(left))
+		left = append(append(left[:trunc:trunc], '<'), left[end:]...)
+		if len(left)+len(right) > width {
+			left = left[:max(width-len(right), 0)]
+		}
+	}
+
+	screenFill(0, y, width, st)
+	screenPutString(0, y, string(left), st)
+	screenPutString(width-len(right), y, string(right), st)
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestStatusLineRunes(t *testing.T) {
+	testBuffers(t, "one")
+	left, right, _ := editorStatusLine("→ %l ‖%=%%é %ä")
+	if string(left) != "→ 1 ‖" || string(right) != "%é %ä" {
+		t.Errorf("status line is %q and %q", string(left), string(right))
+	}
+}
+
+func TestSearchCount(t *testing.T) {
+	testBuffers(t, "a b a", "b", "a")
+	E.cy, E.rx = 0, 2
+	if cur, total := editorSearchCount("a"); cur != 1 || total != 3 {
+		t.Errorf("search count on row 0 is %d/%d, want 1/3", cur, total)
+	}
+	// moving doesn't need the text looked at again
+	matches := E.search_matches
+	E.cy, E.rx = 2, 0
+	if cur, total := editorSearchCount("a"); cur != 3 || total != 3 || &matches[0] != &E.search_matches[0] {
+		t.Errorf("search count on row 2 is %d/%d, want 3/3 from the same matches", cur, total)
+	}
+	// but changing it does
+	editorInsertRow(0, []byte("a"))
+	if cur, total := editorSearchCount("a"); cur != 3 || total != 4 {
+		t.Errorf("search count after inserting a row is %d/%d, want 3/4", cur, total)
+	}
+	if cur, total := editorSearchCount("b"); cur != 2 || total != 2 {
+		t.Errorf("search count of b is %d/%d, want 2/2", cur, total)
+	}
+}
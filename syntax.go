@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	HL_NORMAL = iota
+	HL_COMMENT
+	HL_MLCOMMENT
+	HL_KEYWORD1
+	HL_KEYWORD2
+	HL_STRING
+	HL_NUMBER
+	HL_MATCH
+)
+
+const (
+	HL_HIGHLIGHT_NUMBERS = 1 << iota
+	HL_HIGHLIGHT_STRINGS
+)
+
+type editorSyntax struct {
+	filetype                string
+	filematch               []string
+	keywords                []string // keywords ending in | are the second kind (types)
+	singleline_comment      string
+	multiline_comment_start string
+	multiline_comment_end   string
+	string_quotes           string
+	flags                   int
+}
+
+var HLDB = []editorSyntax{
+	{
+		filetype:  "go",
+		filematch: []string{".go"},
+		keywords: []string{
+			"break", "case", "chan", "const", "continue", "default", "defer", "else",
+			"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+			"map", "package", "range", "return", "select", "struct", "switch", "type", "var",
+
+			"any|", "bool|", "byte|", "complex64|", "complex128|", "error|", "float32|",
+			"float64|", "int|", "int8|", "int16|", "int32|", "int64|", "rune|", "string|",
+			"uint|", "uint8|", "uint16|", "uint32|", "uint64|", "uintptr|",
+			"true|", "false|", "nil|", "iota|",
+		},
+		singleline_comment:      "//",
+		multiline_comment_start: "/*",
+		multiline_comment_end:   "*/",
+		string_quotes:           "\"'`",
+		flags:                   HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+	{
+		filetype:  "c",
+		filematch: []string{".c", ".h", ".cpp", ".hpp", ".cc"},
+		keywords: []string{
+			"switch", "if", "while", "for", "break", "continue", "return", "else",
+			"struct", "union", "typedef", "static", "enum", "class", "case", "default",
+			"goto", "sizeof", "const", "#include", "#define", "#ifdef", "#ifndef", "#endif",
+
+			"int|", "long|", "double|", "float|", "char|", "unsigned|", "signed|",
+			"void|", "short|", "auto|", "bool|", "true|", "false|", "NULL|",
+		},
+		singleline_comment:      "//",
+		multiline_comment_start: "/*",
+		multiline_comment_end:   "*/",
+		string_quotes:           "\"'",
+		flags:                   HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+	{
+		filetype:  "python",
+		filematch: []string{".py"},
+		keywords: []string{
+			"and", "as", "assert", "async", "await", "break", "class", "continue", "def",
+			"del", "elif", "else", "except", "finally", "for", "from", "global", "if",
+			"import", "in", "is", "lambda", "nonlocal", "not", "or", "pass", "raise",
+			"return", "try", "while", "with", "yield",
+
+			"True|", "False|", "None|", "self|", "int|", "str|", "float|", "list|",
+			"dict|", "set|", "tuple|", "bool|",
+		},
+		singleline_comment: "#",
+		string_quotes:      "\"'",
+		flags:              HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+	{
+		filetype:  "javascript",
+		filematch: []string{".js", ".mjs", ".ts", ".jsx", ".tsx"},
+		keywords: []string{
+			"async", "await", "break", "case", "catch", "class", "const", "continue",
+			"default", "delete", "do", "else", "export", "extends", "finally", "for",
+			"function", "if", "import", "in", "instanceof", "let", "new", "return",
+			"switch", "throw", "try", "typeof", "var", "while", "yield",
+
+			"true|", "false|", "null|", "undefined|", "this|", "number|", "string|",
+			"boolean|", "any|", "void|",
+		},
+		singleline_comment:      "//",
+		multiline_comment_start: "/*",
+		multiline_comment_end:   "*/",
+		string_quotes:           "\"'`",
+		flags:                   HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+	{
+		filetype:      "json",
+		filematch:     []string{".json"},
+		keywords:      []string{"true|", "false|", "null|"},
+		string_quotes: "\"",
+		flags:         HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+	{
+		filetype:  "sh",
+		filematch: []string{".sh", ".bash"},
+		keywords: []string{
+			"if", "then", "else", "elif", "fi", "for", "while", "until", "do", "done",
+			"case", "esac", "in", "function", "return", "local", "export",
+		},
+		singleline_comment: "#",
+		string_quotes:      "\"'",
+		flags:              HL_HIGHLIGHT_NUMBERS | HL_HIGHLIGHT_STRINGS,
+	},
+}
+
+func isSeparator(c byte) bool {
+	return c == ' ' || c == '\t' || c == 0 || strings.IndexByte(",.()+-/*=~%<>[];{}:&|!^", c) >= 0
+}
+
+// editorUpdateSyntax highlights row and then keeps going down as long as
+// the open multi-line comment state keeps changing
+func editorUpdateSyntax(row *erow) {
+	for {
+		changed := editorHighlightRow(row)
+		if !changed || row.idx+1 >= len(E.row) {
+			return
+		}
+		row = &E.row[row.idx+1]
+	}
+}
+
+// editorHighlightRow fills row.hl and reports whether the row's open comment state changed
+func editorHighlightRow(row *erow) bool {
+	row.hl = make([]byte, row.rsize)
+
+	syntax := E.syntax
+	if syntax == nil {
+		return false
+	}
+
+	scs := syntax.singleline_comment
+	mcs := syntax.multiline_comment_start
+	mce := syntax.multiline_comment_end
+
+	prevSep := true
+	var inString byte
+	inComment := row.idx > 0 && row.idx-1 < len(E.row) && E.row[row.idx-1].hl_open_comment
+
+	render := row.render[:row.rsize]
+	for i := 0; i < row.rsize; {
+		c := render[i]
+		prevHl := byte(HL_NORMAL)
+		if i > 0 {
+			prevHl = row.hl[i-1]
+		}
+
+		if scs != "" && inString == 0 && !inComment && bytes.HasPrefix(render[i:], []byte(scs)) {
+			for j := i; j < row.rsize; j++ {
+				row.hl[j] = HL_COMMENT
+			}
+			break
+		}
+
+		if mcs != "" && mce != "" && inString == 0 {
+			if inComment {
+				row.hl[i] = HL_MLCOMMENT
+				if bytes.HasPrefix(render[i:], []byte(mce)) {
+					for j := 0; j < len(mce); j++ {
+						row.hl[i+j] = HL_MLCOMMENT
+					}
+					i += len(mce)
+					inComment = false
+					prevSep = true
+				} else {
+					i++
+				}
+				continue
+			} else if bytes.HasPrefix(render[i:], []byte(mcs)) {
+				for j := 0; j < len(mcs); j++ {
+					row.hl[i+j] = HL_MLCOMMENT
+				}
+				i += len(mcs)
+				inComment = true
+				continue
+			}
+		}
+
+		if syntax.flags&HL_HIGHLIGHT_STRINGS != 0 {
+			if inString != 0 {
+				row.hl[i] = HL_STRING
+				if c == '\\' && i+1 < row.rsize {
+					row.hl[i+1] = HL_STRING
+					i += 2
+					continue
+				}
+				if c == inString {
+					inString = 0
+				}
+				i++
+				prevSep = true
+				continue
+			} else if strings.IndexByte(syntax.string_quotes, c) >= 0 {
+				inString = c
+				row.hl[i] = HL_STRING
+				i++
+				continue
+			}
+		}
+
+		if syntax.flags&HL_HIGHLIGHT_NUMBERS != 0 {
+			if (c >= '0' && c <= '9' && (prevSep || prevHl == HL_NUMBER)) || (c == '.' && prevHl == HL_NUMBER) {
+				row.hl[i] = HL_NUMBER
+				i++
+				prevSep = false
+				continue
+			}
+		}
+
+		if prevSep {
+			found := false
+			for _, kw := range syntax.keywords {
+				kw2 := strings.HasSuffix(kw, "|")
+				kw = strings.TrimSuffix(kw, "|")
+				end := i + len(kw)
+				if bytes.HasPrefix(render[i:], []byte(kw)) && (end == row.rsize || isSeparator(render[end])) {
+					hl := byte(HL_KEYWORD1)
+					if kw2 {
+						hl = HL_KEYWORD2
+					}
+					for j := i; j < end; j++ {
+						row.hl[j] = hl
+					}
+					i = end
+					found = true
+					break
+				}
+			}
+			if found {
+				prevSep = false
+				continue
+			}
+		}
+
+		prevSep = isSeparator(c)
+		i++
+	}
+
+	changed := row.hl_open_comment != inComment
+	row.hl_open_comment = inComment
+	return changed
+}
+
+func editorSyntaxToGroup(hl byte) hlGroup {
+	switch hl {
+	case HL_COMMENT, HL_MLCOMMENT:
+		return HG_COMMENT
+	case HL_KEYWORD1:
+		return HG_KEYWORD
+	case HL_KEYWORD2:
+		return HG_TYPE
+	case HL_STRING:
+		return HG_STRING
+	case HL_NUMBER:
+		return HG_NUMBER
+	case HL_MATCH:
+		return HG_SEARCH
+	default:
+		return HG_NORMAL
+	}
+}
+
+// editorSelectSyntaxHighlight picks the syntax from the file extension and rehighlights everything
+func editorSelectSyntaxHighlight() {
+	E.syntax = nil
+	if E.filename != "" {
+		ext := filepath.Ext(E.filename)
+	search:
+		for i := range HLDB {
+			for _, match := range HLDB[i].filematch {
+				if ext == match {
+					E.syntax = &HLDB[i]
+					break search
+				}
+			}
+		}
+	}
+
+	for r := range E.row {
+		editorHighlightRow(&E.row[r])
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// TERMINAL is terminal-insert mode, where the keys go to the program in a terminal buffer
+const TERMINAL = 't'
+
+// how many lines that scrolled off a terminal are kept
+var TERMINAL_SCROLLBACK = 10000
+
+// a program running in a terminal buffer. the buffer's rows are the text of
+// the scrollback and then the screen, so NORMAL mode can move over and yank
+// them, and they're drawn with the colors of the cells
+type terminal struct {
+	name string
+	buf  int
+	vt   *vterm
+	pty  *os.File
+	cmd  *exec.Cmd
+	done bool
+
+	synced     int // vt.sbTotal when the rows were last brought up to date
+	screenRows int // rows at the end of the buffer that are the screen
+
+	mu      sync.Mutex
+	pending []byte // output the main loop hasn't run through vt yet
+	posted  bool
+}
+
+// editorTerminal is :terminal, which runs cmd, or the shell, in a new window
+func editorTerminal(args string) {
+	argv := editorSplitArgs(args)
+	if len(argv) == 0 {
+		shell := os.Getenv("SHELL")
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		argv = []string{shell}
+	}
+	if editorSplit(false) == nil {
+		return
+	}
+	editorAddBuffer()
+	E.buftype = "terminal"
+	editorLayout()
+
+	cols, rows := max(E.raw_screencols, 1), E.screenrows
+	t := &terminal{name: strings.Join(argv, " "), buf: curbuf, vt: newVterm(cols, rows)}
+	t.cmd = exec.Command(argv[0], argv[1:]...)
+	t.cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	pty, err := ptyStart(t.cmd, cols, rows)
+	if err != nil {
+		editorCloseWindow(curwin)
+		editorSetStatusMessage("terminal: %s", err)
+		return
+	}
+	t.pty = pty
+	E.term = t
+	editorTermSync()
+	E.mode = TERMINAL
+
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := pty.Read(buf)
+			if n > 0 {
+				t.mu.Lock()
+				t.pending = append(t.pending, buf[:n]...)
+				post := !t.posted
+				t.posted = true
+				t.mu.Unlock()
+				// one event at a time, what comes meanwhile is run with it
+				if post {
+					events <- func() { editorTermOutput(t) }
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		err := t.cmd.Wait()
+		events <- func() { editorTermExit(t, err) }
+	}()
+}
+
+// editorTermOutput runs what the program wrote through its terminal
+func editorTermOutput(t *terminal) {
+	t.mu.Lock()
+	data := t.pending
+	t.pending, t.posted = nil, false
+	t.mu.Unlock()
+
+	editorInBuffer(t.buf, func() {
+		t.vt.feed(data)
+		if reply := t.vt.takeReply(); len(reply) > 0 && !t.done {
+			t.pty.Write(reply)
+		}
+		editorTermSync()
+	})
+}
+
+// editorTermExit is the program ending, the buffer stays with what it wrote
+func editorTermExit(t *terminal, err error) {
+	t.done = true
+	t.pty.Close()
+	editorInBuffer(t.buf, func() {
+		if E.mode == TERMINAL {
+			E.mode = NORMAL
+		}
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			editorSetStatusMessage("%s exited with %d", t.name, exit.ExitCode())
+		} else {
+			editorSetStatusMessage("%s finished", t.name)
+		}
+	})
+}
+
+// editorTermSync brings the rows of the terminal buffer up to date with the
+// scrollback and the screen
+func editorTermSync() {
+	t := E.term
+	vt := t.vt
+	E.undo = undoTree{off: true}
+
+	// the old screen rows go, the lines that scrolled off since come in their place
+	for n := 0; n < t.screenRows && E.numrows > 0; n++ {
+		editorDelRow(E.numrows - 1)
+	}
+	added := min(vt.sbTotal-t.synced, len(vt.scrollback))
+	for _, line := range vt.scrollback[len(vt.scrollback)-added:] {
+		editorInsertRow(E.numrows, vtLineText(line, 0))
+	}
+	// and the ones the scrollback dropped from its top go
+	if over := E.numrows - len(vt.scrollback); over > 0 {
+		E.row = append([]erow(nil), E.row[over:]...)
+		E.numrows -= over
+		for i := range E.row {
+			E.row[i].idx = i
+		}
+		E.cy = max(E.cy-over, 0)
+	}
+	for y, line := range vt.lines {
+		n := 0
+		if y == vt.cy {
+			n = vt.cx
+		}
+		editorInsertRow(E.numrows, vtLineText(line, n))
+	}
+	t.synced, t.screenRows = vt.sbTotal, vt.h
+
+	E.undo = undoTree{}
+	E.dirty = false
+	if E.mode == TERMINAL {
+		editorTermCursor()
+	}
+}
+
+// editorTermCursor puts the cursor where the program has it, with the screen in view
+func editorTermCursor() {
+	t := E.term
+	E.cy = E.numrows - t.screenRows + t.vt.cy
+	E.cx = 0
+	if E.cy >= 0 && E.cy < E.numrows {
+		E.cx = len(vtLineText(t.vt.lines[t.vt.cy][:t.vt.cx], t.vt.cx))
+	}
+	E.rowoff = max(E.numrows-E.screenrows, 0)
+	E.coloff = 0
+}
+
+// editorTermResize gives the terminal the size of the window it's drawn in
+func editorTermResize(cols, rows int) {
+	t := E.term
+	if t.done || cols == t.vt.w && rows == t.vt.h {
+		return
+	}
+	t.vt.resize(cols, rows)
+	ptyResize(t.pty, cols, rows)
+	editorTermSync()
+}
+
+// editorTermCells are the cells of filerow, nil when it isn't one of the terminal's lines
+func editorTermCells(filerow int) []vcell {
+	t := E.term
+	screen := E.numrows - t.screenRows
+	if filerow >= screen {
+		if y := filerow - screen; y < len(t.vt.lines) {
+			return t.vt.lines[y]
+		}
+		return nil
+	}
+	if i := len(t.vt.scrollback) - screen + filerow; i >= 0 && i < len(t.vt.scrollback) {
+		return t.vt.scrollback[i]
+	}
+	return nil
+}
+
+// editorDrawTermRow draws filerow of a terminal buffer with the colors the program gave it
+func editorDrawTermRow(y, filerow int) {
+	row := &E.row[filerow]
+	cells := editorTermCells(filerow)
+	for x := 0; x < E.screencols; x++ {
+		col := E.coloff + x
+		c := vcell{}
+		if col < len(cells) {
+			c = cells[col]
+		}
+		st := c.style
+		st.fg, st.bg = fitColor(st.fg), fitColor(st.bg)
+		switch {
+		case c.ch == vtWide && x > 0 && cells[col-1].ch > 0:
+			// drawn with the character it's the right half of
+		case c.ch > 0:
+			screenPutString(E.linenum_indent+x, y, string(c.ch)+c.marks, st)
+		default:
+			screenPutString(E.linenum_indent+x, y, " ", st)
+		}
+		if rx := editorRenderIndex(row, col); rx < row.rsize && editorSelectedRx(row, rx) {
+			screenTint(E.linenum_indent+x, y, 1, editorTint(HG_SELECTION))
+		}
+	}
+}
+
+// editorTermNormalKey handles i and a on a terminal buffer in NORMAL mode,
+// which go back to typing into the program
+func editorTermNormalKey(c int) bool {
+	switch c {
+	case 'i', 'a', 'I', 'A':
+		if E.term.done {
+			editorSetStatusMessage("%s has finished", E.term.name)
+		} else {
+			E.mode = TERMINAL
+			editorTermCursor()
+		}
+		return true
+	}
+	return false
+}
+
+// editorTermKey sends a key to the program. Ctrl-\ Ctrl-N goes to NORMAL mode
+// and Ctrl-W starts a window command, Ctrl-W . sending the Ctrl-W itself
+func editorTermKey(c int) {
+	t := E.term
+	switch c {
+	case CONTROL_KEY('\\'):
+		next := editorReadKey()
+		if next == CONTROL_KEY('n') {
+			E.mode = NORMAL
+			return
+		}
+		t.pty.Write(append([]byte{byte(c)}, editorTermKeyBytes(next, t.vt.cursorKeys)...))
+		return
+	case CONTROL_KEY('w'):
+		next := editorReadKey()
+		if next == '.' {
+			t.pty.Write([]byte{byte(c)})
+			return
+		}
+		E.mode = NORMAL
+		editorWindowCommand(next)
+		return
+	}
+	t.pty.Write(editorTermKeyBytes(c, t.vt.cursorKeys))
+}
+
+// editorTermKeyBytes is what a terminal sends for key
+func editorTermKeyBytes(key int, cursorKeys bool) []byte {
+	arrow := func(c byte) []byte {
+		if cursorKeys {
+			return []byte{0x1b, 'O', c}
+		}
+		return []byte{0x1b, '[', c}
+	}
+	switch key {
+	case ARROW_UP:
+		return arrow('A')
+	case ARROW_DOWN:
+		return arrow('B')
+	case ARROW_RIGHT:
+		return arrow('C')
+	case ARROW_LEFT:
+		return arrow('D')
+	case PAGE_UP:
+		return []byte("\x1b[5~")
+	case PAGE_DOWN:
+		return []byte("\x1b[6~")
+	case DEL_KEY:
+		return []byte("\x1b[3~")
+	}
+	if key < 256 {
+		return []byte{byte(key)}
+	}
+	return nil
+}
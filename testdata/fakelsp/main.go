@@ -0,0 +1,209 @@
+// fakelsp is a language server for the tests. it keeps the text of the
+// documents it's sent and answers from it: lines with "bad" in them are
+// errors and lines with "meh" warnings, formatting drops trailing blanks and
+// rename renames every whole word like the one at the position. positions
+// count utf-16 code units
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+type message struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  any             `json:"result,omitempty"`
+}
+
+type position struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type textRange struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+}
+
+type textEdit struct {
+	Range   textRange `json:"range"`
+	NewText string    `json:"newText"`
+}
+
+var docs = map[string]string{}
+
+func main() {
+	r := bufio.NewReader(os.Stdin)
+	for {
+		msg, err := read(r)
+		if err != nil {
+			os.Exit(0)
+		}
+		handle(msg)
+	}
+}
+
+func read(r *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+			length, _ = strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	msg := &message{}
+	return msg, json.Unmarshal(body, msg)
+}
+
+func write(msg message) {
+	msg.Jsonrpc = "2.0"
+	body, _ := json.Marshal(msg)
+	fmt.Printf("Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+func handle(msg *message) {
+	var p struct {
+		TextDocument struct {
+			URI  string `json:"uri"`
+			Text string `json:"text"`
+		} `json:"textDocument"`
+		ContentChanges []struct {
+			Text string `json:"text"`
+		} `json:"contentChanges"`
+		Position position `json:"position"`
+		NewName  string   `json:"newName"`
+	}
+	json.Unmarshal(msg.Params, &p)
+	uri := p.TextDocument.URI
+
+	var result any
+	switch msg.Method {
+	case "initialize":
+		result = map[string]any{"capabilities": map[string]any{
+			"positionEncoding":           "utf-16",
+			"documentFormattingProvider": true,
+			"renameProvider":             true,
+		}}
+	case "textDocument/didOpen":
+		docs[uri] = p.TextDocument.Text
+		diagnose(uri)
+	case "textDocument/didChange":
+		for _, c := range p.ContentChanges {
+			docs[uri] = c.Text
+		}
+		diagnose(uri)
+	case "textDocument/formatting":
+		var edits []textEdit
+		for i, line := range lines(uri) {
+			if t := strings.TrimRight(line, " \t"); t != line {
+				edits = append(edits, textEdit{Range: textRange{position{i, units(t)}, position{i, units(line)}}})
+			}
+		}
+		result = edits
+	case "textDocument/rename":
+		result = map[string]any{"changes": map[string][]textEdit{uri: rename(uri, p.Position, p.NewName)}}
+	case "exit":
+		os.Exit(0)
+	}
+	if msg.ID != nil {
+		if result == nil {
+			result = json.RawMessage("null")
+		}
+		write(message{ID: msg.ID, Result: result})
+	}
+}
+
+func lines(uri string) []string {
+	return strings.Split(strings.TrimSuffix(docs[uri], "\n"), "\n")
+}
+
+// units is how many utf-16 code units s is
+func units(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+func diagnose(uri string) {
+	diags := []map[string]any{}
+	for i, line := range lines(uri) {
+		for word, severity := range map[string]int{"bad": 1, "meh": 2} {
+			if j := strings.Index(line, word); j >= 0 {
+				diags = append(diags, map[string]any{
+					"range":    textRange{position{i, units(line[:j])}, position{i, units(line[:j+3])}},
+					"severity": severity,
+					"message":  word + " line",
+				})
+			}
+		}
+	}
+	write(message{Method: "textDocument/publishDiagnostics", Params: mustMarshal(map[string]any{"uri": uri, "diagnostics": diags})})
+}
+
+func rename(uri string, pos position, name string) []textEdit {
+	all := lines(uri)
+	if pos.Line >= len(all) {
+		return nil
+	}
+	isWord := func(r rune) bool { return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) }
+	// the word the position is in
+	line := []rune(all[pos.Line])
+	at, n := 0, 0
+	for at < len(line) && n < pos.Character {
+		n += len(utf16.Encode(line[at : at+1]))
+		at++
+	}
+	start, end := at, at
+	for start > 0 && isWord(line[start-1]) {
+		start--
+	}
+	for end < len(line) && isWord(line[end]) {
+		end++
+	}
+	word := string(line[start:end])
+	if word == "" {
+		return nil
+	}
+
+	var edits []textEdit
+	for i, l := range all {
+		for j := 0; ; {
+			k := strings.Index(l[j:], word)
+			if k < 0 {
+				break
+			}
+			k += j
+			j = k + len(word)
+			before, after := []rune(l[:k]), []rune(l[j:])
+			if len(before) > 0 && isWord(before[len(before)-1]) || len(after) > 0 && isWord(after[0]) {
+				continue
+			}
+			edits = append(edits, textEdit{textRange{position{i, units(l[:k])}, position{i, units(l[:j])}}, name})
+		}
+	}
+	return edits
+}
+
+func mustMarshal(v any) json.RawMessage {
+	b, _ := json.Marshal(v)
+	return b
+}
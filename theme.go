@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+type hlGroup int
+
+const (
+	HG_NORMAL hlGroup = iota
+	HG_KEYWORD
+	HG_TYPE
+	HG_STRING
+	HG_COMMENT
+	HG_NUMBER
+	HG_SEARCH
+	HG_LINENR
+	HG_CURSORLINENR
+	HG_CURSORLINE
+	HG_STATUSLINE
+	HG_NONTEXT
+	HG_SELECTION
+	HG_DIFFADD
+	HG_DIFFCHANGE
+	HG_DIFFDELETE
+	HG_ERROR
+	HG_SPECIAL
+	HG_WHITESPACE
+	HG_TRAILING
+	HG_CURSORCOLUMN
+	HG_COLORCOLUMN
+	HG_MATCHPAREN
+	HG_FOLDED
+	HG_DIAGERROR
+	HG_DIAGWARN
+	HG_DIAGINFO
+	HG_POPUP
+	HG_STATUSLINENC
+	HG_DIFFADDLINE
+	HG_DIFFCHANGELINE
+	HG_DIFFTEXT
+	HG_COUNT
+)
+
+var hlGroupNames = [HG_COUNT]string{
+	"normal", "keyword", "type", "string", "comment", "number", "search",
+	"linenr", "cursorlinenr", "cursorline", "statusline", "nontext", "selection",
+	"diffadd", "diffchange", "diffdelete", "error", "special", "whitespace", "trailing",
+	"cursorcolumn", "colorcolumn", "matchparen", "folded",
+	"diagerror", "diagwarn", "diaginfo", "popup", "statuslinenc",
+	"diffaddline", "diffchangeline", "difftext",
+}
+
+const (
+	COLORS_16   = 16
+	COLORS_256  = 256
+	COLORS_TRUE = 1 << 24
+)
+
+var (
+	COLOR_DEPTH = editorDetectColorDepth()
+	THEME       = "default"
+
+	// the theme as written, and what's left of it after fitting it to COLOR_DEPTH
+	themeSpec   [HG_COUNT]style
+	themeStyles [HG_COUNT]style
+)
+
+// the bundled themes, written in the same format as user themes in <config>/themes/<name>.theme
+var THEMES = map[string]string{
+	"default": `
+keyword      fg=yellow
+type         fg=green
+string       fg=magenta
+comment      fg=cyan
+number       fg=red
+search       fg=black bg=yellow
+linenr       fg=brightblack
+cursorlinenr attr=bold
+cursorline   attr=underline
+statusline   attr=reverse
+nontext      fg=brightblack
+selection    attr=reverse
+diffadd      fg=green
+diffchange   fg=yellow
+diffdelete   fg=red
+error        fg=brightwhite bg=red
+special      fg=brightblue
+whitespace   fg=brightblack
+trailing     bg=red
+cursorcolumn bg=brightblack
+colorcolumn  bg=brightblack
+matchparen   bg=cyan
+folded       fg=cyan
+diagerror    fg=red
+diagwarn     fg=yellow
+diaginfo     fg=brightblue
+popup        fg=white bg=brightblack
+statuslinenc fg=black bg=white
+diffaddline  bg=blue
+diffchangeline bg=magenta
+difftext     bg=red attr=bold
+`,
+	"gruvbox": `
+normal       fg=#ebdbb2 bg=#282828
+keyword      fg=#fb4934
+type         fg=#fabd2f
+string       fg=#b8bb26
+comment      fg=#928374 attr=italic
+number       fg=#d3869b
+search       fg=#282828 bg=#fabd2f
+linenr       fg=#7c6f64
+cursorlinenr fg=#fabd2f
+cursorline   bg=#3c3836
+statusline   fg=#ebdbb2 bg=#504945
+nontext      fg=#665c54
+selection    bg=#504945
+diffadd      fg=#b8bb26
+diffchange   fg=#8ec07c
+diffdelete   fg=#fb4934
+error        fg=#fb4934 attr=bold
+special      fg=#83a598
+whitespace   fg=#504945
+trailing     bg=#cc241d
+cursorcolumn bg=#3c3836
+colorcolumn  bg=#3c3836
+matchparen   bg=#665c54 attr=bold
+folded       fg=#928374 bg=#3c3836
+diagerror    fg=#fb4934
+diagwarn     fg=#fabd2f
+diaginfo     fg=#83a598
+popup        fg=#ebdbb2 bg=#504945
+statuslinenc fg=#a89984 bg=#3c3836
+diffaddline  bg=#34381b
+diffchangeline bg=#0e363e
+difftext     bg=#5b4a1c attr=bold
+`,
+	"solarized": `
+normal       fg=#657b83 bg=#fdf6e3
+keyword      fg=#859900
+type         fg=#b58900
+string       fg=#2aa198
+comment      fg=#93a1a1 attr=italic
+number       fg=#d33682
+search       fg=#fdf6e3 bg=#b58900
+linenr       fg=#93a1a1
+cursorlinenr fg=#586e75 attr=bold
+cursorline   bg=#eee8d5
+statusline   fg=#586e75 bg=#eee8d5 attr=bold
+nontext      fg=#93a1a1
+selection    bg=#e4ddc8
+diffadd      fg=#859900
+diffchange   fg=#b58900
+diffdelete   fg=#dc322f
+error        fg=#dc322f attr=bold
+special      fg=#268bd2
+whitespace   fg=#93a1a1
+trailing     bg=#dc322f
+cursorcolumn bg=#eee8d5
+colorcolumn  bg=#eee8d5
+matchparen   bg=#93a1a1 attr=bold
+folded       fg=#586e75 bg=#eee8d5
+diagerror    fg=#dc322f
+diagwarn     fg=#b58900
+diaginfo     fg=#268bd2
+popup        fg=#586e75 bg=#eee8d5
+statuslinenc fg=#93a1a1 bg=#eee8d5
+diffaddline  bg=#e5edc4
+diffchangeline bg=#f3e9c6
+difftext     bg=#ecd59a attr=bold
+`,
+	"mono": `
+keyword      attr=bold
+comment      attr=italic
+search       attr=reverse
+cursorlinenr attr=bold
+cursorline   attr=underline
+statusline   attr=reverse
+selection    attr=reverse
+diffadd      attr=bold
+diffdelete   attr=underline
+error        attr=reverse,bold
+special      attr=bold,underline
+trailing     attr=reverse
+cursorcolumn attr=reverse
+colorcolumn  attr=underline
+matchparen   attr=bold,underline
+folded       attr=bold
+diagerror    attr=bold
+diagwarn     attr=underline
+diaginfo     attr=italic
+popup        attr=reverse
+statuslinenc attr=underline
+diffaddline  attr=bold
+diffchangeline attr=underline
+difftext     attr=reverse
+`,
+}
+
+var colorNames = map[string]int{
+	"black": 0, "red": 1, "green": 2, "yellow": 3, "blue": 4, "magenta": 5, "cyan": 6, "white": 7,
+	"brightblack": 8, "brightred": 9, "brightgreen": 10, "brightyellow": 11,
+	"brightblue": 12, "brightmagenta": 13, "brightcyan": 14, "brightwhite": 15,
+	"gray": 8, "grey": 8,
+}
+
+func hlStyle(g hlGroup) style {
+	return themeStyles[g]
+}
+
+func editorDetectColorDepth() int {
+	colorterm := strings.ToLower(os.Getenv("COLORTERM"))
+	if colorterm == "truecolor" || colorterm == "24bit" || os.Getenv("WT_SESSION") != "" {
+		return COLORS_TRUE
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return COLORS_256
+	}
+	return COLORS_16
+}
+
+func parseColor(value string) (color, error) {
+	value = strings.ToLower(value)
+	if value == "" || value == "none" || value == "default" {
+		return 0, nil
+	}
+	if n, ok := colorNames[value]; ok {
+		return indexedColor(n), nil
+	}
+	if strings.HasPrefix(value, "#") && len(value) == 7 {
+		rgb, err := strconv.ParseUint(value[1:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("bad color %q", value)
+		}
+		return colorRGB | color(rgb), nil
+	}
+	if n, err := strconv.Atoi(value); err == nil && n >= 0 && n < 256 {
+		return indexedColor(n), nil
+	}
+	return 0, fmt.Errorf("bad color %q", value)
+}
+
+// parseTheme reads lines like `keyword fg=#fb4934 bg=236 attr=bold,italic`
+func parseTheme(text string) ([HG_COUNT]style, error) {
+	var spec [HG_COUNT]style
+
+	sc := bufio.NewScanner(strings.NewReader(text))
+	for lineno := 1; sc.Scan(); lineno++ {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		g := hlGroup(-1)
+		for i, name := range hlGroupNames {
+			if name == fields[0] {
+				g = hlGroup(i)
+			}
+		}
+		if g < 0 {
+			return spec, fmt.Errorf("line %d: unknown group %q", lineno, fields[0])
+		}
+
+		var st style
+		for _, field := range fields[1:] {
+			key, value, _ := strings.Cut(field, "=")
+			var err error
+			switch key {
+			case "fg":
+				st.fg, err = parseColor(value)
+			case "bg":
+				st.bg, err = parseColor(value)
+			case "attr":
+				for _, a := range strings.Split(value, ",") {
+					switch a {
+					case "bold":
+						st.attr |= attrBold
+					case "underline":
+						st.attr |= attrUnderline
+					case "reverse":
+						st.attr |= attrReverse
+					case "italic":
+						st.attr |= attrItalic
+					case "none", "":
+					default:
+						err = fmt.Errorf("unknown attr %q", a)
+					}
+				}
+			default:
+				err = fmt.Errorf("unknown key %q", key)
+			}
+			if err != nil {
+				return spec, fmt.Errorf("line %d: %v", lineno, err)
+			}
+		}
+		spec[g] = st
+	}
+	return spec, sc.Err()
+}
+
+// editorLoadTheme looks for a user theme first so the bundled ones can be overridden
+func editorLoadTheme(name string) error {
+	text, ok := THEMES[name]
+	if dir := editorConfigDir(); dir != "" {
+		if data, err := os.ReadFile(filepath.Join(dir, "themes", name+".theme")); err == nil {
+			text, ok = string(data), true
+		}
+	}
+	if !ok {
+		return fmt.Errorf("theme not found: %s", name)
+	}
+
+	spec, err := parseTheme(text)
+	if err != nil {
+		return fmt.Errorf("theme %s: %v", name, err)
+	}
+	themeSpec = spec
+	THEME = name
+	editorApplyTheme()
+	return nil
+}
+
+// editorApplyTheme fits the theme to the terminal. groups without their own
+// colors take the ones of normal, so a theme background covers the whole screen
+func editorApplyTheme() {
+	normal := themeSpec[HG_NORMAL]
+	for g := range themeSpec {
+		st := themeSpec[g]
+		if st.fg == 0 {
+			st.fg = normal.fg
+		}
+		if st.bg == 0 {
+			st.bg = normal.bg
+		}
+		st.fg = fitColor(st.fg)
+		st.bg = fitColor(st.bg)
+		themeStyles[g] = st
+	}
+	screenInvalidate()
+}
+
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// the usual xterm values for the first 16 colors
+var ansiRGB = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+func paletteRGB(n int) (int, int, int) {
+	if n < 16 {
+		return ansiRGB[n][0], ansiRGB[n][1], ansiRGB[n][2]
+	} else if n < 232 {
+		n -= 16
+		return cubeLevels[n/36], cubeLevels[(n/6)%6], cubeLevels[n%6]
+	}
+	gray := 8 + (n-232)*10
+	return gray, gray, gray
+}
+
+func colorDistance(r1, g1, b1, r2, g2, b2 int) int {
+	return (r1-r2)*(r1-r2) + (g1-g2)*(g1-g2) + (b1-b2)*(b1-b2)
+}
+
+// nearestPalette finds the closest palette entry below limit (16 or 256)
+func nearestPalette(r, g, b, limit int) int {
+	best, bestDist := 0, -1
+	for n := 0; n < limit; n++ {
+		pr, pg, pb := paletteRGB(n)
+		if d := colorDistance(r, g, b, pr, pg, pb); bestDist < 0 || d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// fitColor brings c down to what COLOR_DEPTH can show
+func fitColor(c color) color {
+	switch c &^ 0xffffff {
+	case colorRGB:
+		if COLOR_DEPTH >= COLORS_TRUE {
+			return c
+		}
+		r, g, b := int(c>>16)&0xff, int(c>>8)&0xff, int(c)&0xff
+		if COLOR_DEPTH >= COLORS_256 {
+			// the first 16 are often remapped by the terminal's own palette, so stay out of them
+			best, bestDist := 16, -1
+			for n := 16; n < 256; n++ {
+				pr, pg, pb := paletteRGB(n)
+				if d := colorDistance(r, g, b, pr, pg, pb); bestDist < 0 || d < bestDist {
+					best, bestDist = n, d
+				}
+			}
+			return indexedColor(best)
+		}
+		return indexedColor(nearestPalette(r, g, b, 16))
+	case colorIndexed:
+		n := int(c & 0xff)
+		if n >= 16 && COLOR_DEPTH < COLORS_256 {
+			r, g, b := paletteRGB(n)
+			return indexedColor(nearestPalette(r, g, b, 16))
+		}
+	}
+	return c
+}
+
+func editorColorscheme(name string) {
+	if name == "" {
+		editorSetStatusMessage("%s", THEME)
+		return
+	}
+	if err := editorLoadTheme(name); err != nil {
+		editorSetStatusMessage("%s", err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseTheme(t *testing.T) {
+	spec, err := parseTheme(`
+# a comment
+normal  fg=#ebdbb2 bg=235
+keyword fg=red attr=bold,italic
+comment fg=none attr=none
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		g    hlGroup
+		want style
+	}{
+		{HG_NORMAL, style{fg: rgbColor(0xeb, 0xdb, 0xb2), bg: indexedColor(235)}},
+		{HG_KEYWORD, style{fg: indexedColor(1), attr: attrBold | attrItalic}},
+		{HG_COMMENT, style{}},
+	} {
+		if spec[tc.g] != tc.want {
+			t.Errorf("%s is %+v, want %+v", hlGroupNames[tc.g], spec[tc.g], tc.want)
+		}
+	}
+
+	for _, text := range []string{
+		"nosuchgroup fg=red",
+		"normal fg=#12345",
+		"normal fg=256",
+		"normal attr=blink",
+		"normal size=2",
+	} {
+		if _, err := parseTheme("# fine\n" + text); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("%q gave the error %v, want one for line 2", text, err)
+		}
+	}
+}
+
+func TestFitColor(t *testing.T) {
+	depth := COLOR_DEPTH
+	defer func() { COLOR_DEPTH = depth }()
+
+	orange := rgbColor(0xfe, 0x80, 0x19)
+	for _, tc := range []struct {
+		depth   int
+		in, out color
+	}{
+		{COLORS_TRUE, orange, orange},
+		{COLORS_TRUE, indexedColor(208), indexedColor(208)},
+		// the nearest of the cube and the grays, never one of the first 16
+		{COLORS_256, orange, indexedColor(208)},
+		{COLORS_256, rgbColor(255, 0, 0), indexedColor(196)},
+		{COLORS_256, rgbColor(0x80, 0x80, 0x80), indexedColor(244)},
+		{COLORS_256, indexedColor(3), indexedColor(3)},
+		// with 16 the palette ones come down too
+		{COLORS_16, rgbColor(250, 10, 10), indexedColor(9)},
+		{COLORS_16, indexedColor(196), indexedColor(9)},
+		{COLORS_16, indexedColor(232), indexedColor(0)},
+		{COLORS_16, indexedColor(4), indexedColor(4)},
+		{COLORS_16, 0, 0},
+	} {
+		COLOR_DEPTH = tc.depth
+		if got := fitColor(tc.in); got != tc.out {
+			t.Errorf("at depth %d %#x fits to %#x, want %#x", tc.depth, tc.in, got, tc.out)
+		}
+	}
+}
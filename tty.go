@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// keys are read from ttyIn and the screen is drawn on ttyOut. they are the
+// controlling terminal when stdin or stdout is a pipe, so `git diff | goditor -` works
+var (
+	ttyIn  = os.Stdin
+	ttyOut = os.Stdout
+)
+
+func editorOpenTTY() {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		f, err := os.Open(TTY_IN)
+		if err != nil {
+			die("opening terminal for input")
+		}
+		ttyIn = f
+	}
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		f, err := os.OpenFile(TTY_OUT, os.O_WRONLY, 0)
+		if err != nil {
+			die("opening terminal for output")
+		}
+		ttyOut = f
+	}
+}
+
+// editorOpenStdin reads stdin into an unnamed buffer
+func editorOpenStdin() {
+	if E.filename != "" || E.dirty || E.numrows > 0 {
+		editorAddBuffer()
+	}
+	E.undo = undoTree{off: true}
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		editorInsertRow(E.numrows, []byte(sc.Text()))
+	}
+	if err := sc.Err(); err != nil {
+		die(fmt.Sprintf("reading stdin %v", err))
+	}
+	E.undo.off = false
+	E.dirty = false
+}
+
+// editorWriteStdout hands the buffer on when goditor sits in the middle of a pipe
+func editorWriteStdout() {
+	if ttyOut == os.Stdout {
+		return
+	}
+	buf, _ := editorRowToString()
+	io.WriteString(os.Stdout, buf)
+}
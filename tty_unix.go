@@ -0,0 +1,8 @@
+//go:build !windows
+
+package main
+
+const (
+	TTY_IN  = "/dev/tty"
+	TTY_OUT = "/dev/tty"
+)
@@ -0,0 +1,6 @@
+package main
+
+const (
+	TTY_IN  = "CONIN$"
+	TTY_OUT = "CONOUT$"
+)
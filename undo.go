@@ -0,0 +1,371 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const UNDO_FILE_VERSION = 1
+
+var UNDO_LEVELS = 1000
+var UNDO_FILE = true
+
+type undoChange struct {
+	Op  byte   `json:"op"` // 'i' = row inserted, 'd' = row deleted, 's' = row changed, 'x' = bytes spliced in the hex view
+	At  int    `json:"at"`
+	Old []byte `json:"old,omitempty"`
+	New []byte `json:"new,omitempty"`
+}
+
+type undoNode struct {
+	Seq     int          `json:"seq"`
+	Parent  int          `json:"parent"` // 0 is the state the file was opened in
+	Changes []undoChange `json:"changes"`
+	Cx      int          `json:"cx"` // cursor before the change
+	Cy      int          `json:"cy"`
+	Time    time.Time    `json:"time"`
+}
+
+type undoTree struct {
+	nodes   []undoNode
+	seq     int // last seq handed out
+	current int // seq of the node whose state the buffer is in
+	saved   int // seq of the state that was last written to disk
+	pending []undoChange
+	cx, cy  int
+	off     bool // set while loading a file or replaying history
+}
+
+// the on-disk form of an undoTree, see editorWriteUndoFile
+type undoFile struct {
+	Version int        `json:"version"`
+	Path    string     `json:"path"`
+	Hash    string     `json:"hash"`
+	Hex     bool       `json:"hex,omitempty"` // the history is of the hex view, made of 'x' changes
+	Seq     int        `json:"seq"`
+	Current int        `json:"current"`
+	Nodes   []undoNode `json:"nodes"`
+}
+
+func editorUndoRecord(change undoChange) {
+	if E.undo.off {
+		return
+	}
+	if len(E.undo.pending) == 0 {
+		E.undo.cx = E.cx
+		E.undo.cy = E.cy
+	}
+	E.undo.pending = append(E.undo.pending, change)
+}
+
+// editorUndoRow records that row at went from old to whatever it holds now.
+// typing into the same row keeps updating one change instead of piling up new ones
+func editorUndoRow(at int, old []byte) {
+	if E.undo.off {
+		return
+	}
+	n := len(E.undo.pending)
+	if n > 0 && E.undo.pending[n-1].Op == 's' && E.undo.pending[n-1].At == at {
+		E.undo.pending[n-1].New = append([]byte(nil), E.row[at].chars...)
+		return
+	}
+	editorUndoRecord(undoChange{Op: 's', At: at, Old: old, New: append([]byte(nil), E.row[at].chars...)})
+}
+
+// editorUndoCommit closes the pending changes into one undo step
+func editorUndoCommit() {
+	if len(E.undo.pending) == 0 {
+		return
+	}
+	E.undo.seq++
+	E.undo.nodes = append(E.undo.nodes, undoNode{
+		Seq:     E.undo.seq,
+		Parent:  E.undo.current,
+		Changes: E.undo.pending,
+		Cx:      E.undo.cx,
+		Cy:      E.undo.cy,
+		Time:    time.Now(),
+	})
+	E.undo.current = E.undo.seq
+	E.undo.pending = nil
+	editorUndoTrim()
+}
+
+func editorUndoFind(seq int) int {
+	for i := range E.undo.nodes {
+		if E.undo.nodes[i].Seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// editorUndoOnPath reports whether seq is current or one of its ancestors
+func editorUndoOnPath(seq int) bool {
+	for s := E.undo.current; s != 0; {
+		if s == seq {
+			return true
+		}
+		i := editorUndoFind(s)
+		if i < 0 {
+			break
+		}
+		s = E.undo.nodes[i].Parent
+	}
+	return false
+}
+
+// editorUndoTrim drops the oldest steps until we're within UNDO_LEVELS.
+// an old step on the way to the current state becomes the new starting point,
+// anything else goes away together with its children
+func editorUndoTrim() {
+	for len(E.undo.nodes) > UNDO_LEVELS {
+		oldest := E.undo.nodes[0]
+		if oldest.Parent == 0 && editorUndoOnPath(oldest.Seq) {
+			var keep []undoNode
+			for _, n := range E.undo.nodes[1:] {
+				if n.Parent == 0 {
+					continue
+				}
+				if n.Parent == oldest.Seq {
+					n.Parent = 0
+				}
+				keep = append(keep, n)
+			}
+			E.undo.nodes = keep
+			if E.undo.current == oldest.Seq {
+				E.undo.current = 0
+			}
+			if E.undo.saved == oldest.Seq {
+				E.undo.saved = 0
+			}
+		} else {
+			editorUndoDrop(oldest.Seq)
+		}
+		editorUndoPrune()
+	}
+}
+
+func editorUndoDrop(seq int) {
+	var keep []undoNode
+	for _, n := range E.undo.nodes {
+		if n.Seq != seq {
+			keep = append(keep, n)
+		}
+	}
+	E.undo.nodes = keep
+}
+
+// editorUndoPrune removes nodes whose parent no longer exists
+func editorUndoPrune() {
+	for {
+		var keep []undoNode
+		for _, n := range E.undo.nodes {
+			if n.Parent == 0 || editorUndoFind(n.Parent) >= 0 {
+				keep = append(keep, n)
+			}
+		}
+		if len(keep) == len(E.undo.nodes) {
+			return
+		}
+		E.undo.nodes = keep
+	}
+}
+
+func editorUndoSetRow(at int, chars []byte) {
+	E.row[at].chars = append([]byte(nil), chars...)
+	E.row[at].size = len(E.row[at].chars)
+	editorUpdateRow(&E.row[at])
+}
+
+func editorUndoApply(changes []undoChange, reverse bool) {
+	E.undo.off = true
+	if reverse {
+		for i := len(changes) - 1; i >= 0; i-- {
+			ch := changes[i]
+			switch ch.Op {
+			case 'i':
+				editorDelRow(ch.At)
+			case 'd':
+				editorInsertRow(ch.At, append([]byte(nil), ch.Old...))
+			case 's':
+				editorUndoSetRow(ch.At, ch.Old)
+			case 'x':
+				editorHexUndo(ch, true)
+			}
+		}
+	} else {
+		for _, ch := range changes {
+			switch ch.Op {
+			case 'i':
+				editorInsertRow(ch.At, append([]byte(nil), ch.New...))
+			case 'd':
+				editorDelRow(ch.At)
+			case 's':
+				editorUndoSetRow(ch.At, ch.New)
+			case 'x':
+				editorHexUndo(ch, false)
+			}
+		}
+	}
+	E.undo.off = false
+	E.dirty = E.undo.current != E.undo.saved
+}
+
+func editorUndoClampCursor() {
+	if E.cy > E.numrows {
+		E.cy = E.numrows
+	}
+	if E.cy < 0 {
+		E.cy = 0
+	}
+	if E.cy < E.numrows && E.cx > E.row[E.cy].size {
+		E.cx = E.row[E.cy].size
+	} else if E.cy == E.numrows {
+		E.cx = 0
+	}
+}
+
+func editorUndo() {
+	editorUndoCommit()
+	if E.undo.current == 0 {
+		editorSetStatusMessage("already at oldest change")
+		return
+	}
+	node := E.undo.nodes[editorUndoFind(E.undo.current)]
+	E.undo.current = node.Parent
+	editorUndoApply(node.Changes, true)
+	E.cx = node.Cx
+	E.cy = node.Cy
+	editorUndoClampCursor()
+	editorSetStatusMessage("undo: %d changes, before #%d", len(node.Changes), node.Seq)
+}
+
+// editorRedo follows the newest branch out of the current state
+func editorRedo() {
+	editorUndoCommit()
+	next := -1
+	for i, n := range E.undo.nodes {
+		if n.Parent == E.undo.current {
+			next = i
+		}
+	}
+	if next < 0 {
+		editorSetStatusMessage("already at newest change")
+		return
+	}
+	node := E.undo.nodes[next]
+	E.undo.current = node.Seq
+	editorUndoApply(node.Changes, false)
+	E.cy = node.Changes[0].At
+	E.cx = 0
+	editorUndoClampCursor()
+	editorSetStatusMessage("redo: %d changes, after #%d", len(node.Changes), node.Seq)
+}
+
+func editorStateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "goditor")
+}
+
+// editorUndoFilePath names the undo file after the absolute path of the file it belongs to
+func editorUndoFilePath(filename string) string {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return ""
+	}
+	dir := editorStateDir()
+	if dir == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, "undo", hex.EncodeToString(sum[:16])+".json")
+}
+
+// editorWriteUndoFile is called after a save, so the history is keyed by what's on disk now
+func editorWriteUndoFile() {
+	if !UNDO_FILE || E.filename == "" {
+		return
+	}
+	path := editorUndoFilePath(E.filename)
+	if path == "" {
+		return
+	}
+	abs, _ := filepath.Abs(E.filename)
+
+	data, err := json.Marshal(undoFile{
+		Version: UNDO_FILE_VERSION,
+		Path:    abs,
+		Hash:    hex.EncodeToString(E.file_hash[:]),
+		Hex:     E.hex,
+		Seq:     E.undo.seq,
+		Current: E.undo.current,
+		Nodes:   E.undo.nodes,
+	})
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// editorReadUndoFile restores the history only if the file is still byte for byte
+// what it was when the history was written, and is shown the same way, as text or hex
+func editorReadUndoFile() {
+	if !UNDO_FILE || E.filename == "" {
+		return
+	}
+	path := editorUndoFilePath(E.filename)
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	var uf undoFile
+	if err := json.Unmarshal(data, &uf); err != nil {
+		return
+	}
+	abs, _ := filepath.Abs(E.filename)
+	if uf.Version != UNDO_FILE_VERSION || uf.Path != abs || uf.Hash != hex.EncodeToString(E.file_hash[:]) {
+		return
+	}
+	// nor if it was made in the other view, whose changes can't be applied to this one
+	if uf.Hex != E.hex {
+		return
+	}
+	for _, n := range uf.Nodes {
+		for _, ch := range n.Changes {
+			if (ch.Op == 'x') != E.hex {
+				return
+			}
+		}
+	}
+
+	E.undo = undoTree{
+		nodes:   uf.Nodes,
+		seq:     uf.Seq,
+		current: uf.Current,
+		saved:   uf.Current,
+	}
+	editorUndoTrim()
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testHexHistory edits name in the hex view and saves it with its history
+func testHexHistory(t *testing.T, name string) {
+	t.Helper()
+	testBuffers(t)
+	editorOpen(name)
+	if !E.hex {
+		editorHexToggle()
+	}
+	editorHexSplice(0, 1, []byte("A"))
+	editorUndoCommit()
+	if err := os.WriteFile(name, E.data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorRecordFileStat()
+	editorWriteUndoFile()
+}
+
+func TestUndoFileOfTheOtherView(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	name := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(name, []byte("abc\ndef\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testHexHistory(t, name)
+
+	// the file is text, so it opens as text and the hex history doesn't apply
+	testBuffers(t)
+	editorOpen(name)
+	if E.hex || len(E.undo.nodes) != 0 {
+		t.Fatalf("opened with hex %v and %d undo steps, want text and none", E.hex, len(E.undo.nodes))
+	}
+	editorUndo()
+	if got := string(E.row[0].chars); got != "Abc" {
+		t.Errorf("row 0 is %q after u, want Abc", got)
+	}
+}
+
+func TestUndoFileOfHexView(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	name := filepath.Join(t.TempDir(), "a.bin")
+	if err := os.WriteFile(name, []byte("x\x00\x01"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testHexHistory(t, name)
+
+	testBuffers(t)
+	editorOpen(name)
+	if !E.hex || len(E.undo.nodes) != 1 {
+		t.Fatalf("opened with hex %v and %d undo steps, want hex and one", E.hex, len(E.undo.nodes))
+	}
+	editorUndo()
+	if string(E.data) != "x\x00\x01" {
+		t.Errorf("data is %q after u", E.data)
+	}
+}
+
+// testUndoStep replaces row 0 with s as one undo step
+func testUndoStep(s string) {
+	old := append([]byte(nil), E.row[0].chars...)
+	editorUndoSetRow(0, []byte(s))
+	editorUndoRow(0, old)
+	editorUndoCommit()
+}
+
+// testUndoSeqs is the seq and parent of every step
+func testUndoSeqs() [][2]int {
+	var seqs [][2]int
+	for _, n := range E.undo.nodes {
+		seqs = append(seqs, [2]int{n.Seq, n.Parent})
+	}
+	return seqs
+}
+
+func TestUndoTrim(t *testing.T) {
+	levels := UNDO_LEVELS
+	defer func() { UNDO_LEVELS = levels }()
+	UNDO_LEVELS = 2
+
+	// the oldest step is on the way to the current state, so it becomes the
+	// start, and its other branch goes with it
+	testBuffers(t, "0")
+	testUndoStep("1")
+	testUndoStep("2")
+	editorUndo()
+	testUndoStep("3")
+	if got := testUndoSeqs(); !slices.Equal(got, [][2]int{{2, 0}, {3, 0}}) {
+		t.Errorf("steps are %v, want 2 and 3 starting from the state after 1", got)
+	}
+	editorUndo()
+	editorUndo()
+	if got := string(E.row[0].chars); got != "1" || E.undo.current != 0 {
+		t.Errorf("undoing everything left %q at step %d, want 1", got, E.undo.current)
+	}
+
+	// off the way, the oldest step goes together with everything after it
+	UNDO_LEVELS = 3
+	testBuffers(t, "0")
+	testUndoStep("1")
+	testUndoStep("2")
+	editorUndo()
+	editorUndo()
+	testUndoStep("3")
+	testUndoStep("4")
+	if got := testUndoSeqs(); !slices.Equal(got, [][2]int{{3, 0}, {4, 3}}) {
+		t.Errorf("steps are %v, want the branch of 1 gone", got)
+	}
+	editorUndo()
+	editorUndo()
+	if got := string(E.row[0].chars); got != "0" {
+		t.Errorf("undoing everything left %q, want 0", got)
+	}
+}
+
+func TestUndoBranches(t *testing.T) {
+	testBuffers(t, "0")
+	testUndoStep("1")
+	editorUndo()
+	testUndoStep("2")
+	editorUndo()
+	// redo takes the newest branch, and undo comes back along whichever was taken
+	editorRedo()
+	if got := string(E.row[0].chars); got != "2" || !E.dirty {
+		t.Errorf("redo went to %q", got)
+	}
+	editorUndo()
+	if got := string(E.row[0].chars); got != "0" || E.undo.current != 0 {
+		t.Errorf("undo went to %q at step %d", got, E.undo.current)
+	}
+	if got := testUndoSeqs(); !slices.Equal(got, [][2]int{{1, 0}, {2, 0}}) {
+		t.Errorf("steps are %v, want two branches from the start", got)
+	}
+}
+
+func TestUndoFileChecks(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	name := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(name, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testBuffers(t)
+	editorOpen(name)
+	testUndoStep("1")
+	if err := os.WriteFile(name, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorRecordFileStat()
+	editorWriteUndoFile()
+	path := editorUndoFilePath(name)
+	saved, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rewrite writes the undo file again with change made to it
+	rewrite := func(change func(uf *undoFile)) {
+		var uf undoFile
+		if err := json.Unmarshal(saved, &uf); err != nil {
+			t.Fatal(err)
+		}
+		change(&uf)
+		data, _ := json.Marshal(uf)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tc := range []struct {
+		name   string
+		change func(uf *undoFile)
+		steps  int
+	}{
+		{"as it was written", func(uf *undoFile) {}, 1},
+		{"of another version", func(uf *undoFile) { uf.Version++ }, 0},
+		{"of another file", func(uf *undoFile) { uf.Path += "x" }, 0},
+		{"of other content", func(uf *undoFile) { uf.Hash = strings.Repeat("0", 64) }, 0},
+		{"of the hex view", func(uf *undoFile) { uf.Hex = true }, 0},
+	} {
+		rewrite(tc.change)
+		testBuffers(t)
+		editorOpen(name)
+		if len(E.undo.nodes) != tc.steps {
+			t.Errorf("history %s gave %d steps, want %d", tc.name, len(E.undo.nodes), tc.steps)
+		}
+	}
+
+	// nor does it apply once the file changed behind its back
+	rewrite(func(uf *undoFile) {})
+	if err := os.WriteFile(name, []byte("2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testBuffers(t)
+	editorOpen(name)
+	if len(E.undo.nodes) != 0 {
+		t.Errorf("history of a file that changed since gave %d steps", len(E.undo.nodes))
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const INFO_VERSION = 1
+
+// how many files we remember positions for
+var INFO_FILES = 200
+
+type mark struct {
+	Cx int `json:"cx"`
+	Cy int `json:"cy"`
+}
+
+type fileInfo struct {
+	Cx    int             `json:"cx"`
+	Cy    int             `json:"cy"`
+	Marks map[string]mark `json:"marks,omitempty"`
+	Time  time.Time       `json:"time"`
+}
+
+// the viminfo of goditor: last cursor position and marks per file and the search history
+type info struct {
+	Version       int                 `json:"version"`
+	Files         map[string]fileInfo `json:"files"`
+	SearchHistory []string            `json:"search_history"`
+}
+
+var fileInfos = map[string]fileInfo{}
+
+func editorInfoPath() string {
+	dir := editorStateDir()
+	if dir == "" {
+		return ""
+	}
+	return filepath.Join(dir, "info.json")
+}
+
+func editorReadInfoFile() info {
+	in := info{Files: map[string]fileInfo{}}
+	path := editorInfoPath()
+	if path == "" {
+		return in
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return in
+	}
+	var read info
+	if err := json.Unmarshal(data, &read); err != nil || read.Version != INFO_VERSION {
+		return in
+	}
+	if read.Files == nil {
+		read.Files = map[string]fileInfo{}
+	}
+	return read
+}
+
+func editorReadInfo() {
+	in := editorReadInfoFile()
+	fileInfos = in.Files
+	if len(searchHistory) == 0 {
+		searchHistory = in.SearchHistory
+	}
+}
+
+// editorRememberBuffer stores where the cursor and marks are in b
+func editorRememberBuffer(b *EditorConfig) {
+	if b.filename == "" || b.buftype != "" {
+		return
+	}
+	abs, err := filepath.Abs(b.filename)
+	if err != nil {
+		return
+	}
+	fi := fileInfo{Cx: b.cx, Cy: b.cy, Time: time.Now()}
+	if len(b.marks) > 0 {
+		fi.Marks = map[string]mark{}
+		for name, m := range b.marks {
+			fi.Marks[string(name)] = m
+		}
+	}
+	fileInfos[abs] = fi
+}
+
+// editorWriteInfo merges what we know into the file on disk, so another
+// goditor that quit in the meantime doesn't lose its entries
+func editorWriteInfo() {
+	path := editorInfoPath()
+	if path == "" {
+		return
+	}
+
+	buffers[curbuf] = E
+	for i := range buffers {
+		editorRememberBuffer(&buffers[i])
+	}
+
+	in := editorReadInfoFile()
+	for name, fi := range fileInfos {
+		if old, ok := in.Files[name]; !ok || old.Time.Before(fi.Time) {
+			in.Files[name] = fi
+		}
+	}
+	if len(in.Files) > INFO_FILES {
+		names := make([]string, 0, len(in.Files))
+		for name := range in.Files {
+			names = append(names, name)
+		}
+		sort.Slice(names, func(i, j int) bool {
+			return in.Files[names[i]].Time.After(in.Files[names[j]].Time)
+		})
+		for _, name := range names[INFO_FILES:] {
+			delete(in.Files, name)
+		}
+	}
+	in.Version = INFO_VERSION
+	in.SearchHistory = searchHistory
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return
+	}
+	os.Rename(tmp, path)
+}
+
+// editorRestorePosition puts the cursor back where it was when the file was last closed
+func editorRestorePosition() {
+	abs, err := filepath.Abs(E.filename)
+	if err != nil {
+		return
+	}
+	fi, ok := fileInfos[abs]
+	if !ok {
+		return
+	}
+
+	E.marks = map[byte]mark{}
+	for name, m := range fi.Marks {
+		if len(name) == 1 {
+			E.marks[name[0]] = m
+		}
+	}
+
+	if E.numrows == 0 {
+		return
+	}
+	E.cy = min(max(fi.Cy, 0), E.numrows-1)
+	E.cx = min(max(fi.Cx, 0), E.row[E.cy].size)
+	E.rowoff = max(E.cy-E.screenrows/2, 0)
+}
+
+func editorSetMark(name byte) {
+	if name < 'a' || name > 'z' {
+		editorSetStatusMessage("invalid mark: %c", name)
+		return
+	}
+	if E.marks == nil {
+		E.marks = map[byte]mark{}
+	}
+	E.marks[name] = mark{Cx: E.cx, Cy: E.cy}
+}
+
+// editorJumpMark goes to a mark, to the start of its line if exact is false
+func editorJumpMark(name byte, exact bool) {
+	m, ok := E.marks[name]
+	if !ok {
+		editorSetStatusMessage("mark not set: %c", name)
+		return
+	}
+	if E.numrows == 0 {
+		return
+	}
+	E.cy = min(max(m.Cy, 0), E.numrows-1)
+	E.cx = 0
+	if exact {
+		E.cx = min(max(m.Cx, 0), E.row[E.cy].size)
+	}
+}
+
+// editorShiftMarks keeps marks on their lines when rows are inserted (n > 0) or deleted (n < 0) at at
+func editorShiftMarks(at, n int) {
+	for name, m := range E.marks {
+		if m.Cy > at || (n > 0 && m.Cy == at) {
+			m.Cy = max(m.Cy+n, at)
+			E.marks[name] = m
+		}
+	}
+}
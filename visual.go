@@ -0,0 +1,69 @@
+package main
+
+// editorStartVisual enters visual mode with the selection anchored at the cursor.
+// pressing the same v/V again leaves it, the other one switches kind
+func editorStartVisual(mode byte) {
+	if E.mode == mode {
+		E.mode = NORMAL
+		return
+	}
+	if E.mode == NORMAL {
+		E.vx, E.vy = E.cx, E.cy
+	}
+	E.mode = mode
+}
+
+func editorInVisual() bool {
+	return E.mode == VISUAL || E.mode == VISUAL_LINE
+}
+
+// editorSelection returns the selected range in chars coordinates, start before end.
+// both ends are included, like in vim
+func editorSelection() (sy, sx, ey, ex int) {
+	sy, sx, ey, ex = E.vy, E.vx, E.cy, E.cx
+	if ey < sy || (ey == sy && ex < sx) {
+		sy, sx, ey, ex = ey, ex, sy, sx
+	}
+	if E.mode == VISUAL_LINE {
+		sx = 0
+		ex = 0
+		if ey < E.numrows {
+			ex = max(E.row[ey].size-1, 0)
+		}
+	}
+	return sy, sx, ey, ex
+}
+
+// editorSelectionSize is the number of selected lines when the selection spans
+// more than one, otherwise the number of selected characters
+func editorSelectionSize() (n int, lines bool) {
+	if !editorInVisual() {
+		return 0, false
+	}
+	sy, sx, ey, ex := editorSelection()
+	if sy != ey || E.mode == VISUAL_LINE {
+		return ey - sy + 1, true
+	}
+	return ex - sx + 1, false
+}
+
+// editorSelectedRx tells whether render column rx of row is inside the selection
+func editorSelectedRx(row *erow, rx int) bool {
+	if !editorInVisual() {
+		return false
+	}
+	sy, sx, ey, ex := editorSelection()
+	if row.idx < sy || row.idx > ey {
+		return false
+	}
+	if E.mode == VISUAL_LINE {
+		return true
+	}
+	if row.idx == sy && rx < editorRowCxToRx(row, sx) {
+		return false
+	}
+	if row.idx == ey && rx >= editorRowCxToRx(row, min(ex+1, row.size)) && ex < row.size {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestVisualOperators(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		lines    []string
+		keys     string
+		want     []string
+		register []string
+		linewise bool
+		cy, cx   int
+	}{
+		{"charwise d", []string{"hello world"}, "lvlld", []string{"ho world"}, []string{"ell"}, false, 0, 1},
+		{"charwise d backwards", []string{"hello world"}, "llllvhhd", []string{"he world"}, []string{"llo"}, false, 0, 2},
+		{"charwise d over rows", []string{"abc", "def"}, "lvjd", []string{"af"}, []string{"bc", "de"}, false, 0, 1},
+		{"charwise y", []string{"hello world"}, "wvey", []string{"hello world"}, []string{"world"}, false, 0, 6},
+		{"charwise c", []string{"hello world"}, "vecbye\x1b", []string{"bye world"}, []string{"hello"}, false, 0, 3},
+		{"linewise d", []string{"one", "two", "three"}, "Vjd", []string{"three"}, []string{"one", "two"}, true, 0, 0},
+		{"linewise y", []string{"one", "two", "three"}, "jVjy", []string{"one", "two", "three"}, []string{"two", "three"}, true, 1, 0},
+		{"linewise c", []string{"one", "two", "three"}, "jVcnew\x1b", []string{"one", "new", "three"}, []string{"two"}, true, 1, 3},
+		// v then V makes the same selection linewise
+		{"v then V", []string{"one", "two"}, "lvVd", []string{"two"}, []string{"one"}, true, 0, 0},
+	} {
+		testBuffers(t, tc.lines...)
+		testKeys(t, tc.keys)
+		if got := editorBufferLines(); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: lines are %q, want %q", tc.name, got, tc.want)
+		}
+		if !slices.Equal(register.lines, tc.register) || register.linewise != tc.linewise {
+			t.Errorf("%s: register has %q linewise %v, want %q linewise %v", tc.name, register.lines, register.linewise, tc.register, tc.linewise)
+		}
+		if E.mode != NORMAL || E.cy != tc.cy || E.cx != tc.cx {
+			t.Errorf("%s: ended in mode %d at %d,%d, want NORMAL at %d,%d", tc.name, E.mode, E.cy, E.cx, tc.cy, tc.cx)
+		}
+	}
+}
+
+func TestVisualSelection(t *testing.T) {
+	testBuffers(t, "hello", "world")
+	testKeys(t, "llvj")
+	if sy, sx, ey, ex := editorSelection(); sy != 0 || sx != 2 || ey != 1 || ex != 2 {
+		t.Errorf("selection is %d,%d to %d,%d, want 0,2 to 1,2", sy, sx, ey, ex)
+	}
+	if n, lines := editorSelectionSize(); n != 2 || !lines {
+		t.Errorf("selection size is %d lines %v, want 2 lines", n, lines)
+	}
+	if editorSelectedRx(&E.row[0], 1) || !editorSelectedRx(&E.row[0], 4) || !editorSelectedRx(&E.row[1], 2) || editorSelectedRx(&E.row[1], 3) {
+		t.Error("the wrong columns are selected")
+	}
+	// the same key again leaves it
+	testKeys(t, "v")
+	if E.mode != NORMAL || editorSelectedRx(&E.row[0], 4) {
+		t.Errorf("v in visual mode left mode %d", E.mode)
+	}
+}
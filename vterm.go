@@ -0,0 +1,625 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// a cell of the emulated terminal, ch is 0 where nothing was written and
+// vtWide in the right half of a wide character. combining marks written
+// after a character go in its marks
+type vcell struct {
+	ch    rune
+	marks string
+	style style
+}
+
+const vtWide rune = -1
+
+// vterm is enough of a VT100/xterm to run a shell and the usual full screen
+// programs in. it takes what the child writes and keeps the grid of cells,
+// the cursor and the lines that scrolled off the top
+type vterm struct {
+	w, h       int
+	lines      [][]vcell // the screen, h rows of w cells
+	saved      [][]vcell // the main screen while the alternate one is shown
+	scrollback [][]vcell
+	sbTotal    int // lines that ever went into the scrollback, some may be dropped since
+
+	cx, cy      int
+	wrapnext    bool // the cursor is past the last column, the next character wraps
+	style       style
+	top, bottom int // the scroll region, bottom not included
+	savedX      int
+	savedY      int
+	savedStyle  style
+	autowrap    bool
+	cursorKeys  bool // arrows send ESC O A instead of ESC [ A
+	hideCursor  bool
+
+	// the parser
+	state  int
+	params []byte
+	osc    []byte
+	utf    []byte // a character that's only partly arrived
+
+	reply []byte // what the terminal answers, like cursor position reports, for the child
+}
+
+const (
+	vtGround = iota
+	vtEscape
+	vtCSI
+	vtOSC
+	vtOSCEscape
+	vtCharset // the character set after ESC ( and friends, which is ignored
+)
+
+func newVterm(w, h int) *vterm {
+	t := &vterm{w: max(w, 1), h: max(h, 1), autowrap: true}
+	t.lines = t.blankLines(t.h)
+	t.top, t.bottom = 0, t.h
+	return t
+}
+
+func (t *vterm) blankLine() []vcell {
+	line := make([]vcell, t.w)
+	for i := range line {
+		line[i].style.bg = t.style.bg
+	}
+	return line
+}
+
+func (t *vterm) blankLines(n int) [][]vcell {
+	lines := make([][]vcell, n)
+	for i := range lines {
+		lines[i] = t.blankLine()
+	}
+	return lines
+}
+
+// takeReply returns what the terminal has to say back to the child
+func (t *vterm) takeReply() []byte {
+	r := t.reply
+	t.reply = nil
+	return r
+}
+
+// feed runs what the child wrote through the terminal
+func (t *vterm) feed(b []byte) {
+	for _, c := range b {
+		switch t.state {
+		case vtGround:
+			t.ground(c)
+		case vtEscape:
+			t.escape(c)
+		case vtCSI:
+			switch {
+			case c >= 0x40 && c <= 0x7e:
+				t.state = vtGround
+				t.csi(c)
+			case c < 0x20:
+				t.control(c)
+			default:
+				t.params = append(t.params, c)
+			}
+		case vtOSC:
+			switch c {
+			case 7:
+				t.state = vtGround
+			case 0x1b:
+				t.state = vtOSCEscape
+			default:
+				if len(t.osc) < 4096 {
+					t.osc = append(t.osc, c)
+				}
+			}
+		case vtOSCEscape:
+			// ESC \ ends the string, anything else starts a new sequence
+			t.state = vtGround
+			if c != '\\' {
+				t.escape(c)
+			}
+		case vtCharset:
+			t.state = vtGround
+		}
+	}
+}
+
+func (t *vterm) ground(c byte) {
+	if c < 0x80 {
+		t.utf = t.utf[:0]
+		if c < 0x20 || c == 0x7f {
+			t.control(c)
+		} else {
+			t.print(rune(c))
+		}
+		return
+	}
+	t.utf = append(t.utf, c)
+	if utf8.FullRune(t.utf) {
+		r, _ := utf8.DecodeRune(t.utf)
+		t.utf = t.utf[:0]
+		if r >= 0x80 && r < 0xa0 {
+			// a C1 control is the same as ESC and the character 0x40 below it,
+			// 0x9b is ESC [
+			t.escape(byte(r - 0x40))
+		} else {
+			t.print(r)
+		}
+	} else if len(t.utf) >= utf8.UTFMax {
+		t.utf = t.utf[:0]
+		t.print(utf8.RuneError)
+	}
+}
+
+func (t *vterm) control(c byte) {
+	switch c {
+	case '\b':
+		t.moveTo(t.cx-1, t.cy)
+	case '\t':
+		t.moveTo(min((t.cx/8+1)*8, t.w-1), t.cy)
+	case '\n', '\v', '\f':
+		t.lineFeed()
+	case '\r':
+		t.moveTo(0, t.cy)
+	case 0x1b:
+		t.state = vtEscape
+	}
+}
+
+func (t *vterm) print(r rune) {
+	if !runePrintable(r) {
+		return
+	}
+	w := runeWidth(r)
+	if w == 0 {
+		// a combining mark goes on the character before the cursor
+		line, x := t.lines[t.cy], t.cx
+		if !t.wrapnext {
+			x--
+		}
+		if x > 0 && line[x].ch == vtWide {
+			x--
+		}
+		if x >= 0 && line[x].ch > 0 {
+			line[x].marks += string(r)
+		}
+		return
+	}
+	if w > t.w {
+		return
+	}
+	if t.wrapnext && t.autowrap {
+		t.cx = 0
+		t.lineFeed()
+	}
+	t.wrapnext = false
+	if t.cx+w > t.w {
+		// a wide character doesn't fit in the last column
+		if t.autowrap {
+			t.eraseLine(t.cy, t.cx, t.w)
+			t.cx = 0
+			t.lineFeed()
+		} else {
+			t.cx = t.w - w
+		}
+	}
+	t.splitWide(t.cy, t.cx)
+	t.splitWide(t.cy, t.cx+w)
+	line := t.lines[t.cy]
+	line[t.cx] = vcell{ch: r, style: t.style}
+	if w == 2 {
+		line[t.cx+1] = vcell{ch: vtWide, style: t.style}
+	}
+	if t.cx+w == t.w {
+		t.cx = t.w - 1
+		t.wrapnext = true
+	} else {
+		t.cx += w
+	}
+}
+
+// splitWide blanks the wide character on line y that x falls in the middle
+// of, when only half of it is about to be overwritten or moved
+func (t *vterm) splitWide(y, x int) {
+	line := t.lines[y]
+	if x > 0 && x < t.w && line[x].ch == vtWide {
+		line[x-1] = vcell{style: style{bg: line[x-1].style.bg}}
+		line[x] = vcell{style: style{bg: line[x].style.bg}}
+	}
+}
+
+// moveTo puts the cursor at x, y, kept on the screen
+func (t *vterm) moveTo(x, y int) {
+	t.cx = max(min(x, t.w-1), 0)
+	t.cy = max(min(y, t.h-1), 0)
+	t.wrapnext = false
+}
+
+func (t *vterm) lineFeed() {
+	if t.cy == t.bottom-1 {
+		t.scrollUp(1, true)
+	} else if t.cy < t.h-1 {
+		t.cy++
+	}
+}
+
+// scrollUp moves the lines of the scroll region up n. with keep what goes
+// off the top of the whole main screen is kept in the scrollback
+func (t *vterm) scrollUp(n int, keep bool) {
+	n = min(n, t.bottom-t.top)
+	if keep && t.top == 0 && t.bottom == t.h && t.saved == nil {
+		t.scrollback = append(t.scrollback, t.lines[:n]...)
+		t.sbTotal += n
+		if over := len(t.scrollback) - TERMINAL_SCROLLBACK; over > 0 {
+			t.scrollback = append([][]vcell(nil), t.scrollback[over:]...)
+		}
+	}
+	region := t.lines[t.top:t.bottom]
+	copy(region, region[n:])
+	for i := len(region) - n; i < len(region); i++ {
+		region[i] = t.blankLine()
+	}
+}
+
+func (t *vterm) scrollDown(n int) {
+	n = min(n, t.bottom-t.top)
+	region := t.lines[t.top:t.bottom]
+	copy(region[n:], region)
+	for i := 0; i < n; i++ {
+		region[i] = t.blankLine()
+	}
+}
+
+func (t *vterm) escape(c byte) {
+	t.state = vtGround
+	switch c {
+	case '[':
+		t.state = vtCSI
+		t.params = t.params[:0]
+	case ']':
+		t.state = vtOSC
+		t.osc = t.osc[:0]
+	case '(', ')', '*', '+', '#':
+		t.state = vtCharset
+	case '7':
+		t.saveCursor()
+	case '8':
+		t.restoreCursor()
+	case 'D':
+		t.lineFeed()
+	case 'E':
+		t.cx = 0
+		t.lineFeed()
+	case 'M':
+		if t.cy == t.top {
+			t.scrollDown(1)
+		} else {
+			t.moveTo(t.cx, t.cy-1)
+		}
+	case 'c':
+		t.reset()
+	}
+}
+
+func (t *vterm) saveCursor() {
+	t.savedX, t.savedY, t.savedStyle = t.cx, t.cy, t.style
+}
+
+func (t *vterm) restoreCursor() {
+	t.moveTo(t.savedX, t.savedY)
+	t.style = t.savedStyle
+}
+
+func (t *vterm) reset() {
+	t.style = style{}
+	t.lines = t.blankLines(t.h)
+	t.saved = nil
+	t.top, t.bottom = 0, t.h
+	t.autowrap, t.cursorKeys, t.hideCursor = true, false, false
+	t.moveTo(0, 0)
+}
+
+// csi runs a control sequence: ESC [ params final
+func (t *vterm) csi(final byte) {
+	raw := string(t.params)
+	private := ""
+	if raw != "" && strings.ContainsRune("?>=", rune(raw[0])) {
+		private, raw = raw[:1], raw[1:]
+	}
+	var params []int
+	for _, p := range strings.Split(raw, ";") {
+		n, _ := strconv.Atoi(p)
+		params = append(params, n)
+	}
+	// the first parameter, 0 or missing meaning 1
+	arg := func(i int) int {
+		if i < len(params) && params[i] > 0 {
+			return params[i]
+		}
+		return 1
+	}
+
+	switch final {
+	case '@':
+		line := t.lines[t.cy]
+		n := min(arg(0), t.w-t.cx)
+		t.splitWide(t.cy, t.cx)
+		t.splitWide(t.cy, t.w-n)
+		copy(line[t.cx+n:], line[t.cx:])
+		for i := t.cx; i < t.cx+n; i++ {
+			line[i] = vcell{style: style{bg: t.style.bg}}
+		}
+	case 'A':
+		t.moveTo(t.cx, t.cy-arg(0))
+	case 'B', 'e':
+		t.moveTo(t.cx, t.cy+arg(0))
+	case 'C', 'a':
+		t.moveTo(t.cx+arg(0), t.cy)
+	case 'D':
+		t.moveTo(t.cx-arg(0), t.cy)
+	case 'E':
+		t.moveTo(0, t.cy+arg(0))
+	case 'F':
+		t.moveTo(0, t.cy-arg(0))
+	case 'G', '`':
+		t.moveTo(arg(0)-1, t.cy)
+	case 'H', 'f':
+		t.moveTo(arg(1)-1, arg(0)-1)
+	case 'd':
+		t.moveTo(t.cx, arg(0)-1)
+	case 'J':
+		switch params[0] {
+		case 0:
+			t.eraseLine(t.cy, t.cx, t.w)
+			for y := t.cy + 1; y < t.h; y++ {
+				t.eraseLine(y, 0, t.w)
+			}
+		case 1:
+			t.eraseLine(t.cy, 0, t.cx+1)
+			for y := 0; y < t.cy; y++ {
+				t.eraseLine(y, 0, t.w)
+			}
+		case 2, 3:
+			for y := 0; y < t.h; y++ {
+				t.eraseLine(y, 0, t.w)
+			}
+			if params[0] == 3 {
+				t.scrollback = nil
+			}
+		}
+	case 'K':
+		switch params[0] {
+		case 0:
+			t.eraseLine(t.cy, t.cx, t.w)
+		case 1:
+			t.eraseLine(t.cy, 0, t.cx+1)
+		case 2:
+			t.eraseLine(t.cy, 0, t.w)
+		}
+	case 'L', 'M':
+		if t.cy < t.top || t.cy >= t.bottom {
+			break
+		}
+		top := t.top
+		t.top = t.cy
+		if final == 'L' {
+			t.scrollDown(arg(0))
+		} else {
+			t.scrollUp(arg(0), false)
+		}
+		t.top = top
+		t.cx = 0
+	case 'P':
+		line := t.lines[t.cy]
+		n := min(arg(0), t.w-t.cx)
+		t.splitWide(t.cy, t.cx)
+		t.splitWide(t.cy, t.cx+n)
+		copy(line[t.cx:], line[t.cx+n:])
+		t.eraseLine(t.cy, t.w-n, t.w)
+	case 'X':
+		t.eraseLine(t.cy, t.cx, min(t.cx+arg(0), t.w))
+	case 'S':
+		t.scrollUp(arg(0), false)
+	case 'T':
+		t.scrollDown(arg(0))
+	case 'm':
+		t.sgr(params)
+	case 'r':
+		top, bottom := arg(0)-1, t.h
+		if len(params) > 1 && params[1] > 0 {
+			bottom = min(params[1], t.h)
+		}
+		if top < bottom-1 {
+			t.top, t.bottom = top, bottom
+			t.moveTo(0, 0)
+		}
+	case 's':
+		if private == "" {
+			t.saveCursor()
+		}
+	case 'u':
+		t.restoreCursor()
+	case 'h', 'l':
+		if private == "?" {
+			for _, p := range params {
+				t.setMode(p, final == 'h')
+			}
+		}
+	case 'n':
+		switch params[0] {
+		case 5:
+			t.reply = append(t.reply, "\x1b[0n"...)
+		case 6:
+			t.reply = append(t.reply, fmt.Sprintf("\x1b[%d;%dR", t.cy+1, t.cx+1)...)
+		}
+	case 'c':
+		switch private {
+		case "":
+			t.reply = append(t.reply, "\x1b[?1;2c"...)
+		case ">":
+			t.reply = append(t.reply, "\x1b[>0;0;0c"...)
+		}
+	}
+}
+
+func (t *vterm) eraseLine(y, from, to int) {
+	t.splitWide(y, from)
+	t.splitWide(y, to)
+	for x := max(from, 0); x < min(to, t.w); x++ {
+		t.lines[y][x] = vcell{style: style{bg: t.style.bg}}
+	}
+}
+
+// setMode is the DEC private modes of CSI ? n h and l
+func (t *vterm) setMode(mode int, on bool) {
+	switch mode {
+	case 1:
+		t.cursorKeys = on
+	case 7:
+		t.autowrap = on
+	case 25:
+		t.hideCursor = !on
+	case 47, 1047, 1049:
+		// the alternate screen, which full screen programs draw on
+		if on && t.saved == nil {
+			if mode == 1049 {
+				t.saveCursor()
+			}
+			t.saved = t.lines
+			t.lines = t.blankLines(t.h)
+		} else if !on && t.saved != nil {
+			t.lines = t.saved
+			t.saved = nil
+			if mode == 1049 {
+				t.restoreCursor()
+			}
+		}
+	}
+}
+
+// sgr sets the style of what's written next
+func (t *vterm) sgr(params []int) {
+	for i := 0; i < len(params); i++ {
+		p := params[i]
+		switch {
+		case p == 0:
+			t.style = style{}
+		case p == 1:
+			t.style.attr |= attrBold
+		case p == 3:
+			t.style.attr |= attrItalic
+		case p == 4:
+			t.style.attr |= attrUnderline
+		case p == 7:
+			t.style.attr |= attrReverse
+		case p == 22:
+			t.style.attr &^= attrBold
+		case p == 23:
+			t.style.attr &^= attrItalic
+		case p == 24:
+			t.style.attr &^= attrUnderline
+		case p == 27:
+			t.style.attr &^= attrReverse
+		case p >= 30 && p <= 37:
+			t.style.fg = indexedColor(p - 30)
+		case p == 39:
+			t.style.fg = 0
+		case p >= 40 && p <= 47:
+			t.style.bg = indexedColor(p - 40)
+		case p == 49:
+			t.style.bg = 0
+		case p >= 90 && p <= 97:
+			t.style.fg = indexedColor(p - 90 + 8)
+		case p >= 100 && p <= 107:
+			t.style.bg = indexedColor(p - 100 + 8)
+		case p == 38 || p == 48:
+			// 38;5;n for the palette, 38;2;r;g;b for true color
+			var c color
+			if i+2 < len(params) && params[i+1] == 5 {
+				c = indexedColor(params[i+2] & 0xff)
+				i += 2
+			} else if i+4 < len(params) && params[i+1] == 2 {
+				c = rgbColor(params[i+2]&0xff, params[i+3]&0xff, params[i+4]&0xff)
+				i += 4
+			} else {
+				return
+			}
+			if p == 38 {
+				t.style.fg = c
+			} else {
+				t.style.bg = c
+			}
+		}
+	}
+}
+
+// resize changes the size of the screen. when it gets shorter the lines
+// above the cursor go to the scrollback, so the cursor's line stays
+func (t *vterm) resize(w, h int) {
+	w, h = max(w, 1), max(h, 1)
+	if w == t.w && h == t.h {
+		return
+	}
+	fit := func(lines [][]vcell) [][]vcell {
+		for i, line := range lines {
+			if len(line) > w {
+				if line[w].ch == vtWide {
+					line[w-1] = vcell{style: style{bg: line[w-1].style.bg}}
+				}
+				lines[i] = line[:w]
+			} else if len(line) < w {
+				lines[i] = append(line, make([]vcell, w-len(line))...)
+			}
+		}
+		return lines
+	}
+	t.w = w
+	if n := t.cy + 1 - h; n > 0 {
+		if t.saved == nil {
+			t.scrollback = append(t.scrollback, t.lines[:n]...)
+			t.sbTotal += n
+		}
+		t.lines = t.lines[n:]
+		t.cy -= n
+	}
+	t.lines = fit(t.lines)
+	for len(t.lines) < h {
+		t.lines = append(t.lines, t.blankLine())
+	}
+	t.lines = t.lines[:h]
+	if t.saved != nil {
+		t.saved = fit(t.saved)
+		for len(t.saved) < h {
+			t.saved = append(t.saved, t.blankLine())
+		}
+		t.saved = t.saved[:h]
+	}
+	t.h = h
+	t.top, t.bottom = 0, h
+	t.moveTo(t.cx, t.cy)
+}
+
+// vtLineText is the text of a line of cells without the blanks at its end,
+// though at least the first n cells, so a cursor after blanks is on the text
+func vtLineText(line []vcell, n int) []byte {
+	end := len(line)
+	for end > n && (line[end-1].ch == 0 || line[end-1].ch == ' ') {
+		end--
+	}
+	var b []byte
+	for _, c := range line[:end] {
+		switch c.ch {
+		case 0:
+			b = append(b, ' ')
+		case vtWide:
+		default:
+			b = utf8.AppendRune(b, c.ch)
+			b = append(b, c.marks...)
+		}
+	}
+	return b
+}
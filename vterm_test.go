@@ -0,0 +1,114 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+// testVtLines is the text of each line of the screen
+func testVtLines(vt *vterm) []string {
+	var lines []string
+	for _, line := range vt.lines {
+		lines = append(lines, string(vtLineText(line, 0)))
+	}
+	return lines
+}
+
+func TestVtermSequences(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		w, h   int
+		input  string
+		lines  []string
+		cx, cy int
+	}{
+		{"text", 6, 3, "ab\r\ncd", []string{"ab", "cd", ""}, 2, 1},
+		{"cursor position", 6, 3, "\x1b[2;3Hx", []string{"", "  x", ""}, 3, 1},
+		{"cursor position past the edge", 6, 3, "\x1b[9;9Hx\x1b[Hy", []string{"y", "", "     x"}, 1, 0},
+		{"relative moves", 6, 3, "\x1b[2B\x1b[3Cx\x1b[A\x1b[2Dy", []string{"", "  y", "   x"}, 3, 1},
+		{"wrap", 4, 3, "abcdef", []string{"abcd", "ef", ""}, 2, 1},
+		{"no wrap", 4, 3, "\x1b[?7labcdef", []string{"abcf", "", ""}, 3, 0},
+		{"return in the last column", 4, 3, "abcd\rx", []string{"xbcd", "", ""}, 1, 0},
+		{"scroll region", 4, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[3;1H\n", []string{"1", "3", "", "4"}, 0, 2},
+		{"reverse index at the top of the region", 4, 4, "1\r\n2\r\n3\r\n4\x1b[2;3r\x1b[2;1H\x1bM", []string{"1", "", "2", "4"}, 0, 1},
+		{"insert lines", 4, 3, "1\r\n2\r\n3\x1b[2;1H\x1b[L", []string{"1", "", "2"}, 0, 1},
+		{"erase to the end of the line", 6, 2, "abcdef\x1b[1;3H\x1b[K", []string{"ab", ""}, 2, 0},
+		{"erase the screen", 6, 2, "ab\r\ncd\x1b[2J", []string{"", ""}, 2, 1},
+		{"delete characters", 6, 1, "abcdef\x1b[1;2H\x1b[2P", []string{"adef"}, 1, 0},
+		{"alternate screen", 6, 2, "main\x1b[?1049h\x1b[Halt", []string{"alt", ""}, 3, 0},
+		{"back from the alternate screen", 6, 2, "main\x1b[?1049h\x1b[2;1Halt\x1b[?1049l", []string{"main", ""}, 4, 0},
+		{"save and restore the cursor", 6, 2, "ab\x1b7\r\ncd\x1b8x", []string{"abx", "cd"}, 3, 0},
+		{"C1 CSI", 6, 3, "\xc2\x9b2;2Hx", []string{"", " x", ""}, 2, 1},
+		{"C1 next line", 6, 3, "ab\xc2\x85c", []string{"ab", "c", ""}, 1, 1},
+		{"C1 OSC", 6, 1, "\xc2\x9d0;title\x07ok", []string{"ok"}, 2, 0},
+		{"invisible characters", 6, 1, "a\u200bb\u202ec", []string{"abc"}, 3, 0},
+		{"wide characters", 5, 2, "日本語", []string{"日本", "語"}, 2, 1},
+		{"wide character at the edge", 3, 2, "a日b日", []string{"a日", "b日"}, 2, 1},
+		{"combining mark", 4, 1, "e\u0301x", []string{"e\u0301x"}, 2, 0},
+		{"overwriting half of a wide character", 4, 1, "日本\x1b[1;2Hx", []string{" x本"}, 2, 0},
+		{"erasing half of a wide character", 4, 1, "日本\x1b[1;4H\x1b[K", []string{"日"}, 3, 0},
+	} {
+		vt := newVterm(tc.w, tc.h)
+		vt.feed([]byte(tc.input))
+		if got := testVtLines(vt); !slices.Equal(got, tc.lines) {
+			t.Errorf("%s: lines are %q, want %q", tc.name, got, tc.lines)
+		}
+		if vt.cx != tc.cx || vt.cy != tc.cy {
+			t.Errorf("%s: cursor is at %d,%d, want %d,%d", tc.name, vt.cx, vt.cy, tc.cx, tc.cy)
+		}
+	}
+}
+
+func TestVtermWideCells(t *testing.T) {
+	vt := newVterm(4, 1)
+	vt.feed([]byte("a日"))
+	if c := vt.lines[0][1]; c.ch != '日' {
+		t.Errorf("cell 1 is %q, want 日", c.ch)
+	}
+	if c := vt.lines[0][2]; c.ch != vtWide {
+		t.Errorf("cell 2 is %q, want the right half of 日", c.ch)
+	}
+	// a character split by the screen getting narrower goes
+	vt.resize(2, 1)
+	if got := testVtLines(vt); !slices.Equal(got, []string{"a"}) {
+		t.Errorf("lines after resizing are %q", got)
+	}
+}
+
+func TestVtermSGR(t *testing.T) {
+	vt := newVterm(8, 1)
+	vt.feed([]byte("\x1b[1;31mA\x1b[0;38;5;200;48;2;1;2;3mB\x1b[4;94;22mC\x1b[mD\x1b[7;39;49mE"))
+	for i, want := range []style{
+		{fg: indexedColor(1), attr: attrBold},
+		{fg: indexedColor(200), bg: rgbColor(1, 2, 3)},
+		{fg: indexedColor(12), bg: rgbColor(1, 2, 3), attr: attrUnderline},
+		{},
+		{attr: attrReverse},
+	} {
+		if st := vt.lines[0][i].style; st != want {
+			t.Errorf("style of %c is %+v, want %+v", vt.lines[0][i].ch, st, want)
+		}
+	}
+}
+
+func TestVtermScrollback(t *testing.T) {
+	vt := newVterm(4, 2)
+	vt.feed([]byte("1\r\n2\r\n3\r\n4"))
+	var sb []string
+	for _, line := range vt.scrollback {
+		sb = append(sb, string(vtLineText(line, 0)))
+	}
+	if !slices.Equal(sb, []string{"1", "2"}) || vt.sbTotal != 2 {
+		t.Errorf("scrollback is %q with %d in total, want 1 and 2", sb, vt.sbTotal)
+	}
+	// what the alternate screen scrolls away isn't kept
+	vt.feed([]byte("\x1b[?1049h5\r\n6\r\n7\x1b[?1049l"))
+	if vt.sbTotal != 2 {
+		t.Errorf("%d lines went to the scrollback from the alternate screen", vt.sbTotal-2)
+	}
+
+	vt.feed([]byte("\x1b[6n\x1b[c"))
+	if got := string(vt.takeReply()); got != "\x1b[2;2R\x1b[?1;2c" {
+		t.Errorf("reply is %q", got)
+	}
+}
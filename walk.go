@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// a pattern from a .gitignore file. base is the slash separated absolute
+// directory of the file, ending in /, and re matches paths relative to it
+type ignoreRule struct {
+	base    string
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// gitignoreRules reads the patterns of an ignore file that applies below dir
+func gitignoreRules(dir, file string) []ignoreRule {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	base := filepath.ToSlash(dir)
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	var rules []ignoreRule
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), " \t\r")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		rule := ignoreRule{base: base}
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// a pattern with a slash before its end only matches from base
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+		if line == "" {
+			continue
+		}
+		expr := "^(.*/)?" + globToRegexp(line) + "$"
+		if anchored {
+			expr = "^" + globToRegexp(line) + "$"
+		}
+		if rule.re, err = regexp.Compile(expr); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// globToRegexp turns a gitignore glob into a regexp: * and ? don't match a /,
+// ** matches any number of directories
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '\\' && i+1 < len(glob):
+			i++
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		case c == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// ignored says whether the rules leave out path, a slash separated absolute
+// path. like git, the last rule that matches decides
+func ignored(rules []ignoreRule, path string, isDir bool) bool {
+	out := false
+	for _, r := range rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		rel, ok := strings.CutPrefix(path, r.base)
+		if !ok {
+			continue
+		}
+		if r.re.MatchString(rel) {
+			out = !r.negate
+		}
+	}
+	return out
+}
+
+// parentIgnoreRules collects the ignore files between the top of the
+// repository dir is in and dir itself, whose own .gitignore isn't included
+func parentIgnoreRules(dir string) []ignoreRule {
+	var dirs []string
+	top := ""
+	for d := filepath.Dir(dir); ; d = filepath.Dir(d) {
+		dirs = append(dirs, d)
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			top = d
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		top, dirs = dir, nil
+	}
+	if top == "" {
+		return nil
+	}
+
+	rules := gitignoreRules(top, filepath.Join(top, ".git", "info", "exclude"))
+	for i := len(dirs) - 1; i >= 0; i-- {
+		rules = append(rules, gitignoreRules(dirs[i], filepath.Join(dirs[i], ".gitignore"))...)
+	}
+	return rules
+}
+
+// walkFiles sends the files under root to out, leaving out .git and what the
+// .gitignore files say to, and closes out when it's done. directories are read
+// concurrently so the order is arbitrary. closing stop ends the walk early
+func walkFiles(root string, out chan<- string, stop <-chan struct{}) {
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		close(out)
+		return
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, runtime.NumCPU()*2)
+	var walk func(dir string, rules []ignoreRule)
+	walk = func(dir string, rules []ignoreRule) {
+		defer wg.Done()
+		select {
+		case <-stop:
+			return
+		case sem <- struct{}{}:
+		}
+		entries, err := os.ReadDir(dir)
+		<-sem
+		if err != nil {
+			return
+		}
+		rules = append(rules[:len(rules):len(rules)], gitignoreRules(dir, filepath.Join(dir, ".gitignore"))...)
+
+		for _, e := range entries {
+			if e.Name() == ".git" {
+				continue
+			}
+			path := filepath.Join(dir, e.Name())
+			if ignored(rules, filepath.ToSlash(path), e.IsDir()) {
+				continue
+			}
+			if e.IsDir() {
+				wg.Add(1)
+				go walk(path, rules)
+				continue
+			}
+			if !e.Type().IsRegular() && e.Type()&os.ModeSymlink == 0 {
+				continue
+			}
+			rel, err := filepath.Rel(abs, path)
+			if err != nil {
+				continue
+			}
+			select {
+			case out <- filepath.Join(root, rel):
+			case <-stop:
+				return
+			}
+		}
+	}
+
+	wg.Add(1)
+	go walk(abs, parentIgnoreRules(abs))
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"testing"
+)
+
+func TestGlobToRegexp(t *testing.T) {
+	for _, tc := range []struct{ glob, want string }{
+		{"*.go", `[^/]*\.go`},
+		{"a?c", `a[^/]c`},
+		{"**/x", `(.*/)?x`},
+		{"a/**", `a/.*`},
+		{"a/**/b", `a/(.*/)?b`},
+		{"[!ab]x", `[^ab]x`},
+		{"[a-c]", `[a-c]`},
+		{"[x", `\[x`},
+		{`\*`, `\*`},
+	} {
+		if got := globToRegexp(tc.glob); got != tc.want {
+			t.Errorf("%s is %s, want %s", tc.glob, got, tc.want)
+		}
+	}
+}
+
+func TestWalkFiles(t *testing.T) {
+	root := t.TempDir()
+	for name, text := range map[string]string{
+		".gitignore":     "# logs\n*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/*.tmp\n",
+		"a.go":           "",
+		"x.log":          "",
+		"keep.log":       "",
+		"top.txt":        "",
+		"build/out.go":   "",
+		"docs/a.tmp":     "",
+		"docs/x/y/b.tmp": "",
+		"docs/c.txt":     "",
+		// build/ is only for directories, and /top.txt only at the top
+		"sub/build":      "",
+		"sub/top.txt":    "",
+		"sub/.gitignore": "*.go\n",
+		"sub/s.go":       "",
+		".git/config":    "",
+	} {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := make(chan string)
+	walkFiles(root, out, make(chan struct{}))
+	var got []string
+	for name := range out {
+		rel, _ := filepath.Rel(root, name)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	sort.Strings(got)
+	want := []string{".gitignore", "a.go", "docs/c.txt", "keep.log", "sub/.gitignore", "sub/build", "sub/top.txt"}
+	if !slices.Equal(got, want) {
+		t.Errorf("files are %q, want %q", got, want)
+	}
+
+	// a rule in a subdirectory only applies below it
+	rules := gitignoreRules(filepath.Join(root, "sub"), filepath.Join(root, "sub", ".gitignore"))
+	if ignored(rules, filepath.ToSlash(filepath.Join(root, "a.go")), false) {
+		t.Error("sub/.gitignore left out a.go")
+	}
+	if !ignored(rules, filepath.ToSlash(filepath.Join(root, "sub", "deep", "d.go")), false) {
+		t.Error("sub/.gitignore didn't leave out sub/deep/d.go")
+	}
+}
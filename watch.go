@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"time"
+)
+
+// how often we stat the open file to see if someone else wrote to it
+var watchTicker = time.NewTicker(time.Second)
+
+func editorHashFile(filename string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return sum, err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// editorRecordFileStat remembers what the file looked like when we last read or wrote it
+func editorRecordFileStat() {
+	info, err := os.Stat(E.filename)
+	if err != nil {
+		return
+	}
+	hash, err := editorHashFile(E.filename)
+	if err != nil {
+		return
+	}
+	E.file_mtime = info.ModTime()
+	E.file_size = info.Size()
+	E.file_hash = hash
+}
+
+// editorFileChanged reports whether the file on disk is no longer the one we
+// recorded, or is gone. a changed mtime alone (touch, checkout of the same
+// content) is not a change
+func editorFileChanged() bool {
+	if E.filename == "" || E.file_mtime.IsZero() {
+		return false
+	}
+	info, err := os.Stat(E.filename)
+	if os.IsNotExist(err) {
+		return true
+	} else if err != nil {
+		return false
+	}
+	if info.ModTime().Equal(E.file_mtime) && info.Size() == E.file_size {
+		return false
+	}
+
+	hash, err := editorHashFile(E.filename)
+	if err != nil {
+		return false
+	}
+	if hash == E.file_hash {
+		E.file_mtime = info.ModTime()
+		E.file_size = info.Size()
+		return false
+	}
+	return true
+}
+
+// editorCheckFileChanged asks what to do about an external modification.
+// returns true if it did anything that needs a redraw
+func editorCheckFileChanged() bool {
+	if !editorFileChanged() {
+		return false
+	}
+
+	if _, err := os.Stat(E.filename); os.IsNotExist(err) {
+		// nothing to reload, the buffer is all that's left of it
+		E.file_mtime = time.Time{}
+		E.dirty = true
+		editorSetStatusMessage("%s was deleted, saving writes it again", E.filename)
+		return true
+	}
+
+	if E.autoread && !E.dirty {
+		if editorReload() {
+			editorSetStatusMessage("%s changed on disk, reloaded", E.filename)
+		}
+		return true
+	}
+
+	for {
+		switch editorAsk("%s changed on disk! [r]eload, [k]eep ours, [d]iff", E.filename) {
+		case 'r':
+			if editorReload() {
+				editorSetStatusMessage("%s reloaded", E.filename)
+			}
+			return true
+		case 'k', '\x1b':
+			editorRecordFileStat()
+			editorSetStatusMessage("keeping the buffer, saving will overwrite %s", E.filename)
+			return true
+		case 'd':
+			editorShowDiff()
+		}
+	}
+}
+
+// editorReload reads the file again as one change, which can be undone like
+// any other. the cursor, marks and signs stay with the lines that didn't change.
+// when the file turned from text into binary there's no such change, it's
+// opened again in the hex view
+func editorReload() bool {
+	data, err := os.ReadFile(E.filename)
+	if err != nil {
+		editorSetStatusMessage("can't read %s: %s", E.filename, err)
+		return false
+	}
+	head := data[:min(len(data), 64*1024)]
+	i := bytes.IndexByte(head, '\n')
+	E.crlf = i > 0 && head[i-1] == '\r'
+
+	switch {
+	case E.hex:
+		editorUndoCommit()
+		editorHexSplice(0, len(E.data), data)
+		E.hexoff = min(E.hexoff, max(len(E.data)-1, 0))
+	case editorIsBinary(head):
+		cx, cy, marks := E.cx, E.cy, maps.Clone(E.marks)
+		E.row = nil
+		E.numrows = 0
+		E.data = nil
+		editorOpen(E.filename)
+		E.marks = marks
+		E.cx, E.cy = cx, cy
+		return true
+	default:
+		var lines []string
+		if len(data) > 0 {
+			lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		}
+		// like the scanner in editorOpen
+		for i := range lines {
+			lines[i] = strings.TrimSuffix(lines[i], "\r")
+		}
+		editorUndoCommit()
+		editorSetLines(lines)
+	}
+	editorUndoCommit()
+	E.undo.saved = E.undo.current
+	E.dirty = false
+	editorRecordFileStat()
+	return true
+}
+
+func editorReadLines(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	sc := bufio.NewScanner(file)
+	for sc.Scan() {
+		lines = append(lines, sc.Text())
+	}
+	return lines, sc.Err()
+}
+
+func editorShowDiff() {
+	disk, err := editorReadLines(E.filename)
+	if err != nil {
+		editorSetStatusMessage("can't read %s: %s", E.filename, err)
+		return
+	}
+	ours := make([]string, E.numrows)
+	for i := range E.row {
+		ours[i] = string(E.row[i].chars)
+	}
+
+	lines := append([]string{"--- " + E.filename + " (disk)", "+++ " + E.filename + " (buffer)"}, diffUnified(disk, ours, 3)...)
+	editorShowLines("diff "+E.filename, lines)
+}
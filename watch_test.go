@@ -0,0 +1,144 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReloadKeepsMarksAndSigns(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	name := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(name, []byte("1\n2\n3\n4\n5\n6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testBuffers(t)
+	editorOpen(name)
+	E.marks = map[byte]mark{'a': {Cy: 2}}
+	E.signs = []sign{{line: 3, text: "E ", source: "test"}}
+	marks := E.marks
+
+	editorReload()
+	if m := E.marks['a']; m.Cy != 2 {
+		t.Errorf("mark a is on row %d after reloading, want 2", m.Cy)
+	}
+	if len(E.signs) != 1 || E.signs[0].line != 3 {
+		t.Errorf("signs are %+v after reloading, want one on row 3", E.signs)
+	}
+	// the marks of before the reload are a copy
+	if marks['a'].Cy != 2 {
+		t.Errorf("the old marks map changed to %+v", marks)
+	}
+
+	// rows inserted afterwards still move them
+	editorInsertRow(0, []byte("0"))
+	if m := E.marks['a']; m.Cy != 3 {
+		t.Errorf("mark a is on row %d after inserting a row above it, want 3", m.Cy)
+	}
+}
+
+// testWatchedFile writes text to a file and opens it
+func testWatchedFile(t *testing.T, text string) string {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	name := filepath.Join(t.TempDir(), "a.txt")
+	if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testBuffers(t)
+	editorOpen(name)
+	return name
+}
+
+func TestFileChanged(t *testing.T) {
+	name := testWatchedFile(t, "one\n")
+	// a new mtime on the same content isn't a change, and is remembered
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(name, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if editorFileChanged() {
+		t.Error("touching the file was taken as a change")
+	}
+	if !E.file_mtime.Equal(later) {
+		t.Errorf("recorded mtime is %v, want %v", E.file_mtime, later)
+	}
+
+	if err := os.WriteFile(name, []byte("two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !editorFileChanged() {
+		t.Error("new content of the same size wasn't taken as a change")
+	}
+
+	// a deleted file is reported once, and the buffer is what's left of it
+	os.Remove(name)
+	if !editorFileChanged() {
+		t.Error("deleting the file wasn't taken as a change")
+	}
+	if !editorCheckFileChanged() || !E.dirty || !strings.Contains(E.statusmsg, "deleted") {
+		t.Errorf("after deleting the file the buffer is dirty %v with %q", E.dirty, E.statusmsg)
+	}
+	if editorFileChanged() {
+		t.Error("the deleted file was reported again")
+	}
+}
+
+func TestAutoread(t *testing.T) {
+	name := testWatchedFile(t, "1\n2\n3\n")
+	E.autoread = true
+	E.cy = 2
+	if err := os.WriteFile(name, []byte("1\nnew\n2\n3\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !editorCheckFileChanged() {
+		t.Fatal("the change on disk wasn't noticed")
+	}
+	if got := editorBufferLines(); !slices.Equal(got, []string{"1", "new", "2", "3"}) || E.dirty {
+		t.Errorf("after reading it again the lines are %q and dirty is %v", got, E.dirty)
+	}
+	if E.cy != 3 {
+		t.Errorf("cursor is on row %d, want 3 where the 3 went", E.cy)
+	}
+	// the reload is one change, which can be undone
+	editorUndo()
+	if got := editorBufferLines(); !slices.Equal(got, []string{"1", "2", "3"}) || !E.dirty {
+		t.Errorf("after undoing the reload the lines are %q and dirty is %v", got, E.dirty)
+	}
+}
+
+func TestReloadLineEndings(t *testing.T) {
+	name := testWatchedFile(t, "a\r\nb\r\n")
+	if !E.crlf {
+		t.Fatal("a CRLF file wasn't taken as one")
+	}
+	if err := os.WriteFile(name, []byte("a\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorReload()
+	if E.crlf {
+		t.Error("the file is LF now, but the buffer would still be written with CRLF")
+	}
+	if got := editorBufferLines(); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("lines are %q", got)
+	}
+
+	// a file that turned binary is shown as hex, and back from there it's text again
+	if err := os.WriteFile(name, []byte("\x00\x01"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorReload()
+	if !E.hex || string(E.data) != "\x00\x01" || E.numrows != 0 {
+		t.Errorf("after reading a binary file hex is %v with %q and %d rows", E.hex, E.data, E.numrows)
+	}
+	if err := os.WriteFile(name, []byte("x\r\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editorReload()
+	if string(E.data) != "x\r\n" || !E.crlf {
+		t.Errorf("after reading it again the hex view has %q and crlf is %v", E.data, E.crlf)
+	}
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// a window shows a buffer in part of the screen. the current window's cursor
+// and scroll position live in E like always, the other windows keep theirs here
+type window struct {
+	buf            int
+	cx, cy         int
+	rowoff, coloff int
+	x, y, w, h     int // where it is on the screen, h counts the status line
+	height         int // rows it keeps when the others are sized, like the quickfix window's. 0 to share
+	width          int // columns it keeps beside others, like the blame window's. 0 to share
+	scrollbind     bool
+	diff           bool // compared with the other diff window of the tab
+	topskip        int  // filler lines above rowoff that are scrolled out of view
+}
+
+// the windows are the leaves of a tree of rows and columns
+type layout struct {
+	win        *window
+	vertical   bool // the children are side by side, with a separator between them
+	children   []*layout
+	parent     *layout
+	x, y, w, h int
+}
+
+// a tab page has its own windows. like E and buffers, root, curwin and
+// prevwin are the live copy of tabs[curtab]
+type tabpage struct {
+	root            *layout
+	curwin, prevwin *window
+}
+
+var (
+	root                  *layout
+	curwin                *window
+	prevwin               *window // the window that was current before curwin
+	tabs                  []tabpage
+	curtab                int
+	termWidth, termHeight int
+)
+
+func editorInitWindows() {
+	curwin = &window{buf: curbuf}
+	root = &layout{win: curwin}
+	tabs = []tabpage{{root: root, curwin: curwin}}
+	curtab = 0
+}
+
+// editorWindows lists the windows from the top left to the bottom right
+func editorWindows() []*window {
+	return editorLayoutWindows(root)
+}
+
+// editorLayoutWindows lists the windows of the tree l in the same order
+func editorLayoutWindows(l *layout) []*window {
+	if l.win != nil {
+		return []*window{l.win}
+	}
+	var wins []*window
+	for _, c := range l.children {
+		wins = append(wins, editorLayoutWindows(c)...)
+	}
+	return wins
+}
+
+func editorFindLayout(l *layout, w *window) *layout {
+	if l.win == w {
+		return l
+	}
+	for _, c := range l.children {
+		if found := editorFindLayout(c, w); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// editorLayout sizes the windows to the terminal, all but the message bar
+// line and the tab line when there's more than one tab
+func editorLayout() {
+	top := 0
+	if len(tabs) > 1 {
+		top = 1
+	}
+	editorPlace(root, 0, top, termWidth, termHeight-1-top)
+	E.screenrows = max(curwin.h-1, 1)
+	E.raw_screencols = max(curwin.w, 1)
+}
+
+func editorPlace(l *layout, x, y, w, h int) {
+	l.x, l.y, l.w, l.h = x, y, w, h
+	if l.win != nil {
+		l.win.x, l.win.y, l.win.w, l.win.h = x, y, w, h
+		return
+	}
+
+	n := len(l.children)
+	sizes := make([]int, n)
+	share := func(avail int, which func(i int) bool) {
+		count := 0
+		for i := range sizes {
+			if which(i) {
+				count++
+			}
+		}
+		k := 0
+		for i := range sizes {
+			if which(i) {
+				sizes[i] = avail / count
+				if k < avail%count {
+					sizes[i]++
+				}
+				k++
+			}
+		}
+	}
+	all := func(int) bool { return true }
+
+	// windows with a size of their own get it first, when that leaves room for the rest
+	fixed := func(c *layout) int {
+		if c.win == nil {
+			return 0
+		}
+		if l.vertical {
+			return c.win.width
+		}
+		return c.win.height
+	}
+	avail, shared := h, 0
+	if l.vertical {
+		avail = w - (n - 1)
+	}
+	total := avail
+	for i, c := range l.children {
+		if fixed(c) > 0 {
+			sizes[i] = fixed(c)
+			if !l.vertical {
+				sizes[i]++
+			}
+			avail -= sizes[i]
+		} else {
+			shared++
+		}
+	}
+	if shared > 0 && avail >= 2*shared {
+		share(avail, func(i int) bool { return fixed(l.children[i]) == 0 })
+	} else {
+		share(total, all)
+	}
+
+	pos := x
+	if !l.vertical {
+		pos = y
+	}
+	for i, c := range l.children {
+		if l.vertical {
+			editorPlace(c, pos, y, sizes[i], h)
+			pos += sizes[i] + 1
+		} else {
+			editorPlace(c, x, pos, w, sizes[i])
+			pos += sizes[i]
+		}
+	}
+}
+
+// editorDrawSeparators draws the lines between windows that are side by side
+func editorDrawSeparators(l *layout) {
+	st := hlStyle(HG_STATUSLINENC)
+	for i, c := range l.children {
+		if l.vertical && i < len(l.children)-1 {
+			for y := l.y; y < l.y+l.h; y++ {
+				screenPutString(c.x+c.w, y, "│", st)
+			}
+		}
+		editorDrawSeparators(c)
+	}
+}
+
+// editorDrawWindow draws a window that isn't the current one, with its buffer and view in E for the time
+func editorDrawWindow(w *window) {
+	screenViewport(w.x, w.y, w.w, w.h)
+	editorInBuffer(w.buf, func() {
+		saved := E
+		E.cx, E.cy, E.rowoff, E.coloff, E.topskip = w.cx, w.cy, w.rowoff, w.coloff, w.topskip
+		E.cy = min(E.cy, E.numrows)
+		E.screenrows, E.raw_screencols = max(w.h-1, 1), max(w.w, 1)
+		E.mode = NORMAL
+		editorDrawView(false)
+		w.cx, w.cy, w.rowoff, w.coloff, w.topskip = E.cx, E.cy, E.rowoff, E.coloff, E.topskip
+		E = saved
+	})
+}
+
+// editorFocusWindow makes w the current window
+func editorFocusWindow(w *window) {
+	if w == curwin || w == nil {
+		return
+	}
+	editorSaveWindow()
+	prevwin = curwin
+	editorLoadWindow(w)
+}
+
+// editorSaveWindow keeps the view of the current window in it, before another one becomes current
+func editorSaveWindow() {
+	curwin.cx, curwin.cy, curwin.rowoff, curwin.coloff, curwin.topskip = E.cx, E.cy, E.rowoff, E.coloff, E.topskip
+}
+
+// editorLoadWindow makes w current with its buffer and view in E
+func editorLoadWindow(w *window) {
+	curwin = w
+	if w.buf != curbuf {
+		editorSwitchBuffer(w.buf)
+	}
+	E.cx, E.cy, E.rowoff, E.coloff, E.topskip = w.cx, w.cy, w.rowoff, w.coloff, w.topskip
+	E.cy = min(E.cy, E.numrows)
+	E.mode = NORMAL
+}
+
+// editorSplit splits the current window in two showing the same buffer, and
+// moves to the new one, which is above or to the left
+func editorSplit(vertical bool) *window {
+	if vertical && curwin.w < 3 || !vertical && curwin.h < 4 {
+		editorSetStatusMessage("not enough room")
+		return nil
+	}
+	w := &window{buf: curbuf, cx: E.cx, cy: E.cy, rowoff: E.rowoff, coloff: E.coloff}
+	editorInsertWindow(curwin, w, vertical, true)
+	editorFocusWindow(w)
+	return w
+}
+
+// editorInsertWindow puts w next to the window at, before or after it
+func editorInsertWindow(at, w *window, vertical, before bool) {
+	l := editorFindLayout(root, at)
+	node := &layout{win: w}
+	parent := l.parent
+	if parent == nil || parent.vertical != vertical {
+		// the window becomes a row or column of its own with the new one
+		inner := &layout{win: at, parent: l}
+		l.win, l.vertical, l.children = nil, vertical, []*layout{inner}
+		parent, l = l, inner
+	}
+	node.parent = parent
+	i := 0
+	for i < len(parent.children) && parent.children[i] != l {
+		i++
+	}
+	if !before {
+		i++
+	}
+	parent.children = append(parent.children[:i], append([]*layout{node}, parent.children[i:]...)...)
+}
+
+// editorAddBottomWindow adds w across the whole bottom of the screen
+func editorAddBottomWindow(w *window) {
+	node := &layout{win: w}
+	if root.win == nil && !root.vertical {
+		node.parent = root
+		root.children = append(root.children, node)
+		return
+	}
+	top := root
+	root = &layout{children: []*layout{top, node}}
+	top.parent, node.parent = root, root
+}
+
+// editorCloseWindow closes w, the space goes to its neighbour
+func editorCloseWindow(w *window) {
+	l := editorFindLayout(root, w)
+	if l == nil || l.parent == nil {
+		editorSetStatusMessage("can't close the last window")
+		return
+	}
+	parent := l.parent
+	i := 0
+	for parent.children[i] != l {
+		i++
+	}
+	parent.children = append(parent.children[:i], parent.children[i+1:]...)
+
+	// the neighbour that gets the space, the nearest window in it becomes current
+	next := parent.children[max(i-1, 0)]
+	for next.win == nil {
+		if i > 0 {
+			next = next.children[len(next.children)-1]
+		} else {
+			next = next.children[0]
+		}
+	}
+	target := next.win
+
+	if len(parent.children) == 1 {
+		// a row or column of one is just that one
+		only := parent.children[0]
+		grand := parent.parent
+		*parent = *only
+		parent.parent = grand
+		for _, c := range parent.children {
+			c.parent = parent
+		}
+		// and merges with the one around it when they go the same way
+		if grand != nil && parent.win == nil && grand.vertical == parent.vertical {
+			j := 0
+			for grand.children[j] != parent {
+				j++
+			}
+			kids := append([]*layout(nil), grand.children[:j]...)
+			kids = append(kids, parent.children...)
+			grand.children = append(kids, grand.children[j+1:]...)
+			for _, c := range grand.children {
+				c.parent = grand
+			}
+		}
+	}
+
+	if w == curwin {
+		// there's nothing to keep of the closed window's view
+		prevwin = nil
+		editorLoadWindow(target)
+	} else if w == prevwin {
+		prevwin = nil
+	}
+	editorUnbindLast()
+}
+
+// editorOnly closes every window but the current one
+func editorOnly() {
+	root = &layout{win: curwin}
+	prevwin = nil
+	curwin.scrollbind = false
+}
+
+// editorUnbindLast takes scrollbind off a window that has nothing left to scroll with
+func editorUnbindLast() {
+	var bound []*window
+	for _, w := range editorWindows() {
+		if w.scrollbind {
+			bound = append(bound, w)
+		}
+	}
+	if len(bound) == 1 {
+		bound[0].scrollbind = false
+	}
+}
+
+// editorSyncScrollbind scrolls the windows bound to the current one to the same
+// line, and puts their cursors on the current one's row
+func editorSyncScrollbind() {
+	if !curwin.scrollbind || E.hex {
+		return
+	}
+	editorScroll()
+	for _, w := range editorWindows() {
+		if w == curwin || !w.scrollbind {
+			continue
+		}
+		if curwin.diff && w.diff {
+			editorDiffSyncWindow(w)
+			continue
+		}
+		w.rowoff = E.rowoff
+		w.cy = E.cy
+		editorInBuffer(w.buf, func() {
+			w.cy = min(w.cy, max(E.numrows-1, 0))
+			w.rowoff = min(w.rowoff, w.cy)
+		})
+	}
+}
+
+// editorWindowInDirection finds the window next to the current one in the
+// direction of an hjkl key, the one beside the cursor when there are several
+func editorWindowInDirection(key int) *window {
+	cx, cy := editorCursorPosition()
+	px, py := curwin.x+cx, curwin.y+cy
+	var best *window
+	bestScore := 0
+	for _, w := range editorWindows() {
+		var gap, off int
+		switch key {
+		case 'h':
+			gap, off = curwin.x-(w.x+w.w), editorOutside(py, w.y, w.h)
+		case 'l':
+			gap, off = w.x-(curwin.x+curwin.w), editorOutside(py, w.y, w.h)
+		case 'k':
+			gap, off = curwin.y-(w.y+w.h), editorOutside(px, w.x, w.w)
+		case 'j':
+			gap, off = w.y-(curwin.y+curwin.h), editorOutside(px, w.x, w.w)
+		}
+		if w == curwin || gap < 0 {
+			continue
+		}
+		if score := gap*10000 + off; best == nil || score < bestScore {
+			best, bestScore = w, score
+		}
+	}
+	return best
+}
+
+// editorOutside is how far p is from the span of n starting at from
+func editorOutside(p, from, n int) int {
+	switch {
+	case p < from:
+		return from - p
+	case p >= from+n:
+		return p - (from + n - 1)
+	}
+	return 0
+}
+
+// editorWindowCommand is CTRL-W followed by key
+func editorWindowCommand(key int) {
+	wins := editorWindows()
+	cur := 0
+	for i, w := range wins {
+		if w == curwin {
+			cur = i
+		}
+	}
+	switch key {
+	case 'w', CONTROL_KEY('w'):
+		editorFocusWindow(wins[(cur+1)%len(wins)])
+	case 'W':
+		editorFocusWindow(wins[(cur+len(wins)-1)%len(wins)])
+	case 'p', CONTROL_KEY('p'):
+		if prevwin != nil && editorFindLayout(root, prevwin) != nil {
+			editorFocusWindow(prevwin)
+		}
+	case 'h', 'j', 'k', 'l':
+		editorFocusWindow(editorWindowInDirection(key))
+	case CONTROL_KEY('h'), CONTROL_KEY('j'), CONTROL_KEY('k'), CONTROL_KEY('l'):
+		editorFocusWindow(editorWindowInDirection(key + 'a' - 1))
+	case 's', 'S', CONTROL_KEY('s'):
+		editorSplit(false)
+	case 'v', CONTROL_KEY('v'):
+		editorSplit(true)
+	case 'c', 'q', CONTROL_KEY('c'):
+		editorCloseWindow(curwin)
+	case 'o', CONTROL_KEY('o'):
+		editorOnly()
+	}
+}
+
+// editorGotoTab makes tab i current
+func editorGotoTab(i int) {
+	if i < 0 || i >= len(tabs) || i == curtab {
+		return
+	}
+	editorSaveWindow()
+	tabs[curtab] = tabpage{root: root, curwin: curwin, prevwin: prevwin}
+	curtab = i
+	root, prevwin = tabs[i].root, tabs[i].prevwin
+	editorLoadWindow(tabs[i].curwin)
+}
+
+// editorTabNew opens a tab after the current one, with a window on the current buffer
+func editorTabNew() {
+	editorSaveWindow()
+	tabs[curtab] = tabpage{root: root, curwin: curwin, prevwin: prevwin}
+	w := &window{buf: curbuf, cx: E.cx, cy: E.cy, rowoff: E.rowoff, coloff: E.coloff}
+	tabs = append(tabs[:curtab+1], append([]tabpage{{root: &layout{win: w}, curwin: w}}, tabs[curtab+1:]...)...)
+	curtab++
+	root, curwin, prevwin = tabs[curtab].root, w, nil
+}
+
+// editorTabClose closes the current tab and goes to the one before it
+func editorTabClose() {
+	if len(tabs) == 1 {
+		editorSetStatusMessage("can't close the last tab")
+		return
+	}
+	tabs = append(tabs[:curtab], tabs[curtab+1:]...)
+	curtab = max(curtab-1, 0)
+	root, prevwin = tabs[curtab].root, tabs[curtab].prevwin
+	editorLoadWindow(tabs[curtab].curwin)
+}
+
+// editorTabOnly closes every tab but the current one
+func editorTabOnly() {
+	tabs = []tabpage{{root: root, curwin: curwin, prevwin: prevwin}}
+	curtab = 0
+}
+
+// editorDrawTabLine draws the names of the tabs across the top, each named after its current window
+func editorDrawTabLine() {
+	if len(tabs) < 2 {
+		return
+	}
+	screenFill(0, 0, termWidth, hlStyle(HG_STATUSLINENC))
+	x := 0
+	for i, t := range tabs {
+		win := t.curwin
+		if i == curtab {
+			win = curwin
+		}
+		name := "[No Name]"
+		if f := editorBufFilename(win.buf); f != "" {
+			name = filepath.Base(f)
+		} else if editorBufType(win.buf) == "quickfix" {
+			name = "[Quickfix List]"
+		} else if editorBufType(win.buf) == "blame" {
+			name = "[Blame]"
+		} else if editorBufType(win.buf) == "terminal" {
+			name = "[Terminal]"
+		}
+		st := hlStyle(HG_STATUSLINENC)
+		if i == curtab {
+			st = hlStyle(HG_STATUSLINE)
+		}
+		x = screenPutString(x, 0, fmt.Sprintf(" %d %s ", i+1, name), st)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import "unicode/utf8"
+
+var (
+	WRAP      = false
+	LINEBREAK = false // wrap at the last blank that fits instead of mid-word
+	SHOWBREAK = "↪"   // drawn in the gutter in front of continuation lines
+)
+
+// editorRowSegments returns where each display line of row starts in row.render,
+// each one screencols columns wide. without wrap every row is a single display line
+func editorRowSegments(row *erow) []int {
+	segs := []int{0}
+	if !WRAP || E.screencols <= 0 {
+		return segs
+	}
+
+	start, width := 0, editorRenderCol(row, row.rsize)
+	for col := 0; width-col > E.screencols; col = editorRenderCol(row, start) {
+		end := editorRenderIndex(row, col+E.screencols)
+		if end == start {
+			// a wide character in a single column window
+			_, n := utf8.DecodeRune(row.render[start:row.rsize])
+			end += n
+		}
+		if LINEBREAK {
+			for i := end - 1; i > start; i-- {
+				if row.render[i] == ' ' || row.render[i] == '\t' {
+					end = i + 1
+					break
+				}
+			}
+		}
+		segs = append(segs, end)
+		start = end
+	}
+	return segs
+}
+
+// editorSegmentOf returns which display line of segs rx of row is on and the column inside it
+func editorSegmentOf(row *erow, segs []int, rx int) (int, int) {
+	i := len(segs) - 1
+	for i > 0 && segs[i] > rx {
+		i--
+	}
+	col := editorRenderCol(row, rx) - editorRenderCol(row, segs[i])
+	// the cursor just past a full last line sits at the start of the next one
+	if WRAP && col >= E.screencols && E.screencols > 0 {
+		return i + 1, col - E.screencols
+	}
+	return i, col
+}
+
+// editorDisplayLines is how many screen lines filerow takes up: none when it's
+// hidden in a closed fold, one for the fold line. diff filler lines above it count too
+func editorDisplayLines(filerow int) int {
+	if filerow >= E.numrows {
+		return 1
+	}
+	if f, ok := editorClosedFold(filerow); ok {
+		if filerow == f.start {
+			return 1 + editorFillAbove(filerow)
+		}
+		return 0
+	}
+	return editorFillAbove(filerow) + len(editorRowSegments(&E.row[filerow]))
+}
+
+// editorScrollWrapped keeps the cursor on screen counting display lines rather than rows
+func editorScrollWrapped() {
+	E.rowoff = editorFoldStart(E.rowoff)
+	if E.cy < E.rowoff {
+		E.rowoff = E.cy
+		E.topskip = editorHiddenFill(E.rowoff)
+	}
+	E.topskip = min(E.topskip, editorFillAbove(E.rowoff))
+
+	seg := 0
+	if E.cy < E.numrows && len(editorRowSegments(&E.row[E.cy])) > 1 {
+		seg, _ = editorSegmentOf(&E.row[E.cy], editorRowSegments(&E.row[E.cy]), E.rx)
+	}
+	lines := seg + editorFillAbove(E.cy) - E.topskip
+	for r := E.rowoff; r < E.cy; r++ {
+		lines += editorDisplayLines(r)
+	}
+	for E.rowoff < E.cy && lines >= E.screenrows {
+		lines -= editorDisplayLines(E.rowoff) - E.topskip
+		E.rowoff++
+		E.topskip = editorHiddenFill(E.rowoff)
+		lines -= E.topskip
+	}
+	for E.rowoff < E.cy && editorDisplayLines(E.rowoff) == 0 {
+		E.rowoff++
+	}
+	E.topskip = min(E.topskip, editorFillAbove(E.rowoff))
+}
+
+// editorCursorPosition is where the cursor goes on screen
+func editorCursorPosition() (int, int) {
+	x, y, _ := editorScreenPosition(E.cy, E.rx)
+	return x, min(y, E.screenrows-1)
+}
+
+// editorScreenPosition is where render column rx of filerow is drawn, and whether that's on screen
+func editorScreenPosition(filerow, rx int) (int, int, bool) {
+	col := rx
+	if filerow < E.numrows {
+		col = editorRenderCol(&E.row[filerow], rx)
+	}
+	if !WRAP && len(E.closed_folds) == 0 && E.diff == nil {
+		x, y := col-E.coloff+E.linenum_indent, filerow-E.rowoff
+		return x, y, filerow >= E.rowoff && y < E.screenrows && x >= E.linenum_indent && x < E.raw_screencols
+	}
+
+	filerow = editorFoldStart(filerow)
+	if filerow < E.rowoff {
+		return 0, 0, false
+	}
+	y := -E.topskip
+	for r := E.rowoff; r < filerow && y < E.screenrows; r++ {
+		y += editorDisplayLines(r)
+	}
+	y += editorFillAbove(filerow)
+	x := col - E.coloff
+	if WRAP && filerow < E.numrows && len(editorRowSegments(&E.row[filerow])) > 1 {
+		var seg int
+		seg, x = editorSegmentOf(&E.row[filerow], editorRowSegments(&E.row[filerow]), rx)
+		y += seg
+	}
+	return x + E.linenum_indent, y, y < E.screenrows && (WRAP || x >= 0 && x < E.screencols)
+}
+
+// editorMoveDisplayLine is gj/gk, moving by screen line inside wrapped rows
+func editorMoveDisplayLine(dir int) {
+	if !WRAP || E.cy >= E.numrows {
+		if dir > 0 {
+			editorMoveCursor(ARROW_DOWN)
+		} else {
+			editorMoveCursor(ARROW_UP)
+		}
+		return
+	}
+
+	row := &E.row[E.cy]
+	segs := editorRowSegments(row)
+	seg, col := editorSegmentOf(row, segs, editorRowCxToRx(row, E.cx))
+	seg = min(seg, len(segs)-1)
+
+	if dir > 0 && seg+1 < len(segs) {
+		E.cx = editorRowRxToCx(row, min(editorRenderIndex(row, editorRenderCol(row, segs[seg+1])+col), row.rsize))
+		return
+	}
+	if dir < 0 && seg > 0 {
+		E.cx = editorRowRxToCx(row, editorRenderIndex(row, editorRenderCol(row, segs[seg-1])+col))
+		return
+	}
+
+	if dir > 0 {
+		if editorNextRow(E.cy) >= E.numrows {
+			return
+		}
+		E.cy = editorNextRow(E.cy)
+		next := &E.row[E.cy]
+		nsegs := editorRowSegments(next)
+		end := next.rsize
+		if len(nsegs) > 1 {
+			end = nsegs[1] - 1
+		}
+		E.cx = editorRowRxToCx(next, min(editorRenderIndex(next, col), end))
+	} else {
+		if E.cy == 0 {
+			return
+		}
+		E.cy = editorFoldStart(E.cy - 1)
+		prev := &E.row[E.cy]
+		psegs := editorRowSegments(prev)
+		E.cx = editorRowRxToCx(prev, min(editorRenderIndex(prev, editorRenderCol(prev, psegs[len(psegs)-1])+col), prev.rsize))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRowSegments(t *testing.T) {
+	testBuffers(t, "one two three four", "short")
+	defer func() { WRAP, LINEBREAK = false, false }()
+	E.screencols = 8
+
+	WRAP, LINEBREAK = false, false
+	if segs := editorRowSegments(&E.row[0]); !slices.Equal(segs, []int{0}) {
+		t.Errorf("segments without wrap are %v", segs)
+	}
+	WRAP = true
+	if segs := editorRowSegments(&E.row[0]); !slices.Equal(segs, []int{0, 8, 16}) {
+		t.Errorf("segments are %v, want [0 8 16]", segs)
+	}
+	if segs := editorRowSegments(&E.row[1]); !slices.Equal(segs, []int{0}) {
+		t.Errorf("segments of a row that fits are %v", segs)
+	}
+	// with linebreak a line ends after the last blank that fits
+	LINEBREAK = true
+	if segs := editorRowSegments(&E.row[0]); !slices.Equal(segs, []int{0, 8, 14}) {
+		t.Errorf("segments with linebreak are %v, want [0 8 14]", segs)
+	}
+	// and a word longer than the line is cut anyway
+	testBuffers(t, "abcdefghijkl")
+	E.screencols = 8
+	if segs := editorRowSegments(&E.row[0]); !slices.Equal(segs, []int{0, 8}) {
+		t.Errorf("segments of one long word are %v, want [0 8]", segs)
+	}
+}
+
+func TestMoveDisplayLine(t *testing.T) {
+	testBuffers(t, "one two three four", "ab")
+	defer func() { WRAP, LINEBREAK = false, false }()
+	WRAP, LINEBREAK = true, true
+	E.screencols = 8
+
+	// down keeps the column across the display lines of a row, then goes to the next row
+	E.cx = 2
+	for _, want := range [][2]int{{0, 10}, {0, 16}, {1, 2}, {1, 2}} {
+		editorMoveDisplayLine(1)
+		if E.cy != want[0] || E.cx != want[1] {
+			t.Errorf("gj went to %d,%d, want %d,%d", E.cy, E.cx, want[0], want[1])
+		}
+	}
+	// up comes into the last display line of the row above
+	for _, want := range [][2]int{{0, 16}, {0, 10}, {0, 2}, {0, 2}} {
+		editorMoveDisplayLine(-1)
+		if E.cy != want[0] || E.cx != want[1] {
+			t.Errorf("gk went to %d,%d, want %d,%d", E.cy, E.cx, want[0], want[1])
+		}
+	}
+}